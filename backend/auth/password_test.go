@@ -0,0 +1,35 @@
+package auth
+
+import (
+	"errors"
+	"testing"
+)
+
+// TestHashAndVerifyPassword round-trips a password and rejects a wrong one.
+func TestHashAndVerifyPassword(t *testing.T) {
+	hash, err := HashPassword("correct horse")
+	if err != nil {
+		t.Fatalf("HashPassword failed: %v", err)
+	}
+
+	other, _ := HashPassword("correct horse")
+	if hash == other {
+		t.Errorf("two hashes of the same password are identical; salt missing?")
+	}
+
+	if ok, err := VerifyPassword("correct horse", hash); err != nil || !ok {
+		t.Errorf("VerifyPassword(correct) = %v, %v", ok, err)
+	}
+	if ok, err := VerifyPassword("battery staple", hash); err != nil || ok {
+		t.Errorf("VerifyPassword(wrong) = %v, %v", ok, err)
+	}
+}
+
+// TestVerifyPasswordRejectsMalformedHash reports ErrInvalidHash instead of panicking.
+func TestVerifyPasswordRejectsMalformedHash(t *testing.T) {
+	for _, hash := range []string{"", "plain", "$argon2i$v=19$m=1,t=1,p=1$AA$AA", "$argon2id$v=19$m=x$AA$AA"} {
+		if _, err := VerifyPassword("x", hash); !errors.Is(err, ErrInvalidHash) {
+			t.Errorf("VerifyPassword(%q) error = %v, want ErrInvalidHash", hash, err)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package auth
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestMatchTOTP checks the RFC 6238 SHA-1 test vectors, truncated to six digits.
+func TestMatchTOTP(t *testing.T) {
+	secret := totpEncoding.EncodeToString([]byte("12345678901234567890"))
+	vectors := map[int64]string{
+		59:         "287082",
+		1111111109: "081804",
+		1111111111: "050471",
+		1234567890: "005924",
+		2000000000: "279037",
+	}
+	for unix, code := range vectors {
+		step, ok := MatchTOTP(secret, code, time.Unix(unix, 0))
+		if !ok || step != unix/totpPeriod {
+			t.Errorf("MatchTOTP(%s at %d) = %d, %v", code, unix, step, ok)
+		}
+	}
+
+	now := time.Unix(1111111111, 0)
+	if _, ok := MatchTOTP(secret, "050471", now.Add(totpPeriod*time.Second)); !ok {
+		t.Error("code from the previous step rejected")
+	}
+	if _, ok := MatchTOTP(secret, "050471", now.Add(3*totpPeriod*time.Second)); ok {
+		t.Error("code from three steps ago accepted")
+	}
+	if _, ok := MatchTOTP(secret, "050 471", now); !ok {
+		t.Error("code with a space rejected")
+	}
+	if _, ok := MatchTOTP(secret, "12345", now); ok {
+		t.Error("short code accepted")
+	}
+}
+
+// TestRecoveryCodes generates distinct codes that survive normalization.
+func TestRecoveryCodes(t *testing.T) {
+	codes, err := NewRecoveryCodes()
+	if err != nil {
+		t.Fatalf("NewRecoveryCodes failed: %v", err)
+	}
+	seen := map[string]bool{}
+	for _, c := range codes {
+		if len(c) != recoveryCodeLen+1 || seen[c] {
+			t.Errorf("bad or duplicate code %q", c)
+		}
+		seen[c] = true
+		if got := NormalizeRecoveryCode(" " + strings.ToUpper(c)); got != strings.ReplaceAll(c, "-", "") {
+			t.Errorf("NormalizeRecoveryCode(%q) = %q", c, got)
+		}
+	}
+	if len(codes) != recoveryCodeCount {
+		t.Errorf("got %d codes, want %d", len(codes), recoveryCodeCount)
+	}
+}
+
+// TestTOTPURI builds a provisioning URI authenticator apps accept.
+func TestTOTPURI(t *testing.T) {
+	uri := TOTPURI("Media Manager", "alice", "JBSWY3DPEHPK3PXP")
+	if !strings.HasPrefix(uri, "otpauth://totp/Media%20Manager:alice?") || !strings.Contains(uri, "secret=JBSWY3DPEHPK3PXP") {
+		t.Errorf("TOTPURI = %q", uri)
+	}
+}
@@ -0,0 +1,133 @@
+package catalog
+
+import (
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Kind is the type of a catalog entry.
+type Kind string
+
+const (
+	KindMovie   Kind = "movie"
+	KindSeries  Kind = "series"
+	KindSeason  Kind = "season"
+	KindEpisode Kind = "episode"
+	KindBook    Kind = "book"
+	KindAlbum   Kind = "album"
+)
+
+// ValidKind reports whether k is one of the known catalog kinds.
+func ValidKind(k string) bool {
+	switch Kind(k) {
+	case KindMovie, KindSeries, KindSeason, KindEpisode, KindBook, KindAlbum:
+		return true
+	}
+	return false
+}
+
+// Suggestion is a catalog entry guessed from a file name.
+type Suggestion struct {
+	Kind    Kind   `json:"kind"`
+	Title   string `json:"title"`
+	Year    int    `json:"year,omitempty"`
+	Season  int    `json:"season,omitempty"`
+	Episode int    `json:"episode,omitempty"`
+}
+
+var (
+	videoExt = map[string]bool{
+		".mkv": true, ".mp4": true, ".m4v": true, ".avi": true, ".mov": true,
+		".wmv": true, ".webm": true, ".ts": true, ".mpg": true, ".mpeg": true,
+	}
+	bookExt = map[string]bool{
+		".epub": true, ".pdf": true, ".mobi": true, ".azw3": true,
+		".djvu": true, ".cbz": true, ".cbr": true, ".fb2": true,
+	}
+	audioExt = map[string]bool{
+		".mp3": true, ".flac": true, ".m4a": true, ".ogg": true, ".opus": true,
+		".wav": true, ".aac": true, ".alac": true,
+	}
+
+	// Show.Name.S02E05, Show Name - s2e5, Show_Name.S02.E05
+	reSxxExx = regexp.MustCompile(`(?i)^(.*?)(?:^|[\s._-]+)s(\d{1,2})[\s._-]?e(\d{1,3})(?:\D|$)`)
+	// Show Name 2x05
+	reNxNN = regexp.MustCompile(`(?i)^(.*?)[\s._-]+(\d{1,2})x(\d{2,3})(?:\D|$)`)
+	// Show.Name.S02 or Show Name Season 2 (folders and season packs)
+	reSeason = regexp.MustCompile(`(?i)^(.*?)[\s._-]+(?:s(\d{1,2})|season[\s._-]*(\d{1,2}))(?:[\s._-]|$)`)
+	// Movie (2019), Movie.2019.1080p, Movie [2019]
+	reYear = regexp.MustCompile(`^(.*?)[\s._\-(\[]+((?:19|20)\d{2})(?:[)\]\s._-]|$)`)
+
+	// release tags that end the title part of a name
+	reJunk = regexp.MustCompile(`(?i)[\s._-]+(?:2160p|1080p|720p|576p|480p|4k|uhd|hdr|bluray|blu-ray|bdrip|brrip|web-?dl|webrip|hdtv|dvdrip|remux|x264|x265|h\.?264|h\.?265|hevc|xvid|aac|dts|proper|repack)(?:[\s._-].*)?$`)
+)
+
+// ParseFilename guesses a catalog entry from a media file name such as
+// "Show.Name.S02E05.1080p.mkv" or "Movie (2019).mp4". Directory components
+// are ignored. The result always has a kind and a best-effort title.
+func ParseFilename(name string) Suggestion {
+	base := filepath.Base(strings.ReplaceAll(name, "\\", "/"))
+	ext := strings.ToLower(filepath.Ext(base))
+	stem := base
+	if videoExt[ext] || bookExt[ext] || audioExt[ext] {
+		stem = strings.TrimSuffix(base, filepath.Ext(base))
+	}
+
+	if m := reSxxExx.FindStringSubmatch(stem); m != nil {
+		title, year := splitYear(m[1])
+		return Suggestion{Kind: KindEpisode, Title: title, Year: year, Season: atoi(m[2]), Episode: atoi(m[3])}
+	}
+	if m := reNxNN.FindStringSubmatch(stem); m != nil {
+		title, year := splitYear(m[1])
+		return Suggestion{Kind: KindEpisode, Title: title, Year: year, Season: atoi(m[2]), Episode: atoi(m[3])}
+	}
+
+	kind := KindMovie
+	switch {
+	case bookExt[ext]:
+		kind = KindBook
+	case audioExt[ext]:
+		kind = KindAlbum
+	}
+
+	if kind == KindMovie {
+		if m := reSeason.FindStringSubmatch(stem); m != nil && m[1] != "" {
+			season := m[2]
+			if season == "" {
+				season = m[3]
+			}
+			title, year := splitYear(m[1])
+			return Suggestion{Kind: KindSeason, Title: title, Year: year, Season: atoi(season)}
+		}
+	}
+
+	title, year := splitYear(stem)
+	return Suggestion{Kind: kind, Title: title, Year: year}
+}
+
+// splitYear separates a trailing release year from a title.
+func splitYear(s string) (string, int) {
+	if m := reYear.FindStringSubmatch(s); m != nil && strings.TrimSpace(m[1]) != "" {
+		return cleanTitle(m[1]), atoi(m[2])
+	}
+	return cleanTitle(s), 0
+}
+
+// cleanTitle turns "Show.Name_-" into "Show Name".
+func cleanTitle(s string) string {
+	s = reJunk.ReplaceAllString(s, "")
+	if !strings.Contains(s, " ") {
+		s = strings.NewReplacer(".", " ", "_", " ").Replace(s)
+	} else {
+		s = strings.ReplaceAll(s, "_", " ")
+	}
+	s = strings.Join(strings.Fields(s), " ")
+	return strings.Trim(s, " -([")
+}
+
+func atoi(s string) int {
+	n, _ := strconv.Atoi(s)
+	return n
+}
@@ -0,0 +1,46 @@
+package catalog
+
+import "testing"
+
+// TestParseFilename checks the common naming schemes we see in the library.
+func TestParseFilename(t *testing.T) {
+	tests := []struct {
+		name string
+		want Suggestion
+	}{
+		{"Show.Name.S02E05.1080p.mkv", Suggestion{Kind: KindEpisode, Title: "Show Name", Season: 2, Episode: 5}},
+		{"show_name_s1e12_720p_x264.mp4", Suggestion{Kind: KindEpisode, Title: "show name", Season: 1, Episode: 12}},
+		{"Show Name (2010) - S03E01 - Pilot.mkv", Suggestion{Kind: KindEpisode, Title: "Show Name", Year: 2010, Season: 3, Episode: 1}},
+		{"Show Name 4x07.avi", Suggestion{Kind: KindEpisode, Title: "Show Name", Season: 4, Episode: 7}},
+		{"tv/Show Name/Season 2/Show.Name.S02E10.mkv", Suggestion{Kind: KindEpisode, Title: "Show Name", Season: 2, Episode: 10}},
+		{"Show.Name.S02.1080p.WEB-DL", Suggestion{Kind: KindSeason, Title: "Show Name", Season: 2}},
+		{"Movie (2019).mp4", Suggestion{Kind: KindMovie, Title: "Movie", Year: 2019}},
+		{"The.Long.Movie.Title.2004.1080p.BluRay.x264.mkv", Suggestion{Kind: KindMovie, Title: "The Long Movie Title", Year: 2004}},
+		{"Another Movie [1999].mkv", Suggestion{Kind: KindMovie, Title: "Another Movie", Year: 1999}},
+		{"2001 A Space Odyssey (1968).mkv", Suggestion{Kind: KindMovie, Title: "2001 A Space Odyssey", Year: 1968}},
+		{"home_video.mp4", Suggestion{Kind: KindMovie, Title: "home video"}},
+		{"Some Author - Some Book (2015).epub", Suggestion{Kind: KindBook, Title: "Some Author - Some Book", Year: 2015}},
+		{"Artist - Album.flac", Suggestion{Kind: KindAlbum, Title: "Artist - Album"}},
+	}
+
+	for _, tt := range tests {
+		got := ParseFilename(tt.name)
+		if got != tt.want {
+			t.Errorf("ParseFilename(%q) = %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+// TestValidKind ensures only known kinds are accepted.
+func TestValidKind(t *testing.T) {
+	for _, k := range []string{"movie", "series", "season", "episode", "book", "album"} {
+		if !ValidKind(k) {
+			t.Errorf("expected %q to be valid", k)
+		}
+	}
+	for _, k := range []string{"", "Movie", "podcast"} {
+		if ValidKind(k) {
+			t.Errorf("expected %q to be invalid", k)
+		}
+	}
+}
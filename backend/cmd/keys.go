@@ -0,0 +1,87 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"media_management_go/backend/database"
+	"media_management_go/backend/keyset"
+)
+
+const commandUsage = `usage:
+  server keys list
+  server keys rotate [-alg HS256|RS256|EdDSA] [-grace 24h]`
+
+// runCommand runs an administrative command instead of the server and
+// returns the exit code.
+func runCommand(args []string) int {
+	if args[0] == "keys" && len(args) > 1 {
+		switch args[1] {
+		case "list":
+			return listKeys()
+		case "rotate":
+			return rotateKeys(args[2:])
+		}
+	}
+	fmt.Fprintln(os.Stderr, commandUsage)
+	return 2
+}
+
+func listKeys() int {
+	keys, err := database.GetSigningKeys()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if len(keys) == 0 {
+		fmt.Println("No signing keys yet; tokens are signed with JWT_KEY.")
+		return 0
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tALGORITHM\tSTATUS\tEXPIRES")
+	for _, k := range keys {
+		id, status := k.ID, "retired"
+		if id == database.LegacyKeyID {
+			id = "(JWT_KEY)"
+		}
+		if k.Signing {
+			status = "signing"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", id, k.Algorithm, status, k.ExpiresAt)
+	}
+	tw.Flush()
+	return 0
+}
+
+// rotateKeys makes a new signing key. The running server picks it up within
+// keyset.ReloadInterval; the old key keeps verifying tokens for the grace
+// period, which must be at least the access token lifetime (15m) so nobody
+// is logged out.
+func rotateKeys(args []string) int {
+	fs := flag.NewFlagSet("keys rotate", flag.ContinueOnError)
+	alg := fs.String("alg", keyset.AlgHS256, "signing algorithm: HS256, RS256 or EdDSA")
+	grace := fs.Duration("grace", 24*time.Hour, "how long the previous key keeps verifying tokens")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if *grace < 15*time.Minute {
+		fmt.Fprintln(os.Stderr, "grace must be at least the access token lifetime of 15m")
+		return 2
+	}
+
+	k, err := keyset.Generate(*alg)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	if err := database.RotateSigningKey(k, *grace); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	fmt.Printf("New %s signing key %s; the previous key is accepted for another %s.\n", k.Algorithm, k.ID, *grace)
+	return 0
+}
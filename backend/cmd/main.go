@@ -1,23 +1,30 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log/slog"
+	"net"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"media_management_go/backend/common"
 	"media_management_go/backend/database"
+	"media_management_go/backend/dlna"
 	"media_management_go/backend/handlers"
+	"media_management_go/backend/jobs"
+	"media_management_go/backend/keyset"
+	"media_management_go/backend/metrics"
+	"media_management_go/backend/middleware"
 )
 
-func enableCORS(w http.ResponseWriter, r *http.Request) {
-	// You might want to restrict this to a specific origin instead of "*"
-	w.Header().Set("Access-Control-Allow-Origin", "http://localhost:3000")
-	w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
-	// If you expect to allow cookies or auth headers:
-	// w.Header().Set("Access-Control-Allow-Credentials", "true")
-}
+// shutdownTimeout is how long in-flight requests get to finish once the
+// server is told to stop.
+const shutdownTimeout = 15 * time.Second
 
 func main() {
 	common.MustLoadConfig()
@@ -31,131 +38,131 @@ func main() {
 	database.MustOpen(cfg.DB_PATH)
 	defer database.Close()
 
-	mux := http.NewServeMux()
-
-	mux.HandleFunc("OPTIONS /", func(w http.ResponseWriter, r *http.Request) {
-		enableCORS(w, r)
-		w.WriteHeader(http.StatusOK)
-	})
-
-	mux.HandleFunc("GET /login", func(w http.ResponseWriter, r *http.Request) {
-		enableCORS(w, r)
-
-		if r.URL.Path != "/login" {
-			http.NotFound(w, r)
-			slog.Info("Login endpoint not processed", slog.String("expected", "/login"), slog.String("received", r.URL.Path))
-			return
-		}
-
-		slog.Info("Processing GET login request")
-		handlers.HandleGetLogin(w, r)
-	})
-
-	mux.HandleFunc("POST /login", func(w http.ResponseWriter, r *http.Request) {
-		enableCORS(w, r)
-
-		if r.URL.Path != "/login" {
-			http.NotFound(w, r)
-			slog.Info("Login endpoint not processed", slog.String("expected", "/login"), slog.String("received", r.URL.Path))
-			return
-		}
-
-		slog.Info("Processing POST login request")
-		handlers.HandlePostLogin(w, r)
-	})
+	// "server keys ..." and friends run instead of the server
+	if len(os.Args) > 1 {
+		code := runCommand(os.Args[1:])
+		database.Close()
+		os.Exit(code)
+	}
 
-	mux.HandleFunc("GET /link", func(w http.ResponseWriter, r *http.Request) {
-		enableCORS(w, r)
+	keyset.MustLoad(cfg.JWT_KEY)
 
-		if r.URL.Path != "/link" {
-			http.NotFound(w, r)
-			slog.Info("Link endpoint not processed", slog.String("expected", "/link"), slog.String("received", r.URL.Path))
-			return
-		}
+	if err := handlers.BootstrapAdmin(); err != nil {
+		slog.Error("Failed to create initial administrator", slog.Any("error", err))
+		os.Exit(1)
+	}
 
-		slog.Info("Processing GET link request")
-		handlers.HandleGetLink(w, r)
-	})
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
-	mux.HandleFunc("POST /link", func(w http.ResponseWriter, r *http.Request) {
-		enableCORS(w, r)
-
-		if r.URL.Path != "/link" {
-			http.NotFound(w, r)
-			slog.Info("Link endpoint not processed", slog.String("expected", "/link"), slog.String("received", r.URL.Path))
-			return
-		}
-
-		slog.Info("Processing POST link request")
-		handlers.HandlePostLink(w, r)
-	})
-
-	mux.HandleFunc("GET /note", func(w http.ResponseWriter, r *http.Request) {
-		enableCORS(w, r)
-
-		if r.URL.Path != "/note" {
-			http.NotFound(w, r)
-			slog.Info("Note endpoint not processed", slog.String("expected", "/note"), slog.String("received", r.URL.Path))
-			return
-		}
+	go jobs.Every(ctx, "session sweeper", jobs.SessionSweepInterval, jobs.SweepSessions(cfg.SESSION_IDLE_TIMEOUT))
+	go jobs.Every(ctx, "signing key reloader", keyset.ReloadInterval, jobs.ReloadSigningKeys)
 
-		slog.Info("Processing GET note request")
-		handlers.HandleGetNote(w, r)
-	})
-
-	mux.HandleFunc("POST /note", func(w http.ResponseWriter, r *http.Request) {
-		enableCORS(w, r)
-
-		if r.URL.Path != "/note" {
-			http.NotFound(w, r)
-			slog.Info("Note endpoint not processed", slog.String("expected", "/note"), slog.String("received", r.URL.Path))
-			return
-		}
-
-		slog.Info("Processing POST note request")
-		handlers.HandlePostNote(w, r)
-	})
-
-	mux.HandleFunc("PUT /note", func(w http.ResponseWriter, r *http.Request) {
-		enableCORS(w, r)
-
-		if r.URL.Path != "/note" {
-			http.NotFound(w, r)
-			slog.Info("Note endpoint not processed", slog.String("expected", "/note"), slog.String("received", r.URL.Path))
-			return
-		}
-
-		slog.Info("Processing PUT note request")
-		handlers.HandlePutNote(w, r)
-	})
-
-	mux.HandleFunc("DELETE /note", func(w http.ResponseWriter, r *http.Request) {
-		enableCORS(w, r)
-
-		if r.URL.Path != "/note" {
-			http.NotFound(w, r)
-			slog.Info("Note endpoint not processed", slog.String("expected", "/note"), slog.String("received", r.URL.Path))
-			return
-		}
-
-		slog.Info("Processing DELETE note request")
-		handlers.HandleDeleteNote(w, r)
-	})
-
-	mux.HandleFunc("DELETE /link", func(w http.ResponseWriter, r *http.Request) {
-		enableCORS(w, r)
+	mux := http.NewServeMux()
 
-		if r.URL.Path != "/link" {
-			http.NotFound(w, r)
-			slog.Info("Link endpoint not processed", slog.String("expected", "/link"), slog.String("received", r.URL.Path))
-			return
+	handlers.RegisterRoutes(mux)
+
+	// WebDAV needs its own verbs (PROPFIND, MKCOL, ...), so it is mounted
+	// once per method.
+	webDAV := handlers.NewWebDAVHandler("/dav")
+	for _, method := range handlers.WebDAVMethods {
+		mux.Handle(method+" /dav/", webDAV)
+	}
+
+	if cfg.DLNA_ENABLED {
+		iface, err := dlna.FindInterface(cfg.DLNA_INTERFACE)
+		owner, userErr := database.GetUserByUsername(cfg.DLNA_USER)
+		if err != nil {
+			slog.Error("DLNA server not started", slog.Any("error", err))
+		} else if userErr != nil {
+			slog.Error("DLNA server not started", slog.String("user", cfg.DLNA_USER), slog.Any("error", userErr))
+		} else {
+			mediaServer := dlna.New(cfg.DLNA_NAME, cfg.PORT, owner.ID, iface, handlers.SignedMediaURL)
+			// renderers cannot log in, so browsing is kept to the LAN
+			dlnaHandler := middleware.LocalOnly(mediaServer.Handler())
+			for _, method := range dlna.Methods {
+				mux.Handle(method+" /dlna/", dlnaHandler)
+			}
+			go func() {
+				if err := mediaServer.Advertise(ctx); err != nil {
+					slog.Error("SSDP advertising stopped", slog.Any("error", err))
+				}
+			}()
 		}
-
-		slog.Info("Processing DELETE link request")
-		handlers.HandleDeleteLink(w, r)
-	})
+	}
+
+	// metrics get a listener of their own when configured, keeping them off
+	// the public port; otherwise the token guards them there
+	var metricsSrv *http.Server
+	if cfg.METRICS_ADDR != "" {
+		metricsMux := http.NewServeMux()
+		metricsMux.Handle("GET /metrics", metrics.Handler(cfg.METRICS_TOKEN))
+		metricsSrv = &http.Server{Addr: cfg.METRICS_ADDR, Handler: metricsMux}
+		go func() {
+			slog.Info("Metrics are served", slog.String("addr", "http://"+cfg.METRICS_ADDR+"/metrics"))
+			if err := metricsSrv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				slog.Error("Metrics server stopped", slog.Any("error", err))
+			}
+		}()
+	} else if cfg.METRICS_TOKEN != "" {
+		mux.Handle("GET /metrics", metrics.Handler(cfg.METRICS_TOKEN))
+	}
 
 	addr := fmt.Sprintf("%s:%s", cfg.ADDR, cfg.PORT)
+	srv := &http.Server{
+		Addr: addr,
+		Handler: middleware.Chain(
+			middleware.RequestID,
+			middleware.RealIP(cfg.TRUSTED_PROXIES),
+			middleware.AccessLog,
+			middleware.Metrics,
+			middleware.Recover,
+			middleware.CORS(middleware.CORSPolicy{
+				AllowedOrigins:   cfg.CORS_ALLOWED_ORIGINS,
+				AllowedMethods:   cfg.CORS_ALLOWED_METHODS,
+				AllowedHeaders:   cfg.CORS_ALLOWED_HEADERS,
+				AllowCredentials: cfg.CORS_ALLOW_CREDENTIALS,
+				MaxAge:           cfg.CORS_MAX_AGE,
+			}, mux),
+		)(mux),
+	}
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		slog.Error("Failed to listen", slog.String("addr", addr), slog.Any("error", err))
+		os.Exit(1)
+	}
+
+	serveErr := make(chan error, 1)
+	go func() { serveErr <- srv.Serve(ln) }()
+	handlers.MarkReady()
 	slog.Info("Server is running.", slog.String("addr", "http://"+addr))
-	http.ListenAndServe(addr, mux)
+
+	select {
+	case err := <-serveErr:
+		slog.Error("Server stopped", slog.Any("error", err))
+		database.Close()
+		os.Exit(1)
+	case <-ctx.Done():
+	}
+
+	// fail readiness first and wait for the load balancer to stop sending
+	// requests, then let the ones in flight finish
+	handlers.MarkStopping()
+	if cfg.SHUTDOWN_DRAIN_DELAY > 0 {
+		slog.Info("Draining", slog.Duration("delay", cfg.SHUTDOWN_DRAIN_DELAY))
+		time.Sleep(cfg.SHUTDOWN_DRAIN_DELAY)
+	}
+	slog.Info("Shutting down", slog.Duration("timeout", shutdownTimeout))
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		slog.Error("Shutdown did not finish", slog.Any("error", err))
+	}
+	// metrics stay up until the last requests are counted
+	if metricsSrv != nil {
+		if err := metricsSrv.Shutdown(shutdownCtx); err != nil {
+			slog.Error("Metrics shutdown did not finish", slog.Any("error", err))
+		}
+	}
+	slog.Info("Server stopped")
 }
@@ -2,19 +2,109 @@ package common
 
 import (
 	"log"
+	"net/http"
+	"net/netip"
+	"net/url"
 	"os"
+	"slices"
+	"strconv"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/joho/godotenv"
 )
 
 type Config struct {
-	ADDR     string
-	PORT     string
-	ENV      string
-	USER_KEY string
-	JWT_KEY  string
-	DB_PATH  string
+	ADDR      string
+	PORT      string
+	ENV       string
+	JWT_KEY   string
+	DB_PATH   string
+	MEDIA_DIR string
+
+	// MAX_UPLOAD_SIZE is the largest upload accepted, in bytes.
+	MAX_UPLOAD_SIZE int64
+
+	// USER_KEY and ADMIN_USERNAME create the first administrator account
+	// when the database has no users yet; afterwards USER_KEY is unused.
+	USER_KEY       string
+	ADMIN_USERNAME string
+
+	// DLNA_ENABLED turns on the UPnP media server for LAN renderers.
+	// DLNA_NAME is the name they show; DLNA_INTERFACE picks the network
+	// interface to announce on when the default guess is wrong. DLNA_USER
+	// is the user whose library is published, to clients on the local
+	// network only.
+	DLNA_ENABLED   bool
+	DLNA_NAME      string
+	DLNA_INTERFACE string
+	DLNA_USER      string
+
+	// SESSION_IDLE_TIMEOUT is how long a session may go unused before the
+	// sweeper removes it; zero keeps sessions until their token expires.
+	SESSION_IDLE_TIMEOUT time.Duration
+
+	// SESSION_COOKIES lets browser clients keep their session in HttpOnly
+	// cookies instead of bearer tokens. SESSION_COOKIE_SECURE and
+	// SESSION_COOKIE_SAMESITE set the cookie attributes; SameSite=None is
+	// only needed when the frontend is served from another site.
+	SESSION_COOKIES         bool
+	SESSION_COOKIE_SECURE   bool
+	SESSION_COOKIE_SAMESITE http.SameSite
+
+	// OIDC_ISSUER turns on single sign-on through an OpenID Connect
+	// provider, with OIDC_CLIENT_ID and OIDC_CLIENT_SECRET as registered
+	// there. OIDC_REDIRECT_URL is the frontend page the provider sends the
+	// browser back to. Identities sign in to the account an administrator
+	// linked them to; with OIDC_AUTO_PROVISION, unknown identities get a new
+	// account with OIDC_DEFAULT_ROLE, named after OIDC_USERNAME_CLAIM.
+	OIDC_ISSUER         string
+	OIDC_CLIENT_ID      string
+	OIDC_CLIENT_SECRET  string
+	OIDC_REDIRECT_URL   string
+	OIDC_SCOPES         []string
+	OIDC_USERNAME_CLAIM string
+	OIDC_AUTO_PROVISION bool
+	OIDC_DEFAULT_ROLE   string
+
+	// CORS_ALLOWED_ORIGINS lists the origins browsers may call the API
+	// from, either exactly or as https://*.example.com for any subdomain;
+	// * allows every origin. CORS_ALLOWED_METHODS and CORS_ALLOWED_HEADERS
+	// are what preflight requests may ask for. CORS_ALLOW_CREDENTIALS lets
+	// those origins send cookies and defaults to on with SESSION_COOKIES or
+	// single sign-on.
+	// CORS_MAX_AGE is how long browsers may cache a preflight response.
+	CORS_ALLOWED_ORIGINS   []string
+	CORS_ALLOWED_METHODS   []string
+	CORS_ALLOWED_HEADERS   []string
+	CORS_ALLOW_CREDENTIALS bool
+	CORS_MAX_AGE           time.Duration
+
+	// TRUSTED_PROXIES lists the addresses or CIDR ranges of reverse proxies
+	// whose X-Forwarded-For and X-Real-IP headers name the real client.
+	// Requests from anywhere else are taken at their connection address.
+	TRUSTED_PROXIES []netip.Prefix
+
+	// PUBLIC_URL is the address clients reach the server at, such as
+	// https://media.example.com, used in share links, exported playlists
+	// and DLNA. Without it the address is taken from each request, believing
+	// X-Forwarded-Proto only from TRUSTED_PROXIES.
+	PUBLIC_URL string
+
+	// METRICS_TOKEN serves Prometheus metrics at /metrics to scrapers that
+	// present it as a bearer token. METRICS_ADDR serves them on a listen
+	// address of their own instead, such as 127.0.0.1:9090, still asking
+	// for the token if one is set. Metrics are off unless either is set.
+	// Scrapers presenting METRICS_TOKEN also get the detail of each
+	// readiness check from /readyz.
+	METRICS_TOKEN string
+	METRICS_ADDR  string
+
+	// SHUTDOWN_DRAIN_DELAY is how long the server keeps serving after
+	// /readyz starts failing on shutdown, so load balancers notice before
+	// it stops accepting connections.
+	SHUTDOWN_DRAIN_DELAY time.Duration
 }
 
 var (
@@ -48,9 +138,11 @@ func MustLoadConfig() {
 		log.Fatal("PORT environment variable missing")
 	}
 
-	userKey, ok := os.LookupEnv("USER_KEY")
+	// optional; only used to bootstrap the first administrator
+	userKey := os.Getenv("USER_KEY")
+	adminUsername, ok := os.LookupEnv("ADMIN_USERNAME")
 	if !ok {
-		log.Fatal("USER_KEY environment variable missing")
+		adminUsername = "admin"
 	}
 
 	jwtKey, ok := os.LookupEnv("JWT_KEY")
@@ -64,18 +156,212 @@ func MustLoadConfig() {
 		dbPath = "backend/data/app.db"
 	}
 
+	mediaDir, ok := os.LookupEnv("MEDIA_DIR")
+	if !ok {
+		// uploaded media files live next to the database by default
+		mediaDir = "backend/data/media"
+	}
+
+	// uploads are capped at 4 GiB unless configured otherwise
+	maxUploadSize := int64(4 << 30)
+	if v, ok := os.LookupEnv("MAX_UPLOAD_SIZE"); ok {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n <= 0 {
+			log.Fatalf("invalid MAX_UPLOAD_SIZE %q: expected a size in bytes", v)
+		}
+		maxUploadSize = n
+	}
+
+	// the DLNA server is off unless explicitly enabled
+	dlnaEnabled := os.Getenv("DLNA_ENABLED") == "true"
+	dlnaName, ok := os.LookupEnv("DLNA_NAME")
+	if !ok {
+		dlnaName = "Media Management"
+	}
+	dlnaInterface := os.Getenv("DLNA_INTERFACE")
+	dlnaUser, ok := os.LookupEnv("DLNA_USER")
+	if !ok {
+		dlnaUser = adminUsername
+	}
+
+	// idle sessions are dropped after 30 days unless configured otherwise
+	idleTimeout := 30 * 24 * time.Hour
+	if v, ok := os.LookupEnv("SESSION_IDLE_TIMEOUT"); ok {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 {
+			log.Fatalf("invalid SESSION_IDLE_TIMEOUT %q: expected a duration such as 720h or 0", v)
+		}
+		idleTimeout = d
+	}
+
+	// cookie sessions are off unless enabled; when on, cookies are Secure
+	// and SameSite=Lax unless configured otherwise
+	sessionCookies := os.Getenv("SESSION_COOKIES") == "true"
+	cookieSecure := os.Getenv("SESSION_COOKIE_SECURE") != "false"
+	var cookieSameSite http.SameSite
+	switch v := strings.ToLower(os.Getenv("SESSION_COOKIE_SAMESITE")); v {
+	case "", "lax":
+		cookieSameSite = http.SameSiteLaxMode
+	case "strict":
+		cookieSameSite = http.SameSiteStrictMode
+	case "none":
+		if !cookieSecure {
+			log.Fatal("SESSION_COOKIE_SAMESITE=none requires SESSION_COOKIE_SECURE")
+		}
+		cookieSameSite = http.SameSiteNoneMode
+	default:
+		log.Fatalf("invalid SESSION_COOKIE_SAMESITE %q: expected lax, strict or none", v)
+	}
+
+	// single sign-on is off unless an issuer is configured
+	oidcIssuer := os.Getenv("OIDC_ISSUER")
+	oidcClientID := os.Getenv("OIDC_CLIENT_ID")
+	oidcRedirectURL := os.Getenv("OIDC_REDIRECT_URL")
+	if oidcIssuer != "" && (oidcClientID == "" || oidcRedirectURL == "") {
+		log.Fatal("OIDC_ISSUER requires OIDC_CLIENT_ID and OIDC_REDIRECT_URL")
+	}
+	oidcScopes := strings.Fields(os.Getenv("OIDC_SCOPES"))
+	oidcUsernameClaim, ok := os.LookupEnv("OIDC_USERNAME_CLAIM")
+	if !ok {
+		oidcUsernameClaim = "preferred_username"
+	}
+	oidcDefaultRole, ok := os.LookupEnv("OIDC_DEFAULT_ROLE")
+	if !ok {
+		oidcDefaultRole = "editor"
+	}
+	switch oidcDefaultRole {
+	case "admin", "editor", "viewer":
+	default:
+		log.Fatalf("invalid OIDC_DEFAULT_ROLE %q: expected admin, editor or viewer", oidcDefaultRole)
+	}
+
+	// the development frontend is allowed unless configured otherwise
+	corsOrigins := []string{"http://localhost:3000"}
+	if v, ok := os.LookupEnv("CORS_ALLOWED_ORIGINS"); ok {
+		corsOrigins = splitList(v)
+	}
+	for _, o := range corsOrigins {
+		if o != "*" && !strings.HasPrefix(o, "http://") && !strings.HasPrefix(o, "https://") {
+			log.Fatalf("invalid CORS_ALLOWED_ORIGINS entry %q: expected an origin such as https://app.example.com", o)
+		}
+	}
+	corsMethods := []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"}
+	if v, ok := os.LookupEnv("CORS_ALLOWED_METHODS"); ok {
+		corsMethods = splitList(strings.ToUpper(v))
+	}
+	corsHeaders := []string{"Content-Type", "Authorization", "X-CSRF-Token", "X-Session-Mode"}
+	if v, ok := os.LookupEnv("CORS_ALLOWED_HEADERS"); ok {
+		corsHeaders = splitList(v)
+	}
+	// single sign-on ties each login to the browser with a cookie, so it
+	// needs credentials too where origins are listed
+	corsCredentials := sessionCookies || (oidcIssuer != "" && !slices.Contains(corsOrigins, "*"))
+	if v, ok := os.LookupEnv("CORS_ALLOW_CREDENTIALS"); ok {
+		corsCredentials = v == "true"
+	}
+	if corsCredentials && slices.Contains(corsOrigins, "*") {
+		log.Fatal("CORS_ALLOWED_ORIGINS=* cannot be combined with CORS_ALLOW_CREDENTIALS")
+	}
+	corsMaxAge := 10 * time.Minute
+	if v, ok := os.LookupEnv("CORS_MAX_AGE"); ok {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 {
+			log.Fatalf("invalid CORS_MAX_AGE %q: expected a duration such as 10m or 0", v)
+		}
+		corsMaxAge = d
+	}
+
+	// shutdown waits 5s for load balancers to see readiness fail unless
+	// configured otherwise
+	drainDelay := 5 * time.Second
+	if v, ok := os.LookupEnv("SHUTDOWN_DRAIN_DELAY"); ok {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 {
+			log.Fatalf("invalid SHUTDOWN_DRAIN_DELAY %q: expected a duration such as 5s or 0", v)
+		}
+		drainDelay = d
+	}
+
+	// forwarding headers are ignored unless a proxy is trusted
+	var trustedProxies []netip.Prefix
+	for _, v := range splitList(os.Getenv("TRUSTED_PROXIES")) {
+		prefix, err := netip.ParsePrefix(v)
+		if err != nil {
+			addr, addrErr := netip.ParseAddr(v)
+			if addrErr != nil {
+				log.Fatalf("invalid TRUSTED_PROXIES entry %q: expected an address or CIDR range", v)
+			}
+			prefix = netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen())
+		}
+		trustedProxies = append(trustedProxies, prefix.Masked())
+	}
+
+	publicURL := strings.TrimSuffix(os.Getenv("PUBLIC_URL"), "/")
+	if publicURL != "" {
+		u, err := url.Parse(publicURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || u.Path != "" || u.RawQuery != "" {
+			log.Fatalf("invalid PUBLIC_URL %q: expected a scheme and host such as https://media.example.com", publicURL)
+		}
+	}
+
 	onceCfg.Do(func() {
 		cfg = &Config{
-			ADDR:     arrd,
-			PORT:     port,
-			ENV:      env,
-			USER_KEY: userKey,
-			JWT_KEY:  jwtKey,
-			DB_PATH:  dbPath,
+			ADDR:      arrd,
+			PORT:      port,
+			ENV:       env,
+			JWT_KEY:   jwtKey,
+			DB_PATH:   dbPath,
+			MEDIA_DIR: mediaDir,
+
+			MAX_UPLOAD_SIZE: maxUploadSize,
+
+			USER_KEY:       userKey,
+			ADMIN_USERNAME: adminUsername,
+
+			DLNA_ENABLED:   dlnaEnabled,
+			DLNA_NAME:      dlnaName,
+			DLNA_INTERFACE: dlnaInterface,
+			DLNA_USER:      dlnaUser,
+
+			SESSION_IDLE_TIMEOUT: idleTimeout,
+
+			SESSION_COOKIES:         sessionCookies,
+			SESSION_COOKIE_SECURE:   cookieSecure,
+			SESSION_COOKIE_SAMESITE: cookieSameSite,
+
+			OIDC_ISSUER:         oidcIssuer,
+			OIDC_CLIENT_ID:      oidcClientID,
+			OIDC_CLIENT_SECRET:  os.Getenv("OIDC_CLIENT_SECRET"),
+			OIDC_REDIRECT_URL:   oidcRedirectURL,
+			OIDC_SCOPES:         oidcScopes,
+			OIDC_USERNAME_CLAIM: oidcUsernameClaim,
+			OIDC_AUTO_PROVISION: os.Getenv("OIDC_AUTO_PROVISION") == "true",
+			OIDC_DEFAULT_ROLE:   oidcDefaultRole,
+
+			CORS_ALLOWED_ORIGINS:   corsOrigins,
+			CORS_ALLOWED_METHODS:   corsMethods,
+			CORS_ALLOWED_HEADERS:   corsHeaders,
+			CORS_ALLOW_CREDENTIALS: corsCredentials,
+			CORS_MAX_AGE:           corsMaxAge,
+
+			TRUSTED_PROXIES: trustedProxies,
+			PUBLIC_URL:      publicURL,
+
+			METRICS_TOKEN: os.Getenv("METRICS_TOKEN"),
+			METRICS_ADDR:  os.Getenv("METRICS_ADDR"),
+
+			SHUTDOWN_DRAIN_DELAY: drainDelay,
 		}
 	})
 }
 
+// splitList splits a comma or space separated list.
+func splitList(v string) []string {
+	return strings.FieldsFunc(v, func(r rune) bool {
+		return r == ',' || r == ' '
+	})
+}
+
 func GetConfig() *Config {
 	if cfg == nil {
 		panic("Global config not initialized. Call MustLoadConfig() first.")
@@ -1,6 +1,7 @@
 package common
 
 import (
+	"context"
 	"log"
 	"log/slog"
 	"os"
@@ -31,7 +32,7 @@ func LoadLogger() {
 		logHandler = slog.NewJSONHandler(os.Stdout, &logOpts)
 	}
 
-	log := slog.New(logHandler)
+	log := slog.New(requestIDHandler{logHandler})
 	slog.SetDefault(log)
 	onceLog.Do(func() {
 		logger = log
@@ -44,3 +45,38 @@ func GetLogger() *slog.Logger {
 	}
 	return logger
 }
+
+type requestIDKey struct{}
+
+// WithRequestID returns ctx carrying the ID of the request it belongs to,
+// which is added to everything logged with ctx.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestID returns the request ID ctx carries, or "" if it has none.
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// requestIDHandler adds the request ID to records logged with a request's
+// context, so handlers only need to log with r.Context().
+type requestIDHandler struct {
+	slog.Handler
+}
+
+func (h requestIDHandler) Handle(ctx context.Context, rec slog.Record) error {
+	if id := RequestID(ctx); id != "" {
+		rec.AddAttrs(slog.String("request_id", id))
+	}
+	return h.Handler.Handle(ctx, rec)
+}
+
+func (h requestIDHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return requestIDHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h requestIDHandler) WithGroup(name string) slog.Handler {
+	return requestIDHandler{h.Handler.WithGroup(name)}
+}
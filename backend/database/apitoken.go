@@ -0,0 +1,156 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// APIToken is a named personal access token for scripts. The token itself is
+// never stored; it is looked up by HashToken like a session token.
+type APIToken struct {
+	ID         string   `json:"id"`
+	UserID     string   `json:"-"`
+	Name       string   `json:"name"`
+	Scopes     []string `json:"scopes"`
+	ExpiresAt  string   `json:"expiresAt,omitempty"`
+	LastUsedAt string   `json:"lastUsedAt,omitempty"`
+	CreatedAt  string   `json:"createdAt"`
+}
+
+// HasScope reports whether the token was granted scope.
+func (t APIToken) HasScope(scope string) bool {
+	for _, s := range t.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+const apiTokenSelect = `SELECT id, user_id, name, scopes, expires_at, last_used_at, createdAt FROM ApiToken`
+
+func scanAPIToken(row interface{ Scan(...any) error }) (APIToken, error) {
+	var t APIToken
+	var scopes string
+	var expires, lastUsed sql.NullString
+	if err := row.Scan(&t.ID, &t.UserID, &t.Name, &scopes, &expires, &lastUsed, &t.CreatedAt); err != nil {
+		return APIToken{}, err
+	}
+	t.Scopes = strings.Fields(scopes)
+	t.ExpiresAt = expires.String
+	t.LastUsedAt = lastUsed.String
+	return t, nil
+}
+
+// AddAPIToken stores a personal access token for userID. A nil expiresAt
+// means the token never expires. Returns the new record ID.
+func AddAPIToken(userID, name, tokenHash string, scopes []string, expiresAt *time.Time) (string, error) {
+	if db == nil {
+		return "", fmt.Errorf("database not initialized")
+	}
+	defer timeQuery("AddAPIToken", time.Now())
+	id := uuid.New().String()
+	_, err := db.Exec(
+		`INSERT INTO ApiToken (id, user_id, name, token_hash, scopes, expires_at, createdAt) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		id, userID, name, tokenHash, strings.Join(scopes, " "), expiresAt, time.Now(),
+	)
+	if err != nil {
+		return "", fmt.Errorf("insert api token: %w", err)
+	}
+	return id, nil
+}
+
+// GetAPITokens retrieves a user's personal access tokens, newest first,
+// including expired ones.
+func GetAPITokens(userID string) ([]APIToken, error) {
+	if db == nil {
+		return nil, fmt.Errorf("database not initialized")
+	}
+	defer timeQuery("GetAPITokens", time.Now())
+
+	rows, err := db.Query(apiTokenSelect+` WHERE user_id = ? ORDER BY createdAt DESC`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("query api tokens: %w", err)
+	}
+	defer rows.Close()
+
+	var tokens []APIToken
+	for rows.Next() {
+		t, err := scanAPIToken(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scan api token: %w", err)
+		}
+		tokens = append(tokens, t)
+	}
+	return tokens, rows.Err()
+}
+
+// CountAPITokens returns the number of personal access tokens that have not
+// expired.
+func CountAPITokens() (int, error) {
+	if db == nil {
+		return 0, fmt.Errorf("database not initialized")
+	}
+	defer timeQuery("CountAPITokens", time.Now())
+	var n int
+	err := db.QueryRow(
+		`SELECT COUNT(*) FROM ApiToken WHERE expires_at IS NULL OR julianday(expires_at) >= julianday(?)`,
+		time.Now(),
+	).Scan(&n)
+	if err != nil {
+		return 0, fmt.Errorf("count api tokens: %w", err)
+	}
+	return n, nil
+}
+
+// GetAPITokenByHash retrieves the unexpired personal access token with
+// tokenHash, or ErrNotFound.
+func GetAPITokenByHash(tokenHash string) (*APIToken, error) {
+	if db == nil {
+		return nil, fmt.Errorf("database not initialized")
+	}
+	defer timeQuery("GetAPITokenByHash", time.Now())
+	t, err := scanAPIToken(db.QueryRow(
+		apiTokenSelect+` WHERE token_hash = ? AND (expires_at IS NULL OR julianday(expires_at) >= julianday('now'))`,
+		tokenHash,
+	))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("api token: %w", ErrNotFound)
+		}
+		return nil, fmt.Errorf("query api token: %w", err)
+	}
+	return &t, nil
+}
+
+// TouchAPIToken records that a personal access token was just used.
+func TouchAPIToken(id string) error {
+	if db == nil {
+		return fmt.Errorf("database not initialized")
+	}
+	defer timeQuery("TouchAPIToken", time.Now())
+	if _, err := db.Exec(`UPDATE ApiToken SET last_used_at = ? WHERE id = ?`, time.Now(), id); err != nil {
+		return fmt.Errorf("touch api token: %w", err)
+	}
+	return nil
+}
+
+// DeleteAPIToken revokes one of a user's personal access tokens.
+func DeleteAPIToken(userID, id string) error {
+	if db == nil {
+		return fmt.Errorf("database not initialized")
+	}
+	defer timeQuery("DeleteAPIToken", time.Now())
+	res, err := db.Exec(`DELETE FROM ApiToken WHERE id = ? AND user_id = ?`, id, userID)
+	if err != nil {
+		return fmt.Errorf("delete api token: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf("api token %s: %w", id, ErrNotFound)
+	}
+	return nil
+}
@@ -0,0 +1,184 @@
+package database
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Audit actions. Changes to library data are "<kind>.<create|update|delete>",
+// so filtering by a kind alone (e.g. "note") matches all of its changes.
+const (
+	AuditLoginSuccess       = "login.success"
+	AuditLoginFailure       = "login.failure"
+	AuditSessionLogout      = "session.logout"
+	AuditSessionRevoke      = "session.revoke"
+	AuditSessionRevokeOther = "session.revoke_others"
+	AuditNoteCreate         = "note.create"
+	AuditNoteUpdate         = "note.update"
+	AuditNoteDelete         = "note.delete"
+	AuditLinkCreate         = "link.create"
+	AuditLinkDelete         = "link.delete"
+	AuditMediaCreate        = "media.create"
+	AuditMediaUpdate        = "media.update"
+	AuditMediaDelete        = "media.delete"
+	AuditShareCreate        = "share.create"
+	AuditShareDelete        = "share.delete"
+	AuditUserCreate         = "user.create"
+	AuditUserUpdate         = "user.update"
+	AuditUserPasswordReset  = "user.password_reset"
+	AuditUserTwoFactorReset = "user.2fa_reset"
+	AuditUserLink           = "user.link"
+)
+
+// AuditEntry is one record in the append-only audit log. Before and After
+// are JSON snapshots of the target, empty where it did not exist.
+type AuditEntry struct {
+	ID        int64           `json:"id"`
+	ActorID   string          `json:"actorId,omitempty"`
+	Username  string          `json:"username,omitempty"`
+	Action    string          `json:"action"`
+	TargetID  string          `json:"targetId,omitempty"`
+	IP        string          `json:"ip"`
+	UserAgent string          `json:"userAgent"`
+	Detail    string          `json:"detail,omitempty"`
+	Before    json.RawMessage `json:"before,omitempty"`
+	After     json.RawMessage `json:"after,omitempty"`
+	CreatedAt string          `json:"createdAt"`
+}
+
+// AuditFilter selects audit entries. Zero fields match everything.
+type AuditFilter struct {
+	// Actor matches the acting user's ID or username.
+	Actor string
+	// Action matches an action exactly or, without a dot, every action of
+	// that kind.
+	Action   string
+	TargetID string
+	Since    *time.Time
+	Until    *time.Time
+	// BeforeID pages back from an earlier result's last ID.
+	BeforeID int64
+	Limit    int
+}
+
+// AddAuditEntry appends e to the audit log. ID and CreatedAt are assigned.
+// Username is only needed where there is no ActorID, as for failed logins.
+func AddAuditEntry(e AuditEntry) error {
+	if db == nil {
+		return fmt.Errorf("database not initialized")
+	}
+	defer timeQuery("AddAuditEntry", time.Now())
+	_, err := db.Exec(
+		`INSERT INTO AuditLog (actor_id, username, action, target_id, ip, user_agent, detail, before, after, createdAt)
+		 VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		e.ActorID, e.Username, e.Action, e.TargetID, e.IP, e.UserAgent, e.Detail,
+		nullJSON(e.Before), nullJSON(e.After), time.Now(),
+	)
+	if err != nil {
+		return fmt.Errorf("insert audit entry: %w", err)
+	}
+	return nil
+}
+
+// GetAuditEntries returns up to f.Limit entries matching f, newest first.
+func GetAuditEntries(f AuditFilter) ([]AuditEntry, error) {
+	defer timeQuery("GetAuditEntries", time.Now())
+	entries := []AuditEntry{}
+	err := queryAuditEntries(f, "DESC", func(e AuditEntry) error {
+		entries = append(entries, e)
+		return nil
+	})
+	return entries, err
+}
+
+// ExportAuditEntries calls fn for every entry matching f, oldest first,
+// ignoring f.Limit. Entries are streamed, so fn must not use the database.
+func ExportAuditEntries(f AuditFilter, fn func(AuditEntry) error) error {
+	f.Limit = 0
+	return queryAuditEntries(f, "ASC", fn)
+}
+
+func queryAuditEntries(f AuditFilter, order string, fn func(AuditEntry) error) error {
+	if db == nil {
+		return fmt.Errorf("database not initialized")
+	}
+
+	var where []string
+	var args []any
+	if f.Actor != "" {
+		where = append(where, `(a.actor_id = ? OR COALESCE(NULLIF(a.username, ''), u.username) = ? COLLATE NOCASE)`)
+		args = append(args, f.Actor, f.Actor)
+	}
+	if f.Action != "" {
+		if strings.Contains(f.Action, ".") {
+			where = append(where, `a.action = ?`)
+			args = append(args, f.Action)
+		} else {
+			where = append(where, `a.action LIKE ? || '.%'`)
+			args = append(args, f.Action)
+		}
+	}
+	if f.TargetID != "" {
+		where = append(where, `a.target_id = ?`)
+		args = append(args, f.TargetID)
+	}
+	if f.Since != nil {
+		where = append(where, `julianday(a.createdAt) >= julianday(?)`)
+		args = append(args, *f.Since)
+	}
+	if f.Until != nil {
+		where = append(where, `julianday(a.createdAt) < julianday(?)`)
+		args = append(args, *f.Until)
+	}
+	if f.BeforeID > 0 {
+		where = append(where, `a.id < ?`)
+		args = append(args, f.BeforeID)
+	}
+
+	query := `SELECT a.id, a.actor_id, COALESCE(NULLIF(a.username, ''), u.username, ''), a.action, a.target_id,
+		a.ip, a.user_agent, a.detail, COALESCE(a.before, ''), COALESCE(a.after, ''), a.createdAt
+		FROM AuditLog a LEFT JOIN User u ON u.id = a.actor_id`
+	if len(where) > 0 {
+		query += ` WHERE ` + strings.Join(where, ` AND `)
+	}
+	query += ` ORDER BY a.id ` + order
+	if f.Limit > 0 {
+		query += ` LIMIT ?`
+		args = append(args, f.Limit)
+	}
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return fmt.Errorf("query audit log: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var e AuditEntry
+		var before, after string
+		if err := rows.Scan(&e.ID, &e.ActorID, &e.Username, &e.Action, &e.TargetID,
+			&e.IP, &e.UserAgent, &e.Detail, &before, &after, &e.CreatedAt); err != nil {
+			return fmt.Errorf("scan audit entry: %w", err)
+		}
+		if before != "" {
+			e.Before = json.RawMessage(before)
+		}
+		if after != "" {
+			e.After = json.RawMessage(after)
+		}
+		if err := fn(e); err != nil {
+			return err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("query audit log: %w", err)
+	}
+	return nil
+}
+
+func nullJSON(b json.RawMessage) sql.NullString {
+	return sql.NullString{String: string(b), Valid: len(b) > 0}
+}
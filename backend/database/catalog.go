@@ -0,0 +1,373 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// CatalogItem represents a title in the media catalog (movie, series, season,
+// episode, book or album). Seasons and episodes point at their parent through ParentID.
+type CatalogItem struct {
+	ID        string   `json:"id"`
+	Kind      string   `json:"kind"`
+	Title     string   `json:"title"`
+	Year      int      `json:"year,omitempty"`
+	ParentID  string   `json:"parentId,omitempty"`
+	Season    int      `json:"season,omitempty"`
+	Episode   int      `json:"episode,omitempty"`
+	MediaIDs  []string `json:"mediaIds"`
+	LinkIDs   []string `json:"linkIds"`
+	CreatedAt string   `json:"createdAt"`
+	UpdatedAt string   `json:"updatedAt"`
+}
+
+const catalogColumns = `id, kind, title, year, parent_id, season, episode, createdAt, updatedAt`
+
+func scanCatalogItem(row interface{ Scan(...any) error }) (CatalogItem, error) {
+	var c CatalogItem
+	var parent sql.NullString
+	err := row.Scan(&c.ID, &c.Kind, &c.Title, &c.Year, &parent, &c.Season, &c.Episode, &c.CreatedAt, &c.UpdatedAt)
+	c.ParentID = parent.String
+	c.MediaIDs = []string{}
+	c.LinkIDs = []string{}
+	return c, err
+}
+
+// ErrCatalogParent is returned when a catalog entry's kind does not fit
+// under its parent's, or its children's kinds no longer fit under it.
+var ErrCatalogParent = errors.New("catalog kind does not fit the parent")
+
+// ErrCatalogCycle is returned when a catalog entry would become its own
+// ancestor.
+var ErrCatalogCycle = errors.New("catalog item would be its own ancestor")
+
+// CatalogParentFits reports whether an entry of kind may sit under a parent
+// of parentKind: seasons under series, episodes under seasons or directly
+// under series. Other kinds stand alone.
+func CatalogParentFits(kind, parentKind string) bool {
+	switch kind {
+	case "season":
+		return parentKind == "series"
+	case "episode":
+		return parentKind == "season" || parentKind == "series"
+	}
+	return false
+}
+
+// checkCatalogParent checks the parent of item belongs to ownerID and is of a
+// kind item fits under.
+func checkCatalogParent(ownerID string, item CatalogItem) error {
+	var kind string
+	err := db.QueryRow(`SELECT kind FROM CatalogItem WHERE id = ? AND owner_id = ?`, item.ParentID, ownerID).Scan(&kind)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("catalog item %s: %w", item.ParentID, ErrNotFound)
+	}
+	if err != nil {
+		return fmt.Errorf("query catalog parent: %w", err)
+	}
+	if !CatalogParentFits(item.Kind, kind) {
+		return fmt.Errorf("%s under %s: %w", item.Kind, kind, ErrCatalogParent)
+	}
+	return nil
+}
+
+// nullString maps an empty string to NULL so optional foreign keys stay valid.
+func nullString(s string) any {
+	if s == "" {
+		return nil
+	}
+	return s
+}
+
+// AddCatalogItem inserts a catalog entry owned by ownerID. The parent, if
+// any, must belong to the same owner and be of a kind the entry fits under.
+// Returns the new record ID.
+func AddCatalogItem(ownerID string, item CatalogItem) (string, error) {
+	if db == nil {
+		return "", fmt.Errorf("database not initialized")
+	}
+	defer timeQuery("AddCatalogItem", time.Now())
+	if item.ParentID != "" {
+		if err := checkCatalogParent(ownerID, item); err != nil {
+			return "", err
+		}
+	}
+	id := uuid.New().String()
+	_, err := db.Exec(
+		`INSERT INTO CatalogItem (id, owner_id, kind, title, year, parent_id, season, episode, createdAt, updatedAt)
+		 VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		id, ownerID, item.Kind, item.Title, item.Year, nullString(item.ParentID), item.Season, item.Episode, time.Now(), time.Now(),
+	)
+	if err != nil {
+		return "", fmt.Errorf("insert catalog item: %w", err)
+	}
+	return id, nil
+}
+
+// GetCatalogItems retrieves catalog entries of ownerID, optionally filtered by kind and parent.
+func GetCatalogItems(ownerID, kind, parentID string) ([]CatalogItem, error) {
+	if db == nil {
+		return nil, fmt.Errorf("database not initialized")
+	}
+	defer timeQuery("GetCatalogItems", time.Now())
+
+	where := []string{"owner_id = ?"}
+	args := []any{ownerID}
+	if kind != "" {
+		where = append(where, "kind = ?")
+		args = append(args, kind)
+	}
+	if parentID != "" {
+		where = append(where, "parent_id = ?")
+		args = append(args, parentID)
+	}
+	query := `SELECT ` + catalogColumns + ` FROM CatalogItem WHERE ` + strings.Join(where, " AND ") + ` ORDER BY title, season, episode`
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("query catalog: %w", err)
+	}
+	defer rows.Close()
+
+	var items []CatalogItem
+	byID := map[string]int{}
+	for rows.Next() {
+		c, err := scanCatalogItem(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scan catalog item: %w", err)
+		}
+		byID[c.ID] = len(items)
+		items = append(items, c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("query catalog: %w", err)
+	}
+	if len(items) == 0 {
+		return items, nil
+	}
+
+	if err := attachCatalogRefs(items, byID, `SELECT r.item_id, r.media_id FROM CatalogMedia r JOIN CatalogItem c ON c.id = r.item_id WHERE c.owner_id = ?`, []any{ownerID}, func(c *CatalogItem, id string) {
+		c.MediaIDs = append(c.MediaIDs, id)
+	}); err != nil {
+		return nil, err
+	}
+	if err := attachCatalogRefs(items, byID, `SELECT r.item_id, r.link_id FROM CatalogLink r JOIN CatalogItem c ON c.id = r.item_id WHERE c.owner_id = ?`, []any{ownerID}, func(c *CatalogItem, id string) {
+		c.LinkIDs = append(c.LinkIDs, id)
+	}); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+// attachCatalogRefs fills media/link IDs of the already loaded items from a join table.
+func attachCatalogRefs(items []CatalogItem, byID map[string]int, query string, args []any, add func(*CatalogItem, string)) error {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return fmt.Errorf("query catalog refs: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var itemID, refID string
+		if err := rows.Scan(&itemID, &refID); err != nil {
+			return fmt.Errorf("scan catalog ref: %w", err)
+		}
+		if i, ok := byID[itemID]; ok {
+			add(&items[i], refID)
+		}
+	}
+	return rows.Err()
+}
+
+// GetCatalogItem retrieves a single catalog entry of ownerID with its media and link IDs.
+func GetCatalogItem(ownerID, id string) (*CatalogItem, error) {
+	if db == nil {
+		return nil, fmt.Errorf("database not initialized")
+	}
+	defer timeQuery("GetCatalogItem", time.Now())
+
+	c, err := scanCatalogItem(db.QueryRow(`SELECT `+catalogColumns+` FROM CatalogItem WHERE id = ? AND owner_id = ?`, id, ownerID))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("catalog item %s: %w", id, ErrNotFound)
+		}
+		return nil, fmt.Errorf("query catalog item: %w", err)
+	}
+
+	byID := map[string]int{c.ID: 0}
+	items := []CatalogItem{c}
+	if err := attachCatalogRefs(items, byID, `SELECT item_id, media_id FROM CatalogMedia WHERE item_id = ?`, []any{id}, func(c *CatalogItem, id string) {
+		c.MediaIDs = append(c.MediaIDs, id)
+	}); err != nil {
+		return nil, err
+	}
+	if err := attachCatalogRefs(items, byID, `SELECT item_id, link_id FROM CatalogLink WHERE item_id = ?`, []any{id}, func(c *CatalogItem, id string) {
+		c.LinkIDs = append(c.LinkIDs, id)
+	}); err != nil {
+		return nil, err
+	}
+	return &items[0], nil
+}
+
+// UpdateCatalogItem replaces the descriptive fields of a catalog entry of
+// ownerID and refreshes updatedAt. The new parent is checked as in
+// AddCatalogItem and must not be the entry itself or one of its descendants;
+// the entry's children must still fit under its new kind.
+func UpdateCatalogItem(ownerID string, item CatalogItem) (CatalogItem, error) {
+	if db == nil {
+		return CatalogItem{}, fmt.Errorf("database not initialized")
+	}
+	defer timeQuery("UpdateCatalogItem", time.Now())
+	if item.ParentID != "" {
+		if err := checkCatalogParent(ownerID, item); err != nil {
+			return CatalogItem{}, err
+		}
+		// walk up from the new parent; meeting the item means a cycle
+		var cycle bool
+		err := db.QueryRow(
+			`WITH RECURSIVE ancestor(id) AS (
+				SELECT ?
+				UNION
+				SELECT c.parent_id FROM CatalogItem c JOIN ancestor a ON c.id = a.id
+				WHERE c.owner_id = ? AND c.parent_id IS NOT NULL
+			)
+			SELECT EXISTS (SELECT 1 FROM ancestor WHERE id = ?)`,
+			item.ParentID, ownerID, item.ID,
+		).Scan(&cycle)
+		if err != nil {
+			return CatalogItem{}, fmt.Errorf("query catalog ancestors: %w", err)
+		}
+		if cycle {
+			return CatalogItem{}, fmt.Errorf("catalog item %s under %s: %w", item.ID, item.ParentID, ErrCatalogCycle)
+		}
+	}
+	rows, err := db.Query(`SELECT DISTINCT kind FROM CatalogItem WHERE parent_id = ? AND owner_id = ?`, item.ID, ownerID)
+	if err != nil {
+		return CatalogItem{}, fmt.Errorf("query catalog children: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var kind string
+		if err := rows.Scan(&kind); err != nil {
+			return CatalogItem{}, fmt.Errorf("scan catalog child: %w", err)
+		}
+		if !CatalogParentFits(kind, item.Kind) {
+			return CatalogItem{}, fmt.Errorf("%s under %s: %w", kind, item.Kind, ErrCatalogParent)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return CatalogItem{}, fmt.Errorf("query catalog children: %w", err)
+	}
+	rows.Close()
+
+	res, err := db.Exec(
+		`UPDATE CatalogItem SET kind = ?, title = ?, year = ?, parent_id = ?, season = ?, episode = ?, updatedAt = ? WHERE id = ? AND owner_id = ?`,
+		item.Kind, item.Title, item.Year, nullString(item.ParentID), item.Season, item.Episode, time.Now(), item.ID, ownerID,
+	)
+	if err != nil {
+		return CatalogItem{}, fmt.Errorf("update catalog item: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return CatalogItem{}, fmt.Errorf("catalog item %s: %w", item.ID, ErrNotFound)
+	}
+
+	updated, err := GetCatalogItem(ownerID, item.ID)
+	if err != nil {
+		return CatalogItem{}, err
+	}
+	return *updated, nil
+}
+
+// DeleteCatalogItem removes a catalog entry of ownerID by ID. Child seasons
+// and episodes are removed with it.
+func DeleteCatalogItem(ownerID, id string) error {
+	if db == nil {
+		return fmt.Errorf("database not initialized")
+	}
+	defer timeQuery("DeleteCatalogItem", time.Now())
+	res, err := db.Exec(`DELETE FROM CatalogItem WHERE id = ? AND owner_id = ?`, id, ownerID)
+	if err != nil {
+		return fmt.Errorf("delete catalog item: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf("catalog item %s: %w", id, ErrNotFound)
+	}
+	return nil
+}
+
+// AttachCatalogMedia links a media file to a catalog entry; both must belong
+// to ownerID. Attaching twice is a no-op.
+func AttachCatalogMedia(ownerID, itemID, mediaID string) error {
+	if db == nil {
+		return fmt.Errorf("database not initialized")
+	}
+	defer timeQuery("AttachCatalogMedia", time.Now())
+	if err := checkOwner(db, "CatalogItem", ownerID, itemID); err != nil {
+		return err
+	}
+	if err := checkOwner(db, "Media", ownerID, mediaID); err != nil {
+		return err
+	}
+	_, err := db.Exec(`INSERT OR IGNORE INTO CatalogMedia (item_id, media_id) VALUES (?, ?)`, itemID, mediaID)
+	if err != nil {
+		return fmt.Errorf("attach catalog media: %w", err)
+	}
+	return nil
+}
+
+// DetachCatalogMedia removes the link between a media file and a catalog entry.
+func DetachCatalogMedia(ownerID, itemID, mediaID string) error {
+	if db == nil {
+		return fmt.Errorf("database not initialized")
+	}
+	defer timeQuery("DetachCatalogMedia", time.Now())
+	if err := checkOwner(db, "CatalogItem", ownerID, itemID); err != nil {
+		return err
+	}
+	_, err := db.Exec(`DELETE FROM CatalogMedia WHERE item_id = ? AND media_id = ?`, itemID, mediaID)
+	if err != nil {
+		return fmt.Errorf("detach catalog media: %w", err)
+	}
+	return nil
+}
+
+// AttachCatalogLink links a saved link to a catalog entry; both must belong
+// to ownerID. Attaching twice is a no-op.
+func AttachCatalogLink(ownerID, itemID, linkID string) error {
+	if db == nil {
+		return fmt.Errorf("database not initialized")
+	}
+	defer timeQuery("AttachCatalogLink", time.Now())
+	if err := checkOwner(db, "CatalogItem", ownerID, itemID); err != nil {
+		return err
+	}
+	if err := checkOwner(db, "Link", ownerID, linkID); err != nil {
+		return err
+	}
+	_, err := db.Exec(`INSERT OR IGNORE INTO CatalogLink (item_id, link_id) VALUES (?, ?)`, itemID, linkID)
+	if err != nil {
+		return fmt.Errorf("attach catalog link: %w", err)
+	}
+	return nil
+}
+
+// DetachCatalogLink removes the link between a saved link and a catalog entry.
+func DetachCatalogLink(ownerID, itemID, linkID string) error {
+	if db == nil {
+		return fmt.Errorf("database not initialized")
+	}
+	defer timeQuery("DetachCatalogLink", time.Now())
+	if err := checkOwner(db, "CatalogItem", ownerID, itemID); err != nil {
+		return err
+	}
+	_, err := db.Exec(`DELETE FROM CatalogLink WHERE item_id = ? AND link_id = ?`, itemID, linkID)
+	if err != nil {
+		return fmt.Errorf("detach catalog link: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,388 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"media_management_go/backend/ordering"
+
+	"github.com/google/uuid"
+)
+
+// Collection kinds.
+const (
+	CollectionAlbum    = "album"
+	CollectionPlaylist = "playlist"
+)
+
+// ErrMediaKind is returned when a file type does not belong in a collection
+// of the requested kind.
+var ErrMediaKind = errors.New("media type does not fit the collection")
+
+// MediaFitsCollection reports whether a file type belongs in an album (photos
+// and clips) or a playlist (audio and video).
+func MediaFitsCollection(kind, mimeType string) bool {
+	switch kind {
+	case CollectionAlbum:
+		return strings.HasPrefix(mimeType, "image/") || strings.HasPrefix(mimeType, "video/")
+	case CollectionPlaylist:
+		return strings.HasPrefix(mimeType, "audio/") || strings.HasPrefix(mimeType, "video/")
+	}
+	return false
+}
+
+// Collection represents an ordered photo album or audio/video playlist.
+type Collection struct {
+	ID           string `json:"id"`
+	Kind         string `json:"kind"`
+	Name         string `json:"name"`
+	CoverMediaID string `json:"coverMediaId,omitempty"`
+	ItemCount    int    `json:"itemCount"`
+	CreatedAt    string `json:"createdAt"`
+	UpdatedAt    string `json:"updatedAt"`
+}
+
+// CollectionItem is one media entry of a collection. Items are ordered by
+// Position, a fractional index key.
+type CollectionItem struct {
+	ID        string `json:"id"`
+	MediaID   string `json:"mediaId"`
+	Filename  string `json:"filename"`
+	MimeType  string `json:"mimeType"`
+	Size      int64  `json:"size"`
+	Path      string `json:"-"`
+	Position  string `json:"position"`
+	CreatedAt string `json:"createdAt"`
+}
+
+const collectionSelect = `SELECT c.id, c.kind, c.name, c.cover_media_id, c.createdAt, c.updatedAt,
+	(SELECT COUNT(*) FROM CollectionItem i WHERE i.collection_id = c.id)
+	FROM Collection c`
+
+func scanCollection(row interface{ Scan(...any) error }) (Collection, error) {
+	var c Collection
+	var cover sql.NullString
+	err := row.Scan(&c.ID, &c.Kind, &c.Name, &cover, &c.CreatedAt, &c.UpdatedAt, &c.ItemCount)
+	c.CoverMediaID = cover.String
+	return c, err
+}
+
+// AddCollection inserts an empty album or playlist owned by ownerID. Returns the new record ID.
+func AddCollection(ownerID, kind, name string) (string, error) {
+	if db == nil {
+		return "", fmt.Errorf("database not initialized")
+	}
+	defer timeQuery("AddCollection", time.Now())
+	id := uuid.New().String()
+	_, err := db.Exec(
+		`INSERT INTO Collection (id, owner_id, kind, name, createdAt, updatedAt) VALUES (?, ?, ?, ?, ?, ?)`,
+		id, ownerID, kind, name, time.Now(), time.Now(),
+	)
+	if err != nil {
+		return "", fmt.Errorf("insert collection: %w", err)
+	}
+	return id, nil
+}
+
+// GetCollections retrieves all collections of ownerID with their item counts.
+func GetCollections(ownerID string) ([]Collection, error) {
+	if db == nil {
+		return nil, fmt.Errorf("database not initialized")
+	}
+	defer timeQuery("GetCollections", time.Now())
+
+	rows, err := db.Query(collectionSelect+` WHERE c.owner_id = ? ORDER BY c.name`, ownerID)
+	if err != nil {
+		return nil, fmt.Errorf("query collections: %w", err)
+	}
+	defer rows.Close()
+
+	var collections []Collection
+	for rows.Next() {
+		c, err := scanCollection(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scan collection: %w", err)
+		}
+		collections = append(collections, c)
+	}
+	return collections, rows.Err()
+}
+
+// GetCollection retrieves a single collection of ownerID.
+func GetCollection(ownerID, id string) (*Collection, error) {
+	if db == nil {
+		return nil, fmt.Errorf("database not initialized")
+	}
+	defer timeQuery("GetCollection", time.Now())
+
+	c, err := scanCollection(db.QueryRow(collectionSelect+` WHERE c.id = ? AND c.owner_id = ?`, id, ownerID))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("collection %s: %w", id, ErrNotFound)
+		}
+		return nil, fmt.Errorf("query collection: %w", err)
+	}
+	return &c, nil
+}
+
+// UpdateCollection renames a collection of ownerID and sets (or clears, with
+// "") its cover image, which must also belong to ownerID.
+func UpdateCollection(ownerID, id, name, coverMediaID string) (Collection, error) {
+	if db == nil {
+		return Collection{}, fmt.Errorf("database not initialized")
+	}
+	defer timeQuery("UpdateCollection", time.Now())
+	if coverMediaID != "" {
+		if err := checkOwner(db, "Media", ownerID, coverMediaID); err != nil {
+			return Collection{}, err
+		}
+	}
+
+	res, err := db.Exec(
+		`UPDATE Collection SET name = ?, cover_media_id = ?, updatedAt = ? WHERE id = ? AND owner_id = ?`,
+		name, nullString(coverMediaID), time.Now(), id, ownerID,
+	)
+	if err != nil {
+		return Collection{}, fmt.Errorf("update collection: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return Collection{}, fmt.Errorf("collection %s: %w", id, ErrNotFound)
+	}
+
+	c, err := GetCollection(ownerID, id)
+	if err != nil {
+		return Collection{}, err
+	}
+	return *c, nil
+}
+
+// DeleteCollection removes a collection of ownerID and its items. Media files are kept.
+func DeleteCollection(ownerID, id string) error {
+	if db == nil {
+		return fmt.Errorf("database not initialized")
+	}
+	defer timeQuery("DeleteCollection", time.Now())
+	res, err := db.Exec(`DELETE FROM Collection WHERE id = ? AND owner_id = ?`, id, ownerID)
+	if err != nil {
+		return fmt.Errorf("delete collection: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf("collection %s: %w", id, ErrNotFound)
+	}
+	return nil
+}
+
+// GetCollectionItems retrieves the items of a collection of ownerID in order.
+func GetCollectionItems(ownerID, collectionID string) ([]CollectionItem, error) {
+	if db == nil {
+		return nil, fmt.Errorf("database not initialized")
+	}
+	defer timeQuery("GetCollectionItems", time.Now())
+
+	rows, err := db.Query(
+		`SELECT i.id, i.media_id, m.filename, m.mime_type, m.size, m.path, i.position, i.createdAt
+		 FROM CollectionItem i JOIN Media m ON m.id = i.media_id JOIN Collection c ON c.id = i.collection_id
+		 WHERE i.collection_id = ? AND c.owner_id = ? ORDER BY i.position`,
+		collectionID, ownerID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("query collection items: %w", err)
+	}
+	defer rows.Close()
+
+	var items []CollectionItem
+	for rows.Next() {
+		var i CollectionItem
+		if err := rows.Scan(&i.ID, &i.MediaID, &i.Filename, &i.MimeType, &i.Size, &i.Path, &i.Position, &i.CreatedAt); err != nil {
+			return nil, fmt.Errorf("scan collection item: %w", err)
+		}
+		items = append(items, i)
+	}
+	return items, rows.Err()
+}
+
+// AddCollectionItem places a media file in a collection right after afterID,
+// right before beforeID, or at the end when both are empty. The collection
+// and the media file must belong to ownerID, and the file type must fit the
+// collection kind (see MediaFitsCollection).
+func AddCollectionItem(ownerID, collectionID, mediaID, afterID, beforeID string) (CollectionItem, error) {
+	if db == nil {
+		return CollectionItem{}, fmt.Errorf("database not initialized")
+	}
+	defer timeQuery("AddCollectionItem", time.Now())
+
+	tx, err := db.Begin()
+	if err != nil {
+		return CollectionItem{}, fmt.Errorf("begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := checkOwner(tx, "Collection", ownerID, collectionID); err != nil {
+		return CollectionItem{}, err
+	}
+	if err := checkOwner(tx, "Media", ownerID, mediaID); err != nil {
+		return CollectionItem{}, err
+	}
+
+	var kind, mimeType string
+	err = tx.QueryRow(
+		`SELECT c.kind, m.mime_type FROM Collection c, Media m WHERE c.id = ? AND m.id = ?`,
+		collectionID, mediaID,
+	).Scan(&kind, &mimeType)
+	if err != nil {
+		return CollectionItem{}, fmt.Errorf("query collection kind: %w", err)
+	}
+	if !MediaFitsCollection(kind, mimeType) {
+		return CollectionItem{}, fmt.Errorf("%s into %s: %w", mimeType, kind, ErrMediaKind)
+	}
+
+	pos, err := positionFor(tx, collectionID, "", afterID, beforeID)
+	if err != nil {
+		return CollectionItem{}, err
+	}
+
+	id := uuid.New().String()
+	now := time.Now()
+	_, err = tx.Exec(
+		`INSERT INTO CollectionItem (id, collection_id, media_id, position, createdAt) VALUES (?, ?, ?, ?, ?)`,
+		id, collectionID, mediaID, pos, now,
+	)
+	if err != nil {
+		return CollectionItem{}, fmt.Errorf("insert collection item: %w", err)
+	}
+	if err := touchCollection(tx, collectionID); err != nil {
+		return CollectionItem{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return CollectionItem{}, fmt.Errorf("commit collection item: %w", err)
+	}
+
+	return CollectionItem{ID: id, MediaID: mediaID, Position: pos, CreatedAt: now.Format(time.RFC3339)}, nil
+}
+
+// MoveCollectionItem moves an item right after afterID, right before beforeID,
+// or to the end when both are empty. Only the moved item is rewritten.
+func MoveCollectionItem(ownerID, collectionID, itemID, afterID, beforeID string) (CollectionItem, error) {
+	if db == nil {
+		return CollectionItem{}, fmt.Errorf("database not initialized")
+	}
+	defer timeQuery("MoveCollectionItem", time.Now())
+
+	tx, err := db.Begin()
+	if err != nil {
+		return CollectionItem{}, fmt.Errorf("begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := checkOwner(tx, "Collection", ownerID, collectionID); err != nil {
+		return CollectionItem{}, err
+	}
+
+	var mediaID string
+	err = tx.QueryRow(`SELECT media_id FROM CollectionItem WHERE id = ? AND collection_id = ?`, itemID, collectionID).Scan(&mediaID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return CollectionItem{}, fmt.Errorf("collection item %s: %w", itemID, ErrNotFound)
+		}
+		return CollectionItem{}, fmt.Errorf("query collection item: %w", err)
+	}
+
+	pos, err := positionFor(tx, collectionID, itemID, afterID, beforeID)
+	if err != nil {
+		return CollectionItem{}, err
+	}
+
+	if _, err := tx.Exec(`UPDATE CollectionItem SET position = ? WHERE id = ?`, pos, itemID); err != nil {
+		return CollectionItem{}, fmt.Errorf("move collection item: %w", err)
+	}
+	if err := touchCollection(tx, collectionID); err != nil {
+		return CollectionItem{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return CollectionItem{}, fmt.Errorf("commit collection item: %w", err)
+	}
+
+	return CollectionItem{ID: itemID, MediaID: mediaID, Position: pos}, nil
+}
+
+// DeleteCollectionItem removes an item from a collection of ownerID.
+func DeleteCollectionItem(ownerID, collectionID, itemID string) error {
+	if db == nil {
+		return fmt.Errorf("database not initialized")
+	}
+	defer timeQuery("DeleteCollectionItem", time.Now())
+	res, err := db.Exec(
+		`DELETE FROM CollectionItem WHERE id = ? AND collection_id = ?
+		 AND collection_id IN (SELECT id FROM Collection WHERE owner_id = ?)`,
+		itemID, collectionID, ownerID,
+	)
+	if err != nil {
+		return fmt.Errorf("delete collection item: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf("collection item %s: %w", itemID, ErrNotFound)
+	}
+	return nil
+}
+
+// positionFor computes the order key for placing an item (excluding itself,
+// when moving) after afterID or before beforeID.
+func positionFor(tx *sql.Tx, collectionID, self, afterID, beforeID string) (string, error) {
+	var lo, hi sql.NullString
+	var err error
+
+	switch {
+	case afterID != "":
+		if lo.String, err = itemPosition(tx, collectionID, afterID); err != nil {
+			return "", err
+		}
+		err = tx.QueryRow(
+			`SELECT MIN(position) FROM CollectionItem WHERE collection_id = ? AND position > ? AND id != ?`,
+			collectionID, lo.String, self,
+		).Scan(&hi)
+	case beforeID != "":
+		if hi.String, err = itemPosition(tx, collectionID, beforeID); err != nil {
+			return "", err
+		}
+		err = tx.QueryRow(
+			`SELECT MAX(position) FROM CollectionItem WHERE collection_id = ? AND position < ? AND id != ?`,
+			collectionID, hi.String, self,
+		).Scan(&lo)
+	default:
+		err = tx.QueryRow(
+			`SELECT MAX(position) FROM CollectionItem WHERE collection_id = ? AND id != ?`,
+			collectionID, self,
+		).Scan(&lo)
+	}
+	if err != nil {
+		return "", fmt.Errorf("query collection positions: %w", err)
+	}
+
+	pos, err := ordering.KeyBetween(lo.String, hi.String)
+	if err != nil {
+		return "", fmt.Errorf("compute position: %w", err)
+	}
+	return pos, nil
+}
+
+func itemPosition(tx *sql.Tx, collectionID, itemID string) (string, error) {
+	var pos string
+	err := tx.QueryRow(`SELECT position FROM CollectionItem WHERE id = ? AND collection_id = ?`, itemID, collectionID).Scan(&pos)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", fmt.Errorf("collection item %s: %w", itemID, ErrNotFound)
+	}
+	if err != nil {
+		return "", fmt.Errorf("query collection item: %w", err)
+	}
+	return pos, nil
+}
+
+func touchCollection(tx *sql.Tx, id string) error {
+	if _, err := tx.Exec(`UPDATE Collection SET updatedAt = ? WHERE id = ?`, time.Now(), id); err != nil {
+		return fmt.Errorf("touch collection: %w", err)
+	}
+	return nil
+}
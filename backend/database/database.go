@@ -1,7 +1,9 @@
 package database
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"log"
 	"log/slog"
@@ -13,6 +15,29 @@ import (
 
 var db *sql.DB
 
+// ErrNotFound is returned when a lookup by ID matches no record. Records
+// owned by another user are reported the same way.
+var ErrNotFound = errors.New("record not found")
+
+// ownedTables hold per-user library data in an owner_id column. Progress,
+// CatalogMedia, CatalogLink and CollectionItem rows belong to the owner of
+// their catalog item or collection.
+var ownedTables = []string{"Note", "Link", "Media", "CatalogItem", "Collection"}
+
+// checkOwner returns ErrNotFound unless the row id of table belongs to ownerID.
+func checkOwner(q interface {
+	QueryRow(query string, args ...any) *sql.Row
+}, table, ownerID, id string) error {
+	var n int
+	if err := q.QueryRow(`SELECT COUNT(*) FROM `+table+` WHERE id = ? AND owner_id = ?`, id, ownerID).Scan(&n); err != nil {
+		return fmt.Errorf("query %s owner: %w", table, err)
+	}
+	if n == 0 {
+		return fmt.Errorf("%s %s: %w", table, id, ErrNotFound)
+	}
+	return nil
+}
+
 // MustOpen opens (and initializes) the database. Logs fatal on any error.
 func MustOpen(path string) {
 	if db != nil {
@@ -51,6 +76,158 @@ func MustOpen(path string) {
 			createdAt DATETIME DEFAULT CURRENT_TIMESTAMP,
 			updatedAt DATETIME DEFAULT CURRENT_TIMESTAMP
 		);`,
+		`CREATE TABLE IF NOT EXISTS Media (
+			id TEXT PRIMARY KEY,
+			filename TEXT NOT NULL,
+			path TEXT NOT NULL,
+			mime_type TEXT NOT NULL,
+			size INTEGER NOT NULL DEFAULT 0,
+			createdAt DATETIME DEFAULT CURRENT_TIMESTAMP,
+			updatedAt DATETIME DEFAULT CURRENT_TIMESTAMP
+		);`,
+		`CREATE TABLE IF NOT EXISTS CatalogItem (
+			id TEXT PRIMARY KEY,
+			kind TEXT NOT NULL,
+			title TEXT NOT NULL,
+			year INTEGER NOT NULL DEFAULT 0,
+			parent_id TEXT REFERENCES CatalogItem(id) ON DELETE CASCADE,
+			season INTEGER NOT NULL DEFAULT 0,
+			episode INTEGER NOT NULL DEFAULT 0,
+			createdAt DATETIME DEFAULT CURRENT_TIMESTAMP,
+			updatedAt DATETIME DEFAULT CURRENT_TIMESTAMP
+		);`,
+		`CREATE TABLE IF NOT EXISTS CatalogMedia (
+			item_id TEXT NOT NULL REFERENCES CatalogItem(id) ON DELETE CASCADE,
+			media_id TEXT NOT NULL REFERENCES Media(id) ON DELETE CASCADE,
+			PRIMARY KEY (item_id, media_id)
+		);`,
+		`CREATE TABLE IF NOT EXISTS CatalogLink (
+			item_id TEXT NOT NULL REFERENCES CatalogItem(id) ON DELETE CASCADE,
+			link_id TEXT NOT NULL REFERENCES Link(id) ON DELETE CASCADE,
+			PRIMARY KEY (item_id, link_id)
+		);`,
+		`CREATE TABLE IF NOT EXISTS Progress (
+			item_id TEXT PRIMARY KEY REFERENCES CatalogItem(id) ON DELETE CASCADE,
+			status TEXT NOT NULL DEFAULT 'planned'
+				CHECK (status IN ('planned', 'in_progress', 'completed', 'dropped')),
+			position INTEGER NOT NULL DEFAULT 0,
+			duration INTEGER NOT NULL DEFAULT 0,
+			page INTEGER NOT NULL DEFAULT 0,
+			started_at DATETIME,
+			finished_at DATETIME,
+			rating INTEGER CHECK (rating IS NULL OR rating BETWEEN 1 AND 10),
+			review TEXT NOT NULL DEFAULT '',
+			createdAt DATETIME DEFAULT CURRENT_TIMESTAMP,
+			updatedAt DATETIME DEFAULT CURRENT_TIMESTAMP
+		);`,
+		`CREATE TABLE IF NOT EXISTS Collection (
+			id TEXT PRIMARY KEY,
+			kind TEXT NOT NULL CHECK (kind IN ('album', 'playlist')),
+			name TEXT NOT NULL,
+			cover_media_id TEXT REFERENCES Media(id) ON DELETE SET NULL,
+			createdAt DATETIME DEFAULT CURRENT_TIMESTAMP,
+			updatedAt DATETIME DEFAULT CURRENT_TIMESTAMP
+		);`,
+		`CREATE TABLE IF NOT EXISTS CollectionItem (
+			id TEXT PRIMARY KEY,
+			collection_id TEXT NOT NULL REFERENCES Collection(id) ON DELETE CASCADE,
+			media_id TEXT NOT NULL REFERENCES Media(id) ON DELETE CASCADE,
+			position TEXT NOT NULL,
+			createdAt DATETIME DEFAULT CURRENT_TIMESTAMP,
+			UNIQUE (collection_id, position)
+		);`,
+		`CREATE TABLE IF NOT EXISTS User (
+			id TEXT PRIMARY KEY,
+			username TEXT NOT NULL UNIQUE COLLATE NOCASE,
+			password_hash TEXT NOT NULL,
+			is_admin BOOLEAN NOT NULL DEFAULT 0,
+			disabled BOOLEAN NOT NULL DEFAULT 0,
+			createdAt DATETIME DEFAULT CURRENT_TIMESTAMP,
+			updatedAt DATETIME DEFAULT CURRENT_TIMESTAMP
+		);`,
+		`CREATE TABLE IF NOT EXISTS RefreshToken (
+			id TEXT PRIMARY KEY,
+			session_id TEXT NOT NULL REFERENCES Session(id) ON DELETE CASCADE,
+			token_hash TEXT NOT NULL UNIQUE,
+			expires_at DATETIME NOT NULL,
+			used_at DATETIME,
+			createdAt DATETIME DEFAULT CURRENT_TIMESTAMP
+		);`,
+		`CREATE TABLE IF NOT EXISTS ApiToken (
+			id TEXT PRIMARY KEY,
+			user_id TEXT NOT NULL REFERENCES User(id) ON DELETE CASCADE,
+			name TEXT NOT NULL,
+			token_hash TEXT NOT NULL UNIQUE,
+			scopes TEXT NOT NULL,
+			expires_at DATETIME,
+			last_used_at DATETIME,
+			createdAt DATETIME DEFAULT CURRENT_TIMESTAMP
+		);`,
+		`CREATE TABLE IF NOT EXISTS RecoveryCode (
+			user_id TEXT NOT NULL REFERENCES User(id) ON DELETE CASCADE,
+			code_hash TEXT NOT NULL,
+			PRIMARY KEY (user_id, code_hash)
+		);`,
+		`CREATE TABLE IF NOT EXISTS LoginAttempt (
+			id TEXT PRIMARY KEY,
+			username TEXT NOT NULL COLLATE NOCASE,
+			ip TEXT NOT NULL,
+			user_agent TEXT NOT NULL DEFAULT '',
+			success BOOLEAN NOT NULL,
+			reason TEXT NOT NULL DEFAULT '',
+			createdAt DATETIME DEFAULT CURRENT_TIMESTAMP
+		);`,
+		`CREATE INDEX IF NOT EXISTS idx_loginattempt_username ON LoginAttempt(username, createdAt)`,
+		`CREATE INDEX IF NOT EXISTS idx_loginattempt_ip ON LoginAttempt(ip, createdAt)`,
+		`CREATE TABLE IF NOT EXISTS SigningKey (
+			id TEXT PRIMARY KEY,
+			algorithm TEXT NOT NULL,
+			private_key BLOB,
+			public_key BLOB,
+			signing BOOLEAN NOT NULL DEFAULT 0,
+			expires_at DATETIME,
+			createdAt DATETIME DEFAULT CURRENT_TIMESTAMP
+		);`,
+		// the audit log is append-only: entries are never changed or removed,
+		// so its ids only grow and work as a paging cursor. actor_id has no
+		// foreign key, since entries must outlive what they refer to.
+		`CREATE TABLE IF NOT EXISTS AuditLog (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			actor_id TEXT NOT NULL DEFAULT '',
+			username TEXT NOT NULL DEFAULT '',
+			action TEXT NOT NULL,
+			target_id TEXT NOT NULL DEFAULT '',
+			ip TEXT NOT NULL DEFAULT '',
+			user_agent TEXT NOT NULL DEFAULT '',
+			detail TEXT NOT NULL DEFAULT '',
+			before TEXT,
+			after TEXT,
+			createdAt DATETIME DEFAULT CURRENT_TIMESTAMP
+		);`,
+		`CREATE INDEX IF NOT EXISTS idx_auditlog_actor ON AuditLog(actor_id)`,
+		`CREATE INDEX IF NOT EXISTS idx_auditlog_target ON AuditLog(target_id)`,
+		`CREATE TRIGGER IF NOT EXISTS auditlog_no_update BEFORE UPDATE ON AuditLog
+		BEGIN
+			SELECT RAISE(ABORT, 'audit log is append-only');
+		END;`,
+		`CREATE TRIGGER IF NOT EXISTS auditlog_no_delete BEFORE DELETE ON AuditLog
+		BEGIN
+			SELECT RAISE(ABORT, 'audit log is append-only');
+		END;`,
+		`CREATE TABLE IF NOT EXISTS Share (
+			id TEXT PRIMARY KEY,
+			owner_id TEXT NOT NULL REFERENCES User(id) ON DELETE CASCADE,
+			note_id TEXT REFERENCES Note(id) ON DELETE CASCADE,
+			link_id TEXT REFERENCES Link(id) ON DELETE CASCADE,
+			token_hash TEXT NOT NULL UNIQUE,
+			password_hash TEXT NOT NULL DEFAULT '',
+			expires_at DATETIME,
+			max_views INTEGER NOT NULL DEFAULT 0,
+			views INTEGER NOT NULL DEFAULT 0,
+			createdAt DATETIME DEFAULT CURRENT_TIMESTAMP,
+			CHECK ((note_id IS NULL) != (link_id IS NULL))
+		);`,
+		`CREATE INDEX IF NOT EXISTS idx_share_owner ON Share(owner_id)`,
 	}
 
 	for _, stmt := range schema {
@@ -60,6 +237,11 @@ func MustOpen(path string) {
 		}
 	}
 
+	if err := migrate(d); err != nil {
+		d.Close()
+		log.Fatal("failed to migrate database:", err)
+	}
+
 	db = d
 	log.Println("database initialized successfully")
 }
@@ -73,6 +255,7 @@ func AddToken(tokenHash string) (string, error) {
 	if db == nil {
 		return "", fmt.Errorf("database not initialized")
 	}
+	defer timeQuery("AddToken", time.Now())
 	id := uuid.New().String()
 	_, err := db.Exec(
 		`INSERT INTO Session (id, token_hash, createdAt, updatedAt) VALUES (?, ?, ?, ?)`,
@@ -84,18 +267,19 @@ func AddToken(tokenHash string) (string, error) {
 	return id, nil
 }
 
-// AddNote inserts a sanitized note into Note table. Returns the new record ID.
-func AddNote(title, note string) (string, error) {
+// AddNote inserts a sanitized note owned by ownerID into Note table. Returns the new record ID.
+func AddNote(ownerID, title, note string) (string, error) {
 	if db == nil {
 		return "", fmt.Errorf("database not initialized")
 	}
+	defer timeQuery("AddNote", time.Now())
 
 	slog.Debug("Inserting Note", slog.String("Title", title), slog.String("Note", note))
 
 	id := uuid.New().String()
 	_, err := db.Exec(
-		`INSERT INTO Note (id, note, createdAt, updatedAt, title) VALUES (?, ?, ?, ?, ?)`,
-		id, note, time.Now(), time.Now(), title,
+		`INSERT INTO Note (id, owner_id, note, createdAt, updatedAt, title) VALUES (?, ?, ?, ?, ?, ?)`,
+		id, ownerID, note, time.Now(), time.Now(), title,
 	)
 	if err != nil {
 		return "", fmt.Errorf("insert note: %w", err)
@@ -103,15 +287,16 @@ func AddNote(title, note string) (string, error) {
 	return id, nil
 }
 
-// AddLink inserts a link and optional img_path into Link table. Returns the new record ID.
-func AddLink(link, imgPath string) (string, error) {
+// AddLink inserts a link and optional img_path owned by ownerID into Link table. Returns the new record ID.
+func AddLink(ownerID, link, imgPath string) (string, error) {
 	if db == nil {
 		return "", fmt.Errorf("database not initialized")
 	}
+	defer timeQuery("AddLink", time.Now())
 	id := uuid.New().String()
 	_, err := db.Exec(
-		`INSERT INTO Link (id, link, img_path, createdAt, updatedAt) VALUES (?, ?, ?, ?, ?)`,
-		id, link, imgPath, time.Now(), time.Now(),
+		`INSERT INTO Link (id, owner_id, link, img_path, createdAt, updatedAt) VALUES (?, ?, ?, ?, ?, ?)`,
+		id, ownerID, link, imgPath, time.Now(), time.Now(),
 	)
 	if err != nil {
 		return "", fmt.Errorf("insert link: %w", err)
@@ -128,10 +313,13 @@ func GetToken(tokenHash string) (*Token, error) {
 	if db == nil {
 		return nil, fmt.Errorf("database not initialized")
 	}
+	defer timeQuery("GetToken", time.Now())
 
 	var t Token
-	err := db.QueryRow(`SELECT id, token_hash, createdAt, updatedAt FROM Session WHERE token_hash = ?`, tokenHash).
-		Scan(&t.ID, &t.TokenHash, &t.CreatedAt, &t.UpdatedAt)
+	var userID sql.NullString
+	err := db.QueryRow(`SELECT id, user_id, token_hash, createdAt, updatedAt FROM Session WHERE token_hash = ?`, tokenHash).
+		Scan(&t.ID, &userID, &t.TokenHash, &t.CreatedAt, &t.UpdatedAt)
+	t.UserID = userID.String
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return nil, fmt.Errorf("token not found")
@@ -141,13 +329,14 @@ func GetToken(tokenHash string) (*Token, error) {
 	return &t, nil
 }
 
-// GetNotes retrieves all notes from the Note table.
-func GetNotes() ([]Note, error) {
+// GetNotes retrieves all notes owned by ownerID from the Note table.
+func GetNotes(ownerID string) ([]Note, error) {
 	if db == nil {
 		return nil, fmt.Errorf("database not initialized")
 	}
+	defer timeQuery("GetNotes", time.Now())
 
-	rows, err := db.Query(`SELECT id, note, createdAt, updatedAt, title FROM Note ORDER BY createdAt DESC`)
+	rows, err := db.Query(`SELECT id, note, createdAt, updatedAt, title FROM Note WHERE owner_id = ? ORDER BY createdAt DESC`, ownerID)
 	if err != nil {
 		return nil, fmt.Errorf("query notes: %w", err)
 	}
@@ -164,13 +353,33 @@ func GetNotes() ([]Note, error) {
 	return notes, nil
 }
 
-// GetLinks retrieves all links from the Link table.
-func GetLinks() ([]Link, error) {
+// GetNote retrieves a single note of ownerID.
+func GetNote(ownerID, id string) (*Note, error) {
+	if db == nil {
+		return nil, fmt.Errorf("database not initialized")
+	}
+	defer timeQuery("GetNote", time.Now())
+
+	var n Note
+	err := db.QueryRow(`SELECT id, note, createdAt, updatedAt, title FROM Note WHERE id = ? AND owner_id = ?`, id, ownerID).
+		Scan(&n.ID, &n.Note, &n.CreatedAt, &n.UpdatedAt, &n.Title)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("note %s: %w", id, ErrNotFound)
+		}
+		return nil, fmt.Errorf("query note: %w", err)
+	}
+	return &n, nil
+}
+
+// GetLinks retrieves all links owned by ownerID from the Link table.
+func GetLinks(ownerID string) ([]Link, error) {
 	if db == nil {
 		return nil, fmt.Errorf("database not initialized")
 	}
+	defer timeQuery("GetLinks", time.Now())
 
-	rows, err := db.Query(`SELECT id, link, img_path, createdAt, updatedAt FROM Link ORDER BY createdAt DESC`)
+	rows, err := db.Query(`SELECT id, link, img_path, createdAt, updatedAt FROM Link WHERE owner_id = ? ORDER BY createdAt DESC`, ownerID)
 	if err != nil {
 		return nil, fmt.Errorf("query links: %w", err)
 	}
@@ -187,23 +396,46 @@ func GetLinks() ([]Link, error) {
 	return links, nil
 }
 
+// GetLink retrieves a single link of ownerID.
+func GetLink(ownerID, id string) (*Link, error) {
+	if db == nil {
+		return nil, fmt.Errorf("database not initialized")
+	}
+	defer timeQuery("GetLink", time.Now())
+
+	var l Link
+	err := db.QueryRow(`SELECT id, link, img_path, createdAt, updatedAt FROM Link WHERE id = ? AND owner_id = ?`, id, ownerID).
+		Scan(&l.ID, &l.Link, &l.ImgPath, &l.CreatedAt, &l.UpdatedAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("link %s: %w", id, ErrNotFound)
+		}
+		return nil, fmt.Errorf("query link: %w", err)
+	}
+	return &l, nil
+}
+
 //
-// ─── UPDATE FUNCTION (NOTE ONLY) ─────────────────────────────────────────────────
+// ─── UPDATE FUNCTIONS (NOTE ONLY) ────────────────────────────────────────────────
 //
 
-// UpdateNote updates an existing note and refreshes updatedAt.
-func UpdateNote(id, newNote string) (Note, error) {
+// UpdateNote updates an existing note of ownerID and refreshes updatedAt.
+func UpdateNote(ownerID, id, newNote string) (Note, error) {
 	if db == nil {
 		return Note{}, fmt.Errorf("database not initialized")
 	}
+	defer timeQuery("UpdateNote", time.Now())
 
-	_, err := db.Exec(
-		`UPDATE Note SET note = ?, updatedAt = ? WHERE id = ?`,
-		newNote, time.Now(), id,
+	res, err := db.Exec(
+		`UPDATE Note SET note = ?, updatedAt = ? WHERE id = ? AND owner_id = ?`,
+		newNote, time.Now(), id, ownerID,
 	)
 	if err != nil {
 		return Note{}, fmt.Errorf("update note: %w", err)
 	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return Note{}, fmt.Errorf("note %s: %w", id, ErrNotFound)
+	}
 	return Note{
 		ID:        id,
 		Note:      newNote,
@@ -211,6 +443,23 @@ func UpdateNote(id, newNote string) (Note, error) {
 	}, nil
 }
 
+// RenameNote changes the title of an existing note of ownerID and refreshes updatedAt.
+func RenameNote(ownerID, id, title string) error {
+	if db == nil {
+		return fmt.Errorf("database not initialized")
+	}
+	defer timeQuery("RenameNote", time.Now())
+
+	res, err := db.Exec(`UPDATE Note SET title = ?, updatedAt = ? WHERE id = ? AND owner_id = ?`, title, time.Now(), id, ownerID)
+	if err != nil {
+		return fmt.Errorf("rename note: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf("note %s: %w", id, ErrNotFound)
+	}
+	return nil
+}
+
 //
 // ─── DELETE FUNCTIONS ─────────────────────────────────────────────────────────────
 //
@@ -220,34 +469,46 @@ func DeleteToken(id string) error {
 	if db == nil {
 		return fmt.Errorf("database not initialized")
 	}
-	_, err := db.Exec(`DELETE FROM Session WHERE id = ?`, id)
+	defer timeQuery("DeleteToken", time.Now())
+	res, err := db.Exec(`DELETE FROM Session WHERE id = ?`, id)
 	if err != nil {
 		return fmt.Errorf("delete token: %w", err)
 	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf("session %s: %w", id, ErrNotFound)
+	}
 	return nil
 }
 
-// DeleteNote removes a Note by ID.
-func DeleteNote(id string) error {
+// DeleteNote removes a Note of ownerID by ID.
+func DeleteNote(ownerID, id string) error {
 	if db == nil {
 		return fmt.Errorf("database not initialized")
 	}
-	_, err := db.Exec(`DELETE FROM Note WHERE id = ?`, id)
+	defer timeQuery("DeleteNote", time.Now())
+	res, err := db.Exec(`DELETE FROM Note WHERE id = ? AND owner_id = ?`, id, ownerID)
 	if err != nil {
 		return fmt.Errorf("delete note: %w", err)
 	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf("note %s: %w", id, ErrNotFound)
+	}
 	return nil
 }
 
-// DeleteLink removes a Link by ID.
-func DeleteLink(id string) error {
+// DeleteLink removes a Link of ownerID by ID.
+func DeleteLink(ownerID, id string) error {
 	if db == nil {
 		return fmt.Errorf("database not initialized")
 	}
-	_, err := db.Exec(`DELETE FROM Link WHERE id = ?`, id)
+	defer timeQuery("DeleteLink", time.Now())
+	res, err := db.Exec(`DELETE FROM Link WHERE id = ? AND owner_id = ?`, id, ownerID)
 	if err != nil {
 		return fmt.Errorf("delete link: %w", err)
 	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf("link %s: %w", id, ErrNotFound)
+	}
 	return nil
 }
 
@@ -276,6 +537,7 @@ type Link struct {
 // Token represents a session token record.
 type Token struct {
 	ID        string `json:"id"`
+	UserID    string `json:"userId"`
 	TokenHash string `json:"tokenHash"`
 	CreatedAt string `json:"createdAt"`
 	UpdatedAt string `json:"updatedAt"`
@@ -285,6 +547,23 @@ type Token struct {
 // ─── CLOSE ────────────────────────────────────────────────────────────────────────
 //
 
+// Ping checks the database connection still works.
+func Ping(ctx context.Context) error {
+	if db == nil {
+		return fmt.Errorf("database not initialized")
+	}
+	defer timeQuery("Ping", time.Now())
+	if err := db.PingContext(ctx); err != nil {
+		return fmt.Errorf("ping database: %w", err)
+	}
+	// a connection can be open with the file gone or unreadable
+	var n int
+	if err := db.QueryRowContext(ctx, `SELECT 1`).Scan(&n); err != nil {
+		return fmt.Errorf("query database: %w", err)
+	}
+	return nil
+}
+
 // Close safely closes the database connection.
 func Close() error {
 	if db == nil {
@@ -1,7 +1,16 @@
 package database
 
 import (
+	"context"
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
 
 	_ "github.com/mattn/go-sqlite3"
 )
@@ -21,6 +30,16 @@ func setupTestDB(t *testing.T) {
 	})
 }
 
+// addTestUser creates a user with a placeholder password hash and returns its ID.
+func addTestUser(t *testing.T, username string) string {
+	t.Helper()
+	id, err := AddUser(username, "hash", RoleEditor)
+	if err != nil {
+		t.Fatalf("AddUser(%s) failed: %v", username, err)
+	}
+	return id
+}
+
 // TestDatabaseSetup ensures that the schema initializes correctly.
 func TestDatabaseSetup(t *testing.T) {
 	setupTestDB(t)
@@ -61,15 +80,16 @@ func TestAddAndGetToken(t *testing.T) {
 // TestAddGetUpdateDeleteNote tests full CRUD lifecycle for Note.
 func TestAddGetUpdateDeleteNote(t *testing.T) {
 	setupTestDB(t)
+	owner := addTestUser(t, "alice")
 
 	// Create
-	id, err := AddNote("Test note", "Sample note text")
+	id, err := AddNote(owner, "Test note", "Sample note text")
 	if err != nil {
 		t.Fatalf("AddNote failed: %v", err)
 	}
 
 	// Read
-	notes, err := GetNotes()
+	notes, err := GetNotes(owner)
 	if err != nil {
 		t.Fatalf("GetNotes failed: %v", err)
 	}
@@ -78,22 +98,22 @@ func TestAddGetUpdateDeleteNote(t *testing.T) {
 	}
 
 	// Update
-	_, err = UpdateNote(id, "Updated note text")
+	_, err = UpdateNote(owner, id, "Updated note text")
 	if err != nil {
 		t.Fatalf("UpdateNote failed: %v", err)
 	}
 
-	notes, _ = GetNotes()
+	notes, _ = GetNotes(owner)
 	if notes[0].Note != "Updated note text" {
 		t.Errorf("note not updated, got: %s", notes[0].Note)
 	}
 
 	// Delete
-	if err := DeleteNote(id); err != nil {
+	if err := DeleteNote(owner, id); err != nil {
 		t.Fatalf("DeleteNote failed: %v", err)
 	}
 
-	notes, _ = GetNotes()
+	notes, _ = GetNotes(owner)
 	if len(notes) != 0 {
 		t.Fatalf("expected 0 notes after delete, got %d", len(notes))
 	}
@@ -102,13 +122,14 @@ func TestAddGetUpdateDeleteNote(t *testing.T) {
 // TestAddGetDeleteLink tests CRUD for Link.
 func TestAddGetDeleteLink(t *testing.T) {
 	setupTestDB(t)
+	owner := addTestUser(t, "alice")
 
-	id, err := AddLink("https://example.com", "/path/to/img.png")
+	id, err := AddLink(owner, "https://example.com", "/path/to/img.png")
 	if err != nil {
 		t.Fatalf("AddLink failed: %v", err)
 	}
 
-	links, err := GetLinks()
+	links, err := GetLinks(owner)
 	if err != nil {
 		t.Fatalf("GetLinks failed: %v", err)
 	}
@@ -117,12 +138,1214 @@ func TestAddGetDeleteLink(t *testing.T) {
 		t.Fatalf("expected one link with ID %s, got %+v", id, links)
 	}
 
-	if err := DeleteLink(id); err != nil {
+	if err := DeleteLink(owner, id); err != nil {
 		t.Fatalf("DeleteLink failed: %v", err)
 	}
 
-	links, _ = GetLinks()
+	links, _ = GetLinks(owner)
 	if len(links) != 0 {
 		t.Fatalf("expected 0 links after delete, got %d", len(links))
 	}
 }
+
+// TestCatalogHierarchyAndRefs tests catalog entries, parent cascade and attached media/links.
+func TestCatalogHierarchyAndRefs(t *testing.T) {
+	setupTestDB(t)
+	owner := addTestUser(t, "alice")
+
+	seriesID, err := AddCatalogItem(owner, CatalogItem{Kind: "series", Title: "Show Name"})
+	if err != nil {
+		t.Fatalf("AddCatalogItem(owner, series) failed: %v", err)
+	}
+	episodeID, err := AddCatalogItem(owner, CatalogItem{Kind: "episode", Title: "Show Name", ParentID: seriesID, Season: 2, Episode: 5})
+	if err != nil {
+		t.Fatalf("AddCatalogItem(owner, episode) failed: %v", err)
+	}
+
+	mediaID, err := AddMedia(owner, "Show.Name.S02E05.mkv", "stored.mkv", "video/x-matroska", 42)
+	if err != nil {
+		t.Fatalf("AddMedia failed: %v", err)
+	}
+	linkID, err := AddLink(owner, "https://example.com/show", "")
+	if err != nil {
+		t.Fatalf("AddLink failed: %v", err)
+	}
+
+	if err := AttachCatalogMedia(owner, episodeID, mediaID); err != nil {
+		t.Fatalf("AttachCatalogMedia failed: %v", err)
+	}
+	if err := AttachCatalogMedia(owner, episodeID, mediaID); err != nil {
+		t.Fatalf("AttachCatalogMedia twice failed: %v", err)
+	}
+	if err := AttachCatalogLink(owner, episodeID, linkID); err != nil {
+		t.Fatalf("AttachCatalogLink failed: %v", err)
+	}
+
+	episodes, err := GetCatalogItems(owner, "episode", seriesID)
+	if err != nil {
+		t.Fatalf("GetCatalogItems failed: %v", err)
+	}
+	if len(episodes) != 1 || episodes[0].ID != episodeID {
+		t.Fatalf("expected one episode with ID %s, got %+v", episodeID, episodes)
+	}
+	if len(episodes[0].MediaIDs) != 1 || episodes[0].MediaIDs[0] != mediaID {
+		t.Errorf("expected media %s attached, got %v", mediaID, episodes[0].MediaIDs)
+	}
+	if len(episodes[0].LinkIDs) != 1 || episodes[0].LinkIDs[0] != linkID {
+		t.Errorf("expected link %s attached, got %v", linkID, episodes[0].LinkIDs)
+	}
+
+	updated, err := UpdateCatalogItem(owner, CatalogItem{ID: episodeID, Kind: "episode", Title: "Pilot", ParentID: seriesID, Season: 2, Episode: 6})
+	if err != nil {
+		t.Fatalf("UpdateCatalogItem failed: %v", err)
+	}
+	if updated.Title != "Pilot" || updated.Episode != 6 || len(updated.MediaIDs) != 1 {
+		t.Errorf("unexpected updated item: %+v", updated)
+	}
+
+	if err := AttachCatalogMedia(owner, episodeID, "missing"); err == nil {
+		t.Errorf("expected foreign key error attaching unknown media")
+	}
+
+	// Deleting the series removes its episodes
+	if err := DeleteCatalogItem(owner, seriesID); err != nil {
+		t.Fatalf("DeleteCatalogItem failed: %v", err)
+	}
+	if _, err := GetCatalogItem(owner, episodeID); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound for cascaded episode, got %v", err)
+	}
+
+	// The media file itself is untouched
+	if _, err := GetMediaByID(owner, mediaID); err != nil {
+		t.Fatalf("GetMediaByID failed: %v", err)
+	}
+}
+
+// TestCatalogParents tests parents must be of a fitting kind and never a
+// descendant of the item.
+func TestCatalogParents(t *testing.T) {
+	setupTestDB(t)
+	owner := addTestUser(t, "alice")
+
+	movieID, err := AddCatalogItem(owner, CatalogItem{Kind: "movie", Title: "Movie"})
+	if err != nil {
+		t.Fatalf("AddCatalogItem(movie) failed: %v", err)
+	}
+	seriesID, err := AddCatalogItem(owner, CatalogItem{Kind: "series", Title: "Show"})
+	if err != nil {
+		t.Fatalf("AddCatalogItem(series) failed: %v", err)
+	}
+	seasonID, err := AddCatalogItem(owner, CatalogItem{Kind: "season", Title: "Show", ParentID: seriesID, Season: 1})
+	if err != nil {
+		t.Fatalf("AddCatalogItem(season) failed: %v", err)
+	}
+	episodeID, err := AddCatalogItem(owner, CatalogItem{Kind: "episode", Title: "Pilot", ParentID: seasonID, Season: 1, Episode: 1})
+	if err != nil {
+		t.Fatalf("AddCatalogItem(episode) failed: %v", err)
+	}
+
+	for _, item := range []CatalogItem{
+		{Kind: "episode", Title: "Odd", ParentID: movieID},
+		{Kind: "season", Title: "Odd", ParentID: seasonID},
+		{Kind: "movie", Title: "Odd", ParentID: seriesID},
+	} {
+		if _, err := AddCatalogItem(owner, item); !errors.Is(err, ErrCatalogParent) {
+			t.Errorf("AddCatalogItem(%s under %s): expected ErrCatalogParent, got %v", item.Kind, item.ParentID, err)
+		}
+	}
+
+	// A series turned into an episode under its own season would loop
+	_, err = UpdateCatalogItem(owner, CatalogItem{ID: seriesID, Kind: "episode", Title: "Show", ParentID: seasonID})
+	if !errors.Is(err, ErrCatalogCycle) {
+		t.Errorf("expected ErrCatalogCycle moving the series under its season, got %v", err)
+	}
+	_, err = UpdateCatalogItem(owner, CatalogItem{ID: episodeID, Kind: "episode", Title: "Pilot", ParentID: movieID})
+	if !errors.Is(err, ErrCatalogParent) {
+		t.Errorf("expected ErrCatalogParent moving the episode under a movie, got %v", err)
+	}
+	// The season still holds an episode, so it cannot become a movie
+	_, err = UpdateCatalogItem(owner, CatalogItem{ID: seasonID, Kind: "movie", Title: "Show"})
+	if !errors.Is(err, ErrCatalogParent) {
+		t.Errorf("expected ErrCatalogParent turning a season with episodes into a movie, got %v", err)
+	}
+
+	// Moving the episode straight under the series is fine
+	updated, err := UpdateCatalogItem(owner, CatalogItem{ID: episodeID, Kind: "episode", Title: "Pilot", ParentID: seriesID, Season: 1, Episode: 1})
+	if err != nil {
+		t.Fatalf("UpdateCatalogItem(episode under series) failed: %v", err)
+	}
+	if updated.ParentID != seriesID {
+		t.Errorf("expected parent %s, got %s", seriesID, updated.ParentID)
+	}
+}
+
+// TestProgressLifecycle tests watchlist status transitions and the continue/completed lists.
+func TestProgressLifecycle(t *testing.T) {
+	setupTestDB(t)
+	owner := addTestUser(t, "alice")
+
+	movieID, err := AddCatalogItem(owner, CatalogItem{Kind: "movie", Title: "Movie", Year: 2019})
+	if err != nil {
+		t.Fatalf("AddCatalogItem failed: %v", err)
+	}
+	bookID, err := AddCatalogItem(owner, CatalogItem{Kind: "book", Title: "Book"})
+	if err != nil {
+		t.Fatalf("AddCatalogItem failed: %v", err)
+	}
+
+	p, err := SetProgress(owner, ProgressUpdate{ItemID: movieID, Status: StatusPlanned})
+	if err != nil {
+		t.Fatalf("SetProgress failed: %v", err)
+	}
+	if p.Status != StatusPlanned || p.StartedAt != "" {
+		t.Errorf("expected planned item without start date, got %+v", p)
+	}
+
+	// A position report starts playback
+	if err := UpdateProgressPosition(owner, movieID, 600, 6000, 0); err != nil {
+		t.Fatalf("UpdateProgressPosition failed: %v", err)
+	}
+	if err := UpdateProgressPosition(owner, bookID, 0, 0, 42); err != nil {
+		t.Fatalf("UpdateProgressPosition failed: %v", err)
+	}
+
+	watching, err := GetContinueWatching(owner, 10)
+	if err != nil {
+		t.Fatalf("GetContinueWatching failed: %v", err)
+	}
+	if len(watching) != 2 {
+		t.Fatalf("expected 2 items in progress, got %+v", watching)
+	}
+	for _, w := range watching {
+		if w.StartedAt == "" {
+			t.Errorf("expected start date for %s", w.Title)
+		}
+		if w.ItemID == bookID && w.Page != 42 {
+			t.Errorf("expected book page 42, got %d", w.Page)
+		}
+	}
+
+	// Playing to the end completes the item
+	if err := UpdateProgressPosition(owner, movieID, 5900, 6000, 0); err != nil {
+		t.Fatalf("UpdateProgressPosition failed: %v", err)
+	}
+	completed, err := GetRecentlyCompleted(owner, 10)
+	if err != nil {
+		t.Fatalf("GetRecentlyCompleted failed: %v", err)
+	}
+	if len(completed) != 1 || completed[0].ItemID != movieID || completed[0].FinishedAt == "" {
+		t.Fatalf("expected completed movie with finish date, got %+v", completed)
+	}
+
+	// Rating and review are kept with an explicit update
+	p, err = SetProgress(owner, ProgressUpdate{ItemID: movieID, Status: StatusCompleted, Position: 6000, Rating: 8, Review: "Good"})
+	if err != nil {
+		t.Fatalf("SetProgress failed: %v", err)
+	}
+	if p.Rating != 8 || p.Review != "Good" || p.StartedAt == "" || p.FinishedAt == "" {
+		t.Errorf("unexpected progress after review: %+v", p)
+	}
+
+	// A re-watch is ranked by when it was finished again
+	first := p.FinishedAt
+	p, err = SetProgress(owner, ProgressUpdate{ItemID: movieID, Status: StatusInProgress})
+	if err != nil {
+		t.Fatalf("SetProgress failed: %v", err)
+	}
+	if p.FinishedAt != "" {
+		t.Errorf("expected finish date cleared on re-watch, got %q", p.FinishedAt)
+	}
+	time.Sleep(10 * time.Millisecond)
+	if err := UpdateProgressPosition(owner, movieID, 5900, 6000, 0); err != nil {
+		t.Fatalf("UpdateProgressPosition failed: %v", err)
+	}
+	if got, err := GetProgress(owner, movieID); err != nil || got.Status != StatusCompleted || got.FinishedAt == "" || got.FinishedAt == first {
+		t.Errorf("expected new finish date after re-watch, got %+v (first %q), %v", got, first, err)
+	}
+
+	// Playing past the end again keeps the finish date
+	second, err := GetProgress(owner, movieID)
+	if err != nil {
+		t.Fatalf("GetProgress failed: %v", err)
+	}
+	time.Sleep(10 * time.Millisecond)
+	if err := UpdateProgressPosition(owner, movieID, 5950, 6000, 0); err != nil {
+		t.Fatalf("UpdateProgressPosition failed: %v", err)
+	}
+	if got, err := GetProgress(owner, movieID); err != nil || got.FinishedAt != second.FinishedAt {
+		t.Errorf("expected finish date %q kept, got %+v, %v", second.FinishedAt, got, err)
+	}
+
+	// A player restarting the completed item starts a re-watch by itself
+	if err := UpdateProgressPosition(owner, movieID, 60, 6000, 0); err != nil {
+		t.Fatalf("UpdateProgressPosition failed: %v", err)
+	}
+	if got, err := GetProgress(owner, movieID); err != nil || got.Status != StatusInProgress || got.FinishedAt != "" {
+		t.Errorf("expected in_progress without finish date after restart, got %+v, %v", got, err)
+	}
+	time.Sleep(10 * time.Millisecond)
+	if err := UpdateProgressPosition(owner, movieID, 5900, 6000, 0); err != nil {
+		t.Fatalf("UpdateProgressPosition failed: %v", err)
+	}
+	if got, err := GetProgress(owner, movieID); err != nil || got.Status != StatusCompleted || got.FinishedAt == "" || got.FinishedAt == second.FinishedAt {
+		t.Errorf("expected new finish date after finishing again, got %+v (previous %q), %v", got, second.FinishedAt, err)
+	}
+
+	if _, err := SetProgress(owner, ProgressUpdate{ItemID: movieID, Status: StatusCompleted, Rating: 11}); err == nil {
+		t.Errorf("expected rating outside 1-10 to be rejected")
+	}
+
+	if err := DeleteProgress(owner, bookID); err != nil {
+		t.Fatalf("DeleteProgress failed: %v", err)
+	}
+	if _, err := GetProgress(owner, bookID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound after delete, got %v", err)
+	}
+}
+
+// TestCollectionOrdering tests adding, reordering and removing collection items.
+func TestCollectionOrdering(t *testing.T) {
+	setupTestDB(t)
+	owner := addTestUser(t, "alice")
+
+	playlistID, err := AddCollection(owner, CollectionPlaylist, "Road trip")
+	if err != nil {
+		t.Fatalf("AddCollection failed: %v", err)
+	}
+
+	var itemIDs []string
+	for _, name := range []string{"a.mp3", "b.mp3", "c.mp3"} {
+		mediaID, err := AddMedia(owner, name, name, "audio/mpeg", 1)
+		if err != nil {
+			t.Fatalf("AddMedia failed: %v", err)
+		}
+		item, err := AddCollectionItem(owner, playlistID, mediaID, "", "")
+		if err != nil {
+			t.Fatalf("AddCollectionItem failed: %v", err)
+		}
+		itemIDs = append(itemIDs, item.ID)
+	}
+
+	order := func() []string {
+		t.Helper()
+		items, err := GetCollectionItems(owner, playlistID)
+		if err != nil {
+			t.Fatalf("GetCollectionItems failed: %v", err)
+		}
+		var names []string
+		for _, i := range items {
+			names = append(names, i.Filename)
+		}
+		return names
+	}
+	if got := strings.Join(order(), ","); got != "a.mp3,b.mp3,c.mp3" {
+		t.Fatalf("unexpected initial order %s", got)
+	}
+
+	// Move c to the front, then a between c and b
+	if _, err := MoveCollectionItem(owner, playlistID, itemIDs[2], "", itemIDs[0]); err != nil {
+		t.Fatalf("MoveCollectionItem failed: %v", err)
+	}
+	if _, err := MoveCollectionItem(owner, playlistID, itemIDs[0], itemIDs[2], ""); err != nil {
+		t.Fatalf("MoveCollectionItem failed: %v", err)
+	}
+	if got := strings.Join(order(), ","); got != "c.mp3,a.mp3,b.mp3" {
+		t.Fatalf("unexpected order after moves %s", got)
+	}
+
+	// Insert a new item right after c
+	mediaID, _ := AddMedia(owner, "d.mp3", "d.mp3", "audio/mpeg", 1)
+	if _, err := AddCollectionItem(owner, playlistID, mediaID, itemIDs[2], ""); err != nil {
+		t.Fatalf("AddCollectionItem after failed: %v", err)
+	}
+	if got := strings.Join(order(), ","); got != "c.mp3,d.mp3,a.mp3,b.mp3" {
+		t.Fatalf("unexpected order after insert %s", got)
+	}
+
+	if err := DeleteCollectionItem(owner, playlistID, itemIDs[1]); err != nil {
+		t.Fatalf("DeleteCollectionItem failed: %v", err)
+	}
+	c, err := GetCollection(owner, playlistID)
+	if err != nil {
+		t.Fatalf("GetCollection failed: %v", err)
+	}
+	if c.ItemCount != 3 {
+		t.Errorf("expected 3 items, got %d", c.ItemCount)
+	}
+
+	if _, err := MoveCollectionItem(owner, playlistID, "missing", "", ""); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound moving unknown item, got %v", err)
+	}
+}
+
+// TestSessions covers listing and revoking a user's sessions.
+func TestSessions(t *testing.T) {
+	setupTestDB(t)
+	alice := addTestUser(t, "alice")
+	bob := addTestUser(t, "bob")
+
+	laptop, err := AddSession(alice, "laptop-token", "Firefox", "192.168.1.10", time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatalf("AddSession failed: %v", err)
+	}
+	if _, err := AddSession(alice, "phone-token", "Safari", "192.168.1.11", time.Now().Add(time.Hour)); err != nil {
+		t.Fatalf("AddSession failed: %v", err)
+	}
+	bobs, err := AddSession(bob, "bob-token", "Chrome", "192.168.1.12", time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatalf("AddSession failed: %v", err)
+	}
+
+	sessions, err := GetSessions(alice)
+	if err != nil {
+		t.Fatalf("GetSessions failed: %v", err)
+	}
+	if len(sessions) != 2 {
+		t.Fatalf("expected 2 sessions, got %d", len(sessions))
+	}
+	for _, s := range sessions {
+		if s.ID == laptop && (s.UserAgent != "Firefox" || s.IP != "192.168.1.10") {
+			t.Errorf("unexpected laptop session: %+v", s)
+		}
+	}
+
+	if err := DeleteSession(alice, bobs); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("deleting another user's session: expected ErrNotFound, got %v", err)
+	}
+	n, err := DeleteOtherSessions(alice, laptop)
+	if err != nil || n != 1 {
+		t.Fatalf("DeleteOtherSessions = %d, %v; want 1", n, err)
+	}
+	if err := DeleteToken(laptop); err != nil {
+		t.Fatalf("DeleteToken failed: %v", err)
+	}
+	if err := DeleteToken(laptop); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound for a revoked session, got %v", err)
+	}
+	if remaining, _ := GetSessions(bob); len(remaining) != 1 {
+		t.Fatalf("bob's session was affected: %+v", remaining)
+	}
+}
+
+// TestUsers covers creating, disabling and resetting users.
+func TestUsers(t *testing.T) {
+	setupTestDB(t)
+
+	id, err := AddUser("Alice", "hash-1", RoleAdmin)
+	if err != nil {
+		t.Fatalf("AddUser failed: %v", err)
+	}
+	if _, err := AddUser("alice", "hash-2", RoleEditor); !errors.Is(err, ErrUsernameTaken) {
+		t.Fatalf("expected ErrUsernameTaken for a case-insensitive duplicate, got %v", err)
+	}
+
+	u, err := GetUserByUsername("ALICE")
+	if err != nil || u.ID != id || u.Role != RoleAdmin || u.PasswordHash != "hash-1" {
+		t.Fatalf("GetUserByUsername = %+v, %v", u, err)
+	}
+
+	if _, err := AddSession(id, "token", "Firefox", "", time.Now().Add(time.Hour)); err != nil {
+		t.Fatalf("AddSession failed: %v", err)
+	}
+	if _, err := AddAPIToken(id, "backup script", "pat-hash", []string{"media:read"}, nil); err != nil {
+		t.Fatalf("AddAPIToken failed: %v", err)
+	}
+	if err := SetUserPassword(id, "hash-3"); err != nil {
+		t.Fatalf("SetUserPassword failed: %v", err)
+	}
+	if sessions, _ := GetSessions(id); len(sessions) != 0 {
+		t.Fatalf("password reset kept %d sessions", len(sessions))
+	}
+	if tokens, _ := GetAPITokens(id); len(tokens) != 0 {
+		t.Fatalf("password reset kept %d api tokens", len(tokens))
+	}
+
+	if _, err := AddSession(id, "token", "Firefox", "", time.Now().Add(time.Hour)); err != nil {
+		t.Fatalf("AddSession failed: %v", err)
+	}
+	updated, err := UpdateUser(id, RoleViewer, true)
+	if err != nil || !updated.Disabled || updated.Role != RoleViewer || updated.PasswordHash != "hash-3" {
+		t.Fatalf("UpdateUser = %+v, %v", updated, err)
+	}
+	if sessions, _ := GetSessions(id); len(sessions) != 0 {
+		t.Fatalf("disabling kept %d sessions", len(sessions))
+	}
+
+	if _, err := UpdateUser("missing", RoleEditor, false); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+	if n, _ := CountUsers(); n != 1 {
+		t.Fatalf("CountUsers = %d, want 1", n)
+	}
+}
+
+// TestMigrateLegacySession upgrades a database created before sessions had
+// client metadata, hashed tokens and owners, one migration at a time.
+func TestMigrateLegacySession(t *testing.T) {
+	legacy, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "legacy.db"))
+	if err != nil {
+		t.Fatalf("open legacy db: %v", err)
+	}
+	defer legacy.Close()
+
+	if _, err := legacy.Exec(`CREATE TABLE Session (id TEXT PRIMARY KEY, token_hash TEXT NOT NULL,
+		createdAt DATETIME DEFAULT CURRENT_TIMESTAMP, updatedAt DATETIME DEFAULT CURRENT_TIMESTAMP)`); err != nil {
+		t.Fatalf("create legacy table: %v", err)
+	}
+	if _, err := legacy.Exec(`INSERT INTO Session (id, token_hash) VALUES ('old', 'header.payload.sig'), ('dup', 'header.payload.sig')`); err != nil {
+		t.Fatalf("insert legacy row: %v", err)
+	}
+
+	// raw tokens are rehashed and duplicates dropped
+	if err := migrateTo(legacy, 3); err != nil {
+		t.Fatalf("migrate to 3: %v", err)
+	}
+	var id, hash, agent string
+	err = legacy.QueryRow(`SELECT id, token_hash, user_agent FROM Session`).Scan(&id, &hash, &agent)
+	if err != nil || id != "old" || hash != HashToken("header.payload.sig") || agent != "" {
+		t.Fatalf("legacy session after rehash = %q %q %q, %v", id, hash, agent, err)
+	}
+
+	// sessions without an owner cannot survive the move to user accounts
+	if err := migrateTo(legacy, 4); err != nil {
+		t.Fatalf("migrate to 4: %v", err)
+	}
+	var version, count int
+	if err := legacy.QueryRow(`PRAGMA user_version`).Scan(&version); err != nil || version != 4 {
+		t.Fatalf("user_version = %d, %v; want 4", version, err)
+	}
+	if err := legacy.QueryRow(`SELECT COUNT(*) FROM Session WHERE user_id IS NULL`).Scan(&count); err != nil || count != 0 {
+		t.Fatalf("ownerless sessions left: %d, %v", count, err)
+	}
+}
+
+// TestMigrateLegacyOwnership gives library rows from before user accounts to
+// the oldest administrator.
+func TestMigrateLegacyOwnership(t *testing.T) {
+	legacy, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "legacy.db"))
+	if err != nil {
+		t.Fatalf("open legacy db: %v", err)
+	}
+	defer legacy.Close()
+
+	stmts := []string{
+		`CREATE TABLE User (id TEXT PRIMARY KEY, username TEXT, password_hash TEXT, is_admin BOOLEAN, disabled BOOLEAN,
+			createdAt DATETIME, updatedAt DATETIME)`,
+		`INSERT INTO User VALUES ('viewer', 'v', '', 0, 0, '2020-01-01', '2020-01-01'),
+			('admin', 'a', '', 1, 0, '2021-01-01', '2021-01-01'), ('later', 'l', '', 1, 0, '2022-01-01', '2022-01-01')`,
+		`PRAGMA user_version = 4`,
+	}
+	// the library tables of the single-user era
+	legacyTables := []string{"Note", "Link", "Media", "CatalogItem", "Collection"}
+	for _, table := range legacyTables {
+		stmts = append(stmts,
+			`CREATE TABLE `+table+` (id TEXT PRIMARY KEY)`,
+			`INSERT INTO `+table+` (id) VALUES ('old')`,
+		)
+	}
+	for _, stmt := range stmts {
+		if _, err := legacy.Exec(stmt); err != nil {
+			t.Fatalf("%s: %v", stmt, err)
+		}
+	}
+
+	if err := migrate(legacy); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	for _, table := range legacyTables {
+		var owner string
+		if err := legacy.QueryRow(`SELECT owner_id FROM ` + table).Scan(&owner); err != nil || owner != "admin" {
+			t.Errorf("%s owner = %q, %v; want admin", table, owner, err)
+		}
+	}
+}
+
+// TestMigrateRoles turns the admin flag into roles, leaving other users as editors.
+func TestMigrateRoles(t *testing.T) {
+	legacy, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "legacy.db"))
+	if err != nil {
+		t.Fatalf("open legacy db: %v", err)
+	}
+	defer legacy.Close()
+
+	for _, stmt := range []string{
+		`CREATE TABLE User (id TEXT PRIMARY KEY, username TEXT, password_hash TEXT, is_admin BOOLEAN NOT NULL DEFAULT 0,
+			disabled BOOLEAN, createdAt DATETIME, updatedAt DATETIME)`,
+		`INSERT INTO User VALUES ('admin', 'a', '', 1, 0, '2021-01-01', '2021-01-01'), ('user', 'u', '', 0, 0, '2022-01-01', '2022-01-01')`,
+		`PRAGMA user_version = 5`,
+	} {
+		if _, err := legacy.Exec(stmt); err != nil {
+			t.Fatalf("%s: %v", stmt, err)
+		}
+	}
+
+	if err := migrateTo(legacy, 6); err != nil {
+		t.Fatalf("migrate to 6: %v", err)
+	}
+	want := map[string]Role{"admin": RoleAdmin, "user": RoleEditor}
+	for id, role := range want {
+		var got Role
+		if err := legacy.QueryRow(`SELECT role FROM User WHERE id = ?`, id).Scan(&got); err != nil || got != role {
+			t.Errorf("%s role = %q, %v; want %q", id, got, err, role)
+		}
+	}
+	if _, err := legacy.Exec(`UPDATE User SET role = 'owner'`); err == nil {
+		t.Fatal("unknown role accepted")
+	}
+}
+
+// TestClaimUnownedRecords hands ownerless rows to the first user.
+func TestClaimUnownedRecords(t *testing.T) {
+	setupTestDB(t)
+	owner := addTestUser(t, "alice")
+
+	noteID, _ := AddNote(owner, "Legacy", "text")
+	if _, err := db.Exec(`UPDATE Note SET owner_id = NULL`); err != nil {
+		t.Fatalf("clear owner: %v", err)
+	}
+	if notes, _ := GetNotes(owner); len(notes) != 0 {
+		t.Fatalf("ownerless note visible: %+v", notes)
+	}
+
+	n, err := ClaimUnownedRecords(owner)
+	if err != nil || n != 1 {
+		t.Fatalf("ClaimUnownedRecords = %d, %v; want 1", n, err)
+	}
+	if notes, _ := GetNotes(owner); len(notes) != 1 || notes[0].ID != noteID {
+		t.Fatalf("claimed note missing: %+v", notes)
+	}
+}
+
+// TestOwnerIsolation checks that one user can never read, update or delete
+// another user's records, and that such attempts look like missing records.
+func TestOwnerIsolation(t *testing.T) {
+	setupTestDB(t)
+	alice := addTestUser(t, "alice")
+	bob := addTestUser(t, "bob")
+
+	noteID, _ := AddNote(alice, "Private", "secret")
+	linkID, _ := AddLink(alice, "https://example.com", "")
+	mediaID, _ := AddMedia(alice, "a.jpg", "a.jpg", "image/jpeg", 1)
+	itemID, _ := AddCatalogItem(alice, CatalogItem{Kind: "movie", Title: "Movie"})
+	collectionID, _ := AddCollection(alice, CollectionAlbum, "Trip")
+	entry, err := AddCollectionItem(alice, collectionID, mediaID, "", "")
+	if err != nil {
+		t.Fatalf("AddCollectionItem failed: %v", err)
+	}
+	if _, err := SetProgress(alice, ProgressUpdate{ItemID: itemID, Status: StatusInProgress}); err != nil {
+		t.Fatalf("SetProgress failed: %v", err)
+	}
+
+	// reads
+	if notes, _ := GetNotes(bob); len(notes) != 0 {
+		t.Errorf("bob sees notes %+v", notes)
+	}
+	if links, _ := GetLinks(bob); len(links) != 0 {
+		t.Errorf("bob sees links %+v", links)
+	}
+	if media, _ := GetMedia(bob); len(media) != 0 {
+		t.Errorf("bob sees media %+v", media)
+	}
+	if items, _ := GetCatalogItems(bob, "", ""); len(items) != 0 {
+		t.Errorf("bob sees catalog %+v", items)
+	}
+	if collections, _ := GetCollections(bob); len(collections) != 0 {
+		t.Errorf("bob sees collections %+v", collections)
+	}
+	if list, _ := GetProgressList(bob, ""); len(list) != 0 {
+		t.Errorf("bob sees progress %+v", list)
+	}
+	if items, _ := GetCollectionItems(bob, collectionID); len(items) != 0 {
+		t.Errorf("bob sees collection items %+v", items)
+	}
+
+	notFound := map[string]error{}
+	_, notFound["GetMediaByID"] = GetMediaByID(bob, mediaID)
+	_, notFound["GetCatalogItem"] = GetCatalogItem(bob, itemID)
+	_, notFound["GetCollection"] = GetCollection(bob, collectionID)
+	_, notFound["GetProgress"] = GetProgress(bob, itemID)
+
+	// updates
+	_, notFound["UpdateNote"] = UpdateNote(bob, noteID, "hacked")
+	notFound["RenameNote"] = RenameNote(bob, noteID, "hacked")
+	notFound["RenameMedia"] = RenameMedia(bob, mediaID, "hacked")
+	notFound["ReplaceMediaFile"] = ReplaceMediaFile(bob, mediaID, "x", "image/png", 1)
+	_, notFound["UpdateCatalogItem"] = UpdateCatalogItem(bob, CatalogItem{ID: itemID, Kind: "movie", Title: "hacked"})
+	_, notFound["UpdateCollection"] = UpdateCollection(bob, collectionID, "hacked", "")
+	_, notFound["SetProgress"] = SetProgress(bob, ProgressUpdate{ItemID: itemID, Status: StatusDropped})
+	notFound["UpdateProgressPosition"] = UpdateProgressPosition(bob, itemID, 1, 2, 0)
+	_, notFound["MoveCollectionItem"] = MoveCollectionItem(bob, collectionID, entry.ID, "", "")
+
+	// references from bob's records to alice's
+	bobItem, _ := AddCatalogItem(bob, CatalogItem{Kind: "movie", Title: "Mine"})
+	bobCollection, _ := AddCollection(bob, CollectionAlbum, "Mine")
+	_, notFound["AddCatalogItem(parent)"] = AddCatalogItem(bob, CatalogItem{Kind: "season", Title: "S1", ParentID: itemID})
+	notFound["AttachCatalogMedia"] = AttachCatalogMedia(bob, bobItem, mediaID)
+	notFound["AttachCatalogLink"] = AttachCatalogLink(bob, bobItem, linkID)
+	notFound["DetachCatalogMedia"] = DetachCatalogMedia(bob, itemID, mediaID)
+	_, notFound["AddCollectionItem"] = AddCollectionItem(bob, bobCollection, mediaID, "", "")
+	_, notFound["UpdateCollection(cover)"] = UpdateCollection(bob, bobCollection, "Mine", mediaID)
+
+	// deletes
+	notFound["DeleteNote"] = DeleteNote(bob, noteID)
+	notFound["DeleteLink"] = DeleteLink(bob, linkID)
+	notFound["DeleteMedia"] = DeleteMedia(bob, mediaID)
+	notFound["DeleteCatalogItem"] = DeleteCatalogItem(bob, itemID)
+	notFound["DeleteProgress"] = DeleteProgress(bob, itemID)
+	notFound["DeleteCollectionItem"] = DeleteCollectionItem(bob, collectionID, entry.ID)
+	notFound["DeleteCollection"] = DeleteCollection(bob, collectionID)
+
+	for name, err := range notFound {
+		if !errors.Is(err, ErrNotFound) {
+			t.Errorf("%s as another user: got %v, want ErrNotFound", name, err)
+		}
+	}
+
+	// alice's data is untouched
+	notes, _ := GetNotes(alice)
+	if len(notes) != 1 || notes[0].Note != "secret" || notes[0].Title != "Private" {
+		t.Errorf("alice's note changed: %+v", notes)
+	}
+	if m, err := GetMediaByID(alice, mediaID); err != nil || m.Filename != "a.jpg" || m.Path != "a.jpg" {
+		t.Errorf("alice's media changed: %+v, %v", m, err)
+	}
+	if c, err := GetCollection(alice, collectionID); err != nil || c.Name != "Trip" || c.ItemCount != 1 {
+		t.Errorf("alice's collection changed: %+v, %v", c, err)
+	}
+	if p, err := GetProgress(alice, itemID); err != nil || p.Status != StatusInProgress || p.Position != 0 {
+		t.Errorf("alice's progress changed: %+v, %v", p, err)
+	}
+	if links, _ := GetLinks(alice); len(links) != 1 {
+		t.Errorf("alice's link deleted: %+v", links)
+	}
+}
+
+// TestSweepSessions removes expired and idle sessions and keeps recently used ones.
+func TestSweepSessions(t *testing.T) {
+	setupTestDB(t)
+	user := addTestUser(t, "alice")
+
+	if _, err := AddSession(user, "expired", "a", "", time.Now().Add(-time.Minute)); err != nil {
+		t.Fatalf("AddSession failed: %v", err)
+	}
+	idle, _ := AddSession(user, "idle", "b", "", time.Now().Add(time.Hour))
+	active, _ := AddSession(user, "active", "c", "", time.Now().Add(time.Hour))
+
+	if _, err := db.Exec(`UPDATE Session SET updatedAt = ? WHERE id IN (?, ?)`, time.Now().Add(-48*time.Hour), idle, active); err != nil {
+		t.Fatalf("backdate sessions: %v", err)
+	}
+	if err := TouchSession(active, "10.0.0.5"); err != nil {
+		t.Fatalf("TouchSession failed: %v", err)
+	}
+
+	n, err := DeleteExpiredSessions(24 * time.Hour)
+	if err != nil || n != 2 {
+		t.Fatalf("DeleteExpiredSessions = %d, %v; want 2", n, err)
+	}
+
+	sessions, err := GetSessions(user)
+	if err != nil {
+		t.Fatalf("GetSessions failed: %v", err)
+	}
+	if len(sessions) != 1 || sessions[0].ID != active || sessions[0].IP != "10.0.0.5" {
+		t.Fatalf("unexpected remaining sessions: %+v", sessions)
+	}
+}
+
+// TestRefreshTokenRotation exchanges a refresh token once and revokes the
+// session when the old token is presented again.
+func TestRefreshTokenRotation(t *testing.T) {
+	setupTestDB(t)
+
+	exp := time.Now().Add(time.Hour)
+	user := addTestUser(t, "alice")
+	sessionID, err := AddSession(user, HashToken("access-1"), "Firefox", "", exp)
+	if err != nil {
+		t.Fatalf("AddSession failed: %v", err)
+	}
+	if err := AddRefreshToken(sessionID, HashToken("refresh-1"), exp); err != nil {
+		t.Fatalf("AddRefreshToken failed: %v", err)
+	}
+
+	got, err := RotateRefreshToken(HashToken("refresh-1"), HashToken("refresh-2"), HashToken("access-2"), exp)
+	if err != nil || got != sessionID {
+		t.Fatalf("RotateRefreshToken = %q, %v; want %q", got, err, sessionID)
+	}
+	if owner, err := GetRefreshTokenUser(HashToken("refresh-2")); err != nil || owner != user {
+		t.Fatalf("GetRefreshTokenUser = %q, %v; want %q", owner, err, user)
+	}
+	if _, err := GetToken(HashToken("access-1")); err == nil {
+		t.Fatalf("old access token still valid after rotation")
+	}
+	if _, err := GetToken(HashToken("access-2")); err != nil {
+		t.Fatalf("new access token not found: %v", err)
+	}
+
+	if _, err := RotateRefreshToken(HashToken("refresh-1"), HashToken("refresh-3"), HashToken("access-3"), exp); !errors.Is(err, ErrRefreshTokenReused) {
+		t.Fatalf("expected ErrRefreshTokenReused, got %v", err)
+	}
+	if _, err := GetToken(HashToken("access-2")); err == nil {
+		t.Fatalf("session survived refresh token reuse")
+	}
+	if _, err := RotateRefreshToken(HashToken("refresh-2"), HashToken("refresh-4"), HashToken("access-4"), exp); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound for a token of a revoked family, got %v", err)
+	}
+}
+
+// TestAPITokens covers creating, looking up, expiring and revoking personal
+// access tokens.
+func TestAPITokens(t *testing.T) {
+	setupTestDB(t)
+	alice := addTestUser(t, "alice")
+	bob := addTestUser(t, "bob")
+
+	id, err := AddAPIToken(alice, "backup script", HashToken("mmg_live"), []string{"notes:read", "media:upload"}, nil)
+	if err != nil {
+		t.Fatalf("AddAPIToken failed: %v", err)
+	}
+	expired := time.Now().Add(-time.Minute)
+	if _, err := AddAPIToken(alice, "old", HashToken("mmg_old"), []string{"notes:read"}, &expired); err != nil {
+		t.Fatalf("AddAPIToken failed: %v", err)
+	}
+
+	tok, err := GetAPITokenByHash(HashToken("mmg_live"))
+	if err != nil || tok.ID != id || tok.UserID != alice || !tok.HasScope("media:upload") || tok.HasScope("notes:write") {
+		t.Fatalf("GetAPITokenByHash = %+v, %v", tok, err)
+	}
+	if tok.ExpiresAt != "" || tok.LastUsedAt != "" {
+		t.Fatalf("unexpected expiry or last use: %+v", tok)
+	}
+	if _, err := GetAPITokenByHash(HashToken("mmg_old")); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound for an expired token, got %v", err)
+	}
+
+	if err := TouchAPIToken(id); err != nil {
+		t.Fatalf("TouchAPIToken failed: %v", err)
+	}
+	if tok, _ := GetAPITokenByHash(HashToken("mmg_live")); tok.LastUsedAt == "" {
+		t.Fatal("last use not recorded")
+	}
+
+	if tokens, _ := GetAPITokens(alice); len(tokens) != 2 {
+		t.Fatalf("GetAPITokens = %d tokens, want 2", len(tokens))
+	}
+	if err := DeleteAPIToken(bob, id); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound revoking another user's token, got %v", err)
+	}
+	if err := DeleteAPIToken(alice, id); err != nil {
+		t.Fatalf("DeleteAPIToken failed: %v", err)
+	}
+	if _, err := GetAPITokenByHash(HashToken("mmg_live")); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound after revoking, got %v", err)
+	}
+}
+
+// TestTwoFactor covers enrolling, replay protection, recovery codes and reset.
+func TestTwoFactor(t *testing.T) {
+	setupTestDB(t)
+	alice := addTestUser(t, "alice")
+
+	if err := EnableTOTP(alice, 1, nil); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound enabling without a secret, got %v", err)
+	}
+	if err := SetTOTPSecret(alice, "SECRET"); err != nil {
+		t.Fatalf("SetTOTPSecret failed: %v", err)
+	}
+	if u, _ := GetUser(alice); u.TOTPSecret != "SECRET" || u.TwoFactor {
+		t.Fatalf("pending secret = %+v", u)
+	}
+	if err := EnableTOTP(alice, 100, []string{"h1", "h2"}); err != nil {
+		t.Fatalf("EnableTOTP failed: %v", err)
+	}
+	if u, _ := GetUser(alice); !u.TwoFactor {
+		t.Fatal("two-factor not enabled")
+	}
+
+	// the confirming step and earlier ones cannot be used to log in
+	for step, want := range map[int64]bool{100: false, 99: false} {
+		if ok, err := UseTOTPStep(alice, step); err != nil || ok != want {
+			t.Errorf("UseTOTPStep(%d) = %v, %v; want %v", step, ok, err, want)
+		}
+	}
+	if ok, _ := UseTOTPStep(alice, 101); !ok {
+		t.Error("fresh step rejected")
+	}
+	if ok, _ := UseTOTPStep(alice, 101); ok {
+		t.Error("step replayed")
+	}
+
+	if ok, _ := UseRecoveryCode(alice, "h1"); !ok {
+		t.Error("recovery code rejected")
+	}
+	if ok, _ := UseRecoveryCode(alice, "h1"); ok {
+		t.Error("recovery code reused")
+	}
+	if n, _ := CountRecoveryCodes(alice); n != 1 {
+		t.Errorf("CountRecoveryCodes = %d, want 1", n)
+	}
+
+	if err := DisableTOTP(alice); err != nil {
+		t.Fatalf("DisableTOTP failed: %v", err)
+	}
+	if u, _ := GetUser(alice); u.TwoFactor || u.TOTPSecret != "" {
+		t.Fatalf("two-factor left after reset: %+v", u)
+	}
+	if n, _ := CountRecoveryCodes(alice); n != 0 {
+		t.Errorf("reset kept %d recovery codes", n)
+	}
+	if err := DisableTOTP("missing"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+}
+
+// TestLoginFailures counts failures per user since their last success and
+// per address regardless of successes.
+func TestLoginFailures(t *testing.T) {
+	setupTestDB(t)
+
+	for _, a := range []struct {
+		username, ip string
+		success      bool
+	}{
+		{"alice", "10.0.0.1", false},
+		{"Alice", "10.0.0.1", false},
+		{"alice", "10.0.0.1", true},
+		{"alice", "10.0.0.2", false},
+		{"bob", "10.0.0.1", false},
+	} {
+		if err := AddLoginAttempt(a.username, a.ip, "curl", a.success, ""); err != nil {
+			t.Fatalf("AddLoginAttempt failed: %v", err)
+		}
+	}
+
+	f, err := GetLoginFailuresForUser("ALICE", time.Hour)
+	if err != nil || f.Count != 1 || f.Since > time.Minute {
+		t.Fatalf("GetLoginFailuresForUser = %+v, %v; want 1 recent failure", f, err)
+	}
+	if f, _ := GetLoginFailuresFromIP("10.0.0.1", time.Hour); f.Count != 3 {
+		t.Fatalf("GetLoginFailuresFromIP = %+v; want 3 failures", f)
+	}
+	if f, _ := GetLoginFailuresForUser("carol", time.Hour); f.Count != 0 || f.Since != 0 {
+		t.Fatalf("unknown user failures = %+v", f)
+	}
+
+	if _, err := db.Exec(`UPDATE LoginAttempt SET createdAt = datetime('now', '-2 hours') WHERE username = 'bob'`); err != nil {
+		t.Fatalf("age attempt: %v", err)
+	}
+	if f, _ := GetLoginFailuresForUser("bob", time.Hour); f.Count != 0 {
+		t.Fatalf("failure outside the window counted: %+v", f)
+	}
+	if n, err := DeleteOldLoginAttempts(time.Hour); err != nil || n != 1 {
+		t.Fatalf("DeleteOldLoginAttempts = %d, %v; want 1", n, err)
+	}
+}
+
+// TestOIDCUsers covers linking accounts to single sign-on identities and
+// provisioning new ones.
+func TestOIDCUsers(t *testing.T) {
+	setupTestDB(t)
+	alice := addTestUser(t, "alice")
+	bob := addTestUser(t, "bob")
+
+	if _, err := GetUserByOIDCSubject("sub-a"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+	if err := SetOIDCSubject(alice, "sub-a"); err != nil {
+		t.Fatalf("SetOIDCSubject failed: %v", err)
+	}
+	if u, err := GetUserByOIDCSubject("sub-a"); err != nil || u.ID != alice || u.OIDCSubject != "sub-a" {
+		t.Fatalf("GetUserByOIDCSubject = %+v, %v", u, err)
+	}
+	if err := SetOIDCSubject(bob, "sub-a"); !errors.Is(err, ErrAlreadyLinked) {
+		t.Fatalf("linking a used identity: expected ErrAlreadyLinked, got %v", err)
+	}
+	if err := SetOIDCSubject(alice, "sub-b"); err != nil {
+		t.Fatalf("relinking to another identity failed: %v", err)
+	}
+	if err := SetOIDCSubject(alice, ""); err != nil {
+		t.Fatalf("unlinking failed: %v", err)
+	}
+	if _, err := GetUserByOIDCSubject("sub-b"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound after unlinking, got %v", err)
+	}
+	if err := SetOIDCSubject("missing", "sub-x"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound for a missing user, got %v", err)
+	}
+
+	id, err := AddOIDCUser("carol", "sub-c", RoleViewer)
+	if err != nil {
+		t.Fatalf("AddOIDCUser failed: %v", err)
+	}
+	u, err := GetUserByOIDCSubject("sub-c")
+	if err != nil || u.ID != id || u.Role != RoleViewer || u.PasswordHash != "" {
+		t.Fatalf("provisioned user = %+v, %v", u, err)
+	}
+	if _, err := AddOIDCUser("Carol", "sub-d", RoleViewer); !errors.Is(err, ErrUsernameTaken) {
+		t.Fatalf("expected ErrUsernameTaken, got %v", err)
+	}
+	if _, err := AddOIDCUser("dave", "sub-c", RoleViewer); !errors.Is(err, ErrAlreadyLinked) {
+		t.Fatalf("expected ErrAlreadyLinked, got %v", err)
+	}
+}
+
+// TestSigningKeys records the legacy key on the first rotation and retires
+// the previous signing key with a grace period.
+func TestSigningKeys(t *testing.T) {
+	setupTestDB(t)
+
+	if keys, err := GetSigningKeys(); err != nil || len(keys) != 0 {
+		t.Fatalf("GetSigningKeys = %v, %v; want none", keys, err)
+	}
+	if err := RotateSigningKey(SigningKey{ID: "k1", Algorithm: "HS256", PrivateKey: []byte("s1")}, time.Hour); err != nil {
+		t.Fatalf("RotateSigningKey failed: %v", err)
+	}
+	if err := RotateSigningKey(SigningKey{ID: "k2", Algorithm: "HS256", PrivateKey: []byte("s2")}, time.Hour); err != nil {
+		t.Fatalf("RotateSigningKey failed: %v", err)
+	}
+
+	keys, err := GetSigningKeys()
+	if err != nil || len(keys) != 3 {
+		t.Fatalf("GetSigningKeys = %v, %v; want 3 keys", keys, err)
+	}
+	if keys[0].ID != LegacyKeyID || keys[0].Signing || keys[0].ExpiresAt == "" {
+		t.Errorf("legacy key = %+v; want a retired key", keys[0])
+	}
+	if keys[1].ID != "k1" || keys[1].Signing || keys[1].ExpiresAt == "" {
+		t.Errorf("k1 = %+v; want a retired key", keys[1])
+	}
+	if keys[2].ID != "k2" || !keys[2].Signing || keys[2].ExpiresAt != "" || string(keys[2].PrivateKey) != "s2" {
+		t.Errorf("k2 = %+v; want the signing key", keys[2])
+	}
+
+	if err := RotateSigningKey(SigningKey{ID: "k3", Algorithm: "HS256", PrivateKey: []byte("s3")}, 0); err != nil {
+		t.Fatalf("RotateSigningKey failed: %v", err)
+	}
+	if keys, _ := GetSigningKeys(); len(keys) != 3 || keys[2].ID != "k3" {
+		t.Fatalf("k2 still listed after a zero grace period: %+v", keys)
+	}
+	if n, err := DeleteExpiredSigningKeys(); err != nil || n != 1 {
+		t.Fatalf("DeleteExpiredSigningKeys = %d, %v; want 1", n, err)
+	}
+}
+
+// TestAuditLog filters and pages the audit log and refuses to change or
+// remove entries.
+func TestAuditLog(t *testing.T) {
+	setupTestDB(t)
+	alice := addTestUser(t, "alice")
+	bob := addTestUser(t, "bob")
+
+	entries := []AuditEntry{
+		{Username: "alice", Action: AuditLoginFailure, Detail: "invalid credentials"},
+		{ActorID: alice, Username: "alice", Action: AuditLoginSuccess},
+		{ActorID: alice, Action: AuditNoteCreate, TargetID: "n1", After: []byte(`{"id":"n1"}`)},
+		{ActorID: bob, Action: AuditLinkCreate, TargetID: "l1"},
+		{ActorID: alice, Action: AuditNoteDelete, TargetID: "n1", Before: []byte(`{"id":"n1"}`)},
+	}
+	for _, e := range entries {
+		if err := AddAuditEntry(e); err != nil {
+			t.Fatalf("AddAuditEntry failed: %v", err)
+		}
+	}
+
+	all, err := GetAuditEntries(AuditFilter{})
+	if err != nil || len(all) != 5 {
+		t.Fatalf("GetAuditEntries = %v, %v; want 5 entries", all, err)
+	}
+	if all[0].Action != AuditNoteDelete || string(all[0].Before) != `{"id":"n1"}` || all[0].After != nil {
+		t.Errorf("newest entry = %+v; want the note deletion", all[0])
+	}
+	if all[1].Username != "bob" {
+		t.Errorf("actor username = %q; want bob", all[1].Username)
+	}
+
+	// the failed login has no actor ID but still matches by username
+	if got, _ := GetAuditEntries(AuditFilter{Actor: "ALICE"}); len(got) != 4 {
+		t.Errorf("entries for alice = %d; want 4", len(got))
+	}
+	if got, _ := GetAuditEntries(AuditFilter{Action: "note"}); len(got) != 2 {
+		t.Errorf("note entries = %d; want 2", len(got))
+	}
+	if got, _ := GetAuditEntries(AuditFilter{Action: AuditLoginSuccess}); len(got) != 1 {
+		t.Errorf("login.success entries = %d; want 1", len(got))
+	}
+	if got, _ := GetAuditEntries(AuditFilter{TargetID: "n1"}); len(got) != 2 {
+		t.Errorf("entries for n1 = %d; want 2", len(got))
+	}
+	future := time.Now().Add(time.Hour)
+	if got, _ := GetAuditEntries(AuditFilter{Since: &future}); len(got) != 0 {
+		t.Errorf("entries since the future = %d; want 0", len(got))
+	}
+
+	page, _ := GetAuditEntries(AuditFilter{Limit: 2, BeforeID: all[1].ID})
+	if len(page) != 2 || page[0].ID != all[2].ID || page[1].ID != all[3].ID {
+		t.Errorf("page before %d = %+v; want entries %d and %d", all[1].ID, page, all[2].ID, all[3].ID)
+	}
+
+	var exported []int64
+	if err := ExportAuditEntries(AuditFilter{Limit: 1}, func(e AuditEntry) error {
+		exported = append(exported, e.ID)
+		return nil
+	}); err != nil || len(exported) != 5 || exported[0] != all[4].ID {
+		t.Errorf("ExportAuditEntries = %v, %v; want all 5 entries oldest first", exported, err)
+	}
+
+	if _, err := db.Exec(`UPDATE AuditLog SET action = 'x'`); err == nil {
+		t.Error("audit entry updated")
+	}
+	if _, err := db.Exec(`DELETE FROM AuditLog`); err == nil {
+		t.Error("audit entry deleted")
+	}
+}
+
+// TestShares covers creating shares of owned records only, and their expiry,
+// view limit and revocation.
+func TestShares(t *testing.T) {
+	setupTestDB(t)
+	alice := addTestUser(t, "alice")
+	bob := addTestUser(t, "bob")
+	noteID, _ := AddNote(alice, "title", "body")
+	linkID, _ := AddLink(alice, "https://example.com", "")
+
+	if _, err := AddShare(bob, noteID, "", HashToken("x"), "", 0, nil); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("sharing another user's note: got %v, want ErrNotFound", err)
+	}
+	if _, err := AddShare(alice, noteID, linkID, HashToken("x"), "", 0, nil); err == nil {
+		t.Fatal("share of both a note and a link accepted")
+	}
+
+	limited, err := AddShare(alice, noteID, "", HashToken("limited"), "hash", 2, nil)
+	if err != nil {
+		t.Fatalf("AddShare failed: %v", err)
+	}
+	past := time.Now().Add(-time.Minute)
+	if _, err := AddShare(alice, "", linkID, HashToken("expired"), "", 0, &past); err != nil {
+		t.Fatalf("AddShare failed: %v", err)
+	}
+
+	s, err := GetShareByToken(HashToken("limited"))
+	if err != nil || s.ID != limited || s.OwnerID != alice || s.Title != "title" || !s.HasPassword {
+		t.Fatalf("GetShareByToken = %+v, %v", s, err)
+	}
+	if _, err := GetShareByToken(HashToken("expired")); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expired share: got %v, want ErrNotFound", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := UseShare(limited); err != nil {
+			t.Fatalf("UseShare %d failed: %v", i, err)
+		}
+	}
+	if err := UseShare(limited); !errors.Is(err, ErrNotFound) {
+		t.Errorf("view past the limit: got %v, want ErrNotFound", err)
+	}
+	if _, err := GetShareByToken(HashToken("limited")); !errors.Is(err, ErrNotFound) {
+		t.Errorf("used up share: got %v, want ErrNotFound", err)
+	}
+
+	open, err := AddShare(alice, noteID, "", HashToken("open"), "", 0, nil)
+	if err != nil {
+		t.Fatalf("AddShare failed: %v", err)
+	}
+	if _, err := UpdateUser(alice, RoleEditor, true); err != nil {
+		t.Fatalf("UpdateUser failed: %v", err)
+	}
+	if _, err := GetShareByToken(HashToken("open")); !errors.Is(err, ErrNotFound) {
+		t.Errorf("share of a disabled owner: got %v, want ErrNotFound", err)
+	}
+	if err := UseShare(open); !errors.Is(err, ErrNotFound) {
+		t.Errorf("view of a disabled owner's share: got %v, want ErrNotFound", err)
+	}
+	if _, err := UpdateUser(alice, RoleEditor, false); err != nil {
+		t.Fatalf("UpdateUser failed: %v", err)
+	}
+	if _, err := GetShareByToken(HashToken("open")); err != nil {
+		t.Errorf("share after re-enabling its owner: %v", err)
+	}
+	if err := DeleteShare(alice, open); err != nil {
+		t.Fatalf("DeleteShare failed: %v", err)
+	}
+
+	shares, err := GetShares(alice)
+	if err != nil || len(shares) != 2 {
+		t.Fatalf("GetShares = %v, %v; want 2 shares", shares, err)
+	}
+	if err := DeleteShare(bob, limited); !errors.Is(err, ErrNotFound) {
+		t.Errorf("revoking another user's share: got %v, want ErrNotFound", err)
+	}
+	if err := DeleteLink(alice, linkID); err != nil {
+		t.Fatalf("DeleteLink failed: %v", err)
+	}
+	if err := DeleteShare(alice, limited); err != nil {
+		t.Fatalf("DeleteShare failed: %v", err)
+	}
+	if shares, _ := GetShares(alice); len(shares) != 0 {
+		t.Errorf("shares left after revoking and deleting the link: %+v", shares)
+	}
+}
+
+// queryTimings returns how many calls of operation have been timed.
+func queryTimings(t *testing.T, operation string) uint64 {
+	t.Helper()
+	var m dto.Metric
+	if err := queryDuration.WithLabelValues(operation).(prometheus.Metric).Write(&m); err != nil {
+		t.Fatalf("read %s timings: %v", operation, err)
+	}
+	return m.GetHistogram().GetSampleCount()
+}
+
+// TestQueryMetrics checks each call of an exported function is timed once
+// under its name, including those running helpers and transactions.
+func TestQueryMetrics(t *testing.T) {
+	setupTestDB(t)
+	ops := []string{"AddNote", "AddShare", "SetUserPassword", "CountSessions"}
+	before := map[string]uint64{}
+	for _, op := range ops {
+		before[op] = queryTimings(t, op)
+	}
+
+	alice := addTestUser(t, "alice")
+	noteID, _ := AddNote(alice, "title", "body")
+	if _, err := AddShare(alice, noteID, "", HashToken("x"), "", 0, nil); err != nil {
+		t.Fatalf("AddShare failed: %v", err)
+	}
+	if err := SetUserPassword(alice, "new hash"); err != nil {
+		t.Fatalf("SetUserPassword failed: %v", err)
+	}
+	if n, err := CountSessions(); err != nil || n != 0 {
+		t.Fatalf("CountSessions = %d, %v; want 0", n, err)
+	}
+
+	for _, op := range ops {
+		if got := queryTimings(t, op) - before[op]; got != 1 {
+			t.Errorf("%s timed %d times, want 1", op, got)
+		}
+	}
+}
+
+// TestReadinessChecks checks Ping and CheckMigrations pass on a migrated
+// database and fail once it is closed or its schema is behind.
+func TestReadinessChecks(t *testing.T) {
+	setupTestDB(t)
+
+	if err := Ping(context.Background()); err != nil {
+		t.Fatalf("Ping: %v", err)
+	}
+	if err := CheckMigrations(); err != nil {
+		t.Fatalf("CheckMigrations: %v", err)
+	}
+
+	if _, err := db.Exec(`PRAGMA user_version = 1`); err != nil {
+		t.Fatalf("set user_version: %v", err)
+	}
+	if err := CheckMigrations(); err == nil {
+		t.Error("CheckMigrations passed with pending migrations")
+	}
+
+	d := db
+	db = nil
+	defer func() { db = d }()
+	if err := Ping(context.Background()); err == nil {
+		t.Error("Ping passed without a database")
+	}
+}
@@ -1,22 +1,108 @@
 package database
 
+import "time"
+
 // Database defines the interface for all database operations.
 type Database interface {
 	// Insert functions
 	AddToken(tokenHash string) (string, error)
-	AddNote(title, note string) (string, error)
-	AddLink(link, imgPath string) (string, error)
+	AddSession(userID, tokenHash, userAgent, ip string, expiresAt time.Time) (string, error)
+	AddRefreshToken(sessionID, tokenHash string, expiresAt time.Time) error
+	AddUser(username, passwordHash string, role Role) (string, error)
+	AddOIDCUser(username, subject string, role Role) (string, error)
+	AddAPIToken(userID, name, tokenHash string, scopes []string, expiresAt *time.Time) (string, error)
+	AddLoginAttempt(username, ip, userAgent string, success bool, reason string) error
+	AddAuditEntry(e AuditEntry) error
+	AddShare(ownerID, noteID, linkID, tokenHash, passwordHash string, maxViews int, expiresAt *time.Time) (string, error)
+	AddNote(ownerID, title, note string) (string, error)
+	AddLink(ownerID, link, imgPath string) (string, error)
+	AddMedia(ownerID, filename, path, mimeType string, size int64) (string, error)
+	AddCatalogItem(ownerID string, item CatalogItem) (string, error)
+	AttachCatalogMedia(ownerID, itemID, mediaID string) error
+	AttachCatalogLink(ownerID, itemID, linkID string) error
+	SetProgress(ownerID string, u ProgressUpdate) (Progress, error)
+	AddCollection(ownerID, kind, name string) (string, error)
+	AddCollectionItem(ownerID, collectionID, mediaID, afterID, beforeID string) (CollectionItem, error)
 
 	// Retrieval functions
 	GetToken(tokenHash string) (*Token, error)
-	GetNotes() ([]Note, error)
-	GetLinks() ([]Link, error)
+	GetSessions(userID string) ([]Session, error)
+	CountSessions() (int, error)
+	GetRefreshTokenUser(tokenHash string) (string, error)
+	GetAPITokens(userID string) ([]APIToken, error)
+	CountAPITokens() (int, error)
+	GetAPITokenByHash(tokenHash string) (*APIToken, error)
+	CountRecoveryCodes(userID string) (int, error)
+	GetLoginFailuresForUser(username string, window time.Duration) (LoginFailures, error)
+	GetLoginFailuresFromIP(ip string, window time.Duration) (LoginFailures, error)
+	CountUsers() (int, error)
+	GetUsers() ([]User, error)
+	GetUser(id string) (*User, error)
+	GetUserByUsername(username string) (*User, error)
+	GetUserByOIDCSubject(subject string) (*User, error)
+	GetNotes(ownerID string) ([]Note, error)
+	GetNote(ownerID, id string) (*Note, error)
+	GetLinks(ownerID string) ([]Link, error)
+	GetLink(ownerID, id string) (*Link, error)
+	GetMedia(ownerID string) ([]Media, error)
+	GetMediaByID(ownerID, id string) (*Media, error)
+	GetMediaByIDAnyOwner(id string) (*Media, error)
+	GetCatalogItems(ownerID, kind, parentID string) ([]CatalogItem, error)
+	GetCatalogItem(ownerID, id string) (*CatalogItem, error)
+	GetProgress(ownerID, itemID string) (*Progress, error)
+	GetProgressList(ownerID, status string) ([]Progress, error)
+	GetContinueWatching(ownerID string, limit int) ([]Progress, error)
+	GetRecentlyCompleted(ownerID string, limit int) ([]Progress, error)
+	GetCollections(ownerID string) ([]Collection, error)
+	GetCollection(ownerID, id string) (*Collection, error)
+	GetCollectionItems(ownerID, collectionID string) ([]CollectionItem, error)
+	GetSigningKeys() ([]SigningKey, error)
+	GetAuditEntries(f AuditFilter) ([]AuditEntry, error)
+	ExportAuditEntries(f AuditFilter, fn func(AuditEntry) error) error
+	GetShares(ownerID string) ([]Share, error)
+	GetShareByToken(tokenHash string) (*Share, error)
 
 	// Update functions
-	UpdateNote(id, newNote string) error
+	UpdateNote(ownerID, id, newNote string) (Note, error)
+	TouchSession(id, ip string) error
+	TouchAPIToken(id string) error
+	SetTOTPSecret(userID, secret string) error
+	EnableTOTP(userID string, step int64, codeHashes []string) error
+	UseTOTPStep(userID string, step int64) (bool, error)
+	UseRecoveryCode(userID, codeHash string) (bool, error)
+	DisableTOTP(userID string) error
+	RotateRefreshToken(oldHash, newHash, accessHash string, expiresAt time.Time) (string, error)
+	UpdateUser(id string, role Role, disabled bool) (User, error)
+	SetUserPassword(id, passwordHash string) error
+	SetOIDCSubject(id, subject string) error
+	ClaimUnownedRecords(userID string) (int64, error)
+	RenameNote(ownerID, id, title string) error
+	RenameMedia(ownerID, id, filename string) error
+	ReplaceMediaFile(ownerID, id, path, mimeType string, size int64) error
+	UpdateCatalogItem(ownerID string, item CatalogItem) (CatalogItem, error)
+	UpdateProgressPosition(ownerID, itemID string, position, duration, page int) error
+	UpdateCollection(ownerID, id, name, coverMediaID string) (Collection, error)
+	MoveCollectionItem(ownerID, collectionID, itemID, afterID, beforeID string) (CollectionItem, error)
+	RotateSigningKey(k SigningKey, grace time.Duration) error
+	UseShare(id string) error
 
 	// Delete functions
 	DeleteToken(id string) error
-	DeleteNote(id string) error
-	DeleteLink(id string) error
+	DeleteSession(userID, id string) error
+	DeleteOtherSessions(userID, keepID string) (int64, error)
+	DeleteExpiredSessions(idleTimeout time.Duration) (int64, error)
+	DeleteExpiredRefreshTokens() (int64, error)
+	DeleteAPIToken(userID, id string) error
+	DeleteOldLoginAttempts(retention time.Duration) (int64, error)
+	DeleteNote(ownerID, id string) error
+	DeleteLink(ownerID, id string) error
+	DeleteMedia(ownerID, id string) error
+	DeleteCatalogItem(ownerID, id string) error
+	DetachCatalogMedia(ownerID, itemID, mediaID string) error
+	DetachCatalogLink(ownerID, itemID, linkID string) error
+	DeleteProgress(ownerID, itemID string) error
+	DeleteCollection(ownerID, id string) error
+	DeleteCollectionItem(ownerID, collectionID, itemID string) error
+	DeleteExpiredSigningKeys() (int64, error)
+	DeleteShare(ownerID, id string) error
 }
@@ -0,0 +1,86 @@
+package database
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// LoginFailures summarizes recent failed logins for a username or address.
+type LoginFailures struct {
+	Count int
+	// Since is how long ago the most recent failure was; zero if Count is zero.
+	Since time.Duration
+}
+
+// AddLoginAttempt records a login attempt in the LoginAttempt audit trail.
+// reason says why a failed attempt failed.
+func AddLoginAttempt(username, ip, userAgent string, success bool, reason string) error {
+	if db == nil {
+		return fmt.Errorf("database not initialized")
+	}
+	defer timeQuery("AddLoginAttempt", time.Now())
+	_, err := db.Exec(
+		`INSERT INTO LoginAttempt (id, username, ip, user_agent, success, reason, createdAt) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		uuid.New().String(), username, ip, userAgent, success, reason, time.Now(),
+	)
+	if err != nil {
+		return fmt.Errorf("insert login attempt: %w", err)
+	}
+	return nil
+}
+
+// GetLoginFailuresForUser counts failed logins for username within window
+// since its last successful login.
+func GetLoginFailuresForUser(username string, window time.Duration) (LoginFailures, error) {
+	defer timeQuery("GetLoginFailuresForUser", time.Now())
+	// rowid gives insertion order; julianday() only resolves milliseconds
+	return getLoginFailures(
+		`username = ? AND rowid > COALESCE((SELECT MAX(rowid) FROM LoginAttempt WHERE success AND username = ?), 0)`,
+		window, username, username,
+	)
+}
+
+// GetLoginFailuresFromIP counts failed logins from ip within window. Unlike
+// the per-user count, a success does not reset it, so an attacker cannot
+// clear it by logging in to an account of their own.
+func GetLoginFailuresFromIP(ip string, window time.Duration) (LoginFailures, error) {
+	defer timeQuery("GetLoginFailuresFromIP", time.Now())
+	return getLoginFailures(`ip = ?`, window, ip)
+}
+
+func getLoginFailures(where string, window time.Duration, args ...any) (LoginFailures, error) {
+	if db == nil {
+		return LoginFailures{}, fmt.Errorf("database not initialized")
+	}
+	var f LoginFailures
+	var since float64
+	err := db.QueryRow(
+		`SELECT COUNT(*), COALESCE((julianday('now') - julianday(MAX(createdAt))) * 86400, 0) FROM LoginAttempt
+		 WHERE NOT success AND (julianday('now') - julianday(createdAt)) * 86400 < ? AND `+where,
+		append([]any{window.Seconds()}, args...)...,
+	).Scan(&f.Count, &since)
+	if err != nil {
+		return LoginFailures{}, fmt.Errorf("count login failures: %w", err)
+	}
+	// 'now' only has milliseconds, so a failure just recorded can look
+	// a little in the future
+	f.Since = max(time.Duration(since*float64(time.Second)), 0)
+	return f, nil
+}
+
+// DeleteOldLoginAttempts removes audit trail entries older than retention.
+// Returns how many were removed.
+func DeleteOldLoginAttempts(retention time.Duration) (int64, error) {
+	if db == nil {
+		return 0, fmt.Errorf("database not initialized")
+	}
+	defer timeQuery("DeleteOldLoginAttempts", time.Now())
+	res, err := db.Exec(`DELETE FROM LoginAttempt WHERE (julianday('now') - julianday(createdAt)) * 86400 > ?`, retention.Seconds())
+	if err != nil {
+		return 0, fmt.Errorf("delete old login attempts: %w", err)
+	}
+	n, _ := res.RowsAffected()
+	return n, nil
+}
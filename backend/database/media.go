@@ -0,0 +1,144 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Media represents an uploaded media file. Path is relative to the media directory.
+type Media struct {
+	ID        string `json:"id"`
+	Filename  string `json:"filename"`
+	Path      string `json:"-"`
+	MimeType  string `json:"mimeType"`
+	Size      int64  `json:"size"`
+	CreatedAt string `json:"createdAt"`
+	UpdatedAt string `json:"updatedAt"`
+}
+
+// AddMedia inserts a media record owned by ownerID for a file already written
+// to storage. Returns the new record ID.
+func AddMedia(ownerID, filename, path, mimeType string, size int64) (string, error) {
+	if db == nil {
+		return "", fmt.Errorf("database not initialized")
+	}
+	defer timeQuery("AddMedia", time.Now())
+	id := uuid.New().String()
+	_, err := db.Exec(
+		`INSERT INTO Media (id, owner_id, filename, path, mime_type, size, createdAt, updatedAt) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		id, ownerID, filename, path, mimeType, size, time.Now(), time.Now(),
+	)
+	if err != nil {
+		return "", fmt.Errorf("insert media: %w", err)
+	}
+	return id, nil
+}
+
+// GetMedia retrieves all media records of ownerID, newest first.
+func GetMedia(ownerID string) ([]Media, error) {
+	if db == nil {
+		return nil, fmt.Errorf("database not initialized")
+	}
+	defer timeQuery("GetMedia", time.Now())
+
+	rows, err := db.Query(`SELECT id, filename, path, mime_type, size, createdAt, updatedAt FROM Media WHERE owner_id = ? ORDER BY createdAt DESC`, ownerID)
+	if err != nil {
+		return nil, fmt.Errorf("query media: %w", err)
+	}
+	defer rows.Close()
+
+	var media []Media
+	for rows.Next() {
+		var m Media
+		if err := rows.Scan(&m.ID, &m.Filename, &m.Path, &m.MimeType, &m.Size, &m.CreatedAt, &m.UpdatedAt); err != nil {
+			return nil, fmt.Errorf("scan media: %w", err)
+		}
+		media = append(media, m)
+	}
+	return media, nil
+}
+
+// GetMediaByID retrieves a single media record of ownerID.
+func GetMediaByID(ownerID, id string) (*Media, error) {
+	defer timeQuery("GetMediaByID", time.Now())
+	return getMedia(id, `WHERE id = ? AND owner_id = ?`, id, ownerID)
+}
+
+// GetMediaByIDAnyOwner retrieves a single media record regardless of its
+// owner. Only for requests authorized another way, such as a signed URL.
+func GetMediaByIDAnyOwner(id string) (*Media, error) {
+	defer timeQuery("GetMediaByIDAnyOwner", time.Now())
+	return getMedia(id, `WHERE id = ?`, id)
+}
+
+func getMedia(id, where string, args ...any) (*Media, error) {
+	if db == nil {
+		return nil, fmt.Errorf("database not initialized")
+	}
+
+	var m Media
+	err := db.QueryRow(`SELECT id, filename, path, mime_type, size, createdAt, updatedAt FROM Media `+where, args...).
+		Scan(&m.ID, &m.Filename, &m.Path, &m.MimeType, &m.Size, &m.CreatedAt, &m.UpdatedAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("media %s: %w", id, ErrNotFound)
+		}
+		return nil, fmt.Errorf("query media: %w", err)
+	}
+	return &m, nil
+}
+
+// RenameMedia changes the original file name shown for a media record of ownerID.
+func RenameMedia(ownerID, id, filename string) error {
+	if db == nil {
+		return fmt.Errorf("database not initialized")
+	}
+	defer timeQuery("RenameMedia", time.Now())
+	res, err := db.Exec(`UPDATE Media SET filename = ?, updatedAt = ? WHERE id = ? AND owner_id = ?`, filename, time.Now(), id, ownerID)
+	if err != nil {
+		return fmt.Errorf("rename media: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf("media %s: %w", id, ErrNotFound)
+	}
+	return nil
+}
+
+// ReplaceMediaFile points a media record of ownerID at a newly stored file.
+// The caller removes the previous file.
+func ReplaceMediaFile(ownerID, id, path, mimeType string, size int64) error {
+	if db == nil {
+		return fmt.Errorf("database not initialized")
+	}
+	defer timeQuery("ReplaceMediaFile", time.Now())
+	res, err := db.Exec(
+		`UPDATE Media SET path = ?, mime_type = ?, size = ?, updatedAt = ? WHERE id = ? AND owner_id = ?`,
+		path, mimeType, size, time.Now(), id, ownerID,
+	)
+	if err != nil {
+		return fmt.Errorf("replace media file: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf("media %s: %w", id, ErrNotFound)
+	}
+	return nil
+}
+
+// DeleteMedia removes a Media record of ownerID by ID. The caller removes the file itself.
+func DeleteMedia(ownerID, id string) error {
+	if db == nil {
+		return fmt.Errorf("database not initialized")
+	}
+	defer timeQuery("DeleteMedia", time.Now())
+	res, err := db.Exec(`DELETE FROM Media WHERE id = ? AND owner_id = ?`, id, ownerID)
+	if err != nil {
+		return fmt.Errorf("delete media: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf("media %s: %w", id, ErrNotFound)
+	}
+	return nil
+}
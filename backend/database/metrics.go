@@ -0,0 +1,65 @@
+package database
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var queryDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+	Name:    "db_query_duration_seconds",
+	Help:    "Time taken by database functions, by function.",
+	Buckets: []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1},
+}, []string{"operation"})
+
+func init() {
+	for state, value := range map[string]func(sql.DBStats) int{
+		"in_use": func(s sql.DBStats) int { return s.InUse },
+		"idle":   func(s sql.DBStats) int { return s.Idle },
+	} {
+		promauto.NewGaugeFunc(prometheus.GaugeOpts{
+			Name:        "db_connections",
+			Help:        "Database connections by state.",
+			ConstLabels: prometheus.Labels{"state": state},
+		}, func() float64 { return float64(value(Stats())) })
+	}
+	promauto.NewCounterFunc(prometheus.CounterOpts{
+		Name: "db_connection_waits_total",
+		Help: "Times a query had to wait for a free connection.",
+	}, func() float64 { return float64(Stats().WaitCount) })
+	promauto.NewCounterFunc(prometheus.CounterOpts{
+		Name: "db_connection_wait_seconds_total",
+		Help: "Time spent waiting for a free connection.",
+	}, func() float64 { return Stats().WaitDuration.Seconds() })
+	promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "sessions_active",
+		Help: "Login sessions that have not been revoked or swept.",
+	}, func() float64 {
+		n, _ := CountSessions()
+		return float64(n)
+	})
+	promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "api_tokens_active",
+		Help: "Personal access tokens that have not expired.",
+	}, func() float64 {
+		n, _ := CountAPITokens()
+		return float64(n)
+	})
+}
+
+// Stats returns the connection pool statistics, or zero ones while the
+// database is closed.
+func Stats() sql.DBStats {
+	if db == nil {
+		return sql.DBStats{}
+	}
+	return db.Stats()
+}
+
+// timeQuery records the time since start for operation, the exported
+// database function calling it.
+func timeQuery(operation string, start time.Time) {
+	queryDuration.WithLabelValues(operation).Observe(time.Since(start).Seconds())
+}
@@ -0,0 +1,167 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+// migrations bring databases created by older versions up to date with the
+// schema. They run in order after the CREATE TABLE statements in MustOpen,
+// each in its own transaction; PRAGMA user_version records how many have
+// been applied. Append new migrations, never edit or reorder existing ones.
+var migrations = []func(tx *sql.Tx) error{
+	// 1: session metadata for the sessions API
+	func(tx *sql.Tx) error {
+		return execAll(tx,
+			`ALTER TABLE Session ADD COLUMN user_agent TEXT NOT NULL DEFAULT ''`,
+			`ALTER TABLE Session ADD COLUMN ip TEXT NOT NULL DEFAULT ''`,
+		)
+	},
+	// 2: hash session tokens that were stored raw
+	func(tx *sql.Tx) error {
+		rows, err := tx.Query(`SELECT id, token_hash FROM Session WHERE token_hash LIKE '%.%'`)
+		if err != nil {
+			return err
+		}
+		raw := map[string]string{}
+		for rows.Next() {
+			var id, token string
+			if err := rows.Scan(&id, &token); err != nil {
+				rows.Close()
+				return err
+			}
+			raw[id] = token
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		for id, token := range raw {
+			if _, err := tx.Exec(`UPDATE Session SET token_hash = ? WHERE id = ?`, HashToken(token), id); err != nil {
+				return err
+			}
+		}
+		return execAll(tx,
+			// identical tokens could be issued within the same second before they carried an ID
+			`DELETE FROM Session WHERE rowid NOT IN (SELECT MIN(rowid) FROM Session GROUP BY token_hash)`,
+			`CREATE UNIQUE INDEX IF NOT EXISTS idx_session_token_hash ON Session(token_hash)`,
+		)
+	},
+	// 3: session expiry; tokens issued so far were valid for 168 hours
+	func(tx *sql.Tx) error {
+		return execAll(tx,
+			`ALTER TABLE Session ADD COLUMN expires_at DATETIME`,
+			`UPDATE Session SET expires_at = datetime(createdAt, '+168 hours')`,
+		)
+	},
+	// 4: sessions belong to users; sessions from the shared-key era are dropped
+	func(tx *sql.Tx) error {
+		return execAll(tx,
+			`ALTER TABLE Session ADD COLUMN user_id TEXT REFERENCES User(id) ON DELETE CASCADE`,
+			`DELETE FROM Session`,
+		)
+	},
+	// 5: library data belongs to users; rows from the single-user era go to
+	// the oldest administrator, or wait for ClaimUnownedRecords if there is none yet
+	func(tx *sql.Tx) error {
+		for _, table := range []string{"Note", "Link", "Media", "CatalogItem", "Collection"} {
+			err := execAll(tx,
+				`ALTER TABLE `+table+` ADD COLUMN owner_id TEXT REFERENCES User(id) ON DELETE CASCADE`,
+				`CREATE INDEX IF NOT EXISTS idx_`+strings.ToLower(table)+`_owner ON `+table+`(owner_id)`,
+				`UPDATE `+table+` SET owner_id = (SELECT id FROM User WHERE is_admin ORDER BY createdAt LIMIT 1)`,
+			)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	},
+	// 6: roles replace the admin flag; existing non-admins keep write access as editors
+	func(tx *sql.Tx) error {
+		return execAll(tx,
+			`ALTER TABLE User ADD COLUMN role TEXT NOT NULL DEFAULT 'editor'
+				CHECK (role IN ('admin', 'editor', 'viewer'))`,
+			`UPDATE User SET role = 'admin' WHERE is_admin`,
+			`ALTER TABLE User DROP COLUMN is_admin`,
+		)
+	},
+	// 7: optional TOTP second factor; the last used step stops code replay
+	func(tx *sql.Tx) error {
+		return execAll(tx,
+			`ALTER TABLE User ADD COLUMN totp_secret TEXT NOT NULL DEFAULT ''`,
+			`ALTER TABLE User ADD COLUMN totp_enabled BOOLEAN NOT NULL DEFAULT 0`,
+			`ALTER TABLE User ADD COLUMN totp_last_step INTEGER NOT NULL DEFAULT 0`,
+		)
+	},
+	// 8: link accounts to their single sign-on identity
+	func(tx *sql.Tx) error {
+		return execAll(tx,
+			`ALTER TABLE User ADD COLUMN oidc_subject TEXT`,
+			`CREATE UNIQUE INDEX IF NOT EXISTS idx_user_oidc_subject ON User(oidc_subject)`,
+		)
+	},
+}
+
+func execAll(tx *sql.Tx, stmts ...string) error {
+	for _, stmt := range stmts {
+		if _, err := tx.Exec(stmt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CheckMigrations returns an error unless every migration has been applied,
+// as when a newer version of the server already migrated the database.
+func CheckMigrations() error {
+	if db == nil {
+		return fmt.Errorf("database not initialized")
+	}
+	defer timeQuery("CheckMigrations", time.Now())
+	var version int
+	if err := db.QueryRow(`PRAGMA user_version`).Scan(&version); err != nil {
+		return fmt.Errorf("read schema version: %w", err)
+	}
+	if version != len(migrations) {
+		return fmt.Errorf("schema version %d, want %d", version, len(migrations))
+	}
+	return nil
+}
+
+// migrate applies pending migrations to d.
+func migrate(d *sql.DB) error {
+	return migrateTo(d, len(migrations))
+}
+
+// migrateTo applies pending migrations up to and including version target.
+func migrateTo(d *sql.DB, target int) error {
+	var version int
+	if err := d.QueryRow(`PRAGMA user_version`).Scan(&version); err != nil {
+		return fmt.Errorf("read schema version: %w", err)
+	}
+
+	for i := version; i < target; i++ {
+		tx, err := d.Begin()
+		if err != nil {
+			return fmt.Errorf("begin migration %d: %w", i+1, err)
+		}
+		if err := migrations[i](tx); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("migration %d: %w", i+1, err)
+		}
+		// PRAGMA does not take bind parameters
+		if _, err := tx.Exec(fmt.Sprintf(`PRAGMA user_version = %d`, i+1)); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("record migration %d: %w", i+1, err)
+		}
+		if err := tx.Commit(); err != nil {
+			return fmt.Errorf("commit migration %d: %w", i+1, err)
+		}
+		slog.Info("Applied database migration", slog.Int("version", i+1))
+	}
+	return nil
+}
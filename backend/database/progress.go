@@ -0,0 +1,285 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Watchlist statuses of a catalog item.
+const (
+	StatusPlanned    = "planned"
+	StatusInProgress = "in_progress"
+	StatusCompleted  = "completed"
+	StatusDropped    = "dropped"
+)
+
+// completedThreshold is the share of a known duration after which playback counts as finished.
+const completedThreshold = 0.95
+
+// Progress represents the watchlist state of one catalog item. Position and
+// Duration are in seconds, Page is for books. Rating is 0 when unset.
+type Progress struct {
+	ItemID     string `json:"itemId"`
+	Kind       string `json:"kind"`
+	Title      string `json:"title"`
+	Status     string `json:"status"`
+	Position   int    `json:"position"`
+	Duration   int    `json:"duration"`
+	Page       int    `json:"page"`
+	StartedAt  string `json:"startedAt,omitempty"`
+	FinishedAt string `json:"finishedAt,omitempty"`
+	Rating     int    `json:"rating,omitempty"`
+	Review     string `json:"review"`
+	CreatedAt  string `json:"createdAt"`
+	UpdatedAt  string `json:"updatedAt"`
+}
+
+// ProgressUpdate holds the writable fields of a Progress record.
+type ProgressUpdate struct {
+	ItemID     string
+	Status     string
+	Position   int
+	Duration   int
+	Page       int
+	StartedAt  *time.Time
+	FinishedAt *time.Time
+	Rating     int
+	Review     string
+}
+
+// ValidStatus reports whether s is a known watchlist status.
+func ValidStatus(s string) bool {
+	switch s {
+	case StatusPlanned, StatusInProgress, StatusCompleted, StatusDropped:
+		return true
+	}
+	return false
+}
+
+const progressSelect = `SELECT p.item_id, c.kind, c.title, p.status, p.position, p.duration, p.page,
+	p.started_at, p.finished_at, p.rating, p.review, p.createdAt, p.updatedAt
+	FROM Progress p JOIN CatalogItem c ON c.id = p.item_id`
+
+func scanProgress(row interface{ Scan(...any) error }) (Progress, error) {
+	var p Progress
+	var started, finished sql.NullString
+	var rating sql.NullInt64
+	err := row.Scan(&p.ItemID, &p.Kind, &p.Title, &p.Status, &p.Position, &p.Duration, &p.Page,
+		&started, &finished, &rating, &p.Review, &p.CreatedAt, &p.UpdatedAt)
+	p.StartedAt = started.String
+	p.FinishedAt = finished.String
+	p.Rating = int(rating.Int64)
+	return p, err
+}
+
+func queryProgress(query string, args ...any) ([]Progress, error) {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("query progress: %w", err)
+	}
+	defer rows.Close()
+
+	var list []Progress
+	for rows.Next() {
+		p, err := scanProgress(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scan progress: %w", err)
+		}
+		list = append(list, p)
+	}
+	return list, rows.Err()
+}
+
+// GetProgress retrieves the watchlist state of a catalog item of ownerID.
+func GetProgress(ownerID, itemID string) (*Progress, error) {
+	if db == nil {
+		return nil, fmt.Errorf("database not initialized")
+	}
+	defer timeQuery("GetProgress", time.Now())
+
+	p, err := scanProgress(db.QueryRow(progressSelect+` WHERE p.item_id = ? AND c.owner_id = ?`, itemID, ownerID))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("progress %s: %w", itemID, ErrNotFound)
+		}
+		return nil, fmt.Errorf("query progress: %w", err)
+	}
+	return &p, nil
+}
+
+// GetProgressList retrieves the watchlist of ownerID, optionally filtered by
+// status, most recently updated first.
+func GetProgressList(ownerID, status string) ([]Progress, error) {
+	if db == nil {
+		return nil, fmt.Errorf("database not initialized")
+	}
+	defer timeQuery("GetProgressList", time.Now())
+	if status == "" {
+		return queryProgress(progressSelect+` WHERE c.owner_id = ? ORDER BY p.updatedAt DESC`, ownerID)
+	}
+	return queryProgress(progressSelect+` WHERE c.owner_id = ? AND p.status = ? ORDER BY p.updatedAt DESC`, ownerID, status)
+}
+
+// GetContinueWatching retrieves in-progress items of ownerID, most recently touched first.
+func GetContinueWatching(ownerID string, limit int) ([]Progress, error) {
+	if db == nil {
+		return nil, fmt.Errorf("database not initialized")
+	}
+	defer timeQuery("GetContinueWatching", time.Now())
+	return queryProgress(progressSelect+` WHERE c.owner_id = ? AND p.status = ? ORDER BY p.updatedAt DESC LIMIT ?`, ownerID, StatusInProgress, limit)
+}
+
+// GetRecentlyCompleted retrieves completed items of ownerID, most recently finished first.
+func GetRecentlyCompleted(ownerID string, limit int) ([]Progress, error) {
+	if db == nil {
+		return nil, fmt.Errorf("database not initialized")
+	}
+	defer timeQuery("GetRecentlyCompleted", time.Now())
+	return queryProgress(progressSelect+` WHERE c.owner_id = ? AND p.status = ? ORDER BY p.finished_at DESC, p.updatedAt DESC LIMIT ?`, ownerID, StatusCompleted, limit)
+}
+
+// SetProgress creates or replaces the watchlist state of a catalog item of ownerID.
+// Start and finish dates are filled in automatically when the status moves
+// to in_progress or completed and no date was given. Leaving completed
+// clears the finish date, so a re-watch is finished anew.
+func SetProgress(ownerID string, u ProgressUpdate) (Progress, error) {
+	if db == nil {
+		return Progress{}, fmt.Errorf("database not initialized")
+	}
+	defer timeQuery("SetProgress", time.Now())
+	if err := checkOwner(db, "CatalogItem", ownerID, u.ItemID); err != nil {
+		return Progress{}, err
+	}
+
+	now := time.Now()
+	started, finished, err := currentProgressDates(u.ItemID)
+	if err != nil {
+		return Progress{}, err
+	}
+	if u.StartedAt != nil {
+		started = u.StartedAt
+	}
+	if u.FinishedAt != nil {
+		finished = u.FinishedAt
+	} else if u.Status != StatusCompleted {
+		finished = nil
+	}
+	if started == nil && (u.Status == StatusInProgress || u.Status == StatusCompleted) {
+		started = &now
+	}
+	if finished == nil && u.Status == StatusCompleted {
+		finished = &now
+	}
+
+	var rating any
+	if u.Rating != 0 {
+		rating = u.Rating
+	}
+
+	_, err = db.Exec(
+		`INSERT INTO Progress (item_id, status, position, duration, page, started_at, finished_at, rating, review, createdAt, updatedAt)
+		 VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		 ON CONFLICT(item_id) DO UPDATE SET
+			status = excluded.status, position = excluded.position, duration = excluded.duration,
+			page = excluded.page, started_at = excluded.started_at, finished_at = excluded.finished_at,
+			rating = excluded.rating, review = excluded.review, updatedAt = excluded.updatedAt`,
+		u.ItemID, u.Status, u.Position, u.Duration, u.Page, started, finished, rating, u.Review, now, now,
+	)
+	if err != nil {
+		return Progress{}, fmt.Errorf("set progress: %w", err)
+	}
+
+	p, err := GetProgress(ownerID, u.ItemID)
+	if err != nil {
+		return Progress{}, err
+	}
+	return *p, nil
+}
+
+// UpdateProgressPosition records a playback position or page reported by a
+// player. Planned (or untracked) items become in_progress, and items played
+// past completedThreshold of a known duration become completed. A completed
+// item played again from below the threshold goes back to in_progress, so
+// finishing it once more records a new finish date. The item must belong to
+// ownerID.
+func UpdateProgressPosition(ownerID, itemID string, position, duration, page int) error {
+	if db == nil {
+		return fmt.Errorf("database not initialized")
+	}
+	defer timeQuery("UpdateProgressPosition", time.Now())
+	if err := checkOwner(db, "CatalogItem", ownerID, itemID); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	status := StatusInProgress
+	var finished any
+	if duration > 0 && float64(position) >= completedThreshold*float64(duration) {
+		status = StatusCompleted
+		finished = now
+	}
+
+	_, err := db.Exec(
+		`INSERT INTO Progress (item_id, status, position, duration, page, started_at, finished_at, createdAt, updatedAt)
+		 VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+		 ON CONFLICT(item_id) DO UPDATE SET
+			status = CASE
+				WHEN excluded.status = 'completed' THEN 'completed'
+				WHEN Progress.status = 'planned' THEN 'in_progress'
+				WHEN Progress.status = 'completed' AND excluded.duration > 0 THEN 'in_progress'
+				ELSE Progress.status END,
+			position = excluded.position,
+			duration = CASE WHEN excluded.duration > 0 THEN excluded.duration ELSE Progress.duration END,
+			page = CASE WHEN excluded.page > 0 THEN excluded.page ELSE Progress.page END,
+			started_at = COALESCE(Progress.started_at, excluded.started_at),
+			finished_at = CASE
+				WHEN Progress.status = 'completed' AND (excluded.status = 'completed' OR excluded.duration = 0) THEN Progress.finished_at
+				ELSE excluded.finished_at END,
+			updatedAt = excluded.updatedAt`,
+		itemID, status, position, duration, page, now, finished, now, now,
+	)
+	if err != nil {
+		return fmt.Errorf("update progress position: %w", err)
+	}
+	return nil
+}
+
+// DeleteProgress removes an item of ownerID from the watchlist.
+func DeleteProgress(ownerID, itemID string) error {
+	if db == nil {
+		return fmt.Errorf("database not initialized")
+	}
+	defer timeQuery("DeleteProgress", time.Now())
+	res, err := db.Exec(
+		`DELETE FROM Progress WHERE item_id = ? AND item_id IN (SELECT id FROM CatalogItem WHERE owner_id = ?)`,
+		itemID, ownerID,
+	)
+	if err != nil {
+		return fmt.Errorf("delete progress: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf("progress %s: %w", itemID, ErrNotFound)
+	}
+	return nil
+}
+
+// currentProgressDates returns the stored start and finish dates of an item, if any.
+func currentProgressDates(itemID string) (started, finished *time.Time, err error) {
+	var s, f sql.NullTime
+	err = db.QueryRow(`SELECT started_at, finished_at FROM Progress WHERE item_id = ?`, itemID).Scan(&s, &f)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil, nil
+		}
+		return nil, nil, fmt.Errorf("query progress dates: %w", err)
+	}
+	if s.Valid {
+		started = &s.Time
+	}
+	if f.Valid {
+		finished = &f.Time
+	}
+	return started, finished, nil
+}
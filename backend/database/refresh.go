@@ -0,0 +1,133 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ErrRefreshTokenReused is returned when a refresh token that was already
+// exchanged is presented again. The session it belongs to has been revoked.
+var ErrRefreshTokenReused = errors.New("refresh token reused")
+
+// AddRefreshToken stores the hash of a refresh token issued for a session.
+func AddRefreshToken(sessionID, tokenHash string, expiresAt time.Time) error {
+	if db == nil {
+		return fmt.Errorf("database not initialized")
+	}
+	defer timeQuery("AddRefreshToken", time.Now())
+	_, err := db.Exec(
+		`INSERT INTO RefreshToken (id, session_id, token_hash, expires_at, createdAt) VALUES (?, ?, ?, ?, ?)`,
+		uuid.New().String(), sessionID, tokenHash, expiresAt, time.Now(),
+	)
+	if err != nil {
+		return fmt.Errorf("insert refresh token: %w", err)
+	}
+	return nil
+}
+
+// GetRefreshTokenUser returns the ID of the user a refresh token was issued to.
+func GetRefreshTokenUser(tokenHash string) (string, error) {
+	if db == nil {
+		return "", fmt.Errorf("database not initialized")
+	}
+	defer timeQuery("GetRefreshTokenUser", time.Now())
+	var userID sql.NullString
+	err := db.QueryRow(
+		`SELECT s.user_id FROM RefreshToken r JOIN Session s ON s.id = r.session_id WHERE r.token_hash = ?`,
+		tokenHash,
+	).Scan(&userID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return "", fmt.Errorf("refresh token: %w", ErrNotFound)
+		}
+		return "", fmt.Errorf("query refresh token: %w", err)
+	}
+	return userID.String, nil
+}
+
+// RotateRefreshToken exchanges the refresh token with hash oldHash for a new
+// one. The session's access token hash is replaced with accessHash and its
+// expiry extended to expiresAt, which also becomes the new refresh token's
+// expiry. Returns the session ID.
+//
+// A token that was already used revokes its whole session (the token family)
+// and yields ErrRefreshTokenReused with the revoked session's ID; an unknown or expired token yields ErrNotFound.
+func RotateRefreshToken(oldHash, newHash, accessHash string, expiresAt time.Time) (string, error) {
+	if db == nil {
+		return "", fmt.Errorf("database not initialized")
+	}
+	defer timeQuery("RotateRefreshToken", time.Now())
+
+	tx, err := db.Begin()
+	if err != nil {
+		return "", fmt.Errorf("begin rotate refresh token: %w", err)
+	}
+	defer tx.Rollback()
+
+	var id, sessionID string
+	var usedAt sql.NullString
+	var expired bool
+	err = tx.QueryRow(
+		`SELECT id, session_id, used_at, julianday(expires_at) < julianday('now') FROM RefreshToken WHERE token_hash = ?`,
+		oldHash,
+	).Scan(&id, &sessionID, &usedAt, &expired)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return "", fmt.Errorf("refresh token: %w", ErrNotFound)
+		}
+		return "", fmt.Errorf("query refresh token: %w", err)
+	}
+
+	if usedAt.Valid {
+		if _, err := tx.Exec(`DELETE FROM Session WHERE id = ?`, sessionID); err != nil {
+			return "", fmt.Errorf("revoke session: %w", err)
+		}
+		if err := tx.Commit(); err != nil {
+			return "", fmt.Errorf("commit revoke session: %w", err)
+		}
+		return sessionID, ErrRefreshTokenReused
+	}
+	if expired {
+		return "", fmt.Errorf("refresh token expired: %w", ErrNotFound)
+	}
+
+	if _, err := tx.Exec(`UPDATE RefreshToken SET used_at = ? WHERE id = ?`, time.Now(), id); err != nil {
+		return "", fmt.Errorf("mark refresh token used: %w", err)
+	}
+	if _, err := tx.Exec(
+		`INSERT INTO RefreshToken (id, session_id, token_hash, expires_at, createdAt) VALUES (?, ?, ?, ?, ?)`,
+		uuid.New().String(), sessionID, newHash, expiresAt, time.Now(),
+	); err != nil {
+		return "", fmt.Errorf("insert refresh token: %w", err)
+	}
+	if _, err := tx.Exec(
+		`UPDATE Session SET token_hash = ?, expires_at = ?, updatedAt = ? WHERE id = ?`,
+		accessHash, expiresAt, time.Now(), sessionID,
+	); err != nil {
+		return "", fmt.Errorf("update session: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return "", fmt.Errorf("commit rotate refresh token: %w", err)
+	}
+	return sessionID, nil
+}
+
+// DeleteExpiredRefreshTokens removes refresh tokens past their expiry. Used
+// tokens are kept until then so reuse can still be detected.
+func DeleteExpiredRefreshTokens() (int64, error) {
+	if db == nil {
+		return 0, fmt.Errorf("database not initialized")
+	}
+	defer timeQuery("DeleteExpiredRefreshTokens", time.Now())
+	res, err := db.Exec(`DELETE FROM RefreshToken WHERE julianday(expires_at) < julianday('now')`)
+	if err != nil {
+		return 0, fmt.Errorf("delete expired refresh tokens: %w", err)
+	}
+	n, _ := res.RowsAffected()
+	return n, nil
+}
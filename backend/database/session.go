@@ -0,0 +1,157 @@
+package database
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Session describes a logged-in client for the sessions API. The token itself
+// is never returned.
+type Session struct {
+	ID        string `json:"id"`
+	UserAgent string `json:"userAgent"`
+	IP        string `json:"ip"`
+	CreatedAt string `json:"createdAt"`
+	LastSeen  string `json:"lastSeen"`
+	ExpiresAt string `json:"expiresAt,omitempty"`
+}
+
+// HashToken returns the form a session token is stored and looked up in.
+// Tokens are long random-looking strings, so a plain SHA-256 is enough to
+// keep a leaked database from being replayable.
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// AddSession inserts a session for a newly issued token together with the
+// user and client it was issued to and when it expires. Returns the new record ID.
+func AddSession(userID, tokenHash, userAgent, ip string, expiresAt time.Time) (string, error) {
+	if db == nil {
+		return "", fmt.Errorf("database not initialized")
+	}
+	defer timeQuery("AddSession", time.Now())
+	id := uuid.New().String()
+	_, err := db.Exec(
+		`INSERT INTO Session (id, user_id, token_hash, user_agent, ip, expires_at, createdAt, updatedAt) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		id, userID, tokenHash, userAgent, ip, expiresAt, time.Now(), time.Now(),
+	)
+	if err != nil {
+		return "", fmt.Errorf("insert session: %w", err)
+	}
+	return id, nil
+}
+
+// GetSessions retrieves a user's sessions, most recently active first.
+func GetSessions(userID string) ([]Session, error) {
+	if db == nil {
+		return nil, fmt.Errorf("database not initialized")
+	}
+	defer timeQuery("GetSessions", time.Now())
+
+	rows, err := db.Query(`SELECT id, user_agent, ip, createdAt, updatedAt, expires_at FROM Session WHERE user_id = ? ORDER BY updatedAt DESC`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("query sessions: %w", err)
+	}
+	defer rows.Close()
+
+	var sessions []Session
+	for rows.Next() {
+		var s Session
+		var expires sql.NullString
+		if err := rows.Scan(&s.ID, &s.UserAgent, &s.IP, &s.CreatedAt, &s.LastSeen, &expires); err != nil {
+			return nil, fmt.Errorf("scan session: %w", err)
+		}
+		s.ExpiresAt = expires.String
+		sessions = append(sessions, s)
+	}
+	return sessions, rows.Err()
+}
+
+// CountSessions returns the number of users' login sessions that have not
+// expired.
+func CountSessions() (int, error) {
+	if db == nil {
+		return 0, fmt.Errorf("database not initialized")
+	}
+	defer timeQuery("CountSessions", time.Now())
+	var n int
+	err := db.QueryRow(
+		`SELECT COUNT(*) FROM Session WHERE user_id IS NOT NULL AND (expires_at IS NULL OR julianday(expires_at) > julianday(?))`,
+		time.Now(),
+	).Scan(&n)
+	if err != nil {
+		return 0, fmt.Errorf("count sessions: %w", err)
+	}
+	return n, nil
+}
+
+// DeleteSession removes one of a user's sessions.
+func DeleteSession(userID, id string) error {
+	if db == nil {
+		return fmt.Errorf("database not initialized")
+	}
+	defer timeQuery("DeleteSession", time.Now())
+	res, err := db.Exec(`DELETE FROM Session WHERE id = ? AND user_id = ?`, id, userID)
+	if err != nil {
+		return fmt.Errorf("delete session: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf("session %s: %w", id, ErrNotFound)
+	}
+	return nil
+}
+
+// DeleteOtherSessions removes every session of a user except keepID. Returns how many were removed.
+func DeleteOtherSessions(userID, keepID string) (int64, error) {
+	if db == nil {
+		return 0, fmt.Errorf("database not initialized")
+	}
+	defer timeQuery("DeleteOtherSessions", time.Now())
+	res, err := db.Exec(`DELETE FROM Session WHERE user_id = ? AND id != ?`, userID, keepID)
+	if err != nil {
+		return 0, fmt.Errorf("delete other sessions: %w", err)
+	}
+	n, _ := res.RowsAffected()
+	return n, nil
+}
+
+// TouchSession records that a session was just used, and from where.
+func TouchSession(id, ip string) error {
+	if db == nil {
+		return fmt.Errorf("database not initialized")
+	}
+	defer timeQuery("TouchSession", time.Now())
+	_, err := db.Exec(`UPDATE Session SET updatedAt = ?, ip = ? WHERE id = ?`, time.Now(), ip, id)
+	if err != nil {
+		return fmt.Errorf("touch session: %w", err)
+	}
+	return nil
+}
+
+// DeleteExpiredSessions removes sessions whose token has expired and, when
+// idleTimeout is positive, sessions not used for longer than idleTimeout.
+// Returns how many were removed.
+func DeleteExpiredSessions(idleTimeout time.Duration) (int64, error) {
+	if db == nil {
+		return 0, fmt.Errorf("database not initialized")
+	}
+	defer timeQuery("DeleteExpiredSessions", time.Now())
+	idle := idleTimeout.Seconds()
+	res, err := db.Exec(
+		`DELETE FROM Session
+		 WHERE julianday(expires_at) < julianday('now')
+		    OR (? > 0 AND (julianday('now') - julianday(updatedAt)) * 86400 > ?)`,
+		idle, idle,
+	)
+	if err != nil {
+		return 0, fmt.Errorf("delete expired sessions: %w", err)
+	}
+	n, _ := res.RowsAffected()
+	return n, nil
+}
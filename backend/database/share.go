@@ -0,0 +1,153 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Share is a public, read-only link to one note or link. Like API tokens,
+// the share token itself is never stored; it is looked up by HashToken.
+type Share struct {
+	ID           string `json:"id"`
+	OwnerID      string `json:"-"`
+	NoteID       string `json:"noteId,omitempty"`
+	LinkID       string `json:"linkId,omitempty"`
+	Title        string `json:"title,omitempty"`
+	PasswordHash string `json:"-"`
+	HasPassword  bool   `json:"hasPassword"`
+	ExpiresAt    string `json:"expiresAt,omitempty"`
+	MaxViews     int    `json:"maxViews,omitempty"`
+	Views        int    `json:"views"`
+	CreatedAt    string `json:"createdAt"`
+}
+
+// a share is live until it expires or runs out of views, and while its
+// owner is not disabled; the time argument is now, since julianday('now')
+// only has millisecond resolution
+const shareLive = `(s.expires_at IS NULL OR julianday(s.expires_at) > julianday(?)) AND (s.max_views = 0 OR s.views < s.max_views)
+	AND EXISTS (SELECT 1 FROM User u WHERE u.id = s.owner_id AND NOT u.disabled)`
+
+const shareSelect = `SELECT s.id, s.owner_id, COALESCE(s.note_id, ''), COALESCE(s.link_id, ''), COALESCE(n.title, l.link, ''),
+	s.password_hash, s.expires_at, s.max_views, s.views, s.createdAt
+	FROM Share s LEFT JOIN Note n ON n.id = s.note_id LEFT JOIN Link l ON l.id = s.link_id`
+
+func scanShare(row interface{ Scan(...any) error }) (Share, error) {
+	var s Share
+	var expires sql.NullString
+	if err := row.Scan(&s.ID, &s.OwnerID, &s.NoteID, &s.LinkID, &s.Title,
+		&s.PasswordHash, &expires, &s.MaxViews, &s.Views, &s.CreatedAt); err != nil {
+		return Share{}, err
+	}
+	s.HasPassword = s.PasswordHash != ""
+	s.ExpiresAt = expires.String
+	return s, nil
+}
+
+// AddShare shares one of ownerID's notes or links; exactly one of noteID and
+// linkID is set. An empty passwordHash needs no password, a zero maxViews
+// allows any number of views and a nil expiresAt never expires. Returns the
+// new record ID.
+func AddShare(ownerID, noteID, linkID, tokenHash, passwordHash string, maxViews int, expiresAt *time.Time) (string, error) {
+	if db == nil {
+		return "", fmt.Errorf("database not initialized")
+	}
+	defer timeQuery("AddShare", time.Now())
+	if (noteID == "") == (linkID == "") {
+		return "", fmt.Errorf("share needs exactly one of a note and a link")
+	}
+	if noteID != "" {
+		if err := checkOwner(db, "Note", ownerID, noteID); err != nil {
+			return "", err
+		}
+	} else if err := checkOwner(db, "Link", ownerID, linkID); err != nil {
+		return "", err
+	}
+
+	id := uuid.New().String()
+	_, err := db.Exec(
+		`INSERT INTO Share (id, owner_id, note_id, link_id, token_hash, password_hash, expires_at, max_views, createdAt) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		id, ownerID, nullString(noteID), nullString(linkID), tokenHash, passwordHash, expiresAt, maxViews, time.Now(),
+	)
+	if err != nil {
+		return "", fmt.Errorf("insert share: %w", err)
+	}
+	return id, nil
+}
+
+// GetShares retrieves ownerID's shares, newest first, including expired and
+// used up ones.
+func GetShares(ownerID string) ([]Share, error) {
+	if db == nil {
+		return nil, fmt.Errorf("database not initialized")
+	}
+	defer timeQuery("GetShares", time.Now())
+
+	rows, err := db.Query(shareSelect+` WHERE s.owner_id = ? ORDER BY s.createdAt DESC`, ownerID)
+	if err != nil {
+		return nil, fmt.Errorf("query shares: %w", err)
+	}
+	defer rows.Close()
+
+	shares := []Share{}
+	for rows.Next() {
+		s, err := scanShare(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scan share: %w", err)
+		}
+		shares = append(shares, s)
+	}
+	return shares, rows.Err()
+}
+
+// GetShareByToken retrieves the live share with tokenHash, or ErrNotFound
+// once it has expired or run out of views or its owner is disabled.
+func GetShareByToken(tokenHash string) (*Share, error) {
+	if db == nil {
+		return nil, fmt.Errorf("database not initialized")
+	}
+	defer timeQuery("GetShareByToken", time.Now())
+	s, err := scanShare(db.QueryRow(shareSelect+` WHERE s.token_hash = ? AND `+shareLive, tokenHash, time.Now()))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("share: %w", ErrNotFound)
+		}
+		return nil, fmt.Errorf("query share: %w", err)
+	}
+	return &s, nil
+}
+
+// UseShare counts a view of a share. Returns ErrNotFound if the share is no
+// longer live, so concurrent views cannot exceed its limit.
+func UseShare(id string) error {
+	if db == nil {
+		return fmt.Errorf("database not initialized")
+	}
+	defer timeQuery("UseShare", time.Now())
+	res, err := db.Exec(`UPDATE Share AS s SET views = views + 1 WHERE s.id = ? AND `+shareLive, id, time.Now())
+	if err != nil {
+		return fmt.Errorf("use share: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf("share %s: %w", id, ErrNotFound)
+	}
+	return nil
+}
+
+// DeleteShare revokes one of ownerID's shares.
+func DeleteShare(ownerID, id string) error {
+	if db == nil {
+		return fmt.Errorf("database not initialized")
+	}
+	defer timeQuery("DeleteShare", time.Now())
+	res, err := db.Exec(`DELETE FROM Share WHERE id = ? AND owner_id = ?`, id, ownerID)
+	if err != nil {
+		return fmt.Errorf("delete share: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf("share %s: %w", id, ErrNotFound)
+	}
+	return nil
+}
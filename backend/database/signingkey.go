@@ -0,0 +1,110 @@
+package database
+
+import (
+	"fmt"
+	"time"
+)
+
+// LegacyKeyID is the ID of the key that stands for JWT_KEY, which signed
+// tokens without a key ID before the first rotation.
+const LegacyKeyID = ""
+
+// SigningKey is a key for signing and verifying JWTs. Exactly one key is the
+// signing key; retired keys still verify tokens until ExpiresAt.
+type SigningKey struct {
+	ID         string `json:"id"`
+	Algorithm  string `json:"algorithm"`
+	PrivateKey []byte `json:"-"`
+	PublicKey  []byte `json:"-"`
+	Signing    bool   `json:"signing"`
+	ExpiresAt  string `json:"expiresAt,omitempty"`
+	CreatedAt  string `json:"createdAt"`
+}
+
+// GetSigningKeys returns the keys that have not expired, oldest first.
+func GetSigningKeys() ([]SigningKey, error) {
+	if db == nil {
+		return nil, fmt.Errorf("database not initialized")
+	}
+	defer timeQuery("GetSigningKeys", time.Now())
+
+	// compare against the same clock expires_at was set from; julianday('now')
+	// is truncated to the millisecond and would briefly keep a key retired
+	// with no grace period
+	rows, err := db.Query(
+		`SELECT id, algorithm, private_key, public_key, signing, COALESCE(expires_at, ''), createdAt FROM SigningKey
+		 WHERE expires_at IS NULL OR julianday(expires_at) > julianday(?)
+		 ORDER BY rowid`,
+		time.Now(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("query signing keys: %w", err)
+	}
+	defer rows.Close()
+
+	var keys []SigningKey
+	for rows.Next() {
+		var k SigningKey
+		if err := rows.Scan(&k.ID, &k.Algorithm, &k.PrivateKey, &k.PublicKey, &k.Signing, &k.ExpiresAt, &k.CreatedAt); err != nil {
+			return nil, fmt.Errorf("scan signing key: %w", err)
+		}
+		keys = append(keys, k)
+	}
+	return keys, rows.Err()
+}
+
+// RotateSigningKey makes k the signing key. The previous signing key keeps
+// verifying tokens for grace. On the first rotation the legacy JWT_KEY is
+// recorded as that previous key, so tokens it signed stay valid too.
+func RotateSigningKey(k SigningKey, grace time.Duration) error {
+	if db == nil {
+		return fmt.Errorf("database not initialized")
+	}
+	defer timeQuery("RotateSigningKey", time.Now())
+
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("begin rotate signing key: %w", err)
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	expires := now.Add(grace)
+	var n int
+	if err := tx.QueryRow(`SELECT COUNT(*) FROM SigningKey`).Scan(&n); err != nil {
+		return fmt.Errorf("count signing keys: %w", err)
+	}
+	if n == 0 {
+		if _, err := tx.Exec(
+			`INSERT INTO SigningKey (id, algorithm, expires_at, createdAt) VALUES (?, 'HS256', ?, ?)`,
+			LegacyKeyID, expires, now,
+		); err != nil {
+			return fmt.Errorf("record legacy signing key: %w", err)
+		}
+	}
+	if _, err := tx.Exec(`UPDATE SigningKey SET signing = 0, expires_at = ? WHERE signing`, expires); err != nil {
+		return fmt.Errorf("retire signing key: %w", err)
+	}
+	if _, err := tx.Exec(
+		`INSERT INTO SigningKey (id, algorithm, private_key, public_key, signing, createdAt) VALUES (?, ?, ?, ?, 1, ?)`,
+		k.ID, k.Algorithm, k.PrivateKey, k.PublicKey, now,
+	); err != nil {
+		return fmt.Errorf("insert signing key: %w", err)
+	}
+	return tx.Commit()
+}
+
+// DeleteExpiredSigningKeys removes keys whose grace period has ended.
+// Returns how many were removed.
+func DeleteExpiredSigningKeys() (int64, error) {
+	if db == nil {
+		return 0, fmt.Errorf("database not initialized")
+	}
+	defer timeQuery("DeleteExpiredSigningKeys", time.Now())
+	res, err := db.Exec(`DELETE FROM SigningKey WHERE julianday(expires_at) <= julianday(?)`, time.Now())
+	if err != nil {
+		return 0, fmt.Errorf("delete expired signing keys: %w", err)
+	}
+	n, _ := res.RowsAffected()
+	return n, nil
+}
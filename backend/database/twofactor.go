@@ -0,0 +1,136 @@
+package database
+
+import (
+	"fmt"
+	"time"
+)
+
+// SetTOTPSecret stores a new, not yet confirmed TOTP secret for a user.
+// Two-factor login stays off until EnableTOTP.
+func SetTOTPSecret(userID, secret string) error {
+	if db == nil {
+		return fmt.Errorf("database not initialized")
+	}
+	defer timeQuery("SetTOTPSecret", time.Now())
+	res, err := db.Exec(
+		`UPDATE User SET totp_secret = ?, totp_enabled = 0, totp_last_step = 0, updatedAt = ? WHERE id = ?`,
+		secret, time.Now(), userID,
+	)
+	if err != nil {
+		return fmt.Errorf("set totp secret: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf("user %s: %w", userID, ErrNotFound)
+	}
+	return nil
+}
+
+// EnableTOTP turns on two-factor login for a user whose pending secret was
+// just confirmed with the code for step, and replaces their recovery codes
+// with codeHashes.
+func EnableTOTP(userID string, step int64, codeHashes []string) error {
+	if db == nil {
+		return fmt.Errorf("database not initialized")
+	}
+	defer timeQuery("EnableTOTP", time.Now())
+
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("begin enable totp: %w", err)
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec(
+		`UPDATE User SET totp_enabled = 1, totp_last_step = ?, updatedAt = ? WHERE id = ? AND totp_secret != ''`,
+		step, time.Now(), userID,
+	)
+	if err != nil {
+		return fmt.Errorf("enable totp: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf("user %s: %w", userID, ErrNotFound)
+	}
+	if _, err := tx.Exec(`DELETE FROM RecoveryCode WHERE user_id = ?`, userID); err != nil {
+		return fmt.Errorf("delete recovery codes: %w", err)
+	}
+	for _, h := range codeHashes {
+		if _, err := tx.Exec(`INSERT INTO RecoveryCode (user_id, code_hash) VALUES (?, ?)`, userID, h); err != nil {
+			return fmt.Errorf("insert recovery code: %w", err)
+		}
+	}
+	return tx.Commit()
+}
+
+// UseTOTPStep records that the code for step was used to log in. It returns
+// false if that step or a later one was already used, so a code cannot be
+// replayed.
+func UseTOTPStep(userID string, step int64) (bool, error) {
+	if db == nil {
+		return false, fmt.Errorf("database not initialized")
+	}
+	defer timeQuery("UseTOTPStep", time.Now())
+	res, err := db.Exec(`UPDATE User SET totp_last_step = ? WHERE id = ? AND totp_last_step < ?`, step, userID, step)
+	if err != nil {
+		return false, fmt.Errorf("use totp step: %w", err)
+	}
+	n, _ := res.RowsAffected()
+	return n > 0, nil
+}
+
+// UseRecoveryCode consumes one of a user's recovery codes. It returns false
+// if the code does not exist or was already used.
+func UseRecoveryCode(userID, codeHash string) (bool, error) {
+	if db == nil {
+		return false, fmt.Errorf("database not initialized")
+	}
+	defer timeQuery("UseRecoveryCode", time.Now())
+	res, err := db.Exec(`DELETE FROM RecoveryCode WHERE user_id = ? AND code_hash = ?`, userID, codeHash)
+	if err != nil {
+		return false, fmt.Errorf("use recovery code: %w", err)
+	}
+	n, _ := res.RowsAffected()
+	return n > 0, nil
+}
+
+// CountRecoveryCodes returns how many unused recovery codes a user has left.
+func CountRecoveryCodes(userID string) (int, error) {
+	if db == nil {
+		return 0, fmt.Errorf("database not initialized")
+	}
+	defer timeQuery("CountRecoveryCodes", time.Now())
+	var n int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM RecoveryCode WHERE user_id = ?`, userID).Scan(&n); err != nil {
+		return 0, fmt.Errorf("count recovery codes: %w", err)
+	}
+	return n, nil
+}
+
+// DisableTOTP turns off two-factor login for a user and removes their secret
+// and recovery codes.
+func DisableTOTP(userID string) error {
+	if db == nil {
+		return fmt.Errorf("database not initialized")
+	}
+	defer timeQuery("DisableTOTP", time.Now())
+
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("begin disable totp: %w", err)
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec(
+		`UPDATE User SET totp_secret = '', totp_enabled = 0, totp_last_step = 0, updatedAt = ? WHERE id = ?`,
+		time.Now(), userID,
+	)
+	if err != nil {
+		return fmt.Errorf("disable totp: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf("user %s: %w", userID, ErrNotFound)
+	}
+	if _, err := tx.Exec(`DELETE FROM RecoveryCode WHERE user_id = ?`, userID); err != nil {
+		return fmt.Errorf("delete recovery codes: %w", err)
+	}
+	return tx.Commit()
+}
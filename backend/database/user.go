@@ -0,0 +1,290 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ErrUsernameTaken is returned when a username is already in use.
+var ErrUsernameTaken = errors.New("username already taken")
+
+// ErrAlreadyLinked is returned when an account or a single sign-on identity
+// is already linked to another one.
+var ErrAlreadyLinked = errors.New("already linked to another identity")
+
+// Role decides what a user is allowed to do: viewers can only read, editors
+// can also change their library, and admins can additionally manage users.
+type Role string
+
+const (
+	RoleAdmin  Role = "admin"
+	RoleEditor Role = "editor"
+	RoleViewer Role = "viewer"
+)
+
+// Valid reports whether r is one of the known roles.
+func (r Role) Valid() bool {
+	return r == RoleAdmin || r == RoleEditor || r == RoleViewer
+}
+
+// User is an account that can log in. PasswordHash is never serialized.
+type User struct {
+	ID           string `json:"id"`
+	Username     string `json:"username"`
+	PasswordHash string `json:"-"`
+	Role         Role   `json:"role"`
+	Disabled     bool   `json:"disabled"`
+	TOTPSecret   string `json:"-"`
+	TwoFactor    bool   `json:"twoFactor"`
+	OIDCSubject  string `json:"oidcSubject,omitempty"`
+	CreatedAt    string `json:"createdAt"`
+	UpdatedAt    string `json:"updatedAt"`
+}
+
+const userSelect = `SELECT id, username, password_hash, role, disabled, totp_secret, totp_enabled, COALESCE(oidc_subject, ''), createdAt, updatedAt FROM User`
+
+func scanUser(row interface{ Scan(...any) error }) (User, error) {
+	var u User
+	err := row.Scan(&u.ID, &u.Username, &u.PasswordHash, &u.Role, &u.Disabled, &u.TOTPSecret, &u.TwoFactor, &u.OIDCSubject, &u.CreatedAt, &u.UpdatedAt)
+	return u, err
+}
+
+// AddUser inserts a user with an already hashed password. Returns the new record ID.
+func AddUser(username, passwordHash string, role Role) (string, error) {
+	if db == nil {
+		return "", fmt.Errorf("database not initialized")
+	}
+	defer timeQuery("AddUser", time.Now())
+	id := uuid.New().String()
+	_, err := db.Exec(
+		`INSERT INTO User (id, username, password_hash, role, createdAt, updatedAt) VALUES (?, ?, ?, ?, ?, ?)`,
+		id, username, passwordHash, role, time.Now(), time.Now(),
+	)
+	if err != nil {
+		if strings.Contains(err.Error(), "UNIQUE constraint failed") {
+			return "", fmt.Errorf("user %s: %w", username, ErrUsernameTaken)
+		}
+		return "", fmt.Errorf("insert user: %w", err)
+	}
+	return id, nil
+}
+
+// AddOIDCUser inserts a user provisioned on their first single sign-on login.
+// They have no password, so they can only log in through the identity provider.
+func AddOIDCUser(username, subject string, role Role) (string, error) {
+	if db == nil {
+		return "", fmt.Errorf("database not initialized")
+	}
+	defer timeQuery("AddOIDCUser", time.Now())
+	id := uuid.New().String()
+	_, err := db.Exec(
+		`INSERT INTO User (id, username, password_hash, role, oidc_subject, createdAt, updatedAt) VALUES (?, ?, '', ?, ?, ?, ?)`,
+		id, username, role, subject, time.Now(), time.Now(),
+	)
+	if err != nil {
+		if strings.Contains(err.Error(), "UNIQUE constraint failed: User.oidc_subject") {
+			return "", fmt.Errorf("subject %s: %w", subject, ErrAlreadyLinked)
+		}
+		if strings.Contains(err.Error(), "UNIQUE constraint failed") {
+			return "", fmt.Errorf("user %s: %w", username, ErrUsernameTaken)
+		}
+		return "", fmt.Errorf("insert user: %w", err)
+	}
+	return id, nil
+}
+
+// SetOIDCSubject links a user to a single sign-on identity, replacing any
+// earlier link, or unlinks them when subject is empty. It returns
+// ErrAlreadyLinked if another user is linked to subject.
+func SetOIDCSubject(id, subject string) error {
+	if db == nil {
+		return fmt.Errorf("database not initialized")
+	}
+	defer timeQuery("SetOIDCSubject", time.Now())
+	var value any
+	if subject != "" {
+		value = subject
+	}
+	res, err := db.Exec(`UPDATE User SET oidc_subject = ?, updatedAt = ? WHERE id = ?`, value, time.Now(), id)
+	if err != nil {
+		if strings.Contains(err.Error(), "UNIQUE constraint failed") {
+			return fmt.Errorf("subject %s: %w", subject, ErrAlreadyLinked)
+		}
+		return fmt.Errorf("link user: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf("user %s: %w", id, ErrNotFound)
+	}
+	return nil
+}
+
+// ClaimUnownedRecords gives library records without an owner, left over
+// from before user accounts existed, to userID. Returns how many were claimed.
+func ClaimUnownedRecords(userID string) (int64, error) {
+	if db == nil {
+		return 0, fmt.Errorf("database not initialized")
+	}
+	defer timeQuery("ClaimUnownedRecords", time.Now())
+
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("begin claim records: %w", err)
+	}
+	defer tx.Rollback()
+
+	var total int64
+	for _, table := range ownedTables {
+		res, err := tx.Exec(`UPDATE `+table+` SET owner_id = ? WHERE owner_id IS NULL`, userID)
+		if err != nil {
+			return 0, fmt.Errorf("claim %s records: %w", table, err)
+		}
+		n, _ := res.RowsAffected()
+		total += n
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("commit claim records: %w", err)
+	}
+	return total, nil
+}
+
+// CountUsers returns the number of user accounts.
+func CountUsers() (int, error) {
+	if db == nil {
+		return 0, fmt.Errorf("database not initialized")
+	}
+	defer timeQuery("CountUsers", time.Now())
+	var n int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM User`).Scan(&n); err != nil {
+		return 0, fmt.Errorf("count users: %w", err)
+	}
+	return n, nil
+}
+
+// GetUsers retrieves all users ordered by username.
+func GetUsers() ([]User, error) {
+	if db == nil {
+		return nil, fmt.Errorf("database not initialized")
+	}
+	defer timeQuery("GetUsers", time.Now())
+
+	rows, err := db.Query(userSelect + ` ORDER BY username`)
+	if err != nil {
+		return nil, fmt.Errorf("query users: %w", err)
+	}
+	defer rows.Close()
+
+	var users []User
+	for rows.Next() {
+		u, err := scanUser(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scan user: %w", err)
+		}
+		users = append(users, u)
+	}
+	return users, rows.Err()
+}
+
+// GetUser retrieves a single user by ID.
+func GetUser(id string) (*User, error) {
+	defer timeQuery("GetUser", time.Now())
+	return getUser(`WHERE id = ?`, id)
+}
+
+// GetUserByUsername retrieves a single user by username, ignoring case.
+func GetUserByUsername(username string) (*User, error) {
+	defer timeQuery("GetUserByUsername", time.Now())
+	return getUser(`WHERE username = ?`, username)
+}
+
+// GetUserByOIDCSubject retrieves the user linked to a single sign-on identity.
+func GetUserByOIDCSubject(subject string) (*User, error) {
+	defer timeQuery("GetUserByOIDCSubject", time.Now())
+	return getUser(`WHERE oidc_subject = ?`, subject)
+}
+
+func getUser(where string, arg string) (*User, error) {
+	if db == nil {
+		return nil, fmt.Errorf("database not initialized")
+	}
+	u, err := scanUser(db.QueryRow(userSelect+` `+where, arg))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("user %s: %w", arg, ErrNotFound)
+		}
+		return nil, fmt.Errorf("query user: %w", err)
+	}
+	return &u, nil
+}
+
+// UpdateUser changes a user's role and disabled state. Disabling a user also
+// ends all of their sessions.
+func UpdateUser(id string, role Role, disabled bool) (User, error) {
+	if db == nil {
+		return User{}, fmt.Errorf("database not initialized")
+	}
+	defer timeQuery("UpdateUser", time.Now())
+
+	tx, err := db.Begin()
+	if err != nil {
+		return User{}, fmt.Errorf("begin update user: %w", err)
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec(`UPDATE User SET role = ?, disabled = ?, updatedAt = ? WHERE id = ?`, role, disabled, time.Now(), id)
+	if err != nil {
+		return User{}, fmt.Errorf("update user: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return User{}, fmt.Errorf("user %s: %w", id, ErrNotFound)
+	}
+	if disabled {
+		if _, err := tx.Exec(`DELETE FROM Session WHERE user_id = ?`, id); err != nil {
+			return User{}, fmt.Errorf("revoke user sessions: %w", err)
+		}
+	}
+
+	u, err := scanUser(tx.QueryRow(userSelect+` WHERE id = ?`, id))
+	if err != nil {
+		return User{}, fmt.Errorf("query user: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return User{}, fmt.Errorf("commit update user: %w", err)
+	}
+	return u, nil
+}
+
+// SetUserPassword replaces a user's password hash, ends all of their sessions
+// and revokes their personal access tokens, so a reset locks out whoever
+// knew the old password.
+func SetUserPassword(id, passwordHash string) error {
+	if db == nil {
+		return fmt.Errorf("database not initialized")
+	}
+	defer timeQuery("SetUserPassword", time.Now())
+
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("begin set password: %w", err)
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec(`UPDATE User SET password_hash = ?, updatedAt = ? WHERE id = ?`, passwordHash, time.Now(), id)
+	if err != nil {
+		return fmt.Errorf("set password: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf("user %s: %w", id, ErrNotFound)
+	}
+	if _, err := tx.Exec(`DELETE FROM Session WHERE user_id = ?`, id); err != nil {
+		return fmt.Errorf("revoke user sessions: %w", err)
+	}
+	if _, err := tx.Exec(`DELETE FROM ApiToken WHERE user_id = ?`, id); err != nil {
+		return fmt.Errorf("revoke user api tokens: %w", err)
+	}
+	return tx.Commit()
+}
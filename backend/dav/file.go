@@ -0,0 +1,441 @@
+package dav
+
+import (
+	"bytes"
+	"cmp"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"media_management_go/backend/database"
+	"media_management_go/backend/storage"
+
+	"golang.org/x/net/webdav"
+)
+
+// fileInfo is the os.FileInfo of a node.
+type fileInfo struct {
+	name    string
+	size    int64
+	modTime time.Time
+	dir     bool
+}
+
+func (fi fileInfo) Name() string       { return fi.name }
+func (fi fileInfo) Size() int64        { return fi.size }
+func (fi fileInfo) ModTime() time.Time { return fi.modTime }
+func (fi fileInfo) IsDir() bool        { return fi.dir }
+func (fi fileInfo) Sys() any           { return nil }
+
+func (fi fileInfo) Mode() fs.FileMode {
+	if fi.dir {
+		return fs.ModeDir | 0o755
+	}
+	return 0o644
+}
+
+func (n node) info() fileInfo {
+	switch {
+	case n.note != nil:
+		return fileInfo{name: n.name, size: int64(len(n.note.Note)), modTime: parseTime(n.note.UpdatedAt)}
+	case n.media != nil:
+		return fileInfo{name: n.name, size: n.media.Size, modTime: parseTime(n.media.UpdatedAt)}
+	case n.collection != nil:
+		return fileInfo{name: n.name, dir: true, modTime: parseTime(n.collection.UpdatedAt)}
+	}
+	return fileInfo{name: n.name, dir: true, modTime: started}
+}
+
+// started stands in as the modification time of the fixed top-level folders.
+var started = time.Now()
+
+// parseTime reads the timestamps database/sql hands back as strings.
+func parseTime(s string) time.Time {
+	t, _ := time.Parse(time.RFC3339Nano, s)
+	return t
+}
+
+//
+// ─── LISTINGS ───────────────────────────────────────────────────────────────────
+//
+
+// safeName makes a title usable as a single path segment.
+func safeName(s string) string {
+	s = strings.Map(func(r rune) rune {
+		if r == '/' || r == '\\' || r < 0x20 {
+			return '_'
+		}
+		return r
+	}, strings.TrimSpace(s))
+	if s == "" || s == "." || s == ".." {
+		return "Untitled"
+	}
+	return s
+}
+
+// uniqueName disambiguates duplicate names with a short ID suffix.
+func uniqueName(used map[string]bool, name, id string) string {
+	if used[name] {
+		ext := path.Ext(name)
+		short := id
+		if len(short) > 8 {
+			short = short[:8]
+		}
+		name = fmt.Sprintf("%s (%s)%s", strings.TrimSuffix(name, ext), short, ext)
+	}
+	used[name] = true
+	return name
+}
+
+func (fsys FS) noteEntries() ([]node, error) {
+	notes, err := database.GetNotes(fsys.OwnerID)
+	if err != nil {
+		return nil, err
+	}
+	used := map[string]bool{}
+	entries := make([]node, 0, len(notes))
+	for i := range notes {
+		name := uniqueName(used, safeName(notes[i].Title)+noteExt, notes[i].ID)
+		entries = append(entries, node{dir: NotesDir, name: name, note: &notes[i]})
+	}
+	return entries, nil
+}
+
+func (fsys FS) mediaEntries() ([]node, error) {
+	media, err := database.GetMedia(fsys.OwnerID)
+	if err != nil {
+		return nil, err
+	}
+	used := map[string]bool{}
+	entries := make([]node, 0, len(media))
+	for i := range media {
+		name := uniqueName(used, safeName(media[i].Filename), media[i].ID)
+		entries = append(entries, node{dir: MediaDir, name: name, media: &media[i]})
+	}
+	return entries, nil
+}
+
+func (fsys FS) collectionEntries() ([]node, error) {
+	collections, err := database.GetCollections(fsys.OwnerID)
+	if err != nil {
+		return nil, err
+	}
+	used := map[string]bool{}
+	entries := make([]node, 0, len(collections))
+	for i := range collections {
+		name := uniqueName(used, safeName(collections[i].Name), collections[i].ID)
+		entries = append(entries, node{dir: CollectionsDir, name: name, collection: &collections[i]})
+	}
+	return entries, nil
+}
+
+func (fsys FS) collectionItemEntries(c *database.Collection) ([]node, error) {
+	items, err := database.GetCollectionItems(fsys.OwnerID, c.ID)
+	if err != nil {
+		return nil, err
+	}
+	used := map[string]bool{}
+	entries := make([]node, 0, len(items))
+	for i := range items {
+		item := &items[i]
+		m := &database.Media{
+			ID:        item.MediaID,
+			Filename:  item.Filename,
+			Path:      item.Path,
+			MimeType:  item.MimeType,
+			Size:      item.Size,
+			CreatedAt: item.CreatedAt,
+			UpdatedAt: item.CreatedAt,
+		}
+		name := uniqueName(used, safeName(item.Filename), item.ID)
+		entries = append(entries, node{dir: CollectionsDir, name: name, media: m, collection: c, item: item})
+	}
+	return entries, nil
+}
+
+func (fsys FS) children(n node) ([]node, error) {
+	switch {
+	case n.collection != nil:
+		return fsys.collectionItemEntries(n.collection)
+	case n.dir == "":
+		return []node{
+			{dir: NotesDir, name: NotesDir},
+			{dir: MediaDir, name: MediaDir},
+			{dir: CollectionsDir, name: CollectionsDir},
+		}, nil
+	case n.dir == NotesDir:
+		return fsys.noteEntries()
+	case n.dir == MediaDir:
+		return fsys.mediaEntries()
+	case n.dir == CollectionsDir:
+		return fsys.collectionEntries()
+	}
+	return nil, os.ErrNotExist
+}
+
+//
+// ─── DIRECTORIES ────────────────────────────────────────────────────────────────
+//
+
+type dirFile struct {
+	node    node
+	entries []node
+	pos     int
+}
+
+func (fsys FS) openDir(n node) (webdav.File, error) {
+	entries, err := fsys.children(n)
+	if err != nil {
+		return nil, err
+	}
+	return &dirFile{node: n, entries: entries}, nil
+}
+
+func (d *dirFile) Readdir(count int) ([]fs.FileInfo, error) {
+	rest := d.entries[d.pos:]
+	if count > 0 {
+		if len(rest) == 0 {
+			return nil, io.EOF
+		}
+		if count < len(rest) {
+			rest = rest[:count]
+		}
+	}
+	infos := make([]fs.FileInfo, 0, len(rest))
+	for _, e := range rest {
+		infos = append(infos, e.info())
+	}
+	d.pos += len(rest)
+	return infos, nil
+}
+
+func (d *dirFile) Stat() (fs.FileInfo, error)     { return d.node.info(), nil }
+func (d *dirFile) Read([]byte) (int, error)       { return 0, errors.New("is a directory") }
+func (d *dirFile) Write([]byte) (int, error)      { return 0, os.ErrPermission }
+func (d *dirFile) Seek(int64, int) (int64, error) { return 0, nil }
+func (d *dirFile) Close() error                   { return nil }
+
+//
+// ─── READING ────────────────────────────────────────────────────────────────────
+//
+
+// readFile serves note contents from memory or media from storage.
+type readFile struct {
+	node node
+	io.ReadSeeker
+	closer io.Closer
+}
+
+func openRead(n node) (webdav.File, error) {
+	if n.note != nil {
+		return &readFile{node: n, ReadSeeker: strings.NewReader(n.note.Note)}, nil
+	}
+	f, err := storage.Open(n.media.Path)
+	if err != nil {
+		return nil, err
+	}
+	return &readFile{node: n, ReadSeeker: f, closer: f}, nil
+}
+
+func (f *readFile) Stat() (fs.FileInfo, error)         { return f.node.info(), nil }
+func (f *readFile) Readdir(int) ([]fs.FileInfo, error) { return nil, errors.New("not a directory") }
+func (f *readFile) Write([]byte) (int, error)          { return 0, os.ErrPermission }
+
+func (f *readFile) Close() error {
+	if f.closer != nil {
+		return f.closer.Close()
+	}
+	return nil
+}
+
+//
+// ─── WRITING ────────────────────────────────────────────────────────────────────
+//
+
+// writeFile collects an upload and commits it on Close: notes are created or
+// updated, media is stored and recorded (and added to the collection it was
+// written into). Nothing is committed if the file was opened for writing but
+// never written or truncated, or if the upload failed; the existing file is
+// then left as it was.
+type writeFile struct {
+	fsys   FS
+	parent node
+	name   string
+	node   *node
+	dirty  bool
+	err    error // the first write error
+
+	buf     bytes.Buffer // notes
+	out     *os.File     // media
+	stored  string
+	written int64
+}
+
+func (fsys FS) newFile(parent node, name string) (webdav.File, error) {
+	switch {
+	case parent.collection != nil:
+		if !database.MediaFitsCollection(parent.collection.Kind, storage.MimeType(name, "")) {
+			return nil, os.ErrPermission
+		}
+	case parent.dir == MediaDir:
+	case parent.dir == NotesDir:
+		if !strings.HasSuffix(name, noteExt) {
+			return nil, os.ErrPermission
+		}
+	default:
+		return nil, os.ErrPermission
+	}
+	return &writeFile{fsys: fsys, parent: parent, name: name, dirty: true}, nil
+}
+
+func (fsys FS) overwriteFile(n node, flag int) (webdav.File, error) {
+	return &writeFile{fsys: fsys, name: n.name, node: &n, dirty: flag&os.O_TRUNC != 0}, nil
+}
+
+func (f *writeFile) isNote() bool {
+	if f.node != nil {
+		return f.node.note != nil
+	}
+	return f.parent.dir == NotesDir && f.parent.collection == nil
+}
+
+// errNoteTooLarge is returned for notes written beyond MaxNoteSize.
+var errNoteTooLarge = fmt.Errorf("note exceeds %d bytes", MaxNoteSize)
+
+func (f *writeFile) Write(p []byte) (int, error) {
+	if f.err != nil {
+		return 0, f.err
+	}
+	n, err := f.write(p)
+	if err == nil && n < len(p) {
+		err = io.ErrShortWrite
+	}
+	f.err = err
+	return n, err
+}
+
+func (f *writeFile) write(p []byte) (int, error) {
+	f.dirty = true
+	if f.isNote() {
+		if f.buf.Len()+len(p) > MaxNoteSize {
+			return 0, errNoteTooLarge
+		}
+		return f.buf.Write(p)
+	}
+	if f.out == nil {
+		out, stored, err := storage.Create(path.Ext(f.name))
+		if err != nil {
+			return 0, err
+		}
+		f.out, f.stored = out, stored
+	}
+	n, err := f.out.Write(p)
+	f.written += int64(n)
+	return n, err
+}
+
+func (f *writeFile) Stat() (fs.FileInfo, error) {
+	size := f.written
+	if f.isNote() {
+		size = int64(f.buf.Len())
+	}
+	return fileInfo{name: f.name, size: size, modTime: time.Now()}, nil
+}
+
+func (f *writeFile) Read([]byte) (int, error)           { return 0, os.ErrPermission }
+func (f *writeFile) Seek(int64, int) (int64, error)     { return 0, nil }
+func (f *writeFile) Readdir(int) ([]fs.FileInfo, error) { return nil, errors.New("not a directory") }
+
+func (f *writeFile) Close() error {
+	if !f.dirty {
+		return nil
+	}
+	if err := cmp.Or(f.err, f.fsys.bodyErr()); err != nil {
+		f.discard()
+		return err
+	}
+	if f.isNote() {
+		return f.commitNote()
+	}
+	return f.commitMedia()
+}
+
+// discard drops a failed upload without touching the file it was to replace.
+func (f *writeFile) discard() {
+	f.buf.Reset()
+	if f.out != nil {
+		f.out.Close()
+		storage.Remove(f.stored)
+	}
+}
+
+func (f *writeFile) commitNote() error {
+	if f.node != nil {
+		note, err := database.UpdateNote(f.fsys.OwnerID, f.node.note.ID, f.buf.String())
+		if err != nil {
+			return err
+		}
+		after := *f.node.note
+		after.Note, after.UpdatedAt = note.Note, note.UpdatedAt
+		f.fsys.audit(database.AuditNoteUpdate, note.ID, f.node.note, after)
+		return nil
+	}
+	title := strings.TrimSuffix(f.name, noteExt)
+	id, err := database.AddNote(f.fsys.OwnerID, title, f.buf.String())
+	if err != nil {
+		return err
+	}
+	f.fsys.audit(database.AuditNoteCreate, id, nil, database.Note{ID: id, Title: title, Note: f.buf.String()})
+	return nil
+}
+
+func (f *writeFile) commitMedia() error {
+	if f.out == nil {
+		// empty upload, e.g. a LOCK on a new name
+		out, stored, err := storage.Create(path.Ext(f.name))
+		if err != nil {
+			return err
+		}
+		f.out, f.stored = out, stored
+	}
+	if err := f.out.Close(); err != nil {
+		storage.Remove(f.stored)
+		return err
+	}
+
+	mimeType := storage.MimeType(f.name, "")
+	if f.node != nil {
+		if err := database.ReplaceMediaFile(f.fsys.OwnerID, f.node.media.ID, f.stored, mimeType, f.written); err != nil {
+			storage.Remove(f.stored)
+			return err
+		}
+		storage.Remove(f.node.media.Path)
+		after := *f.node.media
+		after.MimeType, after.Size = mimeType, f.written
+		f.fsys.audit(database.AuditMediaUpdate, after.ID, f.node.media, after)
+		return nil
+	}
+
+	id, err := database.AddMedia(f.fsys.OwnerID, f.name, f.stored, mimeType, f.written)
+	if err != nil {
+		storage.Remove(f.stored)
+		return err
+	}
+	if f.parent.collection != nil {
+		if _, err := database.AddCollectionItem(f.fsys.OwnerID, f.parent.collection.ID, id, "", ""); err != nil {
+			// the file only existed to go into the collection
+			if derr := database.DeleteMedia(f.fsys.OwnerID, id); derr != nil {
+				return errors.Join(err, derr)
+			}
+			storage.Remove(f.stored)
+			return err
+		}
+	}
+	f.fsys.audit(database.AuditMediaCreate, id, nil, database.Media{ID: id, Filename: f.name, MimeType: mimeType, Size: f.written})
+	return nil
+}
@@ -0,0 +1,288 @@
+// Package dav exposes the library as a webdav.FileSystem:
+//
+//	/Notes/<title>.md              one file per note
+//	/Media/<filename>              uploaded media under their original names
+//	/Collections/<name>/<filename> albums and playlists in order
+//
+// Reads and writes go through the database and storage packages, so changes
+// made over WebDAV are indistinguishable from those made through the API.
+package dav
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+
+	"media_management_go/backend/database"
+	"media_management_go/backend/storage"
+
+	"golang.org/x/net/webdav"
+)
+
+// Top-level folders.
+const (
+	NotesDir       = "Notes"
+	MediaDir       = "Media"
+	CollectionsDir = "Collections"
+)
+
+// noteExt is the extension notes are presented with.
+const noteExt = ".md"
+
+// MaxNoteSize is the largest note that can be written. Notes are collected
+// in memory until the upload finishes, unlike media files.
+const MaxNoteSize = 1 << 20
+
+// FS implements webdav.FileSystem on top of the database. It shows and
+// changes only the library of the user OwnerID.
+type FS struct {
+	OwnerID string
+	// Audit, if set, is called after every change to a note or media file
+	// with snapshots of it before and after, nil where it did not exist.
+	Audit func(action, targetID string, before, after any)
+	// BodyErr, if set, reports an error reading the request body, such as
+	// an upload cut off by a size limit or a dropped connection. The
+	// webdav.Handler closes a file even when copying the body into it
+	// failed, so files are only committed while it reports nil.
+	BodyErr func() error
+}
+
+func (fsys FS) audit(action, targetID string, before, after any) {
+	if fsys.Audit != nil {
+		fsys.Audit(action, targetID, before, after)
+	}
+}
+
+func (fsys FS) bodyErr() error {
+	if fsys.BodyErr != nil {
+		return fsys.BodyErr()
+	}
+	return nil
+}
+
+// WatchBody wraps the body of r and returns a function reporting the first
+// error reading it other than io.EOF, for FS.BodyErr.
+func WatchBody(r *http.Request) func() error {
+	body := &watchedBody{ReadCloser: r.Body}
+	r.Body = body
+	return func() error { return body.err }
+}
+
+type watchedBody struct {
+	io.ReadCloser
+	err error
+}
+
+func (b *watchedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if err != nil && err != io.EOF && b.err == nil {
+		b.err = err
+	}
+	return n, err
+}
+
+var _ webdav.FileSystem = FS{}
+
+// node is a resolved path. Exactly one of the pointers is set for files and
+// collection folders; top-level folders only have dir set.
+type node struct {
+	dir        string
+	name       string
+	note       *database.Note
+	media      *database.Media
+	collection *database.Collection
+	item       *database.CollectionItem
+}
+
+func (n node) isDir() bool {
+	return n.note == nil && n.media == nil
+}
+
+func splitPath(name string) []string {
+	name = strings.Trim(path.Clean("/"+name), "/")
+	if name == "" {
+		return nil
+	}
+	return strings.Split(name, "/")
+}
+
+// resolve maps a WebDAV path to a node. A missing leaf in an existing folder
+// is reported as os.ErrNotExist together with the parent node, so callers can create it.
+func (fsys FS) resolve(name string) (node, node, error) {
+	parts := splitPath(name)
+	root := node{}
+	switch len(parts) {
+	case 0:
+		return root, root, nil
+	case 1:
+		switch parts[0] {
+		case NotesDir, MediaDir, CollectionsDir:
+			return node{dir: parts[0], name: parts[0]}, root, nil
+		}
+		return node{}, root, os.ErrNotExist
+	}
+
+	top := node{dir: parts[0], name: parts[0]}
+	switch {
+	case parts[0] == NotesDir && len(parts) == 2:
+		entries, err := fsys.noteEntries()
+		if err != nil {
+			return node{}, top, err
+		}
+		return lookup(entries, parts[1], top)
+	case parts[0] == MediaDir && len(parts) == 2:
+		entries, err := fsys.mediaEntries()
+		if err != nil {
+			return node{}, top, err
+		}
+		return lookup(entries, parts[1], top)
+	case parts[0] == CollectionsDir && len(parts) <= 3:
+		collections, err := fsys.collectionEntries()
+		if err != nil {
+			return node{}, top, err
+		}
+		c, _, err := lookup(collections, parts[1], top)
+		if err != nil || len(parts) == 2 {
+			return c, top, err
+		}
+		entries, err := fsys.collectionItemEntries(c.collection)
+		if err != nil {
+			return node{}, c, err
+		}
+		return lookup(entries, parts[2], c)
+	}
+	return node{}, node{}, os.ErrNotExist
+}
+
+func lookup(entries []node, name string, parent node) (node, node, error) {
+	for _, e := range entries {
+		if e.name == name {
+			return e, parent, nil
+		}
+	}
+	return node{}, parent, os.ErrNotExist
+}
+
+// Stat implements webdav.FileSystem.
+func (fsys FS) Stat(ctx context.Context, name string) (os.FileInfo, error) {
+	n, _, err := fsys.resolve(name)
+	if err != nil {
+		return nil, err
+	}
+	return n.info(), nil
+}
+
+// OpenFile implements webdav.FileSystem. Writing a note or media file that
+// does not exist yet creates it when the file is closed.
+func (fsys FS) OpenFile(ctx context.Context, name string, flag int, perm os.FileMode) (webdav.File, error) {
+	n, parent, err := fsys.resolve(name)
+	writing := flag&(os.O_WRONLY|os.O_RDWR) != 0
+
+	if err == os.ErrNotExist && flag&os.O_CREATE != 0 && parent.dir != "" {
+		return fsys.newFile(parent, path.Base(path.Clean("/"+name)))
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if n.isDir() {
+		if writing {
+			return nil, os.ErrPermission
+		}
+		return fsys.openDir(n)
+	}
+	if writing {
+		return fsys.overwriteFile(n, flag)
+	}
+	return openRead(n)
+}
+
+// Mkdir implements webdav.FileSystem. Only new collections can be created;
+// they start out as albums.
+func (fsys FS) Mkdir(ctx context.Context, name string, perm os.FileMode) error {
+	parts := splitPath(name)
+	if len(parts) != 2 || parts[0] != CollectionsDir {
+		return os.ErrPermission
+	}
+	if _, _, err := fsys.resolve(name); err == nil {
+		return os.ErrExist
+	}
+	_, err := database.AddCollection(fsys.OwnerID, database.CollectionAlbum, parts[1])
+	return err
+}
+
+// RemoveAll implements webdav.FileSystem. Removing a file from a collection
+// only takes it out of the collection; the media file stays in /Media.
+func (fsys FS) RemoveAll(ctx context.Context, name string) error {
+	n, parent, err := fsys.resolve(name)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case n.note != nil:
+		if err := database.DeleteNote(fsys.OwnerID, n.note.ID); err != nil {
+			return err
+		}
+		fsys.audit(database.AuditNoteDelete, n.note.ID, n.note, nil)
+		return nil
+	case n.item != nil:
+		return database.DeleteCollectionItem(fsys.OwnerID, parent.collection.ID, n.item.ID)
+	case n.media != nil:
+		if err := database.DeleteMedia(fsys.OwnerID, n.media.ID); err != nil {
+			return err
+		}
+		storage.Remove(n.media.Path)
+		fsys.audit(database.AuditMediaDelete, n.media.ID, n.media, nil)
+		return nil
+	case n.collection != nil:
+		return database.DeleteCollection(fsys.OwnerID, n.collection.ID)
+	}
+	return os.ErrPermission
+}
+
+// Rename implements webdav.FileSystem. Notes, media and collections can be
+// renamed within their folder; moving between folders is not supported.
+func (fsys FS) Rename(ctx context.Context, oldName, newName string) error {
+	n, parent, err := fsys.resolve(oldName)
+	if err != nil {
+		return err
+	}
+	if _, _, err := fsys.resolve(newName); err == nil {
+		return os.ErrExist
+	}
+
+	oldParts, newParts := splitPath(oldName), splitPath(newName)
+	if len(oldParts) != len(newParts) || path.Dir(path.Clean("/"+oldName)) != path.Dir(path.Clean("/"+newName)) {
+		return os.ErrPermission
+	}
+	base := newParts[len(newParts)-1]
+
+	switch {
+	case n.note != nil:
+		after := *n.note
+		after.Title = strings.TrimSuffix(base, noteExt)
+		if err := database.RenameNote(fsys.OwnerID, n.note.ID, after.Title); err != nil {
+			return err
+		}
+		fsys.audit(database.AuditNoteUpdate, n.note.ID, n.note, after)
+		return nil
+	case n.item != nil:
+		return os.ErrPermission
+	case n.media != nil && parent.dir == MediaDir:
+		after := *n.media
+		after.Filename = base
+		if err := database.RenameMedia(fsys.OwnerID, n.media.ID, base); err != nil {
+			return err
+		}
+		fsys.audit(database.AuditMediaUpdate, n.media.ID, n.media, after)
+		return nil
+	case n.collection != nil:
+		_, err := database.UpdateCollection(fsys.OwnerID, n.collection.ID, base, n.collection.CoverMediaID)
+		return err
+	}
+	return os.ErrPermission
+}
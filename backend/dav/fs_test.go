@@ -0,0 +1,259 @@
+package dav
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"media_management_go/backend/common"
+	"media_management_go/backend/database"
+
+	"golang.org/x/net/webdav"
+)
+
+// TestMain loads a configuration whose media directory is a temporary one,
+// since uploads are stored on disk.
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "dav-media-")
+	if err != nil {
+		panic(err)
+	}
+	for k, v := range map[string]string{"ENV": "test", "ADDR": "127.0.0.1", "PORT": "0", "JWT_KEY": "test", "MEDIA_DIR": dir} {
+		os.Setenv(k, v)
+	}
+	common.MustLoadConfig()
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+// setupDAV opens a test database and returns a WebDAV handler for a new user
+// together with that user's ID.
+func setupDAV(t *testing.T) (http.Handler, string) {
+	t.Helper()
+
+	database.MustOpen(":memory:")
+	t.Cleanup(func() {
+		if err := database.Close(); err != nil {
+			t.Fatalf("failed to close test DB: %v", err)
+		}
+	})
+
+	return handlerFor(t, "alice")
+}
+
+// handlerFor creates a user and returns a WebDAV handler scoped to them.
+func handlerFor(t *testing.T, username string) (http.Handler, string) {
+	t.Helper()
+
+	owner, err := database.AddUser(username, "hash", database.RoleEditor)
+	if err != nil {
+		t.Fatalf("AddUser failed: %v", err)
+	}
+	return &webdav.Handler{FileSystem: FS{OwnerID: owner}, LockSystem: webdav.NewMemLS()}, owner
+}
+
+func do(t *testing.T, h http.Handler, method, target, body string, headers ...string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	for i := 0; i+1 < len(headers); i += 2 {
+		req.Header.Set(headers[i], headers[i+1])
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+// TestNotesOverWebDAV creates, reads, renames and deletes a note as a .md file.
+func TestNotesOverWebDAV(t *testing.T) {
+	h, owner := setupDAV(t)
+
+	if rec := do(t, h, "PUT", "/Notes/Groceries.md", "milk, eggs"); rec.Code != http.StatusCreated {
+		t.Fatalf("PUT failed: %d %s", rec.Code, rec.Body)
+	}
+
+	notes, err := database.GetNotes(owner)
+	if err != nil {
+		t.Fatalf("GetNotes failed: %v", err)
+	}
+	if len(notes) != 1 || notes[0].Title != "Groceries" || notes[0].Note != "milk, eggs" {
+		t.Fatalf("expected note created through WebDAV, got %+v", notes)
+	}
+
+	if rec := do(t, h, "GET", "/Notes/Groceries.md", ""); rec.Body.String() != "milk, eggs" {
+		t.Errorf("GET returned %q", rec.Body.String())
+	}
+
+	rec := do(t, h, "PROPFIND", "/Notes/", "", "Depth", "1")
+	if rec.Code != http.StatusMultiStatus || !strings.Contains(rec.Body.String(), "/Notes/Groceries.md") {
+		t.Errorf("PROPFIND did not list the note: %d %s", rec.Code, rec.Body)
+	}
+
+	if rec := do(t, h, "MOVE", "/Notes/Groceries.md", "", "Destination", "/Notes/Shopping.md"); rec.Code >= 300 {
+		t.Fatalf("MOVE failed: %d %s", rec.Code, rec.Body)
+	}
+	if rec := do(t, h, "PUT", "/Notes/Shopping.md", "milk, eggs, bread"); rec.Code >= 300 {
+		t.Fatalf("PUT over existing failed: %d %s", rec.Code, rec.Body)
+	}
+
+	notes, _ = database.GetNotes(owner)
+	if len(notes) != 1 || notes[0].Title != "Shopping" || notes[0].Note != "milk, eggs, bread" {
+		t.Fatalf("expected renamed and updated note, got %+v", notes)
+	}
+
+	if rec := do(t, h, "DELETE", "/Notes/Shopping.md", ""); rec.Code != http.StatusNoContent {
+		t.Fatalf("DELETE failed: %d %s", rec.Code, rec.Body)
+	}
+	notes, _ = database.GetNotes(owner)
+	if len(notes) != 0 {
+		t.Fatalf("expected note deleted, got %+v", notes)
+	}
+}
+
+// TestWebDAVRejectsUnknownPaths checks that only the known folders accept writes.
+func TestWebDAVRejectsUnknownPaths(t *testing.T) {
+	h, owner := setupDAV(t)
+
+	if rec := do(t, h, "PUT", "/notes.txt", "x"); rec.Code < 400 {
+		t.Errorf("expected PUT at the root to fail, got %d", rec.Code)
+	}
+	if rec := do(t, h, "PUT", "/Notes/readme.txt", "x"); rec.Code < 400 {
+		t.Errorf("expected non-markdown note to fail, got %d", rec.Code)
+	}
+	if rec := do(t, h, "MKCOL", "/Notes/Sub", ""); rec.Code < 400 {
+		t.Errorf("expected MKCOL outside Collections to fail, got %d", rec.Code)
+	}
+	if rec := do(t, h, "MKCOL", "/Collections/Holiday", ""); rec.Code != http.StatusCreated {
+		t.Errorf("expected MKCOL of a collection to succeed, got %d", rec.Code)
+	}
+	collections, _ := database.GetCollections(owner)
+	if len(collections) != 1 || collections[0].Name != "Holiday" {
+		t.Errorf("expected collection created, got %+v", collections)
+	}
+}
+
+// TestWebDAVIsolatesUsers checks that each user only sees their own library.
+func TestWebDAVIsolatesUsers(t *testing.T) {
+	alice, _ := setupDAV(t)
+	bob, _ := handlerFor(t, "bob")
+
+	if rec := do(t, alice, "PUT", "/Notes/Diary.md", "secret"); rec.Code != http.StatusCreated {
+		t.Fatalf("PUT failed: %d %s", rec.Code, rec.Body)
+	}
+
+	if rec := do(t, bob, "GET", "/Notes/Diary.md", ""); rec.Code != http.StatusNotFound {
+		t.Errorf("bob read alice's note: %d %s", rec.Code, rec.Body)
+	}
+	if rec := do(t, bob, "PROPFIND", "/Notes/", "", "Depth", "1"); strings.Contains(rec.Body.String(), "Diary.md") {
+		t.Errorf("bob's listing shows alice's note: %s", rec.Body)
+	}
+	if rec := do(t, bob, "DELETE", "/Notes/Diary.md", ""); rec.Code != http.StatusNotFound {
+		t.Errorf("bob deleted alice's note: %d", rec.Code)
+	}
+	if rec := do(t, alice, "GET", "/Notes/Diary.md", ""); rec.Body.String() != "secret" {
+		t.Errorf("alice's note changed: %q", rec.Body.String())
+	}
+}
+
+// TestMediaIntoCollection checks files written into a collection folder must
+// fit its kind, and that a file which cannot be added is not kept.
+func TestMediaIntoCollection(t *testing.T) {
+	h, owner := setupDAV(t)
+
+	if rec := do(t, h, "MKCOL", "/Collections/Holiday", ""); rec.Code != http.StatusCreated {
+		t.Fatalf("MKCOL failed: %d", rec.Code)
+	}
+	if rec := do(t, h, "PUT", "/Collections/Holiday/song.mp3", "audio"); rec.Code < 400 {
+		t.Errorf("expected audio in an album to be refused, got %d", rec.Code)
+	}
+	if rec := do(t, h, "PUT", "/Collections/Holiday/beach.jpg", "jpeg"); rec.Code != http.StatusCreated {
+		t.Fatalf("PUT photo failed: %d %s", rec.Code, rec.Body)
+	}
+	collections, _ := database.GetCollections(owner)
+	if len(collections) != 1 || collections[0].ItemCount != 1 {
+		t.Errorf("expected one item in the album, got %+v", collections)
+	}
+
+	// the collection goes away while the upload is in flight
+	fsys := FS{OwnerID: owner}
+	f, err := fsys.OpenFile(context.Background(), "/Collections/Holiday/sunset.jpg", os.O_CREATE|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatalf("OpenFile failed: %v", err)
+	}
+	f.Write([]byte("jpeg"))
+	if err := database.DeleteCollection(owner, collections[0].ID); err != nil {
+		t.Fatalf("DeleteCollection failed: %v", err)
+	}
+	if err := f.Close(); err == nil {
+		t.Error("expected Close to fail without the collection")
+	}
+	media, _ := database.GetMedia(owner)
+	if len(media) != 1 || media[0].Filename != "beach.jpg" {
+		t.Errorf("expected the orphaned upload rolled back, got %+v", media)
+	}
+	files, _ := filepath.Glob(filepath.Join(common.GetConfig().MEDIA_DIR, "*"))
+	if len(files) != 1 {
+		t.Errorf("expected one stored file, got %v", files)
+	}
+}
+
+// TestFailedUploadKeepsFile checks an overwrite whose body fails partway, or
+// a note over MaxNoteSize, leaves the existing file as it was.
+func TestFailedUploadKeepsFile(t *testing.T) {
+	_, owner := setupDAV(t)
+	ls := webdav.NewMemLS()
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fsys := FS{OwnerID: owner, BodyErr: WatchBody(r)}
+		(&webdav.Handler{FileSystem: fsys, LockSystem: ls}).ServeHTTP(w, r)
+	})
+	put := func(target string, body io.Reader) int {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest("PUT", target, body))
+		return rec.Code
+	}
+	cutOff := func(partial string) io.Reader {
+		return io.MultiReader(strings.NewReader(partial), iotest.ErrReader(errors.New("connection reset")))
+	}
+
+	if code := put("/Media/clip.mp4", strings.NewReader("original")); code != http.StatusCreated {
+		t.Fatalf("PUT media failed: %d", code)
+	}
+	if code := put("/Notes/Plan.md", strings.NewReader("original")); code != http.StatusCreated {
+		t.Fatalf("PUT note failed: %d", code)
+	}
+
+	stored, _ := filepath.Glob(filepath.Join(common.GetConfig().MEDIA_DIR, "*"))
+
+	if code := put("/Media/clip.mp4", cutOff("trunc")); code < 400 {
+		t.Errorf("expected cut off media upload to fail, got %d", code)
+	}
+	if code := put("/Notes/Plan.md", cutOff("trunc")); code < 400 {
+		t.Errorf("expected cut off note upload to fail, got %d", code)
+	}
+	if code := put("/Notes/Plan.md", strings.NewReader(strings.Repeat("x", MaxNoteSize+1))); code < 400 {
+		t.Errorf("expected note over MaxNoteSize to fail, got %d", code)
+	}
+	if code := put("/Media/new.mp4", cutOff("trunc")); code < 400 {
+		t.Errorf("expected cut off new upload to fail, got %d", code)
+	}
+
+	for _, target := range []string{"/Media/clip.mp4", "/Notes/Plan.md"} {
+		if rec := do(t, h, "GET", target, ""); rec.Body.String() != "original" {
+			t.Errorf("GET %s after failed upload: %q, want original", target, rec.Body)
+		}
+	}
+	if media, _ := database.GetMedia(owner); len(media) != 1 || media[0].Size != int64(len("original")) {
+		t.Errorf("expected only the original media, got %+v", media)
+	}
+	if files, _ := filepath.Glob(filepath.Join(common.GetConfig().MEDIA_DIR, "*")); !slices.Equal(files, stored) {
+		t.Errorf("stored files changed from %v to %v", stored, files)
+	}
+}
@@ -0,0 +1,303 @@
+package dlna
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"media_management_go/backend/database"
+)
+
+// Object IDs of the content tree:
+//
+//	0                      root
+//	media                  every media file, newest first
+//	albums, playlists      one container per collection of that kind
+//	c:<collectionID>       the collection's items in order
+//	m:<mediaID>            a media file in "media"
+//	c:<collectionID>/<id>  a collection item
+const (
+	rootID      = "0"
+	mediaID     = "media"
+	albumsID    = "albums"
+	playlistsID = "playlists"
+)
+
+var errNoSuchObject = errors.New("no such object")
+
+// object is a container or item of the content tree.
+type object struct {
+	id         string
+	parentID   string
+	title      string
+	class      string
+	childCount int
+
+	// items only
+	mediaID  string
+	mimeType string
+	size     int64
+
+	// albumArt is a media ID used as the container's cover
+	albumArt string
+}
+
+func (o object) isContainer() bool {
+	return o.mediaID == ""
+}
+
+func itemClass(mimeType string) string {
+	switch {
+	case strings.HasPrefix(mimeType, "video/"):
+		return "object.item.videoItem"
+	case strings.HasPrefix(mimeType, "audio/"):
+		return "object.item.audioItem.musicTrack"
+	case strings.HasPrefix(mimeType, "image/"):
+		return "object.item.imageItem.photo"
+	}
+	return "object.item"
+}
+
+func collectionContainer(c database.Collection) object {
+	o := object{
+		id:         "c:" + c.ID,
+		parentID:   albumsID,
+		title:      c.Name,
+		class:      "object.container.album.photoAlbum",
+		childCount: c.ItemCount,
+		albumArt:   c.CoverMediaID,
+	}
+	if c.Kind == database.CollectionPlaylist {
+		o.parentID = playlistsID
+		o.class = "object.container.playlistContainer"
+	}
+	return o
+}
+
+func mediaItem(parentID, id string, m database.Media) object {
+	return object{
+		id:       id,
+		parentID: parentID,
+		title:    m.Filename,
+		class:    itemClass(m.MimeType),
+		mediaID:  m.ID,
+		mimeType: m.MimeType,
+		size:     m.Size,
+	}
+}
+
+func (s *Server) collectionsOfKind(kind string) ([]object, error) {
+	collections, err := database.GetCollections(s.OwnerID)
+	if err != nil {
+		return nil, err
+	}
+	var objects []object
+	for _, c := range collections {
+		if c.Kind == kind {
+			objects = append(objects, collectionContainer(c))
+		}
+	}
+	return objects, nil
+}
+
+// children lists the direct children of a container.
+func (s *Server) children(id string) ([]object, error) {
+	switch {
+	case id == rootID:
+		var root []object
+		for _, cid := range []string{mediaID, albumsID, playlistsID} {
+			o, err := s.metadata(cid)
+			if err != nil {
+				return nil, err
+			}
+			root = append(root, o)
+		}
+		return root, nil
+	case id == mediaID:
+		media, err := database.GetMedia(s.OwnerID)
+		if err != nil {
+			return nil, err
+		}
+		objects := make([]object, 0, len(media))
+		for _, m := range media {
+			objects = append(objects, mediaItem(mediaID, "m:"+m.ID, m))
+		}
+		return objects, nil
+	case id == albumsID:
+		return s.collectionsOfKind(database.CollectionAlbum)
+	case id == playlistsID:
+		return s.collectionsOfKind(database.CollectionPlaylist)
+	case strings.HasPrefix(id, "c:") && !strings.Contains(id, "/"):
+		collectionID := strings.TrimPrefix(id, "c:")
+		if _, err := database.GetCollection(s.OwnerID, collectionID); err != nil {
+			return nil, err
+		}
+		items, err := database.GetCollectionItems(s.OwnerID, collectionID)
+		if err != nil {
+			return nil, err
+		}
+		objects := make([]object, 0, len(items))
+		for _, item := range items {
+			m := database.Media{ID: item.MediaID, Filename: item.Filename, MimeType: item.MimeType, Size: item.Size}
+			objects = append(objects, mediaItem(id, id+"/"+item.ID, m))
+		}
+		return objects, nil
+	}
+	return nil, errNoSuchObject
+}
+
+// metadata describes a single object.
+func (s *Server) metadata(id string) (object, error) {
+	switch {
+	case id == rootID:
+		return object{id: rootID, parentID: "-1", title: "Root", class: "object.container", childCount: 3}, nil
+	case id == mediaID, id == albumsID, id == playlistsID:
+		kids, err := s.children(id)
+		if err != nil {
+			return object{}, err
+		}
+		titles := map[string]string{mediaID: "All Media", albumsID: "Albums", playlistsID: "Playlists"}
+		return object{id: id, parentID: rootID, title: titles[id], class: "object.container.storageFolder", childCount: len(kids)}, nil
+	case strings.HasPrefix(id, "m:"):
+		m, err := database.GetMediaByID(s.OwnerID, strings.TrimPrefix(id, "m:"))
+		if err != nil {
+			return object{}, err
+		}
+		return mediaItem(mediaID, id, *m), nil
+	case strings.HasPrefix(id, "c:"):
+		containerID, _, isItem := strings.Cut(id, "/")
+		if isItem {
+			kids, err := s.children(containerID)
+			if err != nil {
+				return object{}, err
+			}
+			for _, kid := range kids {
+				if kid.id == id {
+					return kid, nil
+				}
+			}
+			return object{}, errNoSuchObject
+		}
+		c, err := database.GetCollection(s.OwnerID, strings.TrimPrefix(id, "c:"))
+		if err != nil {
+			return object{}, err
+		}
+		return collectionContainer(*c), nil
+	}
+	return object{}, errNoSuchObject
+}
+
+//
+// ─── CONTENT DIRECTORY ──────────────────────────────────────────────────────────
+//
+
+func (s *Server) handleContentDirectory(w http.ResponseWriter, r *http.Request) {
+	action, err := readAction(r)
+	if err != nil {
+		writeSOAPFault(w, errInvalidAction, "Invalid Action")
+		return
+	}
+
+	switch action.name {
+	case "Browse":
+		s.browse(w, r, action)
+	case "GetSearchCapabilities":
+		writeSOAP(w, contentDirST, action.name, []soapArg{{"SearchCaps", ""}})
+	case "GetSortCapabilities":
+		writeSOAP(w, contentDirST, action.name, []soapArg{{"SortCaps", ""}})
+	case "GetSystemUpdateID":
+		writeSOAP(w, contentDirST, action.name, []soapArg{{"Id", strconv.FormatUint(uint64(s.systemUpdateID), 10)}})
+	default:
+		slog.DebugContext(r.Context(), "Unsupported ContentDirectory action", slog.String("action", action.name))
+		writeSOAPFault(w, errInvalidAction, "Invalid Action")
+	}
+}
+
+func (s *Server) browse(w http.ResponseWriter, r *http.Request, action soapAction) {
+	id := action.args["ObjectID"]
+	start, err1 := strconv.Atoi(orZero(action.args["StartingIndex"]))
+	count, err2 := strconv.Atoi(orZero(action.args["RequestedCount"]))
+	if id == "" || err1 != nil || err2 != nil || start < 0 || count < 0 {
+		writeSOAPFault(w, errInvalidArgs, "Invalid Args")
+		return
+	}
+
+	var objects []object
+	var total int
+	switch action.args["BrowseFlag"] {
+	case "BrowseMetadata":
+		o, err := s.metadata(id)
+		if err != nil {
+			writeBrowseError(w, r, id, err)
+			return
+		}
+		objects, total = []object{o}, 1
+	case "BrowseDirectChildren":
+		kids, err := s.children(id)
+		if err != nil {
+			writeBrowseError(w, r, id, err)
+			return
+		}
+		total = len(kids)
+		start = min(start, total)
+		end := total
+		if count > 0 {
+			end = min(start+count, total)
+		}
+		objects = kids[start:end]
+	default:
+		writeSOAPFault(w, errInvalidArgs, "Invalid Args")
+		return
+	}
+
+	writeSOAP(w, contentDirST, action.name, []soapArg{
+		{"Result", s.didl(r, objects)},
+		{"NumberReturned", strconv.Itoa(len(objects))},
+		{"TotalMatches", strconv.Itoa(total)},
+		{"UpdateID", strconv.FormatUint(uint64(s.systemUpdateID), 10)},
+	})
+}
+
+func orZero(s string) string {
+	if s == "" {
+		return "0"
+	}
+	return s
+}
+
+func writeBrowseError(w http.ResponseWriter, r *http.Request, id string, err error) {
+	if errors.Is(err, errNoSuchObject) || errors.Is(err, database.ErrNotFound) {
+		writeSOAPFault(w, errUnknownObject, "No such object")
+		return
+	}
+	slog.ErrorContext(r.Context(), "DLNA browse failed", slog.String("object_id", id), slog.Any("error", err))
+	writeSOAPFault(w, errActionFailed, "Action Failed")
+}
+
+// didl renders objects as a DIDL-Lite document.
+func (s *Server) didl(r *http.Request, objects []object) string {
+	var b strings.Builder
+	b.WriteString(`<DIDL-Lite xmlns="urn:schemas-upnp-org:metadata-1-0/DIDL-Lite/" xmlns:dc="http://purl.org/dc/elements/1.1/" xmlns:upnp="urn:schemas-upnp-org:metadata-1-0/upnp/">`)
+	for _, o := range objects {
+		if o.isContainer() {
+			fmt.Fprintf(&b, `<container id="%s" parentID="%s" restricted="1" childCount="%d">`, xmlEscape(o.id), xmlEscape(o.parentID), o.childCount)
+		} else {
+			fmt.Fprintf(&b, `<item id="%s" parentID="%s" restricted="1">`, xmlEscape(o.id), xmlEscape(o.parentID))
+		}
+		fmt.Fprintf(&b, `<dc:title>%s</dc:title><upnp:class>%s</upnp:class>`, xmlEscape(o.title), o.class)
+		if o.albumArt != "" {
+			fmt.Fprintf(&b, `<upnp:albumArtURI>%s</upnp:albumArtURI>`, xmlEscape(s.MediaURL(r, o.albumArt)))
+		}
+		if o.isContainer() {
+			b.WriteString(`</container>`)
+			continue
+		}
+		fmt.Fprintf(&b, `<res protocolInfo="http-get:*:%s:*" size="%d">%s</res></item>`,
+			xmlEscape(o.mimeType), o.size, xmlEscape(s.MediaURL(r, o.mediaID)))
+	}
+	b.WriteString(`</DIDL-Lite>`)
+	return b.String()
+}
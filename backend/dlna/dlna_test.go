@@ -0,0 +1,190 @@
+package dlna
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"media_management_go/backend/database"
+)
+
+func setupServer(t *testing.T) *Server {
+	t.Helper()
+
+	database.MustOpen(":memory:")
+	t.Cleanup(func() {
+		if err := database.Close(); err != nil {
+			t.Fatalf("failed to close test DB: %v", err)
+		}
+	})
+
+	owner, err := database.AddUser("alice", "hash", database.RoleAdmin)
+	if err != nil {
+		t.Fatalf("AddUser failed: %v", err)
+	}
+	return New("Test Library", "8080", owner, nil, func(r *http.Request, id string) string {
+		return "http://" + r.Host + "/media/" + id + "?sig=x&expires=1"
+	})
+}
+
+func browse(t *testing.T, s *Server, objectID, flag string) string {
+	t.Helper()
+	body := fmt.Sprintf(`<?xml version="1.0"?>
+<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/"><s:Body>
+<u:Browse xmlns:u="urn:schemas-upnp-org:service:ContentDirectory:1">
+<ObjectID>%s</ObjectID><BrowseFlag>%s</BrowseFlag><Filter>*</Filter>
+<StartingIndex>0</StartingIndex><RequestedCount>0</RequestedCount><SortCriteria></SortCriteria>
+</u:Browse></s:Body></s:Envelope>`, objectID, flag)
+
+	req := httptest.NewRequest("POST", "/dlna/control/ContentDirectory", strings.NewReader(body))
+	req.Header.Set("SOAPACTION", `"urn:schemas-upnp-org:service:ContentDirectory:1#Browse"`)
+	rec := httptest.NewRecorder()
+	s.Handler().ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Browse %s %s failed: %d %s", objectID, flag, rec.Code, rec.Body)
+	}
+	return rec.Body.String()
+}
+
+// TestBrowseLibrary walks the content tree from the root down to a playlist
+// entry and checks items link to the media endpoint.
+func TestBrowseLibrary(t *testing.T) {
+	s := setupServer(t)
+
+	songID, err := database.AddMedia(s.OwnerID, "song & dance.mp3", "a.mp3", "audio/mpeg", 1234)
+	if err != nil {
+		t.Fatalf("AddMedia failed: %v", err)
+	}
+	playlistID, err := database.AddCollection(s.OwnerID, database.CollectionPlaylist, "Road Trip")
+	if err != nil {
+		t.Fatalf("AddCollection failed: %v", err)
+	}
+	item, err := database.AddCollectionItem(s.OwnerID, playlistID, songID, "", "")
+	if err != nil {
+		t.Fatalf("AddCollectionItem failed: %v", err)
+	}
+	other, _ := database.AddUser("bob", "hash", database.RoleEditor)
+	if _, err := database.AddMedia(other, "private.mp3", "b.mp3", "audio/mpeg", 1); err != nil {
+		t.Fatalf("AddMedia failed: %v", err)
+	}
+
+	root := browse(t, s, "0", "BrowseDirectChildren")
+	for _, want := range []string{"<NumberReturned>3</NumberReturned>", "id=&#34;media&#34;", "id=&#34;playlists&#34;"} {
+		if !strings.Contains(root, want) {
+			t.Errorf("root listing missing %q:\n%s", want, root)
+		}
+	}
+
+	media := browse(t, s, "media", "BrowseDirectChildren")
+	for _, want := range []string{"song &amp;amp; dance.mp3", "object.item.audioItem.musicTrack", "http-get:*:audio/mpeg:*", "/media/" + songID} {
+		if !strings.Contains(media, want) {
+			t.Errorf("media listing missing %q:\n%s", want, media)
+		}
+	}
+	if strings.Contains(media, "private.mp3") {
+		t.Errorf("media listing shows another user's file:\n%s", media)
+	}
+
+	playlists := browse(t, s, "playlists", "BrowseDirectChildren")
+	if !strings.Contains(playlists, "Road Trip") || !strings.Contains(playlists, "object.container.playlistContainer") {
+		t.Errorf("playlists listing missing the playlist:\n%s", playlists)
+	}
+
+	entryID := "c:" + playlistID + "/" + item.ID
+	entry := browse(t, s, entryID, "BrowseMetadata")
+	if !strings.Contains(entry, "<TotalMatches>1</TotalMatches>") || !strings.Contains(entry, "/media/"+songID) {
+		t.Errorf("playlist entry metadata wrong:\n%s", entry)
+	}
+}
+
+// TestBrowseUnknownObject answers with UPnP error 701.
+func TestBrowseUnknownObject(t *testing.T) {
+	s := setupServer(t)
+
+	req := httptest.NewRequest("POST", "/dlna/control/ContentDirectory", strings.NewReader(
+		`<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/"><s:Body><u:Browse xmlns:u="urn:schemas-upnp-org:service:ContentDirectory:1"><ObjectID>c:missing</ObjectID><BrowseFlag>BrowseMetadata</BrowseFlag></u:Browse></s:Body></s:Envelope>`))
+	rec := httptest.NewRecorder()
+	s.Handler().ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusInternalServerError || !strings.Contains(rec.Body.String(), "<errorCode>701</errorCode>") {
+		t.Fatalf("expected error 701, got %d %s", rec.Code, rec.Body)
+	}
+}
+
+// TestSSDPDiscovery sends an M-SEARCH over the loopback interface and expects
+// a response pointing at the device description. Skipped where loopback
+// multicast is unavailable.
+func TestSSDPDiscovery(t *testing.T) {
+	lo, err := loopbackInterface()
+	if err != nil {
+		t.Skipf("no loopback interface: %v", err)
+	}
+
+	s := New("Test Library", "8080", "", lo, nil)
+	s.SSDPAddr = "239.255.255.250:19001"
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	errc := make(chan error, 1)
+	go func() { errc <- s.Advertise(ctx) }()
+
+	client, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("listen failed: %v", err)
+	}
+	defer client.Close()
+
+	group, _ := net.ResolveUDPAddr("udp4", s.SSDPAddr)
+	search := "M-SEARCH * HTTP/1.1\r\nHOST: 239.255.255.250:1900\r\nMAN: \"ssdp:discover\"\r\nMX: 1\r\nST: " + deviceType + "\r\n\r\n"
+
+	deadline := time.Now().Add(3 * time.Second)
+	buf := make([]byte, 2048)
+	for time.Now().Before(deadline) {
+		select {
+		case err := <-errc:
+			t.Skipf("SSDP unavailable on loopback: %v", err)
+		default:
+		}
+
+		if _, err := client.WriteToUDP([]byte(search), group); err != nil {
+			t.Skipf("multicast send failed: %v", err)
+		}
+		client.SetReadDeadline(time.Now().Add(1500 * time.Millisecond))
+		n, _, err := client.ReadFromUDP(buf)
+		if err != nil {
+			continue
+		}
+
+		resp, err := http.ReadResponse(bufio.NewReader(strings.NewReader(string(buf[:n]))), nil)
+		if err != nil {
+			t.Fatalf("malformed response: %v", err)
+		}
+		if resp.Header.Get("ST") != deviceType || resp.Header.Get("USN") != s.UDN+"::"+deviceType {
+			t.Errorf("unexpected ST/USN: %v", resp.Header)
+		}
+		if !strings.HasSuffix(resp.Header.Get("LOCATION"), ":8080/dlna/device.xml") {
+			t.Errorf("unexpected LOCATION %q", resp.Header.Get("LOCATION"))
+		}
+		return
+	}
+	t.Skip("no SSDP response; loopback multicast is probably not routed here")
+}
+
+func loopbackInterface() (*net.Interface, error) {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil, err
+	}
+	for i := range ifaces {
+		if ifaces[i].Flags&net.FlagLoopback != 0 {
+			return &ifaces[i], nil
+		}
+	}
+	return nil, fmt.Errorf("none found")
+}
@@ -0,0 +1,221 @@
+// Package dlna runs an optional UPnP MediaServer so TVs and other DLNA
+// renderers on the LAN can browse and play the library. The device and its
+// ContentDirectory and ConnectionManager services are served over HTTP under
+// /dlna/; the server is announced over SSDP by Advertise. Media is streamed
+// through the regular ranged /media/{id} endpoint using signed URLs.
+package dlna
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Methods lists the methods the /dlna/ mount has to be registered for.
+var Methods = []string{"GET", "HEAD", "POST", "SUBSCRIBE", "UNSUBSCRIBE"}
+
+// Server is a UPnP MediaServer.
+type Server struct {
+	// Name is the friendly name renderers show for the server.
+	Name string
+	// UDN is the unique device name, "uuid:..." form.
+	UDN string
+	// Port is the HTTP port the main server listens on.
+	Port string
+	// OwnerID is the user whose library is published.
+	OwnerID string
+	// Interface is the network interface SSDP runs on.
+	Interface *net.Interface
+	// SSDPAddr overrides DefaultSSDPAddr, mainly for tests.
+	SSDPAddr string
+	// MediaURL returns a URL a renderer can fetch the media file from
+	// without credentials.
+	MediaURL func(r *http.Request, mediaID string) string
+
+	systemUpdateID uint32
+}
+
+// New returns a Server publishing the library of ownerID, whose UDN is
+// derived from name so renderers recognise it again after a restart.
+func New(name, port, ownerID string, iface *net.Interface, mediaURL func(r *http.Request, mediaID string) string) *Server {
+	return &Server{
+		Name:           name,
+		UDN:            "uuid:" + uuid.NewSHA1(uuid.NameSpaceURL, []byte("media_management_go/dlna/"+name)).String(),
+		Port:           port,
+		OwnerID:        ownerID,
+		Interface:      iface,
+		MediaURL:       mediaURL,
+		systemUpdateID: uint32(time.Now().Unix()),
+	}
+}
+
+// Handler serves the device description, service descriptions and control
+// endpoints. It expects to be mounted at /dlna/.
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /dlna/device.xml", s.handleDevice)
+	mux.HandleFunc("GET /dlna/ContentDirectory.xml", serveXML(contentDirectorySCPD))
+	mux.HandleFunc("GET /dlna/ConnectionManager.xml", serveXML(connectionManagerSCPD))
+	mux.HandleFunc("POST /dlna/control/ContentDirectory", s.handleContentDirectory)
+	mux.HandleFunc("POST /dlna/control/ConnectionManager", s.handleConnectionManager)
+	mux.HandleFunc("SUBSCRIBE /dlna/event/", handleSubscribe)
+	mux.HandleFunc("UNSUBSCRIBE /dlna/event/", func(w http.ResponseWriter, r *http.Request) {})
+	return mux
+}
+
+func serveXML(body string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", `text/xml; charset="utf-8"`)
+		io.WriteString(w, body)
+	}
+}
+
+func (s *Server) handleDevice(w http.ResponseWriter, r *http.Request) {
+	serveXML(fmt.Sprintf(deviceDescription, xmlEscape(s.Name), s.UDN))(w, r)
+}
+
+// handleSubscribe accepts event subscriptions so renderers that insist on
+// them keep working. The library is not evented; clients poll SystemUpdateID.
+func handleSubscribe(w http.ResponseWriter, r *http.Request) {
+	sid := r.Header.Get("SID")
+	if sid == "" {
+		sid = "uuid:" + uuid.New().String()
+	}
+	w.Header().Set("SID", sid)
+	w.Header().Set("TIMEOUT", "Second-1800")
+	w.WriteHeader(http.StatusOK)
+}
+
+//
+// ─── SOAP ───────────────────────────────────────────────────────────────────────
+//
+
+type soapEnvelope struct {
+	XMLName xml.Name `xml:"Envelope"`
+	Body    struct {
+		Action struct {
+			XMLName xml.Name
+			Args    []struct {
+				XMLName xml.Name
+				Value   string `xml:",chardata"`
+			} `xml:",any"`
+		} `xml:",any"`
+	} `xml:"Body"`
+}
+
+// soapAction is a decoded control request.
+type soapAction struct {
+	name string
+	args map[string]string
+}
+
+func readAction(r *http.Request) (soapAction, error) {
+	var env soapEnvelope
+	if err := xml.NewDecoder(io.LimitReader(r.Body, 64<<10)).Decode(&env); err != nil {
+		return soapAction{}, fmt.Errorf("decode soap request: %w", err)
+	}
+	action := soapAction{name: env.Body.Action.XMLName.Local, args: map[string]string{}}
+	for _, arg := range env.Body.Action.Args {
+		action.args[arg.XMLName.Local] = arg.Value
+	}
+	return action, nil
+}
+
+// soapArg is one output argument of an action response, in order.
+type soapArg struct {
+	name  string
+	value string
+}
+
+func writeSOAP(w http.ResponseWriter, service, action string, args []soapArg) {
+	var b strings.Builder
+	b.WriteString(xml.Header)
+	b.WriteString(`<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/" s:encodingStyle="http://schemas.xmlsoap.org/soap/encoding/"><s:Body>`)
+	fmt.Fprintf(&b, `<u:%sResponse xmlns:u="%s">`, action, service)
+	for _, arg := range args {
+		fmt.Fprintf(&b, "<%s>%s</%s>", arg.name, xmlEscape(arg.value), arg.name)
+	}
+	fmt.Fprintf(&b, `</u:%sResponse>`, action)
+	b.WriteString(`</s:Body></s:Envelope>`)
+
+	w.Header().Set("Content-Type", `text/xml; charset="utf-8"`)
+	w.Header().Set("EXT", "")
+	io.WriteString(w, b.String())
+}
+
+// UPnP error codes used by the services.
+const (
+	errInvalidAction = 401
+	errInvalidArgs   = 402
+	errActionFailed  = 501
+	errUnknownObject = 701
+)
+
+func writeSOAPFault(w http.ResponseWriter, code int, description string) {
+	w.Header().Set("Content-Type", `text/xml; charset="utf-8"`)
+	w.WriteHeader(http.StatusInternalServerError)
+	fmt.Fprintf(w, `%s<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/" s:encodingStyle="http://schemas.xmlsoap.org/soap/encoding/"><s:Body><s:Fault><faultcode>s:Client</faultcode><faultstring>UPnPError</faultstring><detail><UPnPError xmlns="urn:schemas-upnp-org:control-1-0"><errorCode>%d</errorCode><errorDescription>%s</errorDescription></UPnPError></detail></s:Fault></s:Body></s:Envelope>`,
+		xml.Header, code, xmlEscape(description))
+}
+
+func xmlEscape(s string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
+
+//
+// ─── CONNECTION MANAGER ─────────────────────────────────────────────────────────
+//
+
+// sourceProtocols are advertised by GetProtocolInfo. Renderers mostly use
+// this to decide whether to ask at all; the per-item res elements carry the
+// actual MIME types.
+var sourceProtocols = []string{
+	"http-get:*:video/mp4:*",
+	"http-get:*:video/x-matroska:*",
+	"http-get:*:video/webm:*",
+	"http-get:*:audio/mpeg:*",
+	"http-get:*:audio/flac:*",
+	"http-get:*:audio/ogg:*",
+	"http-get:*:image/jpeg:*",
+	"http-get:*:image/png:*",
+}
+
+func (s *Server) handleConnectionManager(w http.ResponseWriter, r *http.Request) {
+	action, err := readAction(r)
+	if err != nil {
+		writeSOAPFault(w, errInvalidAction, "Invalid Action")
+		return
+	}
+
+	switch action.name {
+	case "GetProtocolInfo":
+		writeSOAP(w, connMgrST, action.name, []soapArg{
+			{"Source", strings.Join(sourceProtocols, ",")},
+			{"Sink", ""},
+		})
+	case "GetCurrentConnectionIDs":
+		writeSOAP(w, connMgrST, action.name, []soapArg{{"ConnectionIDs", "0"}})
+	case "GetCurrentConnectionInfo":
+		writeSOAP(w, connMgrST, action.name, []soapArg{
+			{"RcsID", "-1"},
+			{"AVTransportID", "-1"},
+			{"ProtocolInfo", ""},
+			{"PeerConnectionManager", ""},
+			{"PeerConnectionID", "-1"},
+			{"Direction", "Output"},
+			{"Status", "OK"},
+		})
+	default:
+		slog.DebugContext(r.Context(), "Unsupported ConnectionManager action", slog.String("action", action.name))
+		writeSOAPFault(w, errInvalidAction, "Invalid Action")
+	}
+}
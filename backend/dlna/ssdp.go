@@ -0,0 +1,229 @@
+package dlna
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/net/ipv4"
+)
+
+const (
+	// DefaultSSDPAddr is the standard SSDP multicast group and port.
+	DefaultSSDPAddr = "239.255.255.250:1900"
+
+	ssdpMaxAge   = 1800
+	ssdpServer   = "Linux/1.0 UPnP/1.0 media_management_go/1.0"
+	deviceType   = "urn:schemas-upnp-org:device:MediaServer:1"
+	contentDirST = "urn:schemas-upnp-org:service:ContentDirectory:1"
+	connMgrST    = "urn:schemas-upnp-org:service:ConnectionManager:1"
+)
+
+// notifyTypes are announced in NOTIFY messages and answered for ssdp:all.
+func (s *Server) notifyTypes() []string {
+	return []string{"upnp:rootdevice", s.UDN, deviceType, contentDirST, connMgrST}
+}
+
+func (s *Server) usn(nt string) string {
+	if nt == s.UDN {
+		return s.UDN
+	}
+	return s.UDN + "::" + nt
+}
+
+// searchTargets returns the targets a M-SEARCH for st should be answered with.
+func (s *Server) searchTargets(st string) []string {
+	if st == "ssdp:all" {
+		return s.notifyTypes()
+	}
+	for _, nt := range s.notifyTypes() {
+		if nt == st {
+			return []string{st}
+		}
+	}
+	return nil
+}
+
+// parseSearch reads an SSDP M-SEARCH datagram and returns its search target and MX delay.
+func parseSearch(msg []byte) (st string, mx int, ok bool) {
+	req, err := http.ReadRequest(bufio.NewReader(bytes.NewReader(msg)))
+	if err != nil || req.Method != "M-SEARCH" {
+		return "", 0, false
+	}
+	if strings.Trim(req.Header.Get("MAN"), `"`) != "ssdp:discover" {
+		return "", 0, false
+	}
+	mx, _ = strconv.Atoi(req.Header.Get("MX"))
+	return req.Header.Get("ST"), mx, req.Header.Get("ST") != ""
+}
+
+func (s *Server) searchResponse(st, location string) []byte {
+	var b strings.Builder
+	b.WriteString("HTTP/1.1 200 OK\r\n")
+	fmt.Fprintf(&b, "CACHE-CONTROL: max-age=%d\r\n", ssdpMaxAge)
+	fmt.Fprintf(&b, "DATE: %s\r\n", time.Now().UTC().Format(http.TimeFormat))
+	b.WriteString("EXT:\r\n")
+	fmt.Fprintf(&b, "LOCATION: %s\r\n", location)
+	fmt.Fprintf(&b, "SERVER: %s\r\n", ssdpServer)
+	fmt.Fprintf(&b, "ST: %s\r\n", st)
+	fmt.Fprintf(&b, "USN: %s\r\n", s.usn(st))
+	b.WriteString("\r\n")
+	return []byte(b.String())
+}
+
+func (s *Server) notifyMessage(nt, nts, location string) []byte {
+	var b strings.Builder
+	b.WriteString("NOTIFY * HTTP/1.1\r\n")
+	fmt.Fprintf(&b, "HOST: %s\r\n", s.ssdpAddr())
+	fmt.Fprintf(&b, "NT: %s\r\n", nt)
+	fmt.Fprintf(&b, "NTS: %s\r\n", nts)
+	fmt.Fprintf(&b, "USN: %s\r\n", s.usn(nt))
+	if nts == "ssdp:alive" {
+		fmt.Fprintf(&b, "CACHE-CONTROL: max-age=%d\r\n", ssdpMaxAge)
+		fmt.Fprintf(&b, "LOCATION: %s\r\n", location)
+		fmt.Fprintf(&b, "SERVER: %s\r\n", ssdpServer)
+	}
+	b.WriteString("\r\n")
+	return []byte(b.String())
+}
+
+func (s *Server) ssdpAddr() string {
+	if s.SSDPAddr != "" {
+		return s.SSDPAddr
+	}
+	return DefaultSSDPAddr
+}
+
+// Advertise announces the server over SSDP on s.Interface and answers
+// discovery requests until ctx is cancelled, then says goodbye.
+func (s *Server) Advertise(ctx context.Context) error {
+	group, err := net.ResolveUDPAddr("udp4", s.ssdpAddr())
+	if err != nil {
+		return fmt.Errorf("resolve ssdp address: %w", err)
+	}
+	ip, err := interfaceIPv4(s.Interface)
+	if err != nil {
+		return err
+	}
+	location := fmt.Sprintf("http://%s/dlna/device.xml", net.JoinHostPort(ip.String(), s.Port))
+
+	conn, err := net.ListenMulticastUDP("udp4", s.Interface, group)
+	if err != nil {
+		return fmt.Errorf("join ssdp group: %w", err)
+	}
+	defer conn.Close()
+
+	pc := ipv4.NewPacketConn(conn)
+	_ = pc.SetMulticastInterface(s.Interface)
+	_ = pc.SetMulticastTTL(2)
+	_ = pc.SetMulticastLoopback(true)
+
+	notify := func(nts string) {
+		for _, nt := range s.notifyTypes() {
+			if _, err := conn.WriteToUDP(s.notifyMessage(nt, nts, location), group); err != nil {
+				slog.Debug("SSDP notify failed", slog.String("nts", nts), slog.Any("error", err))
+			}
+		}
+	}
+
+	slog.Info("DLNA server advertising", slog.String("location", location), slog.String("interface", s.Interface.Name))
+	notify("ssdp:alive")
+
+	go func() {
+		ticker := time.NewTicker(ssdpMaxAge / 2 * time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				notify("ssdp:byebye")
+				conn.Close()
+				return
+			case <-ticker.C:
+				notify("ssdp:alive")
+			}
+		}
+	}()
+
+	buf := make([]byte, 2048)
+	for {
+		n, from, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			if ctx.Err() != nil || errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return fmt.Errorf("read ssdp: %w", err)
+		}
+
+		st, mx, ok := parseSearch(buf[:n])
+		if !ok {
+			continue
+		}
+		targets := s.searchTargets(st)
+		if len(targets) == 0 {
+			continue
+		}
+
+		go func(from *net.UDPAddr) {
+			// spread replies over the MX window as the spec asks
+			if mx > 0 {
+				time.Sleep(time.Duration(rand.IntN(min(mx, 5)*1000)) * time.Millisecond)
+			}
+			for _, target := range targets {
+				if _, err := conn.WriteToUDP(s.searchResponse(target, location), from); err != nil {
+					slog.Debug("SSDP search response failed", slog.Any("error", err))
+				}
+			}
+		}(from)
+	}
+}
+
+// FindInterface returns the named network interface, or the first
+// multicast-capable, non-loopback interface with an IPv4 address.
+func FindInterface(name string) (*net.Interface, error) {
+	if name != "" {
+		iface, err := net.InterfaceByName(name)
+		if err != nil {
+			return nil, fmt.Errorf("interface %s: %w", name, err)
+		}
+		return iface, nil
+	}
+
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil, fmt.Errorf("list interfaces: %w", err)
+	}
+	for i := range ifaces {
+		iface := &ifaces[i]
+		if iface.Flags&net.FlagUp == 0 || iface.Flags&net.FlagMulticast == 0 || iface.Flags&net.FlagLoopback != 0 {
+			continue
+		}
+		if _, err := interfaceIPv4(iface); err == nil {
+			return iface, nil
+		}
+	}
+	return nil, errors.New("no multicast-capable network interface found")
+}
+
+func interfaceIPv4(iface *net.Interface) (net.IP, error) {
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return nil, fmt.Errorf("addresses of %s: %w", iface.Name, err)
+	}
+	for _, addr := range addrs {
+		if ipnet, ok := addr.(*net.IPNet); ok {
+			if ip := ipnet.IP.To4(); ip != nil {
+				return ip, nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("interface %s has no IPv4 address", iface.Name)
+}
@@ -0,0 +1,211 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"media_management_go/backend/database"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// apiTokenPrefix marks personal access tokens so they can be told apart from
+// JWTs without a database lookup, and found by secret scanners.
+const apiTokenPrefix = "mmg_"
+
+type PostAPITokenRequest struct {
+	Name          string   `json:"name"`
+	Scopes        []string `json:"scopes"`
+	ExpiresInDays int      `json:"expires_in_days"`
+}
+
+// PostAPITokenResponse is a newly created token. Token is only ever shown here.
+type PostAPITokenResponse struct {
+	database.APIToken
+	Token string `json:"token"`
+}
+
+// newAPIToken returns a random personal access token.
+func newAPIToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("generate api token: %w", err)
+	}
+	return apiTokenPrefix + base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// authenticateAny is validateToken that also accepts personal access tokens.
+// For those the claims carry the token's owner as subject and the token is
+// returned so its scopes can be checked; for JWTs the token is nil.
+func authenticateAny(r *http.Request) (*jwt.RegisteredClaims, *database.APIToken, error) {
+	raw, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || !strings.HasPrefix(raw, apiTokenPrefix) {
+		claims, err := validateToken(r)
+		return claims, nil, err
+	}
+	return authenticateAPIToken(r, raw)
+}
+
+// authenticateAPIToken looks up a raw personal access token and checks its
+// owner may still sign in.
+func authenticateAPIToken(r *http.Request, raw string) (*jwt.RegisteredClaims, *database.APIToken, error) {
+	t, err := database.GetAPITokenByHash(database.HashToken(raw))
+	if err != nil {
+		if errors.Is(err, database.ErrNotFound) {
+			return nil, nil, fmt.Errorf("invalid or expired API token")
+		}
+		return nil, nil, fmt.Errorf("database error: %v", err)
+	}
+	if err := checkUserActive(t.UserID); err != nil {
+		return nil, nil, err
+	}
+	touchAPIToken(r, t)
+
+	created, _ := time.Parse(time.RFC3339Nano, t.CreatedAt)
+	claims := &jwt.RegisteredClaims{Subject: t.UserID, ID: t.ID, IssuedAt: jwt.NewNumericDate(created)}
+	if expires, err := time.Parse(time.RFC3339Nano, t.ExpiresAt); err == nil {
+		claims.ExpiresAt = jwt.NewNumericDate(expires)
+	}
+	return claims, t, nil
+}
+
+// touchAPIToken records the token as used if it was last recorded more than
+// lastSeenInterval ago.
+func touchAPIToken(r *http.Request, t *database.APIToken) {
+	if recentlyUsed(t) {
+		return
+	}
+	if err := database.TouchAPIToken(t.ID); err != nil {
+		slog.WarnContext(r.Context(), "Failed to record API token use", slog.String("token", t.ID), slog.Any("error", err))
+	}
+}
+
+// recentlyUsed reports whether t was last used within lastSeenInterval, as
+// recorded before the current request.
+func recentlyUsed(t *database.APIToken) bool {
+	used, err := time.Parse(time.RFC3339Nano, t.LastUsedAt)
+	return err == nil && time.Since(used) < lastSeenInterval
+}
+
+// HandleGetAPITokens lists the caller's personal access tokens. Like the
+// other token endpoints it needs a login session, so a leaked token cannot
+// be used to mint more.
+func HandleGetAPITokens(w http.ResponseWriter, r *http.Request) {
+	current := requestSession(r)
+
+	tokens, err := database.GetAPITokens(current.UserID)
+	if err != nil {
+		writeJSONError(w, fmt.Sprintf("Failed to fetch tokens: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, struct {
+		Tokens []database.APIToken `json:"tokens"`
+	}{
+		Tokens: tokens,
+	}, http.StatusOK)
+}
+
+// HandlePostAPIToken creates a personal access token limited to the requested
+// scopes, all of which the caller's role must allow. An expires_in_days of
+// zero creates a token that never expires.
+func HandlePostAPIToken(w http.ResponseWriter, r *http.Request) {
+	current := requestSession(r)
+
+	var req PostAPITokenRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSONError(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" {
+		writeJSONError(w, "Name is required", http.StatusBadRequest)
+		return
+	}
+	if len(req.Scopes) == 0 {
+		writeJSONError(w, "At least one scope is required", http.StatusBadRequest)
+		return
+	}
+	if req.ExpiresInDays < 0 {
+		writeJSONError(w, "Expiry must not be negative", http.StatusBadRequest)
+		return
+	}
+
+	user, err := database.GetUser(current.UserID)
+	if err != nil {
+		writeJSONError(w, fmt.Sprintf("Failed to fetch user: %v", err), http.StatusInternalServerError)
+		return
+	}
+	for _, s := range req.Scopes {
+		perm, known := scopePermissions[scope(s)]
+		if !known {
+			writeJSONError(w, fmt.Sprintf("Unknown scope %q", s), http.StatusBadRequest)
+			return
+		}
+		if !can(user.Role, perm) {
+			writeJSONError(w, fmt.Sprintf("Your role does not allow the %s scope", s), http.StatusForbidden)
+			return
+		}
+	}
+
+	token, err := newAPIToken()
+	if err != nil {
+		writeJSONError(w, "Failed to generate token", http.StatusInternalServerError)
+		return
+	}
+	now := time.Now()
+	var expiresAt *time.Time
+	if req.ExpiresInDays > 0 {
+		exp := now.AddDate(0, 0, req.ExpiresInDays)
+		expiresAt = &exp
+	}
+
+	id, err := database.AddAPIToken(user.ID, req.Name, database.HashToken(token), req.Scopes, expiresAt)
+	if err != nil {
+		writeJSONError(w, fmt.Sprintf("Failed to create token: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	resp := PostAPITokenResponse{
+		APIToken: database.APIToken{
+			ID:        id,
+			Name:      req.Name,
+			Scopes:    req.Scopes,
+			CreatedAt: now.Format(time.RFC3339Nano),
+		},
+		Token: token,
+	}
+	if expiresAt != nil {
+		resp.ExpiresAt = expiresAt.Format(time.RFC3339Nano)
+	}
+	writeJSON(w, resp, http.StatusCreated)
+}
+
+// HandleDeleteAPIToken revokes the personal access token named in the path.
+func HandleDeleteAPIToken(w http.ResponseWriter, r *http.Request) {
+	current := requestSession(r)
+
+	id := r.PathValue("id")
+	if err := database.DeleteAPIToken(current.UserID, id); err != nil {
+		if errors.Is(err, database.ErrNotFound) {
+			writeJSONError(w, "Token not found", http.StatusNotFound)
+			return
+		}
+		writeJSONError(w, fmt.Sprintf("Failed to revoke token: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, struct {
+		Message string `json:"message"`
+	}{
+		Message: "Token revoked successfully",
+	}, http.StatusOK)
+}
@@ -0,0 +1,127 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"media_management_go/backend/database"
+)
+
+const (
+	defaultAuditLimit = 100
+	maxAuditLimit     = 1000
+)
+
+// recordAudit appends e to the audit log with the request's address and user
+// agent. before and after are snapshots of the target, nil where it did not
+// exist. The change has already happened, so a failure is only logged.
+func recordAudit(r *http.Request, e database.AuditEntry, before, after any) {
+	e.IP = clientIP(r)
+	e.UserAgent = r.UserAgent()
+	e.Before = auditSnapshot(before)
+	e.After = auditSnapshot(after)
+	if err := database.AddAuditEntry(e); err != nil {
+		slog.ErrorContext(r.Context(), "Failed to record audit entry", slog.String("action", e.Action), slog.String("target", e.TargetID), slog.Any("error", err))
+	}
+}
+
+// auditSnapshot encodes v for the audit log; nil, including a nil pointer,
+// is no snapshot at all.
+func auditSnapshot(v any) json.RawMessage {
+	if v == nil {
+		return nil
+	}
+	b, err := json.Marshal(v)
+	if err != nil || string(b) == "null" {
+		return nil
+	}
+	return b
+}
+
+// auditFilter reads the ?actor=, ?action=, ?target=, ?since=, ?until= and
+// ?before= filters. Times are RFC 3339 or YYYY-MM-DD. Returns the name of
+// the first invalid parameter, if any.
+func auditFilter(r *http.Request) (database.AuditFilter, string) {
+	q := r.URL.Query()
+	f := database.AuditFilter{
+		Actor:    q.Get("actor"),
+		Action:   q.Get("action"),
+		TargetID: q.Get("target"),
+	}
+	var err error
+	if f.Since, err = parseOptionalDate(q.Get("since")); err != nil {
+		return f, "since"
+	}
+	if f.Until, err = parseOptionalDate(q.Get("until")); err != nil {
+		return f, "until"
+	}
+	if s := q.Get("before"); s != "" {
+		if f.BeforeID, err = strconv.ParseInt(s, 10, 64); err != nil || f.BeforeID <= 0 {
+			return f, "before"
+		}
+	}
+	return f, ""
+}
+
+// HandleGetAudit lists audit entries matching the filters, newest first.
+// next_before pages back to older entries when there may be more.
+func HandleGetAudit(w http.ResponseWriter, r *http.Request) {
+	f, invalid := auditFilter(r)
+	if invalid != "" {
+		writeJSONError(w, fmt.Sprintf("Invalid %s", invalid), http.StatusBadRequest)
+		return
+	}
+	f.Limit = min(queryLimit(r, defaultAuditLimit), maxAuditLimit)
+
+	entries, err := database.GetAuditEntries(f)
+	if err != nil {
+		writeJSONError(w, fmt.Sprintf("Failed to fetch audit log: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	var next int64
+	if len(entries) == f.Limit {
+		next = entries[len(entries)-1].ID
+	}
+	writeJSON(w, struct {
+		Entries    []database.AuditEntry `json:"entries"`
+		NextBefore int64                 `json:"next_before,omitempty"`
+	}{
+		Entries:    entries,
+		NextBefore: next,
+	}, http.StatusOK)
+}
+
+// HandleGetAuditExport downloads every audit entry matching the filters as
+// JSON Lines, oldest first.
+func HandleGetAuditExport(w http.ResponseWriter, r *http.Request) {
+	f, invalid := auditFilter(r)
+	if invalid != "" {
+		writeJSONError(w, fmt.Sprintf("Invalid %s", invalid), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="audit-%s.jsonl"`, time.Now().Format("20060102-150405")))
+	enc := json.NewEncoder(w)
+	written := false
+	err := database.ExportAuditEntries(f, func(e database.AuditEntry) error {
+		written = true
+		return enc.Encode(e)
+	})
+	if err == nil {
+		return
+	}
+	if written {
+		// the status is gone once entries are written; a cut-off export is
+		// all the client can be told
+		slog.ErrorContext(r.Context(), "Audit log export failed", slog.Any("error", err))
+		return
+	}
+	w.Header().Del("Content-Disposition")
+	writeJSONError(w, fmt.Sprintf("Failed to export audit log: %v", err), http.StatusInternalServerError)
+}
@@ -0,0 +1,296 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"media_management_go/backend/catalog"
+	"media_management_go/backend/database"
+)
+
+type PostCatalogRequest struct {
+	Kind     string `json:"kind"`
+	Title    string `json:"title"`
+	Year     int    `json:"year"`
+	ParentID string `json:"parent_id"`
+	Season   int    `json:"season"`
+	Episode  int    `json:"episode"`
+}
+
+type PostCatalogResponse struct {
+	ID       string `json:"id"`
+	Kind     string `json:"kind"`
+	Title    string `json:"title"`
+	Year     int    `json:"year,omitempty"`
+	ParentID string `json:"parent_id,omitempty"`
+	Season   int    `json:"season,omitempty"`
+	Episode  int    `json:"episode,omitempty"`
+}
+
+type PutCatalogRequest struct {
+	ID string `json:"id"`
+	PostCatalogRequest
+}
+
+type DeleteCatalogRequest struct {
+	ID string `json:"id"`
+}
+
+// CatalogAttachRequest links (POST) or unlinks (DELETE) a media file and/or a
+// saved link to a catalog entry.
+type CatalogAttachRequest struct {
+	ItemID  string `json:"item_id"`
+	MediaID string `json:"media_id"`
+	LinkID  string `json:"link_id"`
+}
+
+type PostCatalogSuggestRequest struct {
+	Filenames []string `json:"filenames"`
+}
+
+type CatalogSuggestion struct {
+	Filename string `json:"filename"`
+	catalog.Suggestion
+}
+
+func HandleGetCatalog(w http.ResponseWriter, r *http.Request) {
+	claims := requestClaims(r)
+
+	kind := r.URL.Query().Get("kind")
+	if kind != "" && !catalog.ValidKind(kind) {
+		writeJSONError(w, "Invalid kind", http.StatusBadRequest)
+		return
+	}
+
+	items, err := database.GetCatalogItems(claims.Subject, kind, r.URL.Query().Get("parent_id"))
+	if err != nil {
+		writeJSONError(w, fmt.Sprintf("Failed to fetch catalog: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, struct {
+		Items []database.CatalogItem `json:"items"`
+	}{
+		Items: items,
+	}, http.StatusOK)
+}
+
+func HandlePostCatalog(w http.ResponseWriter, r *http.Request) {
+	claims := requestClaims(r)
+
+	var req PostCatalogRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSONError(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+
+	if msg := validateCatalogRequest(req); msg != "" {
+		writeJSONError(w, msg, http.StatusBadRequest)
+		return
+	}
+
+	id, err := database.AddCatalogItem(claims.Subject, req.toItem())
+	if err != nil {
+		if errors.Is(err, database.ErrNotFound) {
+			writeJSONError(w, "Parent catalog item not found", http.StatusBadRequest)
+			return
+		}
+		if errors.Is(err, database.ErrCatalogParent) {
+			writeJSONError(w, "Kind does not fit under the parent's kind", http.StatusBadRequest)
+			return
+		}
+		writeJSONError(w, fmt.Sprintf("Failed to create catalog item: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	resp := PostCatalogResponse{
+		ID:       id,
+		Kind:     req.Kind,
+		Title:    req.Title,
+		Year:     req.Year,
+		ParentID: req.ParentID,
+		Season:   req.Season,
+		Episode:  req.Episode,
+	}
+	writeJSON(w, resp, http.StatusCreated)
+}
+
+func HandlePutCatalog(w http.ResponseWriter, r *http.Request) {
+	claims := requestClaims(r)
+
+	var req PutCatalogRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSONError(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+
+	if req.ID == "" {
+		writeJSONError(w, "ID is required", http.StatusBadRequest)
+		return
+	}
+	if msg := validateCatalogRequest(req.PostCatalogRequest); msg != "" {
+		writeJSONError(w, msg, http.StatusBadRequest)
+		return
+	}
+	if req.ParentID == req.ID {
+		writeJSONError(w, "Item cannot be its own parent", http.StatusBadRequest)
+		return
+	}
+
+	item := req.toItem()
+	item.ID = req.ID
+	updated, err := database.UpdateCatalogItem(claims.Subject, item)
+	if err != nil {
+		if errors.Is(err, database.ErrNotFound) {
+			writeJSONError(w, "Catalog item not found", http.StatusNotFound)
+			return
+		}
+		if errors.Is(err, database.ErrCatalogParent) {
+			writeJSONError(w, "Kind does not fit under the parent's kind or over the children's", http.StatusBadRequest)
+			return
+		}
+		if errors.Is(err, database.ErrCatalogCycle) {
+			writeJSONError(w, "Item cannot be its own ancestor", http.StatusBadRequest)
+			return
+		}
+		writeJSONError(w, fmt.Sprintf("Failed to update catalog item: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, updated, http.StatusOK)
+}
+
+func HandleDeleteCatalog(w http.ResponseWriter, r *http.Request) {
+	claims := requestClaims(r)
+
+	var req DeleteCatalogRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSONError(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+
+	if req.ID == "" {
+		writeJSONError(w, "ID is required", http.StatusBadRequest)
+		return
+	}
+
+	if err := database.DeleteCatalogItem(claims.Subject, req.ID); err != nil {
+		if errors.Is(err, database.ErrNotFound) {
+			writeJSONError(w, "Catalog item not found", http.StatusNotFound)
+			return
+		}
+		writeJSONError(w, fmt.Sprintf("Failed to delete catalog item: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, struct {
+		Message string `json:"message"`
+	}{
+		Message: "Catalog item deleted successfully",
+	}, http.StatusOK)
+}
+
+func HandlePostCatalogAttach(w http.ResponseWriter, r *http.Request) {
+	handleCatalogAttach(w, r, database.AttachCatalogMedia, database.AttachCatalogLink, "attached")
+}
+
+func HandleDeleteCatalogAttach(w http.ResponseWriter, r *http.Request) {
+	handleCatalogAttach(w, r, database.DetachCatalogMedia, database.DetachCatalogLink, "detached")
+}
+
+func handleCatalogAttach(w http.ResponseWriter, r *http.Request, media, link func(ownerID, itemID, refID string) error, verb string) {
+	claims := requestClaims(r)
+
+	var req CatalogAttachRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSONError(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+
+	if req.ItemID == "" || (req.MediaID == "" && req.LinkID == "") {
+		writeJSONError(w, "Item ID and a media or link ID are required", http.StatusBadRequest)
+		return
+	}
+
+	if req.MediaID != "" {
+		if err := media(claims.Subject, req.ItemID, req.MediaID); err != nil {
+			if errors.Is(err, database.ErrNotFound) {
+				writeJSONError(w, err.Error(), http.StatusNotFound)
+				return
+			}
+			writeJSONError(w, fmt.Sprintf("Failed to update catalog media: %v", err), http.StatusBadRequest)
+			return
+		}
+	}
+	if req.LinkID != "" {
+		if err := link(claims.Subject, req.ItemID, req.LinkID); err != nil {
+			if errors.Is(err, database.ErrNotFound) {
+				writeJSONError(w, err.Error(), http.StatusNotFound)
+				return
+			}
+			writeJSONError(w, fmt.Sprintf("Failed to update catalog link: %v", err), http.StatusBadRequest)
+			return
+		}
+	}
+
+	writeJSON(w, struct {
+		Message string `json:"message"`
+	}{
+		Message: "Catalog references " + verb + " successfully",
+	}, http.StatusOK)
+}
+
+// HandlePostCatalogSuggest parses file names into catalog suggestions without
+// storing anything.
+func HandlePostCatalogSuggest(w http.ResponseWriter, r *http.Request) {
+	var req PostCatalogSuggestRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSONError(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+
+	if len(req.Filenames) == 0 {
+		writeJSONError(w, "Filenames are required", http.StatusBadRequest)
+		return
+	}
+
+	suggestions := make([]CatalogSuggestion, 0, len(req.Filenames))
+	for _, name := range req.Filenames {
+		suggestions = append(suggestions, CatalogSuggestion{
+			Filename:   name,
+			Suggestion: catalog.ParseFilename(name),
+		})
+	}
+
+	writeJSON(w, struct {
+		Suggestions []CatalogSuggestion `json:"suggestions"`
+	}{
+		Suggestions: suggestions,
+	}, http.StatusOK)
+}
+
+func validateCatalogRequest(req PostCatalogRequest) string {
+	if req.Title == "" {
+		return "Title is required"
+	}
+	if !catalog.ValidKind(req.Kind) {
+		return "Kind must be one of movie, series, season, episode, book, album"
+	}
+	if req.Year < 0 || req.Season < 0 || req.Episode < 0 {
+		return "Year, season and episode must not be negative"
+	}
+	return ""
+}
+
+func (req PostCatalogRequest) toItem() database.CatalogItem {
+	return database.CatalogItem{
+		Kind:     req.Kind,
+		Title:    req.Title,
+		Year:     req.Year,
+		ParentID: req.ParentID,
+		Season:   req.Season,
+		Episode:  req.Episode,
+	}
+}
@@ -0,0 +1,354 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"media_management_go/backend/database"
+)
+
+type PostCollectionRequest struct {
+	Kind string `json:"kind"`
+	Name string `json:"name"`
+}
+
+type PostCollectionResponse struct {
+	ID   string `json:"id"`
+	Kind string `json:"kind"`
+	Name string `json:"name"`
+}
+
+type PutCollectionRequest struct {
+	ID           string `json:"id"`
+	Name         string `json:"name"`
+	CoverMediaID string `json:"cover_media_id"`
+}
+
+type DeleteCollectionRequest struct {
+	ID string `json:"id"`
+}
+
+// PostCollectionItemRequest adds a media file to a collection. Without
+// after_id/before_id the item is appended.
+type PostCollectionItemRequest struct {
+	CollectionID string `json:"collection_id"`
+	MediaID      string `json:"media_id"`
+	AfterID      string `json:"after_id"`
+	BeforeID     string `json:"before_id"`
+}
+
+// PutCollectionItemRequest moves an item after after_id or before before_id.
+type PutCollectionItemRequest struct {
+	CollectionID string `json:"collection_id"`
+	ID           string `json:"id"`
+	AfterID      string `json:"after_id"`
+	BeforeID     string `json:"before_id"`
+}
+
+type DeleteCollectionItemRequest struct {
+	CollectionID string `json:"collection_id"`
+	ID           string `json:"id"`
+}
+
+func HandleGetCollection(w http.ResponseWriter, r *http.Request) {
+	claims := requestClaims(r)
+
+	collections, err := database.GetCollections(claims.Subject)
+	if err != nil {
+		writeJSONError(w, fmt.Sprintf("Failed to fetch collections: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, struct {
+		Collections []database.Collection `json:"collections"`
+	}{
+		Collections: collections,
+	}, http.StatusOK)
+}
+
+func HandlePostCollection(w http.ResponseWriter, r *http.Request) {
+	claims := requestClaims(r)
+
+	var req PostCollectionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSONError(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+
+	if req.Name == "" {
+		writeJSONError(w, "Name is required", http.StatusBadRequest)
+		return
+	}
+	if req.Kind != database.CollectionAlbum && req.Kind != database.CollectionPlaylist {
+		writeJSONError(w, "Kind must be album or playlist", http.StatusBadRequest)
+		return
+	}
+
+	id, err := database.AddCollection(claims.Subject, req.Kind, req.Name)
+	if err != nil {
+		writeJSONError(w, fmt.Sprintf("Failed to create collection: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	resp := PostCollectionResponse{
+		ID:   id,
+		Kind: req.Kind,
+		Name: req.Name,
+	}
+	writeJSON(w, resp, http.StatusCreated)
+}
+
+func HandlePutCollection(w http.ResponseWriter, r *http.Request) {
+	claims := requestClaims(r)
+
+	var req PutCollectionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSONError(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+
+	if req.ID == "" || req.Name == "" {
+		writeJSONError(w, "ID and name are required", http.StatusBadRequest)
+		return
+	}
+
+	if req.CoverMediaID != "" {
+		cover, err := database.GetMediaByID(claims.Subject, req.CoverMediaID)
+		if err != nil {
+			if errors.Is(err, database.ErrNotFound) {
+				writeJSONError(w, "Cover media not found", http.StatusBadRequest)
+				return
+			}
+			writeJSONError(w, fmt.Sprintf("Failed to fetch cover media: %v", err), http.StatusInternalServerError)
+			return
+		}
+		if !strings.HasPrefix(cover.MimeType, "image/") {
+			writeJSONError(w, "Cover must be an image", http.StatusBadRequest)
+			return
+		}
+	}
+
+	c, err := database.UpdateCollection(claims.Subject, req.ID, req.Name, req.CoverMediaID)
+	if err != nil {
+		if errors.Is(err, database.ErrNotFound) {
+			writeJSONError(w, "Collection not found", http.StatusNotFound)
+			return
+		}
+		writeJSONError(w, fmt.Sprintf("Failed to update collection: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, c, http.StatusOK)
+}
+
+func HandleDeleteCollection(w http.ResponseWriter, r *http.Request) {
+	claims := requestClaims(r)
+
+	var req DeleteCollectionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSONError(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+
+	if req.ID == "" {
+		writeJSONError(w, "ID is required", http.StatusBadRequest)
+		return
+	}
+
+	if err := database.DeleteCollection(claims.Subject, req.ID); err != nil {
+		if errors.Is(err, database.ErrNotFound) {
+			writeJSONError(w, "Collection not found", http.StatusNotFound)
+			return
+		}
+		writeJSONError(w, fmt.Sprintf("Failed to delete collection: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, struct {
+		Message string `json:"message"`
+	}{
+		Message: "Collection deleted successfully",
+	}, http.StatusOK)
+}
+
+func HandleGetCollectionItems(w http.ResponseWriter, r *http.Request) {
+	claims := requestClaims(r)
+
+	c, ok := collectionFromQuery(w, r, claims.Subject)
+	if !ok {
+		return
+	}
+
+	items, err := database.GetCollectionItems(claims.Subject, c.ID)
+	if err != nil {
+		writeJSONError(w, fmt.Sprintf("Failed to fetch collection items: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, struct {
+		Collection database.Collection       `json:"collection"`
+		Items      []database.CollectionItem `json:"items"`
+	}{
+		Collection: *c,
+		Items:      items,
+	}, http.StatusOK)
+}
+
+func HandlePostCollectionItem(w http.ResponseWriter, r *http.Request) {
+	claims := requestClaims(r)
+
+	var req PostCollectionItemRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSONError(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+
+	if req.CollectionID == "" || req.MediaID == "" {
+		writeJSONError(w, "Collection ID and media ID are required", http.StatusBadRequest)
+		return
+	}
+
+	c, err := database.GetCollection(claims.Subject, req.CollectionID)
+	if err != nil {
+		writeCollectionError(w, err)
+		return
+	}
+	m, err := database.GetMediaByID(claims.Subject, req.MediaID)
+	if err != nil {
+		if errors.Is(err, database.ErrNotFound) {
+			writeJSONError(w, "Media not found", http.StatusBadRequest)
+			return
+		}
+		writeJSONError(w, fmt.Sprintf("Failed to fetch media: %v", err), http.StatusInternalServerError)
+		return
+	}
+	item, err := database.AddCollectionItem(claims.Subject, c.ID, m.ID, req.AfterID, req.BeforeID)
+	if errors.Is(err, database.ErrMediaKind) {
+		writeJSONError(w, fmt.Sprintf("Media of type %s cannot be added to a %s", m.MimeType, c.Kind), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		writeCollectionError(w, err)
+		return
+	}
+	item.Filename = m.Filename
+	item.MimeType = m.MimeType
+
+	writeJSON(w, item, http.StatusCreated)
+}
+
+func HandlePutCollectionItem(w http.ResponseWriter, r *http.Request) {
+	claims := requestClaims(r)
+
+	var req PutCollectionItemRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSONError(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+
+	if req.CollectionID == "" || req.ID == "" {
+		writeJSONError(w, "Collection ID and item ID are required", http.StatusBadRequest)
+		return
+	}
+
+	item, err := database.MoveCollectionItem(claims.Subject, req.CollectionID, req.ID, req.AfterID, req.BeforeID)
+	if err != nil {
+		writeCollectionError(w, err)
+		return
+	}
+
+	writeJSON(w, item, http.StatusOK)
+}
+
+func HandleDeleteCollectionItem(w http.ResponseWriter, r *http.Request) {
+	claims := requestClaims(r)
+
+	var req DeleteCollectionItemRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSONError(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+
+	if req.CollectionID == "" || req.ID == "" {
+		writeJSONError(w, "Collection ID and item ID are required", http.StatusBadRequest)
+		return
+	}
+
+	if err := database.DeleteCollectionItem(claims.Subject, req.CollectionID, req.ID); err != nil {
+		if errors.Is(err, database.ErrNotFound) {
+			writeJSONError(w, "Collection item not found", http.StatusNotFound)
+			return
+		}
+		writeJSONError(w, fmt.Sprintf("Failed to delete collection item: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, struct {
+		Message string `json:"message"`
+	}{
+		Message: "Collection item deleted successfully",
+	}, http.StatusOK)
+}
+
+// HandleGetCollectionM3U8 exports a collection as an extended M3U playlist
+// whose entries are signed media URLs, so any player can stream them.
+func HandleGetCollectionM3U8(w http.ResponseWriter, r *http.Request) {
+	claims := requestClaims(r)
+
+	c, ok := collectionFromQuery(w, r, claims.Subject)
+	if !ok {
+		return
+	}
+
+	items, err := database.GetCollectionItems(claims.Subject, c.ID)
+	if err != nil {
+		writeJSONError(w, fmt.Sprintf("Failed to fetch collection items: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	var b strings.Builder
+	b.WriteString("#EXTM3U\n")
+	fmt.Fprintf(&b, "#PLAYLIST:%s\n", m3uText(c.Name))
+	for _, item := range items {
+		fmt.Fprintf(&b, "#EXTINF:-1,%s\n", m3uText(item.Filename))
+		b.WriteString(SignedMediaURL(r, item.MediaID))
+		b.WriteString("\n")
+	}
+
+	w.Header().Set("Content-Type", "application/vnd.apple.mpegurl; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", c.ID+".m3u8"))
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write([]byte(b.String()))
+}
+
+// collectionFromQuery loads the collection of ownerID named by ?id= and
+// writes an error response if it cannot.
+func collectionFromQuery(w http.ResponseWriter, r *http.Request, ownerID string) (*database.Collection, bool) {
+	id := r.URL.Query().Get("id")
+	if id == "" {
+		writeJSONError(w, "ID is required", http.StatusBadRequest)
+		return nil, false
+	}
+	c, err := database.GetCollection(ownerID, id)
+	if err != nil {
+		writeCollectionError(w, err)
+		return nil, false
+	}
+	return c, true
+}
+
+func writeCollectionError(w http.ResponseWriter, err error) {
+	if errors.Is(err, database.ErrNotFound) {
+		writeJSONError(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	writeJSONError(w, fmt.Sprintf("Failed to update collection: %v", err), http.StatusInternalServerError)
+}
+
+// m3uText keeps a title on a single playlist line.
+func m3uText(s string) string {
+	return strings.NewReplacer("\r", " ", "\n", " ").Replace(s)
+}
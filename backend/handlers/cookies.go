@@ -0,0 +1,138 @@
+package handlers
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"strings"
+
+	"media_management_go/backend/common"
+	"media_management_go/backend/database"
+)
+
+// In cookie mode a browser keeps its session in HttpOnly cookies that
+// scripts cannot read. With SESSION_COOKIES on, a login opts in with the
+// X-Session-Mode: cookie header; without it logins keep returning bearer
+// tokens, so API clients are unaffected. Cookie-authenticated requests that
+// change state must echo the session's CSRF token in X-CSRF-Token. The token
+// is an HMAC of the session ID, so it needs no storage and stays the same
+// across token refreshes.
+const (
+	sessionCookieName = "mm_session"
+	refreshCookieName = "mm_refresh"
+	// the refresh cookie is only ever sent to the endpoint that uses it
+	refreshCookiePath = "/token/refresh"
+
+	sessionModeHeader = "X-Session-Mode"
+	csrfHeader        = "X-CSRF-Token"
+)
+
+// errBadCSRFToken is returned for a cookie-authenticated request that
+// changes state without the session's CSRF token.
+var errBadCSRFToken = errors.New("missing or invalid CSRF token")
+
+// csrfSafeMethods do not change state, so they need no CSRF token.
+var csrfSafeMethods = map[string]bool{
+	http.MethodGet:     true,
+	http.MethodHead:    true,
+	http.MethodOptions: true,
+}
+
+// CookieSessionResponse is the login and refresh response in cookie mode.
+// The tokens themselves are only in the cookies.
+type CookieSessionResponse struct {
+	CSRFToken string `json:"csrf_token"`
+	ExpiresIn int    `json:"expires_in"`
+}
+
+// wantsCookieSession reports whether a login asked for cookie mode and the
+// server allows it.
+func wantsCookieSession(r *http.Request) bool {
+	return common.GetConfig().SESSION_COOKIES && strings.EqualFold(r.Header.Get(sessionModeHeader), "cookie")
+}
+
+func sessionCookie(name, value, path string, maxAge int) *http.Cookie {
+	cfg := common.GetConfig()
+	return &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     path,
+		MaxAge:   maxAge,
+		HttpOnly: true,
+		Secure:   cfg.SESSION_COOKIE_SECURE,
+		SameSite: cfg.SESSION_COOKIE_SAMESITE,
+	}
+}
+
+// writeCookieSession sets the session cookies for a new token pair and
+// writes the CSRF token for the session.
+func writeCookieSession(w http.ResponseWriter, sessionID, access, refresh string) {
+	http.SetCookie(w, sessionCookie(sessionCookieName, access, "/", int(accessTokenTTL.Seconds())))
+	http.SetCookie(w, sessionCookie(refreshCookieName, refresh, refreshCookiePath, int(refreshTokenTTL.Seconds())))
+	writeJSON(w, CookieSessionResponse{
+		CSRFToken: csrfToken(sessionID),
+		ExpiresIn: int(accessTokenTTL.Seconds()),
+	}, http.StatusOK)
+}
+
+// clearSessionCookies tells the browser to drop the session cookies.
+func clearSessionCookies(w http.ResponseWriter) {
+	http.SetCookie(w, sessionCookie(sessionCookieName, "", "/", -1))
+	http.SetCookie(w, sessionCookie(refreshCookieName, "", refreshCookiePath, -1))
+}
+
+// cookieToken returns the access token from the session cookie, if cookie
+// mode is on and the request has one.
+func cookieToken(r *http.Request) (string, bool) {
+	if !common.GetConfig().SESSION_COOKIES {
+		return "", false
+	}
+	c, err := r.Cookie(sessionCookieName)
+	if err != nil || c.Value == "" {
+		return "", false
+	}
+	return c.Value, true
+}
+
+// cookieCSRFToken returns the CSRF token for the session in the request's
+// cookie, so a reloaded frontend can recover it, or "" for bearer requests.
+func cookieCSRFToken(r *http.Request) string {
+	token, ok := cookieToken(r)
+	if !ok || r.Header.Get("Authorization") != "" {
+		return ""
+	}
+	session, err := database.GetToken(database.HashToken(token))
+	if err != nil || session == nil {
+		return ""
+	}
+	return csrfToken(session.ID)
+}
+
+// csrfToken derives the CSRF token for a session.
+func csrfToken(sessionID string) string {
+	mac := hmac.New(sha256.New, []byte(common.GetConfig().JWT_KEY))
+	mac.Write([]byte("csrf:" + sessionID))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// checkCSRF requires the session's CSRF token on requests that change state.
+func checkCSRF(r *http.Request, sessionID string) error {
+	if csrfSafeMethods[r.Method] {
+		return nil
+	}
+	if !hmac.Equal([]byte(r.Header.Get(csrfHeader)), []byte(csrfToken(sessionID))) {
+		return errBadCSRFToken
+	}
+	return nil
+}
+
+// authErrorStatus is the status to reject a request with when
+// authentication failed with err.
+func authErrorStatus(err error) int {
+	if errors.Is(err, errBadCSRFToken) {
+		return http.StatusForbidden
+	}
+	return http.StatusUnauthorized
+}
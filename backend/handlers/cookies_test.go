@@ -0,0 +1,95 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"media_management_go/backend/database"
+)
+
+// cookieLogin signs in in cookie mode and returns the session cookie, as a
+// Cookie header value, and the CSRF token.
+func cookieLogin(t *testing.T, h http.Handler, username string) (string, string) {
+	t.Helper()
+	rec := serve(h, "POST", "/login", `{"username":"`+username+`","password":"`+testPassword+`"}`,
+		sessionModeHeader, "cookie")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("cookie login as %s: %d %s", username, rec.Code, rec.Body)
+	}
+	var resp CookieSessionResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil || resp.CSRFToken == "" {
+		t.Fatalf("cookie login response without CSRF token: %v", err)
+	}
+	for _, c := range rec.Result().Cookies() {
+		if c.Name == sessionCookieName {
+			return c.Name + "=" + c.Value, resp.CSRFToken
+		}
+	}
+	t.Fatalf("cookie login did not set %s", sessionCookieName)
+	return "", ""
+}
+
+// addNote creates a note through h and returns its ID.
+func addNote(t *testing.T, h http.Handler, headers ...string) string {
+	t.Helper()
+	rec := serve(h, "POST", "/note", `{"title":"t","note":"n"}`, headers...)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("POST /note: %d %s", rec.Code, rec.Body)
+	}
+	var resp PostNoteResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatalf("decode PostNoteResponse: %v", err)
+	}
+	return resp.ID
+}
+
+// TestCookieSessionNeedsCSRFToken checks cookie-authenticated requests that
+// change state are refused without the session's CSRF token, and accepted
+// with it.
+func TestCookieSessionNeedsCSRFToken(t *testing.T) {
+	h := setupRoutes(t)
+	addUser(t, "editor", database.RoleEditor)
+	cookie, csrf := cookieLogin(t, h, "editor")
+	id := addNote(t, h, "Cookie", cookie, csrfHeader, csrf)
+
+	requests := []struct{ method, body string }{
+		{"POST", `{"title":"t","note":"n"}`},
+		{"PUT", `{"id":"` + id + `","note":"changed"}`},
+		{"DELETE", `{"id":"` + id + `"}`},
+	}
+	for _, req := range requests {
+		for _, token := range []string{"", "wrong"} {
+			rec := serve(h, req.method, "/note", req.body, "Cookie", cookie, csrfHeader, token)
+			if rec.Code != http.StatusForbidden {
+				t.Errorf("%s /note with cookie and CSRF token %q: %d, want 403", req.method, token, rec.Code)
+			}
+		}
+	}
+
+	if rec := serve(h, "GET", "/note", "", "Cookie", cookie); rec.Code != http.StatusOK {
+		t.Errorf("GET /note with cookie and no CSRF token: %d, want 200", rec.Code)
+	}
+	for _, req := range requests[1:] {
+		rec := serve(h, req.method, "/note", req.body, "Cookie", cookie, csrfHeader, csrf)
+		if rec.Code != http.StatusOK {
+			t.Errorf("%s /note with cookie and CSRF token: %d, want 200", req.method, rec.Code)
+		}
+	}
+}
+
+// TestBearerSessionNeedsNoCSRFToken checks bearer requests are unaffected by
+// cookie mode, since a browser never attaches them on its own.
+func TestBearerSessionNeedsNoCSRFToken(t *testing.T) {
+	h := setupRoutes(t)
+	addUser(t, "editor", database.RoleEditor)
+	bearer := "Bearer " + login(t, h, "editor")
+
+	id := addNote(t, h, "Authorization", bearer)
+	if rec := serve(h, "PUT", "/note", `{"id":"`+id+`","note":"changed"}`, "Authorization", bearer); rec.Code != http.StatusOK {
+		t.Errorf("PUT /note with bearer token: %d, want 200", rec.Code)
+	}
+	if rec := serve(h, "DELETE", "/note", `{"id":"`+id+`"}`, "Authorization", bearer); rec.Code != http.StatusOK {
+		t.Errorf("DELETE /note with bearer token: %d, want 200", rec.Code)
+	}
+}
@@ -2,9 +2,10 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
-	"media_management_go/backend/common"
 	"media_management_go/backend/database"
+	"media_management_go/backend/keyset"
 	"net/http"
 	"strings"
 	"time"
@@ -13,11 +14,14 @@ import (
 )
 
 type PostLoginRequest struct {
-	Key string `json:"key"`
+	Username string `json:"username"`
+	Password string `json:"password"`
 }
 
 type PostLoginResponse struct {
-	Token string `json:"token"`
+	Token        string `json:"token"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    int    `json:"expires_in"`
 }
 
 type PostLinkRequest struct {
@@ -62,23 +66,39 @@ type DeleteNoteRequest struct {
 }
 
 func HandleGetLogin(w http.ResponseWriter, r *http.Request) {
-	// Validate token and return 200 if valid
-	if claims, ok := requireAuth(w, r); ok {
-		writeJSON(w, struct {
-			Subject   string    `json:"subject"`
-			IssuedAt  time.Time `json:"issued_at"`
-			ExpiresAt time.Time `json:"expires_at"`
-		}{
-			Subject:   claims.Subject,
-			IssuedAt:  claims.IssuedAt.Time,
-			ExpiresAt: claims.ExpiresAt.Time,
-		}, http.StatusOK)
+	// the route only lets valid tokens through, so this confirms the login
+	claims := requestClaims(r)
+	user, err := database.GetUser(claims.Subject)
+	if err != nil {
+		writeJSONError(w, fmt.Sprintf("Failed to fetch user: %v", err), http.StatusInternalServerError)
+		return
 	}
+	// personal access tokens may have no expiry
+	var expiresAt *time.Time
+	if claims.ExpiresAt != nil {
+		expiresAt = &claims.ExpiresAt.Time
+	}
+	writeJSON(w, struct {
+		Subject   string        `json:"subject"`
+		Username  string        `json:"username"`
+		Role      database.Role `json:"role"`
+		TwoFactor bool          `json:"two_factor"`
+		IssuedAt  time.Time     `json:"issued_at"`
+		ExpiresAt *time.Time    `json:"expires_at,omitempty"`
+		CSRFToken string        `json:"csrf_token,omitempty"`
+	}{
+		Subject:   claims.Subject,
+		Username:  user.Username,
+		Role:      user.Role,
+		TwoFactor: user.TwoFactor,
+		IssuedAt:  claims.IssuedAt.Time,
+		ExpiresAt: expiresAt,
+		CSRFToken: cookieCSRFToken(r),
+	}, http.StatusOK)
 }
 
 func HandlePostLogin(w http.ResponseWriter, r *http.Request) {
-	// process login key, create session or cookie
-	cfg := common.GetConfig()
+	// check username and password, create session
 	var req PostLoginRequest
 
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -86,45 +106,87 @@ func HandlePostLogin(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if req.Key != cfg.USER_KEY {
-		writeJSONError(w, "Unauthorized", http.StatusUnauthorized)
+	if !checkLoginThrottle(w, r, req.Username) {
+		return // checkLoginThrottle already wrote error response
+	}
+
+	user, ok := checkPassword(r.Context(), req.Username, req.Password)
+	if !ok {
+		recordLoginAttempt(r, req.Username, "", false, "invalid credentials")
+		writeJSONError(w, "Invalid username or password", http.StatusUnauthorized)
+		return
+	}
+	if user.Disabled {
+		recordLoginAttempt(r, req.Username, user.ID, false, "account disabled")
+		writeJSONError(w, "Account disabled", http.StatusForbidden)
 		return
 	}
 
-	agent := r.UserAgent()
-	exp := time.Now().Add(168 * time.Hour)
-	claims := jwt.RegisteredClaims{
-		ExpiresAt: jwt.NewNumericDate(exp),
-		IssuedAt:  jwt.NewNumericDate(time.Now()),
-		Subject:   agent,
+	// users with two-factor login finish at POST /login/2fa
+	if user.TwoFactor {
+		challenge, err := signChallengeToken(user.ID)
+		if err != nil {
+			writeJSONError(w, "Failed to generate token", http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, PostLoginChallengeResponse{
+			TwoFactorRequired: true,
+			ChallengeToken:    challenge,
+			ExpiresIn:         int(twoFactorChallengeTTL.Seconds()),
+		}, http.StatusOK)
+		return
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	signed, err := token.SignedString([]byte(cfg.JWT_KEY))
+	recordLoginAttempt(r, user.Username, user.ID, true, "")
+	startSession(w, r, user.ID)
+}
+
+// startSession issues an access and refresh token pair for a user who has
+// just logged in and writes them as the login response, or sets them as
+// cookies if the client asked for a cookie session.
+func startSession(w http.ResponseWriter, r *http.Request, userID string) {
+	agent := r.UserAgent()
+	signed, err := signAccessToken(userID)
+	if err != nil {
+		writeJSONError(w, "Failed to generate token", http.StatusInternalServerError)
+		return
+	}
+	refresh, err := newRefreshToken()
 	if err != nil {
 		writeJSONError(w, "Failed to generate token", http.StatusInternalServerError)
 		return
 	}
+	exp := time.Now().Add(refreshTokenTTL)
 
 	// persist the token in sqlite for later introspection / revocation
-	if _, err := database.AddToken(signed); err != nil {
+	sessionID, err := database.AddSession(userID, database.HashToken(signed), agent, clientIP(r), exp)
+	if err != nil {
+		writeJSONError(w, "Failed to persist token", http.StatusInternalServerError)
+		return
+	}
+	if err := database.AddRefreshToken(sessionID, database.HashToken(refresh), exp); err != nil {
+		_ = database.DeleteToken(sessionID)
 		writeJSONError(w, "Failed to persist token", http.StatusInternalServerError)
 		return
 	}
 
+	if wantsCookieSession(r) {
+		writeCookieSession(w, sessionID, signed, refresh)
+		return
+	}
 	resp := PostLoginResponse{
-		Token: signed,
+		Token:        signed,
+		RefreshToken: refresh,
+		ExpiresIn:    int(accessTokenTTL.Seconds()),
 	}
 	writeJSON(w, resp, http.StatusOK)
 
 }
 
 func HandleGetLink(w http.ResponseWriter, r *http.Request) {
-	if _, ok := requireAuth(w, r); !ok {
-		return // requireAuth already wrote error response
-	}
+	claims := requestClaims(r)
 
-	links, err := database.GetLinks()
+	links, err := database.GetLinks(claims.Subject)
 	if err != nil {
 		writeJSONError(w, fmt.Sprintf("Failed to fetch links: %v", err), http.StatusInternalServerError)
 		return
@@ -138,9 +200,7 @@ func HandleGetLink(w http.ResponseWriter, r *http.Request) {
 }
 
 func HandlePostLink(w http.ResponseWriter, r *http.Request) {
-	if _, ok := requireAuth(w, r); !ok {
-		return // requireAuth already wrote error response
-	}
+	claims := requestClaims(r)
 
 	var req PostLinkRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -155,11 +215,13 @@ func HandlePostLink(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Add link to database
-	id, err := database.AddLink(req.Link, req.ImgPath)
+	id, err := database.AddLink(claims.Subject, req.Link, req.ImgPath)
 	if err != nil {
 		writeJSONError(w, fmt.Sprintf("Failed to create link: %v", err), http.StatusInternalServerError)
 		return
 	}
+	recordAudit(r, database.AuditEntry{ActorID: claims.Subject, Action: database.AuditLinkCreate, TargetID: id},
+		nil, database.Link{ID: id, Link: req.Link, ImgPath: req.ImgPath})
 
 	// Return the created link data
 	resp := PostLinkResponse{
@@ -171,9 +233,7 @@ func HandlePostLink(w http.ResponseWriter, r *http.Request) {
 }
 
 func HandleDeleteLink(w http.ResponseWriter, r *http.Request) {
-	if _, ok := requireAuth(w, r); !ok {
-		return // requireAuth already wrote error response
-	}
+	claims := requestClaims(r)
 
 	var req DeleteLinkRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -186,10 +246,25 @@ func HandleDeleteLink(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := database.DeleteLink(req.ID); err != nil {
+	link, err := database.GetLink(claims.Subject, req.ID)
+	if err != nil {
+		if errors.Is(err, database.ErrNotFound) {
+			writeJSONError(w, "Link not found", http.StatusNotFound)
+			return
+		}
+		writeJSONError(w, fmt.Sprintf("Failed to fetch link: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	if err := database.DeleteLink(claims.Subject, link.ID); err != nil {
+		if errors.Is(err, database.ErrNotFound) {
+			writeJSONError(w, "Link not found", http.StatusNotFound)
+			return
+		}
 		writeJSONError(w, fmt.Sprintf("Failed to delete note: %v", err), http.StatusInternalServerError)
 		return
 	}
+	recordAudit(r, database.AuditEntry{ActorID: claims.Subject, Action: database.AuditLinkDelete, TargetID: link.ID}, link, nil)
 
 	writeJSON(w, struct {
 		Message string `json:"message"`
@@ -199,11 +274,9 @@ func HandleDeleteLink(w http.ResponseWriter, r *http.Request) {
 }
 
 func HandleGetNote(w http.ResponseWriter, r *http.Request) {
-	if _, ok := requireAuth(w, r); !ok {
-		return // requireAuth already wrote error response
-	}
+	claims := requestClaims(r)
 
-	notes, err := database.GetNotes()
+	notes, err := database.GetNotes(claims.Subject)
 	if err != nil {
 		writeJSONError(w, fmt.Sprintf("Failed to fetch notes: %v", err), http.StatusInternalServerError)
 		return
@@ -217,9 +290,7 @@ func HandleGetNote(w http.ResponseWriter, r *http.Request) {
 }
 
 func HandlePostNote(w http.ResponseWriter, r *http.Request) {
-	if _, ok := requireAuth(w, r); !ok {
-		return // requireAuth already wrote error response
-	}
+	claims := requestClaims(r)
 
 	var req PostNoteRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -232,11 +303,13 @@ func HandlePostNote(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	id, err := database.AddNote(req.Title, req.Note)
+	id, err := database.AddNote(claims.Subject, req.Title, req.Note)
 	if err != nil {
 		writeJSONError(w, fmt.Sprintf("Failed to create note: %v", err), http.StatusInternalServerError)
 		return
 	}
+	recordAudit(r, database.AuditEntry{ActorID: claims.Subject, Action: database.AuditNoteCreate, TargetID: id},
+		nil, database.Note{ID: id, Title: req.Title, Note: req.Note})
 
 	resp := PostNoteResponse{
 		ID:    id,
@@ -247,9 +320,7 @@ func HandlePostNote(w http.ResponseWriter, r *http.Request) {
 }
 
 func HandlePutNote(w http.ResponseWriter, r *http.Request) {
-	if _, ok := requireAuth(w, r); !ok {
-		return // requireAuth already wrote error response
-	}
+	claims := requestClaims(r)
 
 	var req PutNoteRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -262,11 +333,28 @@ func HandlePutNote(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	note, err := database.UpdateNote(req.ID, req.Note)
+	before, err := database.GetNote(claims.Subject, req.ID)
 	if err != nil {
+		if errors.Is(err, database.ErrNotFound) {
+			writeJSONError(w, "Note not found", http.StatusNotFound)
+			return
+		}
+		writeJSONError(w, fmt.Sprintf("Failed to fetch note: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	note, err := database.UpdateNote(claims.Subject, req.ID, req.Note)
+	if err != nil {
+		if errors.Is(err, database.ErrNotFound) {
+			writeJSONError(w, "Note not found", http.StatusNotFound)
+			return
+		}
 		writeJSONError(w, fmt.Sprintf("Failed to update note: %v", err), http.StatusInternalServerError)
 		return
 	}
+	after := *before
+	after.Note, after.UpdatedAt = note.Note, note.UpdatedAt
+	recordAudit(r, database.AuditEntry{ActorID: claims.Subject, Action: database.AuditNoteUpdate, TargetID: note.ID}, before, after)
 
 	resp := PutNoteResponse{
 		ID:        note.ID,
@@ -277,9 +365,7 @@ func HandlePutNote(w http.ResponseWriter, r *http.Request) {
 }
 
 func HandleDeleteNote(w http.ResponseWriter, r *http.Request) {
-	if _, ok := requireAuth(w, r); !ok {
-		return // requireAuth already wrote error response
-	}
+	claims := requestClaims(r)
 
 	var req DeleteNoteRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -292,10 +378,25 @@ func HandleDeleteNote(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := database.DeleteNote(req.ID); err != nil {
+	note, err := database.GetNote(claims.Subject, req.ID)
+	if err != nil {
+		if errors.Is(err, database.ErrNotFound) {
+			writeJSONError(w, "Note not found", http.StatusNotFound)
+			return
+		}
+		writeJSONError(w, fmt.Sprintf("Failed to fetch note: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	if err := database.DeleteNote(claims.Subject, note.ID); err != nil {
+		if errors.Is(err, database.ErrNotFound) {
+			writeJSONError(w, "Note not found", http.StatusNotFound)
+			return
+		}
 		writeJSONError(w, fmt.Sprintf("Failed to delete note: %v", err), http.StatusInternalServerError)
 		return
 	}
+	recordAudit(r, database.AuditEntry{ActorID: claims.Subject, Action: database.AuditNoteDelete, TargetID: note.ID}, note, nil)
 
 	writeJSON(w, struct {
 		Message string `json:"message"`
@@ -317,54 +418,89 @@ func writeJSONError(w http.ResponseWriter, msg string, status int) {
 	writeJSON(w, errResp{Error: msg}, status)
 }
 
-// validateToken extracts and validates the JWT from Authorization header.
+// validateToken extracts and validates the JWT from the Authorization header,
+// or from the session cookie in cookie mode.
 // Returns the parsed claims if token is valid, or error if validation fails.
 func validateToken(r *http.Request) (*jwt.RegisteredClaims, error) {
+	claims, _, err := authenticate(r)
+	return claims, err
+}
+
+// authenticate is validateToken that also returns the session the token belongs to.
+func authenticate(r *http.Request) (*jwt.RegisteredClaims, *database.Token, error) {
 	authHeader := r.Header.Get("Authorization")
 	if authHeader == "" {
-		return nil, fmt.Errorf("missing Authorization header")
+		if token, ok := cookieToken(r); ok {
+			return authenticateCookie(r, token)
+		}
+		return nil, nil, fmt.Errorf("missing Authorization header")
 	}
 
 	const prefix = "Bearer "
 	if !strings.HasPrefix(authHeader, prefix) {
-		return nil, fmt.Errorf("invalid Authorization header format")
+		return nil, nil, fmt.Errorf("invalid Authorization header format")
 	}
 
-	tokenStr := strings.TrimPrefix(authHeader, prefix)
+	claims, session, err := validateTokenString(strings.TrimPrefix(authHeader, prefix))
+	if err != nil {
+		return nil, nil, err
+	}
+	touchSession(r, session)
+	return claims, session, nil
+}
+
+// authenticateCookie is authenticate for a token from the session cookie,
+// which also needs the CSRF token unless the request is read-only.
+func authenticateCookie(r *http.Request, token string) (*jwt.RegisteredClaims, *database.Token, error) {
+	claims, session, err := validateTokenString(token)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := checkCSRF(r, session.ID); err != nil {
+		return nil, nil, err
+	}
+	touchSession(r, session)
+	return claims, session, nil
+}
+
+// validateTokenString validates a raw JWT and checks that its session still exists.
+func validateTokenString(tokenStr string) (*jwt.RegisteredClaims, *database.Token, error) {
 	if tokenStr == "" {
-		return nil, fmt.Errorf("empty token")
+		return nil, nil, fmt.Errorf("empty token")
 	}
 
 	// Parse and validate JWT signature
 	claims := &jwt.RegisteredClaims{}
-	token, err := jwt.ParseWithClaims(tokenStr, claims, func(t *jwt.Token) (interface{}, error) {
-		if t.Method.Alg() != jwt.SigningMethodHS256.Alg() {
-			return nil, fmt.Errorf("unexpected signing method: %v", t.Method.Alg())
-		}
-		return []byte(common.GetConfig().JWT_KEY), nil
-	})
+	token, err := jwt.ParseWithClaims(tokenStr, claims, keyset.Get().Keyfunc)
 
 	if err != nil || !token.Valid {
-		return nil, fmt.Errorf("invalid token: %v", err)
+		return nil, nil, fmt.Errorf("invalid token: %v", err)
 	}
 
 	// Verify token exists in database
-	storedToken, err := database.GetToken(tokenStr)
+	storedToken, err := database.GetToken(database.HashToken(tokenStr))
 	if err != nil {
-		return nil, fmt.Errorf("database error: %v", err)
+		return nil, nil, fmt.Errorf("database error: %v", err)
 	}
 	if storedToken == nil {
-		return nil, fmt.Errorf("token not found in database")
+		return nil, nil, fmt.Errorf("token not found in database")
+	}
+	if storedToken.UserID == "" || storedToken.UserID != claims.Subject {
+		return nil, nil, fmt.Errorf("token does not belong to a user")
+	}
+	if err := checkUserActive(storedToken.UserID); err != nil {
+		return nil, nil, err
 	}
 
-	return claims, nil
+	return claims, storedToken, nil
 }
 
-// requireAuth is a helper that validates the token and writes error response if invalid
+// requireAuth is a helper that validates the token and writes error response if invalid.
+// Personal access tokens are accepted too; use requireScope to also check scopes.
 func requireAuth(w http.ResponseWriter, r *http.Request) (*jwt.RegisteredClaims, bool) {
-	claims, err := validateToken(r)
+	claims, _, err := authenticateAny(r)
 	if err != nil {
-		writeJSONError(w, err.Error(), http.StatusUnauthorized)
+		writeJSONError(w, err.Error(), authErrorStatus(err))
 		return nil, false
 	}
 	return claims, true
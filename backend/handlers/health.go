@@ -0,0 +1,131 @@
+package handlers
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"media_management_go/backend/common"
+	"media_management_go/backend/database"
+	"media_management_go/backend/jobs"
+	"media_management_go/backend/metrics"
+	"media_management_go/backend/storage"
+)
+
+const (
+	// readyCheckTimeout bounds the database ping, so a locked database
+	// makes the server not ready instead of hanging the probe.
+	readyCheckTimeout = 2 * time.Second
+	// storageCheckInterval is how long a storage probe result is reused,
+	// so frequent or anonymous probes do not write a file each time.
+	storageCheckInterval = 30 * time.Second
+)
+
+// lifecycle is where the server is between starting and shutting down.
+type lifecycle int32
+
+const (
+	lifecycleStarting lifecycle = iota
+	lifecycleReady
+	lifecycleStopping
+)
+
+var state atomic.Int32
+
+// MarkReady makes /readyz run its checks, once the server is listening.
+func MarkReady() {
+	state.Store(int32(lifecycleReady))
+}
+
+// MarkStopping makes /readyz fail from now on, so load balancers stop
+// sending requests while the server shuts down.
+func MarkStopping() {
+	state.Store(int32(lifecycleStopping))
+}
+
+// readyChecks are the dependencies the server needs to serve requests.
+var readyChecks = []struct {
+	name  string
+	check func(ctx context.Context) error
+}{
+	{"database", database.Ping},
+	{"migrations", func(context.Context) error { return database.CheckMigrations() }},
+	{"storage", cached(storageCheckInterval, func(context.Context) error { return storage.CheckWritable() })},
+	{"jobs", func(context.Context) error { return jobs.Check() }},
+}
+
+// cached returns check with its result reused for interval after each run.
+func cached(interval time.Duration, check func(ctx context.Context) error) func(ctx context.Context) error {
+	var (
+		mu      sync.Mutex
+		lastRun time.Time
+		lastErr error
+	)
+	return func(ctx context.Context) error {
+		mu.Lock()
+		defer mu.Unlock()
+		if time.Since(lastRun) >= interval {
+			lastErr = check(ctx)
+			lastRun = time.Now()
+		}
+		return lastErr
+	}
+}
+
+type checkResult struct {
+	Status   string `json:"status"`
+	Error    string `json:"error,omitempty"`
+	Duration string `json:"duration"`
+}
+
+type readyResponse struct {
+	Status string                 `json:"status"`
+	Checks map[string]checkResult `json:"checks,omitempty"`
+}
+
+// HandleGetHealthz reports the process is alive. It checks nothing else, so
+// a failing dependency does not get the server restarted.
+func HandleGetHealthz(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, map[string]string{"status": "ok"}, http.StatusOK)
+}
+
+// HandleGetReadyz reports whether the server can serve requests. It is 503
+// while starting, shutting down or when any check fails. The result of each
+// dependency check is only shown to callers presenting METRICS_TOKEN, as
+// errors can reveal paths and internals.
+func HandleGetReadyz(w http.ResponseWriter, r *http.Request) {
+	switch lifecycle(state.Load()) {
+	case lifecycleStarting:
+		writeJSON(w, readyResponse{Status: "starting"}, http.StatusServiceUnavailable)
+		return
+	case lifecycleStopping:
+		writeJSON(w, readyResponse{Status: "stopping"}, http.StatusServiceUnavailable)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), readyCheckTimeout)
+	defer cancel()
+
+	resp := readyResponse{Status: "ok", Checks: map[string]checkResult{}}
+	status := http.StatusOK
+	for _, c := range readyChecks {
+		start := time.Now()
+		err := c.check(ctx)
+		res := checkResult{Status: "ok", Duration: time.Since(start).Round(time.Microsecond).String()}
+		if err != nil {
+			res.Status = "failed"
+			res.Error = err.Error()
+			resp.Status = "failed"
+			status = http.StatusServiceUnavailable
+			slog.WarnContext(r.Context(), "Readiness check failed", slog.String("check", c.name), slog.Any("error", err))
+		}
+		resp.Checks[c.name] = res
+	}
+	if token := common.GetConfig().METRICS_TOKEN; token == "" || !metrics.HasToken(r, token) {
+		resp.Checks = nil
+	}
+	writeJSON(w, resp, status)
+}
@@ -0,0 +1,40 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+// TestReadyzHidesCheckDetails checks /readyz only reports its status to
+// anonymous callers and the result of each check to those with the
+// metrics token.
+func TestReadyzHidesCheckDetails(t *testing.T) {
+	h := setupRoutes(t)
+	MarkReady()
+	t.Cleanup(func() { state.Store(int32(lifecycleStarting)) })
+
+	for _, tc := range []struct {
+		auth        string
+		wantDetails bool
+	}{
+		{"", false},
+		{"Bearer wrong", false},
+		{"Bearer test-metrics-token", true},
+	} {
+		rec := serve(h, "GET", "/readyz", "", "Authorization", tc.auth)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("GET /readyz with %q: %d %s", tc.auth, rec.Code, rec.Body)
+		}
+		var resp readyResponse
+		if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+			t.Fatalf("decode readyResponse: %v", err)
+		}
+		if resp.Status != "ok" {
+			t.Errorf("GET /readyz with %q: status %q, want ok", tc.auth, resp.Status)
+		}
+		if got := len(resp.Checks) > 0; got != tc.wantDetails {
+			t.Errorf("GET /readyz with %q: checks shown = %v, want %v", tc.auth, got, tc.wantDetails)
+		}
+	}
+}
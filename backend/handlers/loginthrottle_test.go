@@ -0,0 +1,111 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"media_management_go/backend/database"
+)
+
+// TestLoginBackoff checks nothing waits below the threshold, and past it
+// the wait doubles with each failure up to loginBackoffMax, counted from the
+// last failure.
+func TestLoginBackoff(t *testing.T) {
+	for _, tc := range []struct {
+		count int
+		since time.Duration
+		want  time.Duration
+	}{
+		{0, 0, 0},
+		{userFailureThreshold - 1, 0, 0},
+		{userFailureThreshold, 0, loginBackoffBase},
+		{userFailureThreshold + 1, 0, 2 * loginBackoffBase},
+		{userFailureThreshold + 2, 0, 4 * loginBackoffBase},
+		{userFailureThreshold, 10 * time.Second, loginBackoffBase - 10*time.Second},
+		{userFailureThreshold, 2 * loginBackoffBase, 0},
+		{userFailureThreshold + 7, 0, loginBackoffMax},
+		{userFailureThreshold + 100, 0, loginBackoffMax},
+		{userFailureThreshold + 100, loginBackoffMax - time.Minute, time.Minute},
+	} {
+		f := database.LoginFailures{Count: tc.count, Since: tc.since}
+		if got := loginBackoff(f, userFailureThreshold); got != tc.want {
+			t.Errorf("loginBackoff(%d failures, %v ago) = %v, want %v", tc.count, tc.since, got, tc.want)
+		}
+	}
+}
+
+// checkThrottle runs checkLoginThrottle for username from ip.
+func checkThrottle(username, ip string) (bool, *httptest.ResponseRecorder) {
+	req := httptest.NewRequest("POST", "/login", nil)
+	req.RemoteAddr = ip + ":4000"
+	rec := httptest.NewRecorder()
+	return checkLoginThrottle(rec, req, username), rec
+}
+
+// TestCheckLoginThrottle checks the per-username and per-address thresholds
+// and the Retry-After header.
+func TestCheckLoginThrottle(t *testing.T) {
+	setupRoutes(t)
+
+	for i := 0; i < userFailureThreshold-1; i++ {
+		database.AddLoginAttempt("alice", "192.0.2.1", "", false, "test")
+	}
+	if ok, rec := checkThrottle("alice", "192.0.2.1"); !ok {
+		t.Fatalf("throttled below the threshold: %d", rec.Code)
+	}
+	database.AddLoginAttempt("alice", "192.0.2.1", "", false, "test")
+	ok, rec := checkThrottle("alice", "192.0.2.2")
+	if ok || rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("not throttled at the threshold from another address: %v %d", ok, rec.Code)
+	}
+	if got := rec.Header().Get("Retry-After"); got != strconv.Itoa(int(loginBackoffBase.Seconds())) {
+		t.Errorf("Retry-After = %q, want %v in seconds", got, loginBackoffBase)
+	}
+	if ok, _ := checkThrottle("bob", "192.0.2.1"); !ok {
+		t.Error("another user throttled by alice's failures")
+	}
+
+	for i := 0; i < ipFailureThreshold; i++ {
+		database.AddLoginAttempt("user"+strconv.Itoa(i), "198.51.100.7", "", false, "test")
+	}
+	if ok, _ := checkThrottle("carol", "198.51.100.7"); ok {
+		t.Error("address not throttled past its threshold")
+	}
+	if ok, _ := checkThrottle("carol", "198.51.100.8"); !ok {
+		t.Error("carol throttled from an address without failures")
+	}
+}
+
+// TestWebDAVThrottle checks WebDAV Basic failures are counted per address,
+// whatever the username, and leave that user's password login alone.
+func TestWebDAVThrottle(t *testing.T) {
+	setupRoutes(t)
+	addUser(t, "editor", database.RoleEditor)
+	webDAV := NewWebDAVHandler("/dav")
+	mux := http.NewServeMux()
+	RegisterRoutes(mux)
+	mux.Handle("PROPFIND /dav/", webDAV)
+
+	propfind := func(username string) int {
+		req := httptest.NewRequest("PROPFIND", "/dav/", nil)
+		req.SetBasicAuth(username, "wrong")
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		return rec.Code
+	}
+	for i := 0; i < userFailureThreshold; i++ {
+		if code := propfind("guess" + strconv.Itoa(i)); code != http.StatusUnauthorized {
+			t.Fatalf("guess %d: %d, want 401", i, code)
+		}
+	}
+	if code := propfind("another-guess"); code != http.StatusTooManyRequests {
+		t.Errorf("new username after %d failures: %d, want 429", userFailureThreshold, code)
+	}
+	if code := propfind("editor"); code != http.StatusTooManyRequests {
+		t.Errorf("real username after %d failures: %d, want 429", userFailureThreshold, code)
+	}
+	login(t, mux, "editor")
+}
@@ -0,0 +1,206 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"strings"
+
+	"media_management_go/backend/catalog"
+	"media_management_go/backend/common"
+	"media_management_go/backend/database"
+	"media_management_go/backend/storage"
+)
+
+// maxUploadMemory is how much of a multipart upload is buffered in memory before spilling to disk.
+const maxUploadMemory = 32 << 20
+
+// mediaCSP keeps an uploaded file opened in the browser from running script
+// or loading anything on the API origin.
+const mediaCSP = "default-src 'none'; img-src 'self'; media-src 'self'; sandbox"
+
+type PostMediaResponse struct {
+	ID         string             `json:"id"`
+	Filename   string             `json:"filename"`
+	MimeType   string             `json:"mime_type"`
+	Size       int64              `json:"size"`
+	Suggestion catalog.Suggestion `json:"suggestion"`
+}
+
+type DeleteMediaRequest struct {
+	ID string `json:"id"`
+}
+
+func HandleGetMedia(w http.ResponseWriter, r *http.Request) {
+	claims := requestClaims(r)
+
+	media, err := database.GetMedia(claims.Subject)
+	if err != nil {
+		writeJSONError(w, fmt.Sprintf("Failed to fetch media: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, struct {
+		Media []database.Media `json:"media"`
+	}{
+		Media: media,
+	}, http.StatusOK)
+}
+
+// HandlePostMedia stores an uploaded file (multipart field "file") and returns
+// a catalog suggestion parsed from its file name.
+func HandlePostMedia(w http.ResponseWriter, r *http.Request) {
+	claims := requestClaims(r)
+
+	r.Body = http.MaxBytesReader(w, r.Body, common.GetConfig().MAX_UPLOAD_SIZE)
+	if err := r.ParseMultipartForm(maxUploadMemory); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			writeJSONError(w, fmt.Sprintf("Upload exceeds %d bytes", tooLarge.Limit), http.StatusRequestEntityTooLarge)
+			return
+		}
+		writeJSONError(w, "Invalid multipart payload", http.StatusBadRequest)
+		return
+	}
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		writeJSONError(w, "File is required", http.StatusBadRequest)
+		return
+	}
+	defer file.Close()
+
+	filename := filepath.Base(header.Filename)
+	mimeType := storage.MimeType(filename, header.Header.Get("Content-Type"))
+
+	stored, size, err := storage.Save(file, filepath.Ext(filename))
+	if err != nil {
+		writeJSONError(w, fmt.Sprintf("Failed to store file: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	id, err := database.AddMedia(claims.Subject, filename, stored, mimeType, size)
+	if err != nil {
+		storage.Remove(stored)
+		writeJSONError(w, fmt.Sprintf("Failed to create media: %v", err), http.StatusInternalServerError)
+		return
+	}
+	recordAudit(r, database.AuditEntry{ActorID: claims.Subject, Action: database.AuditMediaCreate, TargetID: id},
+		nil, database.Media{ID: id, Filename: filename, MimeType: mimeType, Size: size})
+
+	resp := PostMediaResponse{
+		ID:         id,
+		Filename:   filename,
+		MimeType:   mimeType,
+		Size:       size,
+		Suggestion: catalog.ParseFilename(filename),
+	}
+	writeJSON(w, resp, http.StatusCreated)
+}
+
+// HandleGetMediaFile streams a media file. Range requests are supported so
+// players can seek. Signed URLs (see SignedMediaURL) are accepted in place of
+// a bearer token.
+func HandleGetMediaFile(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	var m *database.Media
+	var err error
+	if r.URL.Query().Has("sig") {
+		if !validMediaSignature(r, id) {
+			writeJSONError(w, "invalid or expired media signature", http.StatusUnauthorized)
+			return
+		}
+		// the signature was issued to whoever could see the file
+		m, err = database.GetMediaByIDAnyOwner(id)
+	} else {
+		m, err = database.GetMediaByID(requestClaims(r).Subject, id)
+	}
+	if err != nil {
+		if errors.Is(err, database.ErrNotFound) {
+			writeJSONError(w, "Media not found", http.StatusNotFound)
+			return
+		}
+		writeJSONError(w, fmt.Sprintf("Failed to fetch media: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	serveMediaFile(w, r, m)
+}
+
+func HandleDeleteMedia(w http.ResponseWriter, r *http.Request) {
+	claims := requestClaims(r)
+
+	var req DeleteMediaRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSONError(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+
+	if req.ID == "" {
+		writeJSONError(w, "ID is required", http.StatusBadRequest)
+		return
+	}
+
+	m, err := database.GetMediaByID(claims.Subject, req.ID)
+	if err != nil {
+		if errors.Is(err, database.ErrNotFound) {
+			writeJSONError(w, "Media not found", http.StatusNotFound)
+			return
+		}
+		writeJSONError(w, fmt.Sprintf("Failed to fetch media: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	if err := database.DeleteMedia(claims.Subject, m.ID); err != nil {
+		writeJSONError(w, fmt.Sprintf("Failed to delete media: %v", err), http.StatusInternalServerError)
+		return
+	}
+	storage.Remove(m.Path)
+	recordAudit(r, database.AuditEntry{ActorID: claims.Subject, Action: database.AuditMediaDelete, TargetID: m.ID}, m, nil)
+
+	writeJSON(w, struct {
+		Message string `json:"message"`
+	}{
+		Message: "Media deleted successfully",
+	}, http.StatusOK)
+}
+
+// serveMediaFile writes the stored file of m, honouring Range and conditional
+// headers. The stored type came from the uploader, so only images, audio and
+// video are shown inline, and never with script.
+func serveMediaFile(w http.ResponseWriter, r *http.Request, m *database.Media) {
+	f, err := storage.Open(m.Path)
+	if err != nil {
+		writeJSONError(w, "Media file missing", http.StatusNotFound)
+		return
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		writeJSONError(w, "Media file missing", http.StatusNotFound)
+		return
+	}
+
+	disposition := "attachment"
+	if playableInline(m.MimeType) {
+		disposition = "inline"
+	}
+	w.Header().Set("Content-Type", m.MimeType)
+	w.Header().Set("Content-Disposition", mime.FormatMediaType(disposition, map[string]string{"filename": m.Filename}))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Content-Security-Policy", mediaCSP)
+	http.ServeContent(w, r, m.Filename, info.ModTime(), f)
+}
+
+// playableInline reports whether a browser may show a file of mimeType in
+// place. SVG is an image that can carry script, so it is downloaded instead.
+func playableInline(mimeType string) bool {
+	mediaType, _, _ := mime.ParseMediaType(mimeType)
+	if mediaType == "image/svg+xml" {
+		return false
+	}
+	return strings.HasPrefix(mediaType, "image/") || strings.HasPrefix(mediaType, "audio/") || strings.HasPrefix(mediaType, "video/")
+}
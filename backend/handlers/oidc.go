@@ -0,0 +1,293 @@
+package handlers
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"media_management_go/backend/common"
+	"media_management_go/backend/database"
+	"media_management_go/backend/oidc"
+)
+
+// Single sign-on uses the OpenID Connect authorization code flow with PKCE.
+// GET /login/oidc hands the frontend the provider's authorization URL; the
+// provider sends the browser back to OIDC_REDIRECT_URL, and that page posts
+// the code and state to POST /login/oidc/callback for the same token pair a
+// password login gets. Pending logins live in memory for oidcLoginTTL, so a
+// restart only means starting over at the provider. The state is also kept,
+// hashed, in a cookie of the browser that started the login, so a callback
+// link made by someone else cannot sign the browser in to their account.
+const (
+	oidcLoginTTL = 10 * time.Minute
+	// the state cookie is only ever sent to the single sign-on endpoints
+	oidcStateCookieName = "mm_oidc_state"
+	oidcStateCookiePath = "/login/oidc"
+	// maxPendingOIDCLogins caps the pending logins anyone can create
+	// without credentials.
+	maxPendingOIDCLogins = 10000
+)
+
+var (
+	errOIDCDisabled   = errors.New("single sign-on is not configured")
+	errNoOIDCUsername = errors.New("identity provider sent no usable username")
+	errNoLocalAccount = errors.New("no local account for this identity")
+	errTooManyPending = errors.New("too many pending single sign-on logins")
+)
+
+var (
+	oidcProviderMu sync.Mutex
+	oidcProvider   *oidc.Provider
+
+	oidcLoginsMu      sync.Mutex
+	oidcPendingLogins = map[string]oidcLogin{}
+)
+
+// oidcLogin is a login started at GET /login/oidc, keyed by its state.
+type oidcLogin struct {
+	nonce    string
+	verifier string
+	expires  time.Time
+}
+
+type GetLoginOIDCResponse struct {
+	AuthorizationURL string `json:"authorization_url"`
+}
+
+type PostLoginOIDCCallbackRequest struct {
+	Code  string `json:"code"`
+	State string `json:"state"`
+}
+
+// getOIDCProvider discovers the provider on first use rather than at
+// startup, so the server runs while the provider is unreachable and a failed
+// discovery is retried on the next login.
+func getOIDCProvider(ctx context.Context) (*oidc.Provider, error) {
+	cfg := common.GetConfig()
+	if cfg.OIDC_ISSUER == "" {
+		return nil, errOIDCDisabled
+	}
+
+	oidcProviderMu.Lock()
+	defer oidcProviderMu.Unlock()
+	if oidcProvider != nil {
+		return oidcProvider, nil
+	}
+	p, err := oidc.Discover(ctx, oidc.Config{
+		Issuer:       cfg.OIDC_ISSUER,
+		ClientID:     cfg.OIDC_CLIENT_ID,
+		ClientSecret: cfg.OIDC_CLIENT_SECRET,
+		RedirectURL:  cfg.OIDC_REDIRECT_URL,
+		Scopes:       cfg.OIDC_SCOPES,
+	})
+	if err != nil {
+		return nil, err
+	}
+	oidcProvider = p
+	return p, nil
+}
+
+func addOIDCLogin(state string, login oidcLogin) error {
+	oidcLoginsMu.Lock()
+	defer oidcLoginsMu.Unlock()
+
+	now := time.Now()
+	for s, l := range oidcPendingLogins {
+		if now.After(l.expires) {
+			delete(oidcPendingLogins, s)
+		}
+	}
+	if len(oidcPendingLogins) >= maxPendingOIDCLogins {
+		return errTooManyPending
+	}
+	oidcPendingLogins[state] = login
+	return nil
+}
+
+// takeOIDCLogin removes and returns the pending login for state; each can
+// be completed only once.
+func takeOIDCLogin(state string) (oidcLogin, bool) {
+	oidcLoginsMu.Lock()
+	defer oidcLoginsMu.Unlock()
+
+	login, ok := oidcPendingLogins[state]
+	delete(oidcPendingLogins, state)
+	if !ok || time.Now().After(login.expires) {
+		return oidcLogin{}, false
+	}
+	return login, true
+}
+
+// oidcUsername returns the configured username claim. An email address is
+// only trusted once the provider has verified it.
+func oidcUsername(c *oidc.Claims) string {
+	claim := common.GetConfig().OIDC_USERNAME_CLAIM
+	if claim == "email" && !c.EmailVerified {
+		return ""
+	}
+	return strings.TrimSpace(c.String(claim))
+}
+
+// oidcUser maps an identity to a local user: by the subject an
+// administrator linked it to, or by provisioning a new account if
+// OIDC_AUTO_PROVISION is set. Existing accounts are never linked by
+// username, since the provider may let users pick their own.
+func oidcUser(ctx context.Context, c *oidc.Claims) (*database.User, error) {
+	user, err := database.GetUserByOIDCSubject(c.Subject)
+	if err == nil || !errors.Is(err, database.ErrNotFound) {
+		return user, err
+	}
+
+	cfg := common.GetConfig()
+	if !cfg.OIDC_AUTO_PROVISION {
+		return nil, errNoLocalAccount
+	}
+	username := oidcUsername(c)
+	if username == "" {
+		return nil, errNoOIDCUsername
+	}
+	id, err := database.AddOIDCUser(username, c.Subject, database.Role(cfg.OIDC_DEFAULT_ROLE))
+	if err != nil {
+		return nil, err
+	}
+	slog.InfoContext(ctx, "Provisioned user from single sign-on", slog.String("user", id), slog.String("username", username))
+	return database.GetUser(id)
+}
+
+// auditOIDCFailure records a single sign-on that failed before it reached a
+// local account. These are not login attempts the throttle counts, since
+// the provider checked the credentials.
+func auditOIDCFailure(r *http.Request, username, reason string) {
+	recordAudit(r, database.AuditEntry{Username: username, Action: database.AuditLoginFailure, Detail: "single sign-on: " + reason}, nil, nil)
+}
+
+// HandleGetLoginOIDC starts a single sign-on login and returns the URL to
+// send the browser to.
+func HandleGetLoginOIDC(w http.ResponseWriter, r *http.Request) {
+	p, err := getOIDCProvider(r.Context())
+	if errors.Is(err, errOIDCDisabled) {
+		writeJSONError(w, "Single sign-on is not configured", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "OpenID Connect discovery failed", slog.Any("error", err))
+		writeJSONError(w, "Identity provider unavailable", http.StatusBadGateway)
+		return
+	}
+
+	var login oidcLogin
+	state, err := oidc.RandomString()
+	if err == nil {
+		login.nonce, err = oidc.RandomString()
+	}
+	if err == nil {
+		login.verifier, err = oidc.RandomString()
+	}
+	if err != nil {
+		writeJSONError(w, "Failed to start login", http.StatusInternalServerError)
+		return
+	}
+	login.expires = time.Now().Add(oidcLoginTTL)
+	if err := addOIDCLogin(state, login); err != nil {
+		writeJSONError(w, "Too many pending logins; try again later", http.StatusServiceUnavailable)
+		return
+	}
+	http.SetCookie(w, sessionCookie(oidcStateCookieName, database.HashToken(state), oidcStateCookiePath, int(oidcLoginTTL.Seconds())))
+
+	writeJSON(w, GetLoginOIDCResponse{
+		AuthorizationURL: p.AuthCodeURL(state, login.nonce, login.verifier),
+	}, http.StatusOK)
+}
+
+// HandlePostLoginOIDCCallback finishes a single sign-on login with the code
+// and state the provider redirected back with, and starts the session. The
+// provider is responsible for any second factor, so local two-factor login
+// does not apply here.
+func HandlePostLoginOIDCCallback(w http.ResponseWriter, r *http.Request) {
+	var req PostLoginOIDCCallbackRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSONError(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+	if req.Code == "" || req.State == "" {
+		writeJSONError(w, "Code and state are required", http.StatusBadRequest)
+		return
+	}
+
+	p, err := getOIDCProvider(r.Context())
+	if errors.Is(err, errOIDCDisabled) {
+		writeJSONError(w, "Single sign-on is not configured", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "OpenID Connect discovery failed", slog.Any("error", err))
+		writeJSONError(w, "Identity provider unavailable", http.StatusBadGateway)
+		return
+	}
+
+	c, err := r.Cookie(oidcStateCookieName)
+	if err != nil || subtle.ConstantTimeCompare([]byte(c.Value), []byte(database.HashToken(req.State))) != 1 {
+		slog.WarnContext(r.Context(), "Single sign-on state not started by this browser", slog.String("ip", clientIP(r)))
+		writeJSONError(w, "Login was not started in this browser", http.StatusBadRequest)
+		return
+	}
+	http.SetCookie(w, sessionCookie(oidcStateCookieName, "", oidcStateCookiePath, -1))
+
+	login, ok := takeOIDCLogin(req.State)
+	if !ok {
+		writeJSONError(w, "Invalid or expired login state", http.StatusBadRequest)
+		return
+	}
+	rawIDToken, err := p.Exchange(r.Context(), req.Code, login.verifier)
+	if err != nil {
+		slog.WarnContext(r.Context(), "Single sign-on code exchange failed", slog.String("ip", clientIP(r)), slog.Any("error", err))
+		auditOIDCFailure(r, "", "code exchange failed")
+		writeJSONError(w, "Single sign-on failed", http.StatusUnauthorized)
+		return
+	}
+	claims, err := p.VerifyIDToken(r.Context(), rawIDToken, login.nonce)
+	if err != nil {
+		slog.WarnContext(r.Context(), "Single sign-on ID token rejected", slog.String("ip", clientIP(r)), slog.Any("error", err))
+		auditOIDCFailure(r, "", "ID token rejected")
+		writeJSONError(w, "Single sign-on failed", http.StatusUnauthorized)
+		return
+	}
+
+	user, err := oidcUser(r.Context(), claims)
+	switch {
+	case errors.Is(err, errNoOIDCUsername), errors.Is(err, errNoLocalAccount):
+		slog.WarnContext(r.Context(), "Single sign-on refused", slog.String("subject", claims.Subject), slog.String("reason", err.Error()))
+		auditOIDCFailure(r, oidcUsername(claims), err.Error())
+		writeJSONError(w, "No account for this identity; ask an administrator", http.StatusForbidden)
+		return
+	case errors.Is(err, database.ErrAlreadyLinked):
+		slog.WarnContext(r.Context(), "Single sign-on refused", slog.String("subject", claims.Subject), slog.String("reason", err.Error()))
+		auditOIDCFailure(r, oidcUsername(claims), err.Error())
+		writeJSONError(w, "Account is linked to another identity", http.StatusConflict)
+		return
+	case errors.Is(err, database.ErrUsernameTaken):
+		// an existing account has to be linked by an administrator
+		slog.WarnContext(r.Context(), "Single sign-on refused", slog.String("subject", claims.Subject), slog.String("reason", err.Error()))
+		auditOIDCFailure(r, oidcUsername(claims), err.Error())
+		writeJSONError(w, "An account with this username exists but is not linked to this identity; ask an administrator", http.StatusConflict)
+		return
+	case err != nil:
+		writeJSONError(w, fmt.Sprintf("Failed to look up user: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if user.Disabled {
+		recordLoginAttempt(r, user.Username, user.ID, false, "account disabled")
+		writeJSONError(w, "Account disabled", http.StatusForbidden)
+		return
+	}
+
+	recordLoginAttempt(r, user.Username, user.ID, true, "")
+	startSession(w, r, user.ID)
+}
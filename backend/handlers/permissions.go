@@ -0,0 +1,118 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+
+	"media_management_go/backend/database"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// permission is something a handler needs the caller's role to allow.
+type permission int
+
+const (
+	permRead        permission = iota // list and download library data
+	permWrite                         // create, change and delete library data
+	permManageUsers                   // create users, change roles, reset passwords, read the audit log
+)
+
+// rolePermissions lists what each role may do. A role missing from the map
+// may do nothing.
+var rolePermissions = map[database.Role][]permission{
+	database.RoleAdmin:  {permRead, permWrite, permManageUsers},
+	database.RoleEditor: {permRead, permWrite},
+	database.RoleViewer: {permRead},
+}
+
+// can reports whether role grants perm.
+func can(role database.Role, perm permission) bool {
+	for _, p := range rolePermissions[role] {
+		if p == perm {
+			return true
+		}
+	}
+	return false
+}
+
+// scope names what a route does, for limiting personal access tokens. Each
+// scope needs a role permission, so a token can never do more than its owner.
+type scope string
+
+const (
+	scopeNotesRead        scope = "notes:read"
+	scopeNotesWrite       scope = "notes:write"
+	scopeLinksRead        scope = "links:read"
+	scopeLinksWrite       scope = "links:write"
+	scopeMediaRead        scope = "media:read"
+	scopeMediaUpload      scope = "media:upload"
+	scopeMediaWrite       scope = "media:write"
+	scopeCatalogRead      scope = "catalog:read"
+	scopeCatalogWrite     scope = "catalog:write"
+	scopeProgressRead     scope = "progress:read"
+	scopeProgressWrite    scope = "progress:write"
+	scopeCollectionsRead  scope = "collections:read"
+	scopeCollectionsWrite scope = "collections:write"
+	scopeSharesRead       scope = "shares:read"
+	scopeSharesWrite      scope = "shares:write"
+	scopeUsersAdmin       scope = "users:admin"
+	scopeAuditRead        scope = "audit:read"
+	scopeWebDAVRead       scope = "webdav:read"
+	scopeWebDAVWrite      scope = "webdav:write"
+)
+
+// scopePermissions maps every scope to the permission it needs.
+var scopePermissions = map[scope]permission{
+	scopeNotesRead:        permRead,
+	scopeNotesWrite:       permWrite,
+	scopeLinksRead:        permRead,
+	scopeLinksWrite:       permWrite,
+	scopeMediaRead:        permRead,
+	scopeMediaUpload:      permWrite,
+	scopeMediaWrite:       permWrite,
+	scopeCatalogRead:      permRead,
+	scopeCatalogWrite:     permWrite,
+	scopeProgressRead:     permRead,
+	scopeProgressWrite:    permWrite,
+	scopeCollectionsRead:  permRead,
+	scopeCollectionsWrite: permWrite,
+	scopeSharesRead:       permRead,
+	scopeSharesWrite:      permWrite,
+	scopeUsersAdmin:       permManageUsers,
+	scopeAuditRead:        permManageUsers,
+	scopeWebDAVRead:       permRead,
+	scopeWebDAVWrite:      permWrite,
+}
+
+// requireScope is requireAuth that also checks the caller's current role
+// grants the permission sc needs and, for personal access tokens, that the
+// token was granted sc, writing 403 if not. Roles are read from the database
+// on every request so a change applies immediately to existing sessions.
+func requireScope(w http.ResponseWriter, r *http.Request, sc scope) (*jwt.RegisteredClaims, bool) {
+	claims, apiToken, err := authenticateAny(r)
+	if err != nil {
+		writeJSONError(w, err.Error(), authErrorStatus(err))
+		return nil, false
+	}
+	user, err := database.GetUser(claims.Subject)
+	if err != nil {
+		writeJSONError(w, fmt.Sprintf("Failed to fetch user: %v", err), http.StatusInternalServerError)
+		return nil, false
+	}
+
+	perm := scopePermissions[sc]
+	if !can(user.Role, perm) {
+		if perm == permManageUsers {
+			writeJSONError(w, "Administrator access required", http.StatusForbidden)
+		} else {
+			writeJSONError(w, "Your role does not allow this action", http.StatusForbidden)
+		}
+		return nil, false
+	}
+	if apiToken != nil && !apiToken.HasScope(string(sc)) {
+		writeJSONError(w, fmt.Sprintf("Token is missing the %s scope", sc), http.StatusForbidden)
+		return nil, false
+	}
+	return claims, true
+}
@@ -0,0 +1,258 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"media_management_go/backend/database"
+)
+
+// defaultProgressLimit bounds the "continue watching" and "recently completed" lists.
+const defaultProgressLimit = 20
+
+type PutProgressRequest struct {
+	ItemID     string `json:"item_id"`
+	Status     string `json:"status"`
+	Position   int    `json:"position"`
+	Duration   int    `json:"duration"`
+	Page       int    `json:"page"`
+	StartedAt  string `json:"started_at"`
+	FinishedAt string `json:"finished_at"`
+	Rating     int    `json:"rating"`
+	Review     string `json:"review"`
+}
+
+// PutProgressPositionRequest is the lightweight update sent by media players.
+type PutProgressPositionRequest struct {
+	ItemID   string `json:"item_id"`
+	Position int    `json:"position"`
+	Duration int    `json:"duration"`
+	Page     int    `json:"page"`
+}
+
+type DeleteProgressRequest struct {
+	ItemID string `json:"item_id"`
+}
+
+// maxReviewLength keeps reviews short.
+const maxReviewLength = 2000
+
+func HandleGetProgress(w http.ResponseWriter, r *http.Request) {
+	claims := requestClaims(r)
+
+	if itemID := r.URL.Query().Get("item_id"); itemID != "" {
+		p, err := database.GetProgress(claims.Subject, itemID)
+		if err != nil {
+			if errors.Is(err, database.ErrNotFound) {
+				writeJSONError(w, "Progress not found", http.StatusNotFound)
+				return
+			}
+			writeJSONError(w, fmt.Sprintf("Failed to fetch progress: %v", err), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, p, http.StatusOK)
+		return
+	}
+
+	status := r.URL.Query().Get("status")
+	if status != "" && !database.ValidStatus(status) {
+		writeJSONError(w, "Invalid status", http.StatusBadRequest)
+		return
+	}
+
+	list, err := database.GetProgressList(claims.Subject, status)
+	if err != nil {
+		writeJSONError(w, fmt.Sprintf("Failed to fetch progress: %v", err), http.StatusInternalServerError)
+		return
+	}
+	writeProgressList(w, list)
+}
+
+func HandleGetContinueWatching(w http.ResponseWriter, r *http.Request) {
+	claims := requestClaims(r)
+
+	list, err := database.GetContinueWatching(claims.Subject, queryLimit(r, defaultProgressLimit))
+	if err != nil {
+		writeJSONError(w, fmt.Sprintf("Failed to fetch progress: %v", err), http.StatusInternalServerError)
+		return
+	}
+	writeProgressList(w, list)
+}
+
+func HandleGetRecentlyCompleted(w http.ResponseWriter, r *http.Request) {
+	claims := requestClaims(r)
+
+	list, err := database.GetRecentlyCompleted(claims.Subject, queryLimit(r, defaultProgressLimit))
+	if err != nil {
+		writeJSONError(w, fmt.Sprintf("Failed to fetch progress: %v", err), http.StatusInternalServerError)
+		return
+	}
+	writeProgressList(w, list)
+}
+
+func HandlePutProgress(w http.ResponseWriter, r *http.Request) {
+	claims := requestClaims(r)
+
+	var req PutProgressRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSONError(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+
+	if req.ItemID == "" {
+		writeJSONError(w, "Item ID is required", http.StatusBadRequest)
+		return
+	}
+	if !database.ValidStatus(req.Status) {
+		writeJSONError(w, "Status must be one of planned, in_progress, completed, dropped", http.StatusBadRequest)
+		return
+	}
+	if req.Rating < 0 || req.Rating > 10 {
+		writeJSONError(w, "Rating must be between 1 and 10", http.StatusBadRequest)
+		return
+	}
+	if req.Position < 0 || req.Duration < 0 || req.Page < 0 {
+		writeJSONError(w, "Position, duration and page must not be negative", http.StatusBadRequest)
+		return
+	}
+	if len(req.Review) > maxReviewLength {
+		writeJSONError(w, fmt.Sprintf("Review must be at most %d characters", maxReviewLength), http.StatusBadRequest)
+		return
+	}
+
+	started, err := parseOptionalDate(req.StartedAt)
+	if err != nil {
+		writeJSONError(w, "Invalid started_at date", http.StatusBadRequest)
+		return
+	}
+	finished, err := parseOptionalDate(req.FinishedAt)
+	if err != nil {
+		writeJSONError(w, "Invalid finished_at date", http.StatusBadRequest)
+		return
+	}
+
+	if _, err := database.GetCatalogItem(claims.Subject, req.ItemID); err != nil {
+		if errors.Is(err, database.ErrNotFound) {
+			writeJSONError(w, "Catalog item not found", http.StatusNotFound)
+			return
+		}
+		writeJSONError(w, fmt.Sprintf("Failed to fetch catalog item: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	p, err := database.SetProgress(claims.Subject, database.ProgressUpdate{
+		ItemID:     req.ItemID,
+		Status:     req.Status,
+		Position:   req.Position,
+		Duration:   req.Duration,
+		Page:       req.Page,
+		StartedAt:  started,
+		FinishedAt: finished,
+		Rating:     req.Rating,
+		Review:     req.Review,
+	})
+	if err != nil {
+		writeJSONError(w, fmt.Sprintf("Failed to update progress: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, p, http.StatusOK)
+}
+
+// HandlePutProgressPosition stores a playback position or page. It is called
+// frequently by players, so it answers with an empty 204.
+func HandlePutProgressPosition(w http.ResponseWriter, r *http.Request) {
+	claims := requestClaims(r)
+
+	var req PutProgressPositionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSONError(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+
+	if req.ItemID == "" {
+		writeJSONError(w, "Item ID is required", http.StatusBadRequest)
+		return
+	}
+	if req.Position < 0 || req.Duration < 0 || req.Page < 0 {
+		writeJSONError(w, "Position, duration and page must not be negative", http.StatusBadRequest)
+		return
+	}
+
+	if err := database.UpdateProgressPosition(claims.Subject, req.ItemID, req.Position, req.Duration, req.Page); err != nil {
+		if errors.Is(err, database.ErrNotFound) {
+			writeJSONError(w, "Catalog item not found", http.StatusNotFound)
+			return
+		}
+		writeJSONError(w, fmt.Sprintf("Failed to update progress: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func HandleDeleteProgress(w http.ResponseWriter, r *http.Request) {
+	claims := requestClaims(r)
+
+	var req DeleteProgressRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSONError(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+
+	if req.ItemID == "" {
+		writeJSONError(w, "Item ID is required", http.StatusBadRequest)
+		return
+	}
+
+	if err := database.DeleteProgress(claims.Subject, req.ItemID); err != nil {
+		if errors.Is(err, database.ErrNotFound) {
+			writeJSONError(w, "Progress not found", http.StatusNotFound)
+			return
+		}
+		writeJSONError(w, fmt.Sprintf("Failed to delete progress: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, struct {
+		Message string `json:"message"`
+	}{
+		Message: "Progress deleted successfully",
+	}, http.StatusOK)
+}
+
+func writeProgressList(w http.ResponseWriter, list []database.Progress) {
+	writeJSON(w, struct {
+		Progress []database.Progress `json:"progress"`
+	}{
+		Progress: list,
+	}, http.StatusOK)
+}
+
+// queryLimit reads a positive ?limit= value, falling back to def.
+func queryLimit(r *http.Request, def int) int {
+	n, err := strconv.Atoi(r.URL.Query().Get("limit"))
+	if err != nil || n <= 0 {
+		return def
+	}
+	return n
+}
+
+// parseOptionalDate accepts an RFC 3339 timestamp or a plain YYYY-MM-DD date.
+func parseOptionalDate(s string) (*time.Time, error) {
+	if s == "" {
+		return nil, nil
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return &t, nil
+	}
+	t, err := time.Parse(time.DateOnly, s)
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
@@ -0,0 +1,197 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"media_management_go/backend/database"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// access is who may call a route. The zero value is refused by
+// RegisterRoutes, so a new route cannot forget to say.
+type access struct {
+	kind  accessKind
+	scope scope
+}
+
+type accessKind int
+
+const (
+	accessUnset         accessKind = iota
+	accessPublic                   // anyone, e.g. login and share links
+	accessSignedIn                 // any session or personal access token
+	accessSession                  // a login session, never a personal access token
+	accessScope                    // a session or token allowed scope
+	accessSignedOrScope            // a media signature, checked by the handler, or scope
+)
+
+var (
+	public     = access{kind: accessPublic}
+	signedIn   = access{kind: accessSignedIn}
+	ownSession = access{kind: accessSession}
+)
+
+// needs is access for callers allowed sc; see requireScope.
+func needs(sc scope) access {
+	return access{kind: accessScope, scope: sc}
+}
+
+// signedOr is needs(sc) for routes that also accept a signed URL instead.
+func signedOr(sc scope) access {
+	return access{kind: accessSignedOrScope, scope: sc}
+}
+
+// route is one entry of the API. pattern is a ServeMux pattern, so paths
+// without a trailing slash only match exactly.
+type route struct {
+	pattern string
+	access  access
+	handler http.HandlerFunc
+}
+
+var routes = []route{
+	{"GET /healthz", public, HandleGetHealthz},
+	{"GET /readyz", public, HandleGetReadyz},
+
+	{"GET /login", signedIn, HandleGetLogin},
+	{"POST /login", public, HandlePostLogin},
+	{"POST /login/2fa", public, HandlePostLogin2FA},
+	{"GET /login/oidc", public, HandleGetLoginOIDC},
+	{"POST /login/oidc/callback", public, HandlePostLoginOIDCCallback},
+	{"POST /logout", ownSession, HandlePostLogout},
+	{"POST /token/refresh", public, HandlePostTokenRefresh},
+	{"GET /.well-known/jwks.json", public, HandleGetJWKS},
+
+	{"GET /link", needs(scopeLinksRead), HandleGetLink},
+	{"POST /link", needs(scopeLinksWrite), HandlePostLink},
+	{"DELETE /link", needs(scopeLinksWrite), HandleDeleteLink},
+
+	{"GET /note", needs(scopeNotesRead), HandleGetNote},
+	{"POST /note", needs(scopeNotesWrite), HandlePostNote},
+	{"PUT /note", needs(scopeNotesWrite), HandlePutNote},
+	{"DELETE /note", needs(scopeNotesWrite), HandleDeleteNote},
+
+	{"GET /media", needs(scopeMediaRead), HandleGetMedia},
+	{"POST /media", needs(scopeMediaUpload), HandlePostMedia},
+	{"GET /media/{id}", signedOr(scopeMediaRead), HandleGetMediaFile},
+	{"DELETE /media", needs(scopeMediaWrite), HandleDeleteMedia},
+
+	{"GET /catalog", needs(scopeCatalogRead), HandleGetCatalog},
+	{"POST /catalog", needs(scopeCatalogWrite), HandlePostCatalog},
+	{"PUT /catalog", needs(scopeCatalogWrite), HandlePutCatalog},
+	{"DELETE /catalog", needs(scopeCatalogWrite), HandleDeleteCatalog},
+	{"POST /catalog/attach", needs(scopeCatalogWrite), HandlePostCatalogAttach},
+	{"DELETE /catalog/attach", needs(scopeCatalogWrite), HandleDeleteCatalogAttach},
+	{"POST /catalog/suggest", needs(scopeCatalogRead), HandlePostCatalogSuggest},
+
+	{"GET /progress", needs(scopeProgressRead), HandleGetProgress},
+	{"PUT /progress", needs(scopeProgressWrite), HandlePutProgress},
+	{"DELETE /progress", needs(scopeProgressWrite), HandleDeleteProgress},
+	{"PUT /progress/position", needs(scopeProgressWrite), HandlePutProgressPosition},
+	{"GET /progress/continue", needs(scopeProgressRead), HandleGetContinueWatching},
+	{"GET /progress/completed", needs(scopeProgressRead), HandleGetRecentlyCompleted},
+
+	{"GET /collection", needs(scopeCollectionsRead), HandleGetCollection},
+	{"POST /collection", needs(scopeCollectionsWrite), HandlePostCollection},
+	{"PUT /collection", needs(scopeCollectionsWrite), HandlePutCollection},
+	{"DELETE /collection", needs(scopeCollectionsWrite), HandleDeleteCollection},
+	{"GET /collection/items", needs(scopeCollectionsRead), HandleGetCollectionItems},
+	{"POST /collection/items", needs(scopeCollectionsWrite), HandlePostCollectionItem},
+	{"PUT /collection/items", needs(scopeCollectionsWrite), HandlePutCollectionItem},
+	{"DELETE /collection/items", needs(scopeCollectionsWrite), HandleDeleteCollectionItem},
+	{"GET /collection/m3u8", needs(scopeCollectionsRead), HandleGetCollectionM3U8},
+
+	{"GET /sessions", ownSession, HandleGetSessions},
+	{"DELETE /sessions/{id}", ownSession, HandleDeleteSession},
+	{"POST /sessions/revoke-others", ownSession, HandlePostRevokeOtherSessions},
+
+	{"GET /tokens", ownSession, HandleGetAPITokens},
+	{"POST /tokens", ownSession, HandlePostAPIToken},
+	{"DELETE /tokens/{id}", ownSession, HandleDeleteAPIToken},
+
+	{"POST /2fa/setup", ownSession, HandlePostTwoFactorSetup},
+	{"POST /2fa/enable", ownSession, HandlePostTwoFactorEnable},
+	{"POST /2fa/disable", ownSession, HandlePostTwoFactorDisable},
+
+	{"GET /users", needs(scopeUsersAdmin), HandleGetUsers},
+	{"POST /users", needs(scopeUsersAdmin), HandlePostUser},
+	{"PUT /users", needs(scopeUsersAdmin), HandlePutUser},
+	{"POST /users/password", needs(scopeUsersAdmin), HandlePostUserPassword},
+	{"POST /users/2fa/reset", needs(scopeUsersAdmin), HandlePostUserTwoFactorReset},
+	{"PUT /users/oidc", needs(scopeUsersAdmin), HandlePutUserOIDC},
+
+	{"GET /audit", needs(scopeAuditRead), HandleGetAudit},
+	{"GET /audit/export", needs(scopeAuditRead), HandleGetAuditExport},
+
+	{"GET /shares", needs(scopeSharesRead), HandleGetShares},
+	{"POST /shares", needs(scopeSharesWrite), HandlePostShare},
+	{"DELETE /shares/{id}", needs(scopeSharesWrite), HandleDeleteShare},
+	{"GET /s/{token}", public, HandleGetShared},
+	{"POST /s/{token}", public, HandlePostShared},
+}
+
+// RegisterRoutes adds every API route to mux behind the auth its access
+// requires. It panics on a route without an access rule.
+func RegisterRoutes(mux *http.ServeMux) {
+	for _, rt := range routes {
+		if rt.access.kind == accessUnset {
+			panic(fmt.Sprintf("route %q has no access rule", rt.pattern))
+		}
+		mux.Handle(rt.pattern, rt.access.wrap(rt.handler))
+	}
+}
+
+type authKey struct{}
+
+// authInfo is what the auth middleware learned about the caller.
+type authInfo struct {
+	claims  *jwt.RegisteredClaims
+	session *database.Token
+}
+
+// wrap checks the caller may use a route before calling h, writing the
+// error response otherwise.
+func (a access) wrap(h http.HandlerFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var info authInfo
+		var ok bool
+		switch a.kind {
+		case accessPublic:
+			h(w, r)
+			return
+		case accessSignedIn:
+			info.claims, ok = requireAuth(w, r)
+		case accessSession:
+			info.claims, info.session, ok = requireSession(w, r)
+		case accessSignedOrScope:
+			if r.URL.Query().Has("sig") {
+				h(w, r)
+				return
+			}
+			info.claims, ok = requireScope(w, r, a.scope)
+		case accessScope:
+			info.claims, ok = requireScope(w, r, a.scope)
+		}
+		if !ok {
+			return // the require helpers already wrote the error response
+		}
+		h(w, r.WithContext(context.WithValue(r.Context(), authKey{}, info)))
+	})
+}
+
+// requestClaims returns the claims of the caller the route's access let in,
+// or nil on public routes.
+func requestClaims(r *http.Request) *jwt.RegisteredClaims {
+	info, _ := r.Context().Value(authKey{}).(authInfo)
+	return info.claims
+}
+
+// requestSession returns the caller's login session on ownSession routes,
+// or nil elsewhere.
+func requestSession(r *http.Request) *database.Token {
+	info, _ := r.Context().Value(authKey{}).(authInfo)
+	return info.session
+}
@@ -0,0 +1,195 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"media_management_go/backend/auth"
+	"media_management_go/backend/common"
+	"media_management_go/backend/database"
+	"media_management_go/backend/keyset"
+)
+
+const testPassword = "correct horse"
+
+// TestMain loads a configuration with cookie sessions available and the
+// signing keys, which are global and read once.
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "handlers-media-")
+	if err != nil {
+		panic(err)
+	}
+	for k, v := range map[string]string{
+		"ENV": "test", "ADDR": "127.0.0.1", "PORT": "0", "JWT_KEY": "test-key", "MEDIA_DIR": dir,
+		"SESSION_COOKIES": "true", "METRICS_TOKEN": "test-metrics-token",
+	} {
+		os.Setenv(k, v)
+	}
+	common.MustLoadConfig()
+
+	database.MustOpen(":memory:")
+	keyset.MustLoad(common.GetConfig().JWT_KEY)
+	database.Close()
+
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+// setupRoutes opens a test database and returns a mux serving the route table.
+func setupRoutes(t *testing.T) http.Handler {
+	t.Helper()
+
+	database.MustOpen(":memory:")
+	t.Cleanup(func() {
+		if err := database.Close(); err != nil {
+			t.Fatalf("failed to close test DB: %v", err)
+		}
+	})
+
+	mux := http.NewServeMux()
+	RegisterRoutes(mux)
+	return mux
+}
+
+// addUser creates a user with testPassword and the given role.
+func addUser(t *testing.T, username string, role database.Role) string {
+	t.Helper()
+	hash, err := auth.HashPassword(testPassword)
+	if err != nil {
+		t.Fatalf("HashPassword failed: %v", err)
+	}
+	id, err := database.AddUser(username, hash, role)
+	if err != nil {
+		t.Fatalf("AddUser failed: %v", err)
+	}
+	return id
+}
+
+// serve sends a request through h. headers are name, value pairs.
+func serve(h http.Handler, method, target, body string, headers ...string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	for i := 0; i+1 < len(headers); i += 2 {
+		req.Header.Set(headers[i], headers[i+1])
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+// login signs in with a bearer session and returns its access token.
+func login(t *testing.T, h http.Handler, username string) string {
+	t.Helper()
+	rec := serve(h, "POST", "/login", `{"username":"`+username+`","password":"`+testPassword+`"}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("login as %s: %d %s", username, rec.Code, rec.Body)
+	}
+	var resp PostLoginResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil || resp.Token == "" {
+		t.Fatalf("login response without token: %v", err)
+	}
+	return resp.Token
+}
+
+// routeTarget turns a route pattern into a method and a path that matches it.
+func routeTarget(pattern string) (string, string) {
+	method, path, _ := strings.Cut(pattern, " ")
+	for _, wildcard := range []string{"{id}", "{token}"} {
+		path = strings.ReplaceAll(path, wildcard, "x")
+	}
+	return method, path
+}
+
+// TestRoutesRequireAuth checks every route that is not public turns away
+// callers without credentials.
+func TestRoutesRequireAuth(t *testing.T) {
+	h := setupRoutes(t)
+
+	for _, rt := range routes {
+		if rt.access.kind == accessPublic {
+			continue
+		}
+		method, path := routeTarget(rt.pattern)
+		if rec := serve(h, method, path, `{}`); rec.Code != http.StatusUnauthorized {
+			t.Errorf("%s without credentials: %d, want 401", rt.pattern, rec.Code)
+		}
+	}
+}
+
+// TestViewerCannotWrite checks a viewer's session is refused with 403 on
+// every route needing more than read access, but may read.
+func TestViewerCannotWrite(t *testing.T) {
+	h := setupRoutes(t)
+	addUser(t, "viewer", database.RoleViewer)
+	bearer := "Bearer " + login(t, h, "viewer")
+
+	for _, rt := range routes {
+		if rt.access.kind != accessScope || scopePermissions[rt.access.scope] == permRead {
+			continue
+		}
+		method, path := routeTarget(rt.pattern)
+		if rec := serve(h, method, path, `{}`, "Authorization", bearer); rec.Code != http.StatusForbidden {
+			t.Errorf("%s as viewer: %d, want 403", rt.pattern, rec.Code)
+		}
+	}
+	if rec := serve(h, "GET", "/note", "", "Authorization", bearer); rec.Code != http.StatusOK {
+		t.Errorf("GET /note as viewer: %d, want 200", rec.Code)
+	}
+}
+
+// TestTokenScopes checks a personal access token only reaches the routes of
+// its scopes, and never the session-only ones.
+func TestTokenScopes(t *testing.T) {
+	h := setupRoutes(t)
+	editor := addUser(t, "editor", database.RoleEditor)
+	raw := apiTokenPrefix + "test-token"
+	if _, err := database.AddAPIToken(editor, "script", database.HashToken(raw), []string{string(scopeNotesRead)}, nil); err != nil {
+		t.Fatalf("AddAPIToken failed: %v", err)
+	}
+	bearer := "Bearer " + raw
+
+	if rec := serve(h, "GET", "/note", "", "Authorization", bearer); rec.Code != http.StatusOK {
+		t.Errorf("GET /note with notes:read: %d, want 200", rec.Code)
+	}
+	if rec := serve(h, "POST", "/note", `{"title":"t","note":"n"}`, "Authorization", bearer); rec.Code != http.StatusForbidden {
+		t.Errorf("POST /note without notes:write: %d, want 403", rec.Code)
+	}
+	if rec := serve(h, "GET", "/link", "", "Authorization", bearer); rec.Code != http.StatusForbidden {
+		t.Errorf("GET /link without links:read: %d, want 403", rec.Code)
+	}
+	if rec := serve(h, "POST", "/tokens", `{"name":"more","scopes":["notes:read"]}`, "Authorization", bearer); rec.Code != http.StatusUnauthorized {
+		t.Errorf("POST /tokens with a token: %d, want 401", rec.Code)
+	}
+}
+
+// TestSignedMediaRoute checks the media route rejects a bad signature
+// instead of falling back to no authentication.
+func TestSignedMediaRoute(t *testing.T) {
+	h := setupRoutes(t)
+
+	for _, query := range []string{"?sig=bad&expires=9999999999", "?sig=&expires=1", "?sig=x"} {
+		if rec := serve(h, "GET", "/media/x"+query, ""); rec.Code != http.StatusUnauthorized {
+			t.Errorf("GET /media/x%s: %d, want 401", query, rec.Code)
+		}
+	}
+}
+
+// TestRegisterRoutesRefusesUnsetAccess checks a route cannot be added
+// without saying who may call it.
+func TestRegisterRoutesRefusesUnsetAccess(t *testing.T) {
+	saved := routes
+	t.Cleanup(func() { routes = saved })
+	routes = []route{{"GET /forgotten", access{}, HandleGetNote}}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("RegisterRoutes accepted a route without an access rule")
+		}
+	}()
+	RegisterRoutes(http.NewServeMux())
+}
@@ -0,0 +1,138 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"media_management_go/backend/database"
+	"media_management_go/backend/middleware"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// SessionResponse is a session as listed by GET /sessions.
+type SessionResponse struct {
+	database.Session
+	Current bool `json:"current"`
+}
+
+// clientIP returns the address the request came from, without the port,
+// looking through trusted proxies; see middleware.RealIP.
+func clientIP(r *http.Request) string {
+	return middleware.ClientIP(r)
+}
+
+// lastSeenInterval throttles last-seen updates so authenticated requests do
+// not write to the database every time.
+const lastSeenInterval = 5 * time.Minute
+
+// touchSession records the session as seen from the request's address if it
+// was last recorded more than lastSeenInterval ago.
+func touchSession(r *http.Request, session *database.Token) {
+	seen, err := time.Parse(time.RFC3339Nano, session.UpdatedAt)
+	if err == nil && time.Since(seen) < lastSeenInterval {
+		return
+	}
+	if err := database.TouchSession(session.ID, clientIP(r)); err != nil {
+		slog.WarnContext(r.Context(), "Failed to record session activity", slog.String("session", session.ID), slog.Any("error", err))
+	}
+}
+
+// requireSession is requireAuth for handlers that act on the caller's own
+// session, which also returns that session.
+func requireSession(w http.ResponseWriter, r *http.Request) (*jwt.RegisteredClaims, *database.Token, bool) {
+	claims, session, err := authenticate(r)
+	if err != nil {
+		writeJSONError(w, err.Error(), authErrorStatus(err))
+		return nil, nil, false
+	}
+	return claims, session, true
+}
+
+func HandleGetSessions(w http.ResponseWriter, r *http.Request) {
+	current := requestSession(r)
+
+	sessions, err := database.GetSessions(current.UserID)
+	if err != nil {
+		writeJSONError(w, fmt.Sprintf("Failed to fetch sessions: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	resp := make([]SessionResponse, 0, len(sessions))
+	for _, s := range sessions {
+		resp = append(resp, SessionResponse{Session: s, Current: s.ID == current.ID})
+	}
+
+	writeJSON(w, struct {
+		Sessions []SessionResponse `json:"sessions"`
+	}{
+		Sessions: resp,
+	}, http.StatusOK)
+}
+
+// HandleDeleteSession revokes the session named in the path. Revoking the
+// current session is allowed and works like a logout.
+func HandleDeleteSession(w http.ResponseWriter, r *http.Request) {
+	current := requestSession(r)
+
+	id := r.PathValue("id")
+	if err := database.DeleteSession(current.UserID, id); err != nil {
+		if errors.Is(err, database.ErrNotFound) {
+			writeJSONError(w, "Session not found", http.StatusNotFound)
+			return
+		}
+		writeJSONError(w, fmt.Sprintf("Failed to revoke session: %v", err), http.StatusInternalServerError)
+		return
+	}
+	recordAudit(r, database.AuditEntry{ActorID: current.UserID, Action: database.AuditSessionRevoke, TargetID: id}, nil, nil)
+
+	writeJSON(w, struct {
+		Message string `json:"message"`
+	}{
+		Message: "Session revoked successfully",
+	}, http.StatusOK)
+}
+
+func HandlePostLogout(w http.ResponseWriter, r *http.Request) {
+	current := requestSession(r)
+
+	if err := database.DeleteToken(current.ID); err != nil && !errors.Is(err, database.ErrNotFound) {
+		writeJSONError(w, fmt.Sprintf("Failed to log out: %v", err), http.StatusInternalServerError)
+		return
+	}
+	clearSessionCookies(w)
+	recordAudit(r, database.AuditEntry{ActorID: current.UserID, Action: database.AuditSessionLogout, TargetID: current.ID}, nil, nil)
+
+	writeJSON(w, struct {
+		Message string `json:"message"`
+	}{
+		Message: "Logged out successfully",
+	}, http.StatusOK)
+}
+
+func HandlePostRevokeOtherSessions(w http.ResponseWriter, r *http.Request) {
+	current := requestSession(r)
+
+	n, err := database.DeleteOtherSessions(current.UserID, current.ID)
+	if err != nil {
+		writeJSONError(w, fmt.Sprintf("Failed to revoke sessions: %v", err), http.StatusInternalServerError)
+		return
+	}
+	recordAudit(r, database.AuditEntry{
+		ActorID:  current.UserID,
+		Action:   database.AuditSessionRevokeOther,
+		TargetID: current.ID,
+		Detail:   fmt.Sprintf("%d sessions revoked", n),
+	}, nil, nil)
+
+	writeJSON(w, struct {
+		Message string `json:"message"`
+		Revoked int64  `json:"revoked"`
+	}{
+		Message: "Other sessions revoked successfully",
+		Revoked: n,
+	}, http.StatusOK)
+}
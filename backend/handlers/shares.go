@@ -0,0 +1,364 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"log/slog"
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+
+	"media_management_go/backend/auth"
+	"media_management_go/backend/database"
+)
+
+// A share makes one note or link readable without logging in at /s/{token},
+// as an HTML page or, for ?format=json and clients that accept JSON, as
+// JSON. Password-protected shares take the password by POST, from the
+// page's form or as JSON, and wrong passwords count towards the login
+// throttle. Anything that stops a share from being served (revoked,
+// expired, out of views) looks the same as a token that never existed.
+
+type PostShareRequest struct {
+	NoteID         string `json:"note_id"`
+	LinkID         string `json:"link_id"`
+	Password       string `json:"password"`
+	MaxViews       int    `json:"max_views"`
+	ExpiresInHours int    `json:"expires_in_hours"`
+}
+
+// PostShareResponse is a newly created share. Token and URL are only ever
+// shown here.
+type PostShareResponse struct {
+	database.Share
+	Token string `json:"token"`
+	URL   string `json:"url"`
+}
+
+type PostSharedRequest struct {
+	Password string `json:"password"`
+}
+
+// SharedContent is a shared note or link in JSON form.
+type SharedContent struct {
+	Type      string `json:"type"`
+	Title     string `json:"title,omitempty"`
+	Note      string `json:"note,omitempty"`
+	Link      string `json:"link,omitempty"`
+	UpdatedAt string `json:"updatedAt"`
+}
+
+// sharePage is what the HTML share page shows: a password form, a note or a
+// link.
+type sharePage struct {
+	Title string
+	Error string
+	Form  bool
+	Note  *database.Note
+	Link  string
+}
+
+var shareTemplate = template.Must(template.New("share").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<meta name="robots" content="noindex">
+<title>{{.Title}}</title>
+<style>
+body { font-family: system-ui, sans-serif; max-width: 48rem; margin: 2rem auto; padding: 0 1rem; line-height: 1.5; }
+pre { white-space: pre-wrap; font: inherit; }
+.error { color: #b00020; }
+</style>
+</head>
+<body>
+{{- if .Error}}
+<p class="error">{{.Error}}</p>
+{{- end}}
+{{- if .Form}}
+<form method="post">
+<label>Password <input type="password" name="password" required autofocus></label>
+<button type="submit">View</button>
+</form>
+{{- else if .Note}}
+<h1>{{.Note.Title}}</h1>
+<pre>{{.Note.Note}}</pre>
+{{- else if .Link}}
+<p><a href="{{.Link}}" rel="noopener noreferrer nofollow">{{.Link}}</a></p>
+{{- end}}
+</body>
+</html>
+`))
+
+// newShareToken returns a random share token.
+func newShareToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("generate share token: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// shareThrottleKey is the login throttle's username for a share's password.
+func shareThrottleKey(id string) string {
+	return "share:" + id
+}
+
+func HandleGetShares(w http.ResponseWriter, r *http.Request) {
+	claims := requestClaims(r)
+
+	shares, err := database.GetShares(claims.Subject)
+	if err != nil {
+		writeJSONError(w, fmt.Sprintf("Failed to fetch shares: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, struct {
+		Shares []database.Share `json:"shares"`
+	}{
+		Shares: shares,
+	}, http.StatusOK)
+}
+
+// HandlePostShare shares one of the caller's notes or links. A zero
+// max_views or expires_in_hours means no limit.
+func HandlePostShare(w http.ResponseWriter, r *http.Request) {
+	claims := requestClaims(r)
+
+	var req PostShareRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSONError(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+
+	if (req.NoteID == "") == (req.LinkID == "") {
+		writeJSONError(w, "Exactly one of note_id and link_id is required", http.StatusBadRequest)
+		return
+	}
+	if req.MaxViews < 0 || req.ExpiresInHours < 0 {
+		writeJSONError(w, "Limits must not be negative", http.StatusBadRequest)
+		return
+	}
+
+	var passwordHash string
+	if req.Password != "" {
+		var err error
+		if passwordHash, err = auth.HashPassword(req.Password); err != nil {
+			writeJSONError(w, "Failed to hash password", http.StatusInternalServerError)
+			return
+		}
+	}
+	token, err := newShareToken()
+	if err != nil {
+		writeJSONError(w, "Failed to generate token", http.StatusInternalServerError)
+		return
+	}
+	now := time.Now()
+	var expiresAt *time.Time
+	if req.ExpiresInHours > 0 {
+		exp := now.Add(time.Duration(req.ExpiresInHours) * time.Hour)
+		expiresAt = &exp
+	}
+
+	id, err := database.AddShare(claims.Subject, req.NoteID, req.LinkID, database.HashToken(token), passwordHash, req.MaxViews, expiresAt)
+	if err != nil {
+		if errors.Is(err, database.ErrNotFound) {
+			writeJSONError(w, "Note or link not found", http.StatusNotFound)
+			return
+		}
+		writeJSONError(w, fmt.Sprintf("Failed to create share: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	resp := PostShareResponse{
+		Share: database.Share{
+			ID:          id,
+			NoteID:      req.NoteID,
+			LinkID:      req.LinkID,
+			HasPassword: passwordHash != "",
+			MaxViews:    req.MaxViews,
+			CreatedAt:   now.Format(time.RFC3339Nano),
+		},
+		Token: token,
+		URL:   baseURL(r) + "/s/" + token,
+	}
+	if expiresAt != nil {
+		resp.ExpiresAt = expiresAt.Format(time.RFC3339Nano)
+	}
+	recordAudit(r, database.AuditEntry{ActorID: claims.Subject, Action: database.AuditShareCreate, TargetID: id}, nil, resp.Share)
+	writeJSON(w, resp, http.StatusCreated)
+}
+
+// HandleDeleteShare revokes the share named in the path.
+func HandleDeleteShare(w http.ResponseWriter, r *http.Request) {
+	claims := requestClaims(r)
+
+	id := r.PathValue("id")
+	if err := database.DeleteShare(claims.Subject, id); err != nil {
+		if errors.Is(err, database.ErrNotFound) {
+			writeJSONError(w, "Share not found", http.StatusNotFound)
+			return
+		}
+		writeJSONError(w, fmt.Sprintf("Failed to revoke share: %v", err), http.StatusInternalServerError)
+		return
+	}
+	recordAudit(r, database.AuditEntry{ActorID: claims.Subject, Action: database.AuditShareDelete, TargetID: id}, nil, nil)
+
+	writeJSON(w, struct {
+		Message string `json:"message"`
+	}{
+		Message: "Share revoked successfully",
+	}, http.StatusOK)
+}
+
+// HandleGetShared serves a share. Password-protected shares get the
+// password form, or 401 for JSON.
+func HandleGetShared(w http.ResponseWriter, r *http.Request) {
+	serveShare(w, r, "", false)
+}
+
+// HandlePostShared serves a password-protected share, taking the password
+// from the page's form or a JSON body.
+func HandlePostShared(w http.ResponseWriter, r *http.Request) {
+	var password string
+	if ct, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); ct == "application/json" {
+		var req PostSharedRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeJSONError(w, "Invalid request payload", http.StatusBadRequest)
+			return
+		}
+		password = req.Password
+	} else {
+		password = r.PostFormValue("password")
+	}
+	serveShare(w, r, password, true)
+}
+
+func serveShare(w http.ResponseWriter, r *http.Request, password string, submitted bool) {
+	// the token is in the URL, so keep it out of caches, search engines and
+	// Referer headers
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Referrer-Policy", "no-referrer")
+	w.Header().Set("X-Robots-Tag", "noindex")
+
+	share, err := database.GetShareByToken(database.HashToken(r.PathValue("token")))
+	if err != nil {
+		if errors.Is(err, database.ErrNotFound) {
+			writeShareError(w, r, "Share not found or expired", http.StatusNotFound)
+			return
+		}
+		shareFailed(w, r, err)
+		return
+	}
+
+	if share.HasPassword {
+		if !submitted {
+			if wantsSharedJSON(r) {
+				writeJSONError(w, "Password required", http.StatusUnauthorized)
+				return
+			}
+			writeSharePage(w, r, sharePage{Title: "Password required", Form: true}, http.StatusOK)
+			return
+		}
+		key := shareThrottleKey(share.ID)
+		if !checkLoginThrottle(w, r, key) {
+			return // checkLoginThrottle already wrote error response
+		}
+		ok, err := auth.VerifyPassword(password, share.PasswordHash)
+		valid := err == nil && ok
+		reason := ""
+		if !valid {
+			reason = "wrong share password"
+		}
+		if err := database.AddLoginAttempt(key, clientIP(r), r.UserAgent(), valid, reason); err != nil {
+			slog.ErrorContext(r.Context(), "Failed to record share password attempt", slog.Any("error", err))
+		}
+		if !valid {
+			if wantsSharedJSON(r) {
+				writeJSONError(w, "Invalid password", http.StatusUnauthorized)
+				return
+			}
+			writeSharePage(w, r, sharePage{Title: "Password required", Error: "Wrong password", Form: true}, http.StatusUnauthorized)
+			return
+		}
+	}
+
+	var content SharedContent
+	var page sharePage
+	if share.NoteID != "" {
+		note, err := database.GetNote(share.OwnerID, share.NoteID)
+		if err != nil {
+			shareFailed(w, r, err)
+			return
+		}
+		content = SharedContent{Type: "note", Title: note.Title, Note: note.Note, UpdatedAt: note.UpdatedAt}
+		page = sharePage{Title: note.Title, Note: note}
+	} else {
+		link, err := database.GetLink(share.OwnerID, share.LinkID)
+		if err != nil {
+			shareFailed(w, r, err)
+			return
+		}
+		content = SharedContent{Type: "link", Link: link.Link, UpdatedAt: link.UpdatedAt}
+		page = sharePage{Title: "Shared link", Link: link.Link}
+	}
+
+	// counting the view last means a wrong password or a failed lookup
+	// does not use one up
+	if err := database.UseShare(share.ID); err != nil {
+		if errors.Is(err, database.ErrNotFound) {
+			writeShareError(w, r, "Share not found or expired", http.StatusNotFound)
+			return
+		}
+		shareFailed(w, r, err)
+		return
+	}
+
+	if wantsSharedJSON(r) {
+		writeJSON(w, content, http.StatusOK)
+		return
+	}
+	writeSharePage(w, r, page, http.StatusOK)
+}
+
+// wantsSharedJSON reports whether a share should be served as JSON rather
+// than HTML.
+func wantsSharedJSON(r *http.Request) bool {
+	if f := r.URL.Query().Get("format"); f != "" {
+		return f == "json"
+	}
+	if ct, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); ct == "application/json" {
+		return true
+	}
+	accept := r.Header.Get("Accept")
+	return strings.Contains(accept, "application/json") && !strings.Contains(accept, "text/html")
+}
+
+func writeShareError(w http.ResponseWriter, r *http.Request, msg string, status int) {
+	if wantsSharedJSON(r) {
+		writeJSONError(w, msg, status)
+		return
+	}
+	writeSharePage(w, r, sharePage{Title: http.StatusText(status), Error: msg}, status)
+}
+
+// shareFailed logs err and writes a 500 that, unlike the authenticated
+// endpoints, tells an anonymous visitor nothing about it.
+func shareFailed(w http.ResponseWriter, r *http.Request, err error) {
+	slog.ErrorContext(r.Context(), "Failed to serve share", slog.Any("error", err))
+	writeShareError(w, r, "Failed to load share", http.StatusInternalServerError)
+}
+
+func writeSharePage(w http.ResponseWriter, r *http.Request, page sharePage, status int) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Content-Security-Policy", "default-src 'none'; style-src 'unsafe-inline'; form-action 'self'")
+	w.WriteHeader(status)
+	if err := shareTemplate.Execute(w, page); err != nil {
+		slog.ErrorContext(r.Context(), "Failed to render share page", slog.Any("error", err))
+	}
+}
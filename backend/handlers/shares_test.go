@@ -0,0 +1,142 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"media_management_go/backend/database"
+)
+
+// addShareOf shares a new note of the user signed in with bearer through
+// the API and returns the share.
+func addShareOf(t *testing.T, h http.Handler, bearer, body string) PostShareResponse {
+	t.Helper()
+	noteID := addNote(t, h, "Authorization", bearer)
+	rec := serve(h, "POST", "/shares", `{"note_id":"`+noteID+`"`+body+`}`, "Authorization", bearer)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("POST /shares: %d %s", rec.Code, rec.Body)
+	}
+	var resp PostShareResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil || resp.Token == "" {
+		t.Fatalf("share response without token: %v", err)
+	}
+	return resp
+}
+
+// TestSharePassword checks a protected share asks browsers for the password
+// with a form and JSON clients with a 401, and serves it once given.
+func TestSharePassword(t *testing.T) {
+	h := setupRoutes(t)
+	addUser(t, "editor", database.RoleEditor)
+	share := addShareOf(t, h, "Bearer "+login(t, h, "editor"), `,"password":"open sesame"`)
+	target := "/s/" + share.Token
+
+	rec := serve(h, "GET", target, "", "Content-Type", "", "Accept", "text/html")
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `<form method="post">`) {
+		t.Errorf("GET as a browser: %d, want 200 with the password form:\n%s", rec.Code, rec.Body)
+	}
+	if rec := serve(h, "GET", target+"?format=json", ""); rec.Code != http.StatusUnauthorized {
+		t.Errorf("GET as JSON: %d, want 401", rec.Code)
+	}
+
+	rec = serve(h, "POST", target, "password=nope", "Content-Type", "application/x-www-form-urlencoded")
+	if rec.Code != http.StatusUnauthorized || !strings.Contains(rec.Body.String(), "Wrong password") {
+		t.Errorf("POST wrong password from the form: %d, want 401 with the form again", rec.Code)
+	}
+	rec = serve(h, "POST", target, "password=open+sesame", "Content-Type", "application/x-www-form-urlencoded")
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "<h1>t</h1>") {
+		t.Errorf("POST password from the form: %d, want 200 with the note:\n%s", rec.Code, rec.Body)
+	}
+	var content SharedContent
+	rec = serve(h, "POST", target, `{"password":"open sesame"}`)
+	if err := json.NewDecoder(rec.Body).Decode(&content); rec.Code != http.StatusOK || err != nil || content.Note != "n" {
+		t.Errorf("POST password as JSON: %d %+v, want 200 with the note", rec.Code, content)
+	}
+}
+
+// TestSharePasswordThrottle checks wrong share passwords count towards the
+// login throttle, so guessing is cut off.
+func TestSharePasswordThrottle(t *testing.T) {
+	h := setupRoutes(t)
+	addUser(t, "editor", database.RoleEditor)
+	share := addShareOf(t, h, "Bearer "+login(t, h, "editor"), `,"password":"open sesame"`)
+	target := "/s/" + share.Token
+
+	for i := 0; i < userFailureThreshold; i++ {
+		if rec := serve(h, "POST", target, `{"password":"guess"}`); rec.Code != http.StatusUnauthorized {
+			t.Fatalf("guess %d: %d, want 401", i, rec.Code)
+		}
+	}
+	f, err := database.GetLoginFailuresForUser(shareThrottleKey(share.ID), loginFailureWindow)
+	if err != nil || f.Count != userFailureThreshold {
+		t.Fatalf("share failures = %+v, %v; want %d", f, err, userFailureThreshold)
+	}
+	rec := serve(h, "POST", target, `{"password":"open sesame"}`)
+	if rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") == "" {
+		t.Errorf("password after %d wrong guesses: %d, want 429 with Retry-After", userFailureThreshold, rec.Code)
+	}
+}
+
+// TestShareViewLimit checks only views that serve the share use up its
+// limit.
+func TestShareViewLimit(t *testing.T) {
+	h := setupRoutes(t)
+	addUser(t, "editor", database.RoleEditor)
+	share := addShareOf(t, h, "Bearer "+login(t, h, "editor"), `,"password":"open sesame","max_views":1`)
+	target := "/s/" + share.Token
+
+	if rec := serve(h, "GET", target+"?format=json", ""); rec.Code != http.StatusUnauthorized {
+		t.Errorf("GET without password: %d, want 401", rec.Code)
+	}
+	if rec := serve(h, "POST", target, `{"password":"guess"}`); rec.Code != http.StatusUnauthorized {
+		t.Errorf("POST wrong password: %d, want 401", rec.Code)
+	}
+	if rec := serve(h, "POST", target, `{"password":"open sesame"}`); rec.Code != http.StatusOK {
+		t.Errorf("first view: %d, want 200", rec.Code)
+	}
+	if rec := serve(h, "POST", target, `{"password":"open sesame"}`); rec.Code != http.StatusNotFound {
+		t.Errorf("view past the limit: %d, want 404", rec.Code)
+	}
+}
+
+// TestShareGone checks expired, revoked and unknown shares, and those of a
+// disabled owner, all look the same: 404.
+func TestShareGone(t *testing.T) {
+	h := setupRoutes(t)
+	editor := addUser(t, "editor", database.RoleEditor)
+	bearer := "Bearer " + login(t, h, "editor")
+
+	revoked := addShareOf(t, h, bearer, "")
+	if rec := serve(h, "DELETE", "/shares/"+revoked.ID, "", "Authorization", bearer); rec.Code != http.StatusOK {
+		t.Fatalf("DELETE /shares: %d %s", rec.Code, rec.Body)
+	}
+	past := time.Now().Add(-time.Minute)
+	noteID := addNote(t, h, "Authorization", bearer)
+	if _, err := database.AddShare(editor, noteID, "", database.HashToken("expired"), "", 0, &past); err != nil {
+		t.Fatalf("AddShare failed: %v", err)
+	}
+	owned := addShareOf(t, h, bearer, "")
+	if rec := serve(h, "GET", "/s/"+owned.Token+"?format=json", ""); rec.Code != http.StatusOK {
+		t.Fatalf("GET live share: %d, want 200", rec.Code)
+	}
+	if _, err := database.UpdateUser(editor, database.RoleEditor, true); err != nil {
+		t.Fatalf("UpdateUser failed: %v", err)
+	}
+
+	for name, token := range map[string]string{
+		"revoked":        revoked.Token,
+		"expired":        "expired",
+		"unknown":        "unknown",
+		"disabled owner": owned.Token,
+	} {
+		if rec := serve(h, "GET", "/s/"+token+"?format=json", ""); rec.Code != http.StatusNotFound {
+			t.Errorf("%s share: %d, want 404", name, rec.Code)
+		}
+		if rec := serve(h, "GET", "/s/"+token, "", "Content-Type", ""); rec.Code != http.StatusNotFound {
+			t.Errorf("%s share page: %d, want 404", name, rec.Code)
+		}
+	}
+}
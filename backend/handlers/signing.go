@@ -0,0 +1,61 @@
+package handlers
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"media_management_go/backend/common"
+	"media_management_go/backend/middleware"
+)
+
+// mediaURLTTL is how long a signed media URL stays valid. Players such as
+// VLC cannot send an Authorization header, so exported playlists carry a
+// signature in the query string instead.
+const mediaURLTTL = 24 * time.Hour
+
+func mediaSignature(id string, expires int64) string {
+	mac := hmac.New(sha256.New, []byte(common.GetConfig().JWT_KEY))
+	fmt.Fprintf(mac, "media:%s:%d", id, expires)
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// SignedMediaURL returns an absolute URL for the media file that works
+// without a bearer token until it expires.
+func SignedMediaURL(r *http.Request, id string) string {
+	expires := time.Now().Add(mediaURLTTL).Unix()
+	q := url.Values{}
+	q.Set("expires", strconv.FormatInt(expires, 10))
+	q.Set("sig", mediaSignature(id, expires))
+	return baseURL(r) + "/media/" + url.PathEscape(id) + "?" + q.Encode()
+}
+
+// validMediaSignature reports whether the request carries a valid, unexpired
+// signature for the media file id.
+func validMediaSignature(r *http.Request, id string) bool {
+	q := r.URL.Query()
+	expires, err := strconv.ParseInt(q.Get("expires"), 10, 64)
+	if err != nil || time.Now().Unix() > expires {
+		return false
+	}
+	return hmac.Equal([]byte(q.Get("sig")), []byte(mediaSignature(id, expires)))
+}
+
+// baseURL is the externally visible scheme and host of the server:
+// PUBLIC_URL, or else derived from the request. X-Forwarded-Proto is only
+// believed from a trusted proxy.
+func baseURL(r *http.Request) string {
+	if u := common.GetConfig().PUBLIC_URL; u != "" {
+		return u
+	}
+	scheme := "http"
+	if r.TLS != nil || (middleware.ViaTrustedProxy(r) && r.Header.Get("X-Forwarded-Proto") == "https") {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host
+}
@@ -0,0 +1,37 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+
+	"media_management_go/backend/middleware"
+)
+
+// TestBaseURL checks X-Forwarded-Proto is only believed from a trusted
+// proxy.
+func TestBaseURL(t *testing.T) {
+	realIP := middleware.RealIP([]netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")})
+	for _, tc := range []struct {
+		remote, proto, want string
+	}{
+		{"203.0.113.5:4000", "", "http://media.test"},
+		{"203.0.113.5:4000", "https", "http://media.test"},
+		{"10.0.0.2:4000", "https", "https://media.test"},
+		{"10.0.0.2:4000", "", "http://media.test"},
+	} {
+		req := httptest.NewRequest("GET", "http://media.test/m3u8", nil)
+		req.RemoteAddr = tc.remote
+		if tc.proto != "" {
+			req.Header.Set("X-Forwarded-Proto", tc.proto)
+		}
+		var got string
+		realIP(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			got = baseURL(r)
+		})).ServeHTTP(httptest.NewRecorder(), req)
+		if got != tc.want {
+			t.Errorf("from %s with X-Forwarded-Proto %q: %q, want %q", tc.remote, tc.proto, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"media_management_go/backend/common"
+	"media_management_go/backend/database"
+	"media_management_go/backend/keyset"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+// Access tokens are short-lived JWTs; refresh tokens are opaque, single-use
+// and exchanged at POST /token/refresh for a new pair. Each exchange extends
+// the session by refreshTokenTTL.
+const (
+	accessTokenTTL  = 15 * time.Minute
+	refreshTokenTTL = 168 * time.Hour
+)
+
+type PostTokenRefreshRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+// signAccessToken issues an access JWT whose subject is the user ID.
+func signAccessToken(subject string) (string, error) {
+	now := time.Now()
+	claims := jwt.RegisteredClaims{
+		ExpiresAt: jwt.NewNumericDate(now.Add(accessTokenTTL)),
+		IssuedAt:  jwt.NewNumericDate(now),
+		Subject:   subject,
+		ID:        uuid.New().String(),
+	}
+	return keyset.Get().Sign(claims)
+}
+
+// newRefreshToken returns a random opaque refresh token.
+func newRefreshToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("generate refresh token: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// HandlePostTokenRefresh exchanges a refresh token for a new pair. In cookie
+// mode the refresh token comes from its cookie and the new pair is set as
+// cookies again. That needs no CSRF token: a forged refresh only rotates the
+// victim's tokens, and its response cannot be read cross-origin.
+func HandlePostTokenRefresh(w http.ResponseWriter, r *http.Request) {
+	var req PostTokenRefreshRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		writeJSONError(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+	fromCookie := false
+	if req.RefreshToken == "" && common.GetConfig().SESSION_COOKIES {
+		if c, err := r.Cookie(refreshCookieName); err == nil {
+			req.RefreshToken = c.Value
+			fromCookie = true
+		}
+	}
+	if req.RefreshToken == "" {
+		writeJSONError(w, "Refresh token is required", http.StatusBadRequest)
+		return
+	}
+
+	userID, err := database.GetRefreshTokenUser(database.HashToken(req.RefreshToken))
+	if err != nil {
+		if errors.Is(err, database.ErrNotFound) {
+			writeJSONError(w, "Invalid or expired refresh token", http.StatusUnauthorized)
+			return
+		}
+		writeJSONError(w, fmt.Sprintf("Failed to refresh token: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if err := checkUserActive(userID); err != nil {
+		writeJSONError(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	signed, err := signAccessToken(userID)
+	if err != nil {
+		writeJSONError(w, "Failed to generate token", http.StatusInternalServerError)
+		return
+	}
+	refresh, err := newRefreshToken()
+	if err != nil {
+		writeJSONError(w, "Failed to generate token", http.StatusInternalServerError)
+		return
+	}
+
+	sessionID, err := database.RotateRefreshToken(
+		database.HashToken(req.RefreshToken),
+		database.HashToken(refresh),
+		database.HashToken(signed),
+		time.Now().Add(refreshTokenTTL),
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, database.ErrRefreshTokenReused):
+			slog.WarnContext(r.Context(), "Refresh token reused; session revoked", slog.String("ip", clientIP(r)))
+			recordAudit(r, database.AuditEntry{ActorID: userID, Action: database.AuditSessionRevoke, TargetID: sessionID, Detail: "refresh token reused"}, nil, nil)
+			writeJSONError(w, "Refresh token already used; session revoked", http.StatusUnauthorized)
+		case errors.Is(err, database.ErrNotFound):
+			writeJSONError(w, "Invalid or expired refresh token", http.StatusUnauthorized)
+		default:
+			writeJSONError(w, fmt.Sprintf("Failed to refresh token: %v", err), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	if fromCookie {
+		writeCookieSession(w, sessionID, signed, refresh)
+		return
+	}
+	writeJSON(w, PostLoginResponse{
+		Token:        signed,
+		RefreshToken: refresh,
+		ExpiresIn:    int(accessTokenTTL.Seconds()),
+	}, http.StatusOK)
+}
+
+// HandleGetJWKS publishes the public keys that verify access tokens, so
+// other services can check them without sharing a secret. HS256 keys are
+// never listed.
+func HandleGetJWKS(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	writeJSON(w, struct {
+		Keys []keyset.JWK `json:"keys"`
+	}{
+		Keys: keyset.Get().JWKS(),
+	}, http.StatusOK)
+}
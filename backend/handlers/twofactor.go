@@ -0,0 +1,303 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"media_management_go/backend/auth"
+	"media_management_go/backend/database"
+	"media_management_go/backend/keyset"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+// A password login for a user with two-factor login enabled yields a
+// challenge token instead of a session; it is exchanged together with a TOTP
+// or recovery code at POST /login/2fa within twoFactorChallengeTTL.
+const (
+	twoFactorChallengeTTL = 5 * time.Minute
+	twoFactorAudience     = "login-2fa"
+	totpIssuer            = "Media Management"
+)
+
+type PostLoginChallengeResponse struct {
+	TwoFactorRequired bool   `json:"two_factor_required"`
+	ChallengeToken    string `json:"challenge_token"`
+	ExpiresIn         int    `json:"expires_in"`
+}
+
+type PostLogin2FARequest struct {
+	ChallengeToken string `json:"challenge_token"`
+	Code           string `json:"code"`
+}
+
+type PostTwoFactorEnableRequest struct {
+	Code string `json:"code"`
+}
+
+type PostTwoFactorDisableRequest struct {
+	Password string `json:"password"`
+	Code     string `json:"code"`
+}
+
+type PostUserTwoFactorResetRequest struct {
+	ID string `json:"id"`
+}
+
+// signChallengeToken issues a challenge JWT for the second login step. It is
+// never stored as a session, so it cannot be used as an access token.
+func signChallengeToken(userID string) (string, error) {
+	now := time.Now()
+	claims := jwt.RegisteredClaims{
+		ExpiresAt: jwt.NewNumericDate(now.Add(twoFactorChallengeTTL)),
+		IssuedAt:  jwt.NewNumericDate(now),
+		Subject:   userID,
+		Audience:  jwt.ClaimStrings{twoFactorAudience},
+		ID:        uuid.New().String(),
+	}
+	return keyset.Get().Sign(claims)
+}
+
+// parseChallengeToken validates a challenge token and returns the user ID it was issued to.
+func parseChallengeToken(tokenStr string) (string, error) {
+	claims := &jwt.RegisteredClaims{}
+	_, err := jwt.ParseWithClaims(tokenStr, claims, keyset.Get().Keyfunc, jwt.WithAudience(twoFactorAudience), jwt.WithExpirationRequired())
+	if err != nil {
+		return "", err
+	}
+	return claims.Subject, nil
+}
+
+// checkSecondFactor accepts either a current TOTP code that has not been used
+// yet or one of the user's unused recovery codes, which it consumes.
+func checkSecondFactor(ctx context.Context, user *database.User, code string) (bool, error) {
+	if step, ok := auth.MatchTOTP(user.TOTPSecret, code, time.Now()); ok {
+		return database.UseTOTPStep(user.ID, step)
+	}
+	ok, err := database.UseRecoveryCode(user.ID, database.HashToken(auth.NormalizeRecoveryCode(code)))
+	if ok {
+		slog.InfoContext(ctx, "Recovery code used", slog.String("user", user.ID))
+	}
+	return ok, err
+}
+
+// HandlePostLogin2FA completes a two-factor login and starts the session.
+func HandlePostLogin2FA(w http.ResponseWriter, r *http.Request) {
+	var req PostLogin2FARequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSONError(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+	if req.ChallengeToken == "" || req.Code == "" {
+		writeJSONError(w, "Challenge token and code are required", http.StatusBadRequest)
+		return
+	}
+
+	userID, err := parseChallengeToken(req.ChallengeToken)
+	if err != nil {
+		writeJSONError(w, "Invalid or expired challenge token", http.StatusUnauthorized)
+		return
+	}
+	user, err := database.GetUser(userID)
+	if err != nil || user.Disabled || !user.TwoFactor {
+		writeJSONError(w, "Invalid or expired challenge token", http.StatusUnauthorized)
+		return
+	}
+
+	if !checkLoginThrottle(w, r, user.Username) {
+		return // checkLoginThrottle already wrote error response
+	}
+
+	ok, err := checkSecondFactor(r.Context(), user, req.Code)
+	if err != nil {
+		writeJSONError(w, fmt.Sprintf("Failed to check code: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		recordLoginAttempt(r, user.Username, user.ID, false, "invalid two-factor code")
+		writeJSONError(w, "Invalid code", http.StatusUnauthorized)
+		return
+	}
+
+	recordLoginAttempt(r, user.Username, user.ID, true, "")
+	startSession(w, r, user.ID)
+}
+
+// HandlePostTwoFactorSetup generates a new TOTP secret for the caller and
+// returns it with an otpauth:// URI for a QR code. Two-factor login only
+// starts once the secret is confirmed at POST /2fa/enable.
+func HandlePostTwoFactorSetup(w http.ResponseWriter, r *http.Request) {
+	current := requestSession(r)
+
+	user, err := database.GetUser(current.UserID)
+	if err != nil {
+		writeJSONError(w, fmt.Sprintf("Failed to fetch user: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if user.TwoFactor {
+		writeJSONError(w, "Two-factor login is already enabled", http.StatusConflict)
+		return
+	}
+
+	secret, err := auth.NewTOTPSecret()
+	if err != nil {
+		writeJSONError(w, "Failed to generate secret", http.StatusInternalServerError)
+		return
+	}
+	if err := database.SetTOTPSecret(user.ID, secret); err != nil {
+		writeJSONError(w, fmt.Sprintf("Failed to store secret: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, struct {
+		Secret string `json:"secret"`
+		URI    string `json:"uri"`
+	}{
+		Secret: secret,
+		URI:    auth.TOTPURI(totpIssuer, user.Username, secret),
+	}, http.StatusOK)
+}
+
+// HandlePostTwoFactorEnable confirms the pending secret with a code from the
+// authenticator app, turns on two-factor login and returns the recovery
+// codes. They are only ever shown here.
+func HandlePostTwoFactorEnable(w http.ResponseWriter, r *http.Request) {
+	current := requestSession(r)
+
+	var req PostTwoFactorEnableRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSONError(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+
+	user, err := database.GetUser(current.UserID)
+	if err != nil {
+		writeJSONError(w, fmt.Sprintf("Failed to fetch user: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if user.TwoFactor {
+		writeJSONError(w, "Two-factor login is already enabled", http.StatusConflict)
+		return
+	}
+	if user.TOTPSecret == "" {
+		writeJSONError(w, "Start two-factor setup first", http.StatusBadRequest)
+		return
+	}
+	step, ok := auth.MatchTOTP(user.TOTPSecret, req.Code, time.Now())
+	if !ok {
+		writeJSONError(w, "Invalid code", http.StatusBadRequest)
+		return
+	}
+
+	codes, err := auth.NewRecoveryCodes()
+	if err != nil {
+		writeJSONError(w, "Failed to generate recovery codes", http.StatusInternalServerError)
+		return
+	}
+	hashes := make([]string, len(codes))
+	for i, c := range codes {
+		hashes[i] = database.HashToken(auth.NormalizeRecoveryCode(c))
+	}
+	if err := database.EnableTOTP(user.ID, step, hashes); err != nil {
+		writeJSONError(w, fmt.Sprintf("Failed to enable two-factor login: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, struct {
+		Message       string   `json:"message"`
+		RecoveryCodes []string `json:"recovery_codes"`
+	}{
+		Message:       "Two-factor login enabled",
+		RecoveryCodes: codes,
+	}, http.StatusOK)
+}
+
+// HandlePostTwoFactorDisable turns off the caller's two-factor login. Both
+// the password and a current or recovery code are required.
+func HandlePostTwoFactorDisable(w http.ResponseWriter, r *http.Request) {
+	current := requestSession(r)
+
+	var req PostTwoFactorDisableRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSONError(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+
+	user, err := database.GetUser(current.UserID)
+	if err != nil {
+		writeJSONError(w, fmt.Sprintf("Failed to fetch user: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if !user.TwoFactor {
+		writeJSONError(w, "Two-factor login is not enabled", http.StatusBadRequest)
+		return
+	}
+	if ok, err := auth.VerifyPassword(req.Password, user.PasswordHash); err != nil || !ok {
+		writeJSONError(w, "Invalid password", http.StatusUnauthorized)
+		return
+	}
+	ok, err := checkSecondFactor(r.Context(), user, req.Code)
+	if err != nil {
+		writeJSONError(w, fmt.Sprintf("Failed to check code: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		writeJSONError(w, "Invalid code", http.StatusUnauthorized)
+		return
+	}
+
+	if err := database.DisableTOTP(user.ID); err != nil {
+		writeJSONError(w, fmt.Sprintf("Failed to disable two-factor login: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, struct {
+		Message string `json:"message"`
+	}{
+		Message: "Two-factor login disabled",
+	}, http.StatusOK)
+}
+
+// HandlePostUserTwoFactorReset lets an administrator turn off two-factor
+// login for a user who lost their authenticator and recovery codes.
+func HandlePostUserTwoFactorReset(w http.ResponseWriter, r *http.Request) {
+	claims := requestClaims(r)
+
+	var req PostUserTwoFactorResetRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSONError(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+	if req.ID == "" {
+		writeJSONError(w, "ID is required", http.StatusBadRequest)
+		return
+	}
+
+	before, ok := targetUser(w, req.ID)
+	if !ok {
+		return
+	}
+	if err := database.DisableTOTP(req.ID); err != nil {
+		if errors.Is(err, database.ErrNotFound) {
+			writeJSONError(w, "User not found", http.StatusNotFound)
+			return
+		}
+		writeJSONError(w, fmt.Sprintf("Failed to reset two-factor login: %v", err), http.StatusInternalServerError)
+		return
+	}
+	slog.InfoContext(r.Context(), "Two-factor login reset by administrator", slog.String("user", req.ID), slog.String("admin", claims.Subject))
+	after, _ := database.GetUser(req.ID)
+	recordAudit(r, database.AuditEntry{ActorID: claims.Subject, Action: database.AuditUserTwoFactorReset, TargetID: req.ID}, before, after)
+
+	writeJSON(w, struct {
+		Message string `json:"message"`
+	}{
+		Message: "Two-factor login reset successfully",
+	}, http.StatusOK)
+}
@@ -0,0 +1,326 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"media_management_go/backend/auth"
+	"media_management_go/backend/common"
+	"media_management_go/backend/database"
+)
+
+// minPasswordLength is enforced for new and reset passwords.
+const minPasswordLength = 8
+
+type PostUserRequest struct {
+	Username string        `json:"username"`
+	Password string        `json:"password"`
+	Role     database.Role `json:"role"`
+}
+
+type PutUserRequest struct {
+	ID       string        `json:"id"`
+	Role     database.Role `json:"role"`
+	Disabled bool          `json:"disabled"`
+}
+
+type PostUserPasswordRequest struct {
+	ID       string `json:"id"`
+	Password string `json:"password"`
+}
+
+type PutUserOIDCRequest struct {
+	ID      string `json:"id"`
+	Subject string `json:"subject"`
+}
+
+// dummyHash is verified against when a username does not exist, so unknown
+// and known usernames take about as long to reject.
+var dummyHash, _ = auth.HashPassword("not a real password")
+
+// checkPassword looks up a user and verifies their password. Users without
+// a password, provisioned by single sign-on, never match.
+func checkPassword(ctx context.Context, username, password string) (*database.User, bool) {
+	user, err := database.GetUserByUsername(username)
+	if err != nil {
+		if !errors.Is(err, database.ErrNotFound) {
+			slog.ErrorContext(ctx, "Failed to look up user", slog.Any("error", err))
+		}
+		_, _ = auth.VerifyPassword(password, dummyHash)
+		return nil, false
+	}
+	if user.PasswordHash == "" {
+		_, _ = auth.VerifyPassword(password, dummyHash)
+		return user, false
+	}
+
+	ok, err := auth.VerifyPassword(password, user.PasswordHash)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to verify password", slog.String("user", user.ID), slog.Any("error", err))
+		return nil, false
+	}
+	return user, ok
+}
+
+// checkUserActive returns an error if the user no longer exists or is disabled.
+func checkUserActive(userID string) error {
+	user, err := database.GetUser(userID)
+	if err != nil {
+		return fmt.Errorf("user not found")
+	}
+	if user.Disabled {
+		return fmt.Errorf("account disabled")
+	}
+	return nil
+}
+
+// BootstrapAdmin creates the first administrator when there are no users
+// yet, with ADMIN_USERNAME as the username and USER_KEY as the password, and
+// gives them any library records that predate user accounts. Once any user
+// exists it does nothing.
+func BootstrapAdmin() error {
+	n, err := database.CountUsers()
+	if err != nil {
+		return err
+	}
+	if n > 0 {
+		return nil
+	}
+
+	cfg := common.GetConfig()
+	if cfg.USER_KEY == "" {
+		slog.Warn("No users exist and USER_KEY is not set; nobody can log in")
+		return nil
+	}
+
+	hash, err := auth.HashPassword(cfg.USER_KEY)
+	if err != nil {
+		return err
+	}
+	id, err := database.AddUser(cfg.ADMIN_USERNAME, hash, database.RoleAdmin)
+	if err != nil {
+		return err
+	}
+	slog.Info("Created initial administrator from USER_KEY", slog.String("username", cfg.ADMIN_USERNAME))
+
+	// notes, links and media from the single-user era belong to the first admin
+	claimed, err := database.ClaimUnownedRecords(id)
+	if err != nil {
+		return err
+	}
+	if claimed > 0 {
+		slog.Info("Assigned existing library records to administrator", slog.Int64("records", claimed))
+	}
+	return nil
+}
+
+func HandleGetUsers(w http.ResponseWriter, r *http.Request) {
+	users, err := database.GetUsers()
+	if err != nil {
+		writeJSONError(w, fmt.Sprintf("Failed to fetch users: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, struct {
+		Users []database.User `json:"users"`
+	}{
+		Users: users,
+	}, http.StatusOK)
+}
+
+func HandlePostUser(w http.ResponseWriter, r *http.Request) {
+	var req PostUserRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSONError(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+
+	req.Username = strings.TrimSpace(req.Username)
+	if req.Username == "" {
+		writeJSONError(w, "Username is required", http.StatusBadRequest)
+		return
+	}
+	if len(req.Password) < minPasswordLength {
+		writeJSONError(w, fmt.Sprintf("Password must be at least %d characters", minPasswordLength), http.StatusBadRequest)
+		return
+	}
+	if req.Role == "" {
+		req.Role = database.RoleEditor
+	}
+	if !req.Role.Valid() {
+		writeJSONError(w, "Role must be admin, editor or viewer", http.StatusBadRequest)
+		return
+	}
+
+	hash, err := auth.HashPassword(req.Password)
+	if err != nil {
+		writeJSONError(w, fmt.Sprintf("Failed to hash password: %v", err), http.StatusInternalServerError)
+		return
+	}
+	id, err := database.AddUser(req.Username, hash, req.Role)
+	if err != nil {
+		if errors.Is(err, database.ErrUsernameTaken) {
+			writeJSONError(w, "Username already taken", http.StatusConflict)
+			return
+		}
+		writeJSONError(w, fmt.Sprintf("Failed to create user: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	user, err := database.GetUser(id)
+	if err != nil {
+		writeJSONError(w, fmt.Sprintf("Failed to fetch user: %v", err), http.StatusInternalServerError)
+		return
+	}
+	recordAudit(r, database.AuditEntry{ActorID: requestClaims(r).Subject, Action: database.AuditUserCreate, TargetID: id}, nil, user)
+	writeJSON(w, user, http.StatusCreated)
+}
+
+// HandlePutUser changes a user's role and disables or re-enables them.
+// Administrators cannot demote or disable themselves, so at least one
+// working administrator always remains.
+func HandlePutUser(w http.ResponseWriter, r *http.Request) {
+	claims := requestClaims(r)
+
+	var req PutUserRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSONError(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+	if req.ID == "" {
+		writeJSONError(w, "ID is required", http.StatusBadRequest)
+		return
+	}
+	if !req.Role.Valid() {
+		writeJSONError(w, "Role must be admin, editor or viewer", http.StatusBadRequest)
+		return
+	}
+	if req.ID == claims.Subject && (req.Role != database.RoleAdmin || req.Disabled) {
+		writeJSONError(w, "You cannot demote or disable yourself", http.StatusBadRequest)
+		return
+	}
+
+	before, ok := targetUser(w, req.ID)
+	if !ok {
+		return
+	}
+	user, err := database.UpdateUser(req.ID, req.Role, req.Disabled)
+	if err != nil {
+		if errors.Is(err, database.ErrNotFound) {
+			writeJSONError(w, "User not found", http.StatusNotFound)
+			return
+		}
+		writeJSONError(w, fmt.Sprintf("Failed to update user: %v", err), http.StatusInternalServerError)
+		return
+	}
+	entry := database.AuditEntry{ActorID: claims.Subject, Action: database.AuditUserUpdate, TargetID: user.ID}
+	if user.Disabled {
+		entry.Detail = "sessions revoked"
+	}
+	recordAudit(r, entry, before, user)
+	writeJSON(w, user, http.StatusOK)
+}
+
+// HandlePostUserPassword resets a user's password, ends their sessions and
+// revokes their personal access tokens.
+func HandlePostUserPassword(w http.ResponseWriter, r *http.Request) {
+	var req PostUserPasswordRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSONError(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+	if req.ID == "" {
+		writeJSONError(w, "ID is required", http.StatusBadRequest)
+		return
+	}
+	if len(req.Password) < minPasswordLength {
+		writeJSONError(w, fmt.Sprintf("Password must be at least %d characters", minPasswordLength), http.StatusBadRequest)
+		return
+	}
+
+	before, ok := targetUser(w, req.ID)
+	if !ok {
+		return
+	}
+	hash, err := auth.HashPassword(req.Password)
+	if err != nil {
+		writeJSONError(w, fmt.Sprintf("Failed to hash password: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if err := database.SetUserPassword(req.ID, hash); err != nil {
+		if errors.Is(err, database.ErrNotFound) {
+			writeJSONError(w, "User not found", http.StatusNotFound)
+			return
+		}
+		writeJSONError(w, fmt.Sprintf("Failed to reset password: %v", err), http.StatusInternalServerError)
+		return
+	}
+	after, _ := database.GetUser(req.ID)
+	recordAudit(r, database.AuditEntry{ActorID: requestClaims(r).Subject, Action: database.AuditUserPasswordReset, TargetID: req.ID,
+		Detail: "sessions and personal access tokens revoked"}, before, after)
+
+	writeJSON(w, struct {
+		Message string `json:"message"`
+	}{
+		Message: "Password reset successfully",
+	}, http.StatusOK)
+}
+
+// HandlePutUserOIDC links a user to the single sign-on identity with the
+// given subject, as shown by the provider, or unlinks them when it is empty.
+// Only linked identities can sign in to an existing account.
+func HandlePutUserOIDC(w http.ResponseWriter, r *http.Request) {
+	var req PutUserOIDCRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSONError(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+	if req.ID == "" {
+		writeJSONError(w, "ID is required", http.StatusBadRequest)
+		return
+	}
+
+	before, ok := targetUser(w, req.ID)
+	if !ok {
+		return
+	}
+	if err := database.SetOIDCSubject(req.ID, strings.TrimSpace(req.Subject)); err != nil {
+		switch {
+		case errors.Is(err, database.ErrNotFound):
+			writeJSONError(w, "User not found", http.StatusNotFound)
+		case errors.Is(err, database.ErrAlreadyLinked):
+			writeJSONError(w, "Identity is linked to another user", http.StatusConflict)
+		default:
+			writeJSONError(w, fmt.Sprintf("Failed to link user: %v", err), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	user, err := database.GetUser(req.ID)
+	if err != nil {
+		writeJSONError(w, fmt.Sprintf("Failed to fetch user: %v", err), http.StatusInternalServerError)
+		return
+	}
+	recordAudit(r, database.AuditEntry{ActorID: requestClaims(r).Subject, Action: database.AuditUserLink, TargetID: user.ID}, before, user)
+	writeJSON(w, user, http.StatusOK)
+}
+
+// targetUser fetches the user an administrator action is about, for the
+// audit log's before snapshot, writing 404 if there is none.
+func targetUser(w http.ResponseWriter, id string) (*database.User, bool) {
+	user, err := database.GetUser(id)
+	if err != nil {
+		if errors.Is(err, database.ErrNotFound) {
+			writeJSONError(w, "User not found", http.StatusNotFound)
+			return nil, false
+		}
+		writeJSONError(w, fmt.Sprintf("Failed to fetch user: %v", err), http.StatusInternalServerError)
+		return nil, false
+	}
+	return user, true
+}
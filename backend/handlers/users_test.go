@@ -0,0 +1,31 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"testing"
+
+	"media_management_go/backend/database"
+)
+
+// TestCheckPasswordWithoutHash checks users provisioned by single sign-on
+// cannot log in with a password, quietly.
+func TestCheckPasswordWithoutHash(t *testing.T) {
+	setupRoutes(t)
+	if _, err := database.AddOIDCUser("sso", "subject", database.RoleEditor); err != nil {
+		t.Fatalf("AddOIDCUser failed: %v", err)
+	}
+	var logs bytes.Buffer
+	defer slog.SetDefault(slog.Default())
+	slog.SetDefault(slog.New(slog.NewTextHandler(&logs, nil)))
+
+	for _, password := range []string{"", testPassword} {
+		if user, ok := checkPassword(context.Background(), "sso", password); ok || user == nil {
+			t.Errorf("checkPassword(%q) = %v, %v; want the user and false", password, user, ok)
+		}
+	}
+	if logs.Len() != 0 {
+		t.Errorf("logged for a user without a password:\n%s", &logs)
+	}
+}
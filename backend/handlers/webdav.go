@@ -0,0 +1,140 @@
+package handlers
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+
+	"media_management_go/backend/common"
+	"media_management_go/backend/database"
+	"media_management_go/backend/dav"
+
+	"github.com/golang-jwt/jwt/v5"
+	"golang.org/x/net/webdav"
+)
+
+// WebDAVMethods lists the methods the WebDAV mount has to be registered for.
+var WebDAVMethods = []string{
+	"OPTIONS", "GET", "HEAD", "PUT", "DELETE", "PROPFIND", "PROPPATCH",
+	"MKCOL", "COPY", "MOVE", "LOCK", "UNLOCK",
+}
+
+// webDAVReadMethods are the WebDAV methods that never change the library;
+// the rest need permWrite.
+var webDAVReadMethods = map[string]bool{"OPTIONS": true, "GET": true, "HEAD": true, "PROPFIND": true}
+
+// NewWebDAVHandler serves each user's library over WebDAV under prefix (e.g.
+// "/dav"). Clients authenticate with a session or personal access token,
+// either as a bearer token or as the Basic password, whose username is
+// ignored. Personal access tokens act as app passwords and need the
+// webdav:read scope, or webdav:write for methods that change the library;
+// users whose role is read-only get 403 for those methods either way.
+func NewWebDAVHandler(prefix string) http.Handler {
+	logger := func(r *http.Request, err error) {
+		if err != nil {
+			slog.DebugContext(r.Context(), "WebDAV request failed", slog.String("method", r.Method), slog.String("path", r.URL.Path), slog.Any("error", err))
+		}
+	}
+
+	// locks are per user since every user sees their own tree
+	var mu sync.Mutex
+	locks := map[string]webdav.LockSystem{}
+	lockSystem := func(userID string) webdav.LockSystem {
+		mu.Lock()
+		defer mu.Unlock()
+		ls, ok := locks[userID]
+		if !ok {
+			ls = webdav.NewMemLS()
+			locks[userID] = ls
+		}
+		return ls
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Basic credentials can be guessed like a login password, so they
+		// are throttled and audited like one
+		_, _, basic := r.BasicAuth()
+		key := webDAVThrottleKey(r)
+		if basic && !checkLoginThrottle(w, r, key) {
+			return // checkLoginThrottle already wrote error response
+		}
+		user, apiToken, ok := webDAVUser(r)
+		if !ok {
+			if basic {
+				recordLoginAttempt(r, key, "", false, "webdav: invalid credentials")
+			}
+			w.Header().Set("WWW-Authenticate", `Basic realm="media", charset="UTF-8"`)
+			writeJSONError(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if basic && apiToken != nil && !recentlyUsed(apiToken) {
+			// clients send credentials with every request; a token coming
+			// back into use is what counts as a login
+			recordLoginAttempt(r, user.Username, user.ID, true, "webdav")
+		}
+		sc := scopeWebDAVRead
+		if !webDAVReadMethods[r.Method] {
+			sc = scopeWebDAVWrite
+		}
+		if !can(user.Role, scopePermissions[sc]) {
+			writeJSONError(w, "Your role does not allow this action", http.StatusForbidden)
+			return
+		}
+		if apiToken != nil && !apiToken.HasScope(string(sc)) {
+			writeJSONError(w, fmt.Sprintf("Token is missing the %s scope", sc), http.StatusForbidden)
+			return
+		}
+		fsys := dav.FS{
+			OwnerID: user.ID,
+			Audit: func(action, targetID string, before, after any) {
+				recordAudit(r, database.AuditEntry{ActorID: user.ID, Action: action, TargetID: targetID, Detail: "webdav"}, before, after)
+			},
+		}
+		if r.Method == http.MethodPut {
+			r.Body = http.MaxBytesReader(w, r.Body, common.GetConfig().MAX_UPLOAD_SIZE)
+			fsys.BodyErr = dav.WatchBody(r)
+		}
+		h := &webdav.Handler{
+			Prefix:     prefix,
+			FileSystem: fsys,
+			LockSystem: lockSystem(user.ID),
+			Logger:     logger,
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// webDAVThrottleKey is the login throttle's username for WebDAV Basic
+// credentials. The Basic username is ignored, so failures are counted per
+// client address instead: a made-up username must neither dodge the
+// throttle nor lock a real user out of logging in.
+func webDAVThrottleKey(r *http.Request) string {
+	return "webdav:" + clientIP(r)
+}
+
+// webDAVUser returns the user a WebDAV request authenticates as, and the
+// personal access token it used, if any.
+func webDAVUser(r *http.Request) (*database.User, *database.APIToken, bool) {
+	var claims *jwt.RegisteredClaims
+	var apiToken *database.APIToken
+	var err error
+	if _, password, ok := r.BasicAuth(); ok {
+		if strings.HasPrefix(password, apiTokenPrefix) {
+			claims, apiToken, err = authenticateAPIToken(r, password)
+		} else {
+			claims, _, err = validateTokenString(password)
+		}
+	} else {
+		claims, apiToken, err = authenticateAny(r)
+	}
+	if err != nil {
+		return nil, nil, false
+	}
+	user, err := database.GetUser(claims.Subject)
+	if err != nil || user.Disabled {
+		return nil, nil, false
+	}
+	return user, apiToken, true
+}
@@ -0,0 +1,117 @@
+// Package jobs runs periodic background work such as the session sweeper.
+package jobs
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	jobRuns = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "job_runs_total",
+		Help: "Background job runs by outcome.",
+	}, []string{"job", "result"})
+	jobDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "job_duration_seconds",
+		Help:    "Time background jobs take to run.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"job"})
+	jobLastSuccess = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "job_last_success_timestamp_seconds",
+		Help: "Unix time each background job last finished without error.",
+	}, []string{"job"})
+
+	mu     sync.Mutex
+	status = map[string]*jobStatus{}
+)
+
+// jobStatus is what is known about a running job.
+type jobStatus struct {
+	interval time.Duration
+	lastRun  time.Time
+	stopped  bool
+}
+
+// Every runs fn once right away and then every interval until ctx is
+// cancelled. Errors are logged and do not stop the job.
+func Every(ctx context.Context, name string, interval time.Duration, fn func(ctx context.Context) error) {
+	st := &jobStatus{interval: interval}
+	mu.Lock()
+	status[name] = st
+	mu.Unlock()
+	defer func() {
+		mu.Lock()
+		st.stopped = true
+		mu.Unlock()
+	}()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		run(ctx, name, fn)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func run(ctx context.Context, name string, fn func(ctx context.Context) error) {
+	start := time.Now()
+	err := fn(ctx)
+	took := time.Since(start)
+	jobDuration.WithLabelValues(name).Observe(took.Seconds())
+
+	mu.Lock()
+	if st, ok := status[name]; ok {
+		st.lastRun = time.Now()
+	}
+	mu.Unlock()
+
+	if err != nil {
+		jobRuns.WithLabelValues(name, "error").Inc()
+		slog.Error("Job failed", slog.String("job", name), slog.Any("error", err))
+		return
+	}
+	jobRuns.WithLabelValues(name, "success").Inc()
+	jobLastSuccess.WithLabelValues(name).SetToCurrentTime()
+	slog.Debug("Job finished", slog.String("job", name), slog.Duration("took", took))
+}
+
+// Check returns an error naming the jobs that have stopped or have not
+// finished a run, with or without error, within twice their interval, as
+// happens until their first run is done or when one hangs.
+func Check() error {
+	mu.Lock()
+	defer mu.Unlock()
+	var stale []string
+	for _, name := range slices.Sorted(maps.Keys(status)) {
+		st := status[name]
+		if st.stopped {
+			stale = append(stale, name+" (stopped)")
+			continue
+		}
+		if st.lastRun.IsZero() {
+			stale = append(stale, name+" (first run not finished)")
+			continue
+		}
+		if since := time.Since(st.lastRun); since > 2*st.interval {
+			stale = append(stale, fmt.Sprintf("%s (last run %s ago)", name, since.Round(time.Second)))
+		}
+	}
+	if len(stale) > 0 {
+		return fmt.Errorf("jobs not running: %s", strings.Join(stale, ", "))
+	}
+	return nil
+}
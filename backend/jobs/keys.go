@@ -0,0 +1,23 @@
+package jobs
+
+import (
+	"context"
+	"log/slog"
+
+	"media_management_go/backend/database"
+	"media_management_go/backend/keyset"
+)
+
+// ReloadSigningKeys is a job that removes signing keys past their grace
+// period and reloads the keyset, so rotations made with the keys command
+// take effect without a restart.
+func ReloadSigningKeys(ctx context.Context) error {
+	n, err := database.DeleteExpiredSigningKeys()
+	if err != nil {
+		return err
+	}
+	if n > 0 {
+		slog.Info("Removed expired signing keys", slog.Int64("count", n))
+	}
+	return keyset.Get().Reload()
+}
@@ -0,0 +1,38 @@
+package jobs
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"media_management_go/backend/database"
+)
+
+// SessionSweepInterval is how often expired and idle sessions are removed.
+const SessionSweepInterval = time.Hour
+
+// LoginAttemptRetention is how long the login audit trail is kept.
+const LoginAttemptRetention = 90 * 24 * time.Hour
+
+// SweepSessions returns a job that deletes expired sessions, sessions idle
+// for longer than idleTimeout (zero disables the idle check), expired
+// refresh tokens and login attempts older than LoginAttemptRetention.
+func SweepSessions(idleTimeout time.Duration) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		n, err := database.DeleteExpiredSessions(idleTimeout)
+		if err != nil {
+			return err
+		}
+		if n > 0 {
+			slog.Info("Removed expired sessions", slog.Int64("count", n))
+		}
+
+		if _, err := database.DeleteExpiredRefreshTokens(); err != nil {
+			return err
+		}
+		if _, err := database.DeleteOldLoginAttempts(LoginAttemptRetention); err != nil {
+			return err
+		}
+		return nil
+	}
+}
@@ -0,0 +1,296 @@
+// Package keyset holds the keys that sign and verify session JWTs. Tokens
+// name their key in the kid header, so several keys can verify at once while
+// one signs; rotating adds a new signing key and lets the old one expire
+// after a grace period instead of logging everyone out.
+package keyset
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"sync"
+	"time"
+
+	"media_management_go/backend/database"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+// Supported signing algorithms. HS256 keys are shared secrets and never
+// published; RS256 and EdDSA public keys are served as a JWKS.
+const (
+	AlgHS256 = "HS256"
+	AlgRS256 = "RS256"
+	AlgEdDSA = "EdDSA"
+)
+
+const (
+	// ReloadInterval is how often a running server picks up rotations made
+	// by the keys command.
+	ReloadInterval = time.Minute
+	// minReloadInterval limits the reloads tokens with an unknown kid can
+	// trigger.
+	minReloadInterval = 10 * time.Second
+
+	rsaKeyBits = 2048
+)
+
+type key struct {
+	id     string
+	method jwt.SigningMethod
+	sign   any // nil for keys that only verify
+	verify any
+	public any // published in the JWKS; nil for shared secrets
+}
+
+// Set is the loaded keyset.
+type Set struct {
+	legacySecret []byte
+
+	mu         sync.RWMutex
+	signing    *key
+	keys       map[string]*key
+	lastReload time.Time
+}
+
+var (
+	current *Set
+	once    sync.Once
+)
+
+// MustLoad loads the keyset from the database. legacySecret is JWT_KEY,
+// which signs tokens without a kid until the first rotation.
+func MustLoad(legacySecret string) {
+	s := New(legacySecret)
+	if err := s.Reload(); err != nil {
+		log.Fatal("failed to load signing keys: ", err)
+	}
+	once.Do(func() {
+		current = s
+	})
+}
+
+// Get returns the keyset loaded by MustLoad.
+func Get() *Set {
+	if current == nil {
+		panic("Signing keys not loaded. Call MustLoad() first.")
+	}
+	return current
+}
+
+// New returns an empty keyset that signs with legacySecret; call Reload to
+// load the keys from the database.
+func New(legacySecret string) *Set {
+	s := &Set{legacySecret: []byte(legacySecret)}
+	s.keys = map[string]*key{database.LegacyKeyID: s.legacyKey()}
+	return s
+}
+
+func (s *Set) legacyKey() *key {
+	return &key{id: database.LegacyKeyID, method: jwt.SigningMethodHS256, sign: s.legacySecret, verify: s.legacySecret}
+}
+
+// Reload replaces the keys with the unexpired ones in the database. With no
+// keys stored yet, tokens are signed with the legacy secret as before.
+func (s *Set) Reload() error {
+	stored, err := database.GetSigningKeys()
+	if err != nil {
+		return err
+	}
+
+	keys := make(map[string]*key, len(stored))
+	var signing *key
+	for _, sk := range stored {
+		k, err := s.parse(sk)
+		if err != nil {
+			return fmt.Errorf("signing key %q: %w", sk.ID, err)
+		}
+		keys[k.id] = k
+		if sk.Signing {
+			signing = k
+		}
+	}
+	if len(stored) == 0 {
+		keys[database.LegacyKeyID] = s.legacyKey()
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.keys = keys
+	s.signing = signing
+	s.lastReload = time.Now()
+	return nil
+}
+
+func (s *Set) parse(sk database.SigningKey) (*key, error) {
+	if sk.ID == database.LegacyKeyID {
+		return s.legacyKey(), nil
+	}
+
+	k := &key{id: sk.ID}
+	switch sk.Algorithm {
+	case AlgHS256:
+		if len(sk.PrivateKey) == 0 {
+			return nil, errors.New("empty secret")
+		}
+		k.method, k.verify = jwt.SigningMethodHS256, sk.PrivateKey
+		if sk.Signing {
+			k.sign = sk.PrivateKey
+		}
+		return k, nil
+	case AlgRS256:
+		k.method = jwt.SigningMethodRS256
+	case AlgEdDSA:
+		k.method = jwt.SigningMethodEdDSA
+	default:
+		return nil, fmt.Errorf("unsupported algorithm %q", sk.Algorithm)
+	}
+
+	pub, err := x509.ParsePKIXPublicKey(sk.PublicKey)
+	if err != nil {
+		return nil, fmt.Errorf("parse public key: %w", err)
+	}
+	k.verify, k.public = pub, pub
+	if sk.Signing {
+		priv, err := x509.ParsePKCS8PrivateKey(sk.PrivateKey)
+		if err != nil {
+			return nil, fmt.Errorf("parse private key: %w", err)
+		}
+		k.sign = priv
+	}
+	return k, nil
+}
+
+// Sign signs claims with the current signing key and names it in the kid
+// header, or with the legacy secret and no kid before the first rotation.
+func (s *Set) Sign(claims jwt.Claims) (string, error) {
+	s.mu.RLock()
+	k := s.signing
+	s.mu.RUnlock()
+
+	if k == nil {
+		return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(s.legacySecret)
+	}
+	t := jwt.NewWithClaims(k.method, claims)
+	t.Header["kid"] = k.id
+	return t.SignedString(k.sign)
+}
+
+// Keyfunc is a jwt.Keyfunc returning the key named by the token's kid. The
+// token's algorithm must be the key's, so a public key can never be used as
+// an HMAC secret.
+func (s *Set) Keyfunc(t *jwt.Token) (any, error) {
+	kid, _ := t.Header["kid"].(string)
+	k, ok := s.lookup(kid)
+	if !ok {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+	if t.Method.Alg() != k.method.Alg() {
+		return nil, fmt.Errorf("unexpected signing method: %v", t.Method.Alg())
+	}
+	return k.verify, nil
+}
+
+// lookup finds a key, reloading the keyset first if kid is unknown, since
+// another instance may have rotated already.
+func (s *Set) lookup(kid string) (*key, bool) {
+	s.mu.RLock()
+	k, ok := s.keys[kid]
+	stale := time.Since(s.lastReload) >= minReloadInterval
+	s.mu.RUnlock()
+	if ok || !stale {
+		return k, ok
+	}
+
+	if err := s.Reload(); err != nil {
+		return nil, false
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	k, ok = s.keys[kid]
+	return k, ok
+}
+
+// JWK is a public key in JSON Web Key form.
+type JWK struct {
+	Kid string `json:"kid"`
+	Kty string `json:"kty"`
+	Alg string `json:"alg"`
+	Use string `json:"use"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+}
+
+// JWKS returns the public keys that currently verify tokens. Shared
+// secrets are left out.
+func (s *Set) JWKS() []JWK {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	jwks := []JWK{}
+	for _, k := range s.keys {
+		switch pub := k.public.(type) {
+		case *rsa.PublicKey:
+			jwks = append(jwks, JWK{
+				Kid: k.id, Kty: "RSA", Alg: AlgRS256, Use: "sig",
+				N: b64(pub.N.Bytes()), E: b64(big.NewInt(int64(pub.E)).Bytes()),
+			})
+		case ed25519.PublicKey:
+			jwks = append(jwks, JWK{Kid: k.id, Kty: "OKP", Alg: AlgEdDSA, Use: "sig", Crv: "Ed25519", X: b64(pub)})
+		}
+	}
+	return jwks
+}
+
+func b64(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// Generate creates a new key for alg with a random kid, ready for
+// database.RotateSigningKey.
+func Generate(alg string) (database.SigningKey, error) {
+	sk := database.SigningKey{ID: uuid.New().String(), Algorithm: alg}
+
+	var priv, pub any
+	switch alg {
+	case AlgHS256:
+		secret := make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			return database.SigningKey{}, fmt.Errorf("generate secret: %w", err)
+		}
+		sk.PrivateKey = secret
+		return sk, nil
+	case AlgRS256:
+		k, err := rsa.GenerateKey(rand.Reader, rsaKeyBits)
+		if err != nil {
+			return database.SigningKey{}, fmt.Errorf("generate rsa key: %w", err)
+		}
+		priv, pub = k, &k.PublicKey
+	case AlgEdDSA:
+		p, k, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return database.SigningKey{}, fmt.Errorf("generate ed25519 key: %w", err)
+		}
+		priv, pub = k, p
+	default:
+		return database.SigningKey{}, fmt.Errorf("unsupported algorithm %q: expected %s, %s or %s", alg, AlgHS256, AlgRS256, AlgEdDSA)
+	}
+
+	var err error
+	if sk.PrivateKey, err = x509.MarshalPKCS8PrivateKey(priv); err != nil {
+		return database.SigningKey{}, fmt.Errorf("encode private key: %w", err)
+	}
+	if sk.PublicKey, err = x509.MarshalPKIXPublicKey(pub); err != nil {
+		return database.SigningKey{}, fmt.Errorf("encode public key: %w", err)
+	}
+	return sk, nil
+}
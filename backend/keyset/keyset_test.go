@@ -0,0 +1,140 @@
+package keyset
+
+import (
+	"testing"
+	"time"
+
+	"media_management_go/backend/database"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func setupTestDB(t *testing.T) {
+	t.Helper()
+	database.MustOpen(":memory:")
+	t.Cleanup(func() {
+		if err := database.Close(); err != nil {
+			t.Fatalf("failed to close test DB: %v", err)
+		}
+	})
+}
+
+func sign(t *testing.T, s *Set, subject string) string {
+	t.Helper()
+	token, err := s.Sign(jwt.RegisteredClaims{Subject: subject, ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Minute))})
+	if err != nil {
+		t.Fatalf("Sign failed: %v", err)
+	}
+	return token
+}
+
+func verify(s *Set, token string) error {
+	_, err := jwt.ParseWithClaims(token, &jwt.RegisteredClaims{}, s.Keyfunc)
+	return err
+}
+
+func rotate(t *testing.T, s *Set, alg string, grace time.Duration) {
+	t.Helper()
+	k, err := Generate(alg)
+	if err != nil {
+		t.Fatalf("Generate(%s) failed: %v", alg, err)
+	}
+	if err := database.RotateSigningKey(k, grace); err != nil {
+		t.Fatalf("RotateSigningKey failed: %v", err)
+	}
+	if err := s.Reload(); err != nil {
+		t.Fatalf("Reload failed: %v", err)
+	}
+}
+
+// TestRotation keeps tokens from earlier keys valid through the grace period
+// and publishes only asymmetric keys.
+func TestRotation(t *testing.T) {
+	setupTestDB(t)
+	s := New("legacy-secret")
+	if err := s.Reload(); err != nil {
+		t.Fatalf("Reload failed: %v", err)
+	}
+
+	legacy := sign(t, s, "a")
+	if tok, _, _ := jwt.NewParser().ParseUnverified(legacy, &jwt.RegisteredClaims{}); tok.Header["kid"] != nil {
+		t.Fatalf("token signed before the first rotation has a kid: %v", tok.Header)
+	}
+	if err := verify(s, legacy); err != nil {
+		t.Fatalf("legacy token rejected: %v", err)
+	}
+
+	rotate(t, s, AlgEdDSA, time.Hour)
+	ed := sign(t, s, "b")
+	rotate(t, s, AlgRS256, time.Hour)
+	rs := sign(t, s, "c")
+	rotate(t, s, AlgHS256, time.Hour)
+	hs := sign(t, s, "d")
+
+	for name, token := range map[string]string{"legacy": legacy, "EdDSA": ed, "RS256": rs, "HS256": hs} {
+		if err := verify(s, token); err != nil {
+			t.Errorf("%s token rejected after rotation: %v", name, err)
+		}
+	}
+	jwks := s.JWKS()
+	if len(jwks) != 2 {
+		t.Fatalf("JWKS has %d keys, want the EdDSA and RS256 ones: %+v", len(jwks), jwks)
+	}
+	for _, k := range jwks {
+		if k.Kty != "OKP" && k.Kty != "RSA" {
+			t.Errorf("unexpected key in JWKS: %+v", k)
+		}
+	}
+
+	// a zero grace period retires the previous key at once
+	rotate(t, s, AlgEdDSA, 0)
+	if err := verify(s, hs); err == nil {
+		t.Error("token from a retired key accepted after its grace period")
+	}
+	if err := verify(s, sign(t, s, "e")); err != nil {
+		t.Errorf("token from the new key rejected: %v", err)
+	}
+	if n, err := database.DeleteExpiredSigningKeys(); err != nil || n != 1 {
+		t.Errorf("DeleteExpiredSigningKeys = %d, %v; want 1", n, err)
+	}
+}
+
+// TestKeyfuncRejectsAlgorithmSwitch refuses an HS256 token that names an
+// asymmetric key, which would otherwise let the public key act as a secret.
+func TestKeyfuncRejectsAlgorithmSwitch(t *testing.T) {
+	setupTestDB(t)
+	s := New("legacy-secret")
+	rotate(t, s, AlgRS256, time.Hour)
+
+	jwks := s.JWKS()
+	forged := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.RegisteredClaims{Subject: "x"})
+	forged.Header["kid"] = jwks[0].Kid
+	token, err := forged.SignedString([]byte(jwks[0].N))
+	if err != nil {
+		t.Fatalf("sign forged token: %v", err)
+	}
+	if err := verify(s, token); err == nil {
+		t.Fatal("HS256 token accepted for an RS256 key")
+	}
+}
+
+// TestReloadOnUnknownKey picks up a rotation made elsewhere when a token
+// names a key this instance has not loaded yet.
+func TestReloadOnUnknownKey(t *testing.T) {
+	setupTestDB(t)
+	a := New("legacy-secret")
+	b := New("legacy-secret")
+	if err := a.Reload(); err != nil {
+		t.Fatalf("Reload failed: %v", err)
+	}
+
+	rotate(t, b, AlgEdDSA, time.Hour)
+	token := sign(t, b, "x")
+	if err := verify(a, token); err == nil {
+		t.Fatal("unknown key accepted without a reload")
+	}
+	a.lastReload = time.Now().Add(-minReloadInterval)
+	if err := verify(a, token); err != nil {
+		t.Fatalf("token rejected after reload: %v", err)
+	}
+}
@@ -0,0 +1,49 @@
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// TestHandler checks registered metrics are served in the text format.
+func TestHandler(t *testing.T) {
+	c := promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "test_events_total",
+		Help: "Events.",
+	}, []string{"kind"})
+	c.WithLabelValues("a").Inc()
+
+	rec := httptest.NewRecorder()
+	Handler("").ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d, want 200", rec.Code)
+	}
+	if want := `test_events_total{kind="a"} 1`; !strings.Contains(rec.Body.String(), want) {
+		t.Errorf("output is missing %q:\n%s", want, rec.Body)
+	}
+}
+
+// TestHandlerToken checks the token is required when set.
+func TestHandlerToken(t *testing.T) {
+	h := Handler("secret")
+	for auth, want := range map[string]int{
+		"":              http.StatusUnauthorized,
+		"Bearer wrong":  http.StatusUnauthorized,
+		"Bearer secret": http.StatusOK,
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+		if auth != "" {
+			req.Header.Set("Authorization", auth)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != want {
+			t.Errorf("Authorization %q: status %d, want %d", auth, rec.Code, want)
+		}
+	}
+}
@@ -0,0 +1,148 @@
+package middleware
+
+import (
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CORSPolicy says which cross-origin browser requests are allowed.
+type CORSPolicy struct {
+	// AllowedOrigins are exact origins, https://*.example.com patterns
+	// matching any subdomain, or * for every origin.
+	AllowedOrigins   []string
+	AllowedMethods   []string
+	AllowedHeaders   []string
+	AllowCredentials bool
+	// MaxAge is how long a preflight response may be cached; zero leaves
+	// it to the browser.
+	MaxAge time.Duration
+}
+
+// allowsOrigin reports whether origin matches one of the allowed origins.
+func (p CORSPolicy) allowsOrigin(origin string) bool {
+	origin = strings.ToLower(origin)
+	for _, allowed := range p.AllowedOrigins {
+		allowed = strings.ToLower(allowed)
+		if allowed == "*" || allowed == origin {
+			return true
+		}
+		scheme, suffix, ok := strings.Cut(allowed, "://*.")
+		if !ok {
+			continue
+		}
+		sub, ok := strings.CutPrefix(origin, scheme+"://")
+		if !ok {
+			continue
+		}
+		sub, ok = strings.CutSuffix(sub, "."+suffix)
+		if ok && validSubdomain(sub) {
+			return true
+		}
+	}
+	return false
+}
+
+// validSubdomain reports whether s is one or more host labels, so that a
+// wildcard cannot be satisfied by smuggling in a port, path or userinfo.
+func validSubdomain(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, label := range strings.Split(s, ".") {
+		if label == "" {
+			return false
+		}
+		for _, c := range label {
+			if !(c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '-') {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// CORS applies p to requests carrying an Origin header. Preflight requests
+// are answered here for paths that routes serves, listing only the allowed
+// methods that path accepts; preflights for other paths fall through to
+// routes, which rejects them like any unknown route.
+func CORS(p CORSPolicy, routes *http.ServeMux) Middleware {
+	headers := strings.Join(p.AllowedHeaders, ", ")
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			origin := r.Header.Get("Origin")
+			preflight := r.Method == http.MethodOptions && origin != "" &&
+				r.Header.Get("Access-Control-Request-Method") != ""
+
+			// the response depends on these, so caches must key on them
+			w.Header().Add("Vary", "Origin")
+			if preflight {
+				w.Header().Add("Vary", "Access-Control-Request-Method")
+				w.Header().Add("Vary", "Access-Control-Request-Headers")
+			}
+			if origin == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			if !preflight {
+				if p.allowsOrigin(origin) {
+					w.Header().Set("Access-Control-Allow-Origin", origin)
+					// lets the frontend quote the ID when reporting an error
+					w.Header().Set("Access-Control-Expose-Headers", "X-Request-ID")
+					if p.AllowCredentials {
+						w.Header().Set("Access-Control-Allow-Credentials", "true")
+					}
+				}
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			allowed := routeMethods(routes, r, p.AllowedMethods)
+			if len(allowed) == 0 {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if !p.allowsOrigin(origin) {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+			methods := strings.Join(allowed, ", ")
+			w.Header().Set("Access-Control-Allow-Methods", methods)
+			if headers != "" {
+				w.Header().Set("Access-Control-Allow-Headers", headers)
+			}
+			if p.AllowCredentials {
+				w.Header().Set("Access-Control-Allow-Credentials", "true")
+			}
+			if p.MaxAge > 0 {
+				w.Header().Set("Access-Control-Max-Age", strconv.Itoa(int(p.MaxAge.Seconds())))
+			}
+			w.Header().Set("Allow", methods)
+			w.WriteHeader(http.StatusNoContent)
+		})
+	}
+}
+
+// routeMethods returns the methods out of candidates that mux has a route
+// for at r's path.
+func routeMethods(mux *http.ServeMux, r *http.Request, candidates []string) []string {
+	var methods []string
+	for _, m := range candidates {
+		if m == http.MethodOptions {
+			continue
+		}
+		probe := r.Clone(r.Context())
+		probe.Method = m
+		if _, pattern := mux.Handler(probe); pattern != "" {
+			methods = append(methods, m)
+		}
+	}
+	if len(methods) > 0 && slices.Contains(candidates, http.MethodOptions) {
+		methods = append(methods, http.MethodOptions)
+	}
+	return methods
+}
@@ -0,0 +1,134 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func testPolicy() CORSPolicy {
+	return CORSPolicy{
+		AllowedOrigins:   []string{"http://localhost:3000", "https://*.example.com"},
+		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
+		AllowedHeaders:   []string{"Content-Type", "Authorization"},
+		AllowCredentials: true,
+		MaxAge:           10 * time.Minute,
+	}
+}
+
+// TestCORSOrigins checks exact and wildcard subdomain origin matching.
+func TestCORSOrigins(t *testing.T) {
+	p := testPolicy()
+	for origin, want := range map[string]bool{
+		"http://localhost:3000":           true,
+		"HTTP://LOCALHOST:3000":           true,
+		"http://localhost:3001":           false,
+		"https://app.example.com":         true,
+		"https://a.b.example.com":         true,
+		"https://example.com":             false,
+		"http://app.example.com":          false,
+		"https://app.example.com:8443":    false,
+		"https://evil.com?.example.com":   false,
+		"https://app.example.com.evil.io": false,
+		"https://evilexample.com":         false,
+	} {
+		if got := p.allowsOrigin(origin); got != want {
+			t.Errorf("allowsOrigin(%q) = %v, want %v", origin, got, want)
+		}
+	}
+
+	if !(CORSPolicy{AllowedOrigins: []string{"*"}}).allowsOrigin("https://anything.test") {
+		t.Error("* should allow every origin")
+	}
+}
+
+func testMux() *http.ServeMux {
+	mux := http.NewServeMux()
+	ok := func(w http.ResponseWriter, r *http.Request) {}
+	mux.HandleFunc("GET /note", ok)
+	mux.HandleFunc("POST /note", ok)
+	mux.HandleFunc("DELETE /shares/{id}", ok)
+	return mux
+}
+
+func serveCORS(method, path, origin string, preflight bool) *httptest.ResponseRecorder {
+	mux := testMux()
+	req := httptest.NewRequest(method, path, nil)
+	if origin != "" {
+		req.Header.Set("Origin", origin)
+	}
+	if preflight {
+		req.Header.Set("Access-Control-Request-Method", "POST")
+	}
+	rec := httptest.NewRecorder()
+	CORS(testPolicy(), mux)(mux).ServeHTTP(rec, req)
+	return rec
+}
+
+// TestCORSRequests checks simple requests get the allow headers only for
+// allowed origins, and always vary on Origin.
+func TestCORSRequests(t *testing.T) {
+	rec := serveCORS("GET", "/note", "https://app.example.com", false)
+	if got := rec.Header().Get("Access-Control-Allow-Origin"); got != "https://app.example.com" {
+		t.Errorf("Allow-Origin = %q", got)
+	}
+	if rec.Header().Get("Access-Control-Allow-Credentials") != "true" {
+		t.Error("credentials not allowed")
+	}
+	if rec.Header().Get("Access-Control-Expose-Headers") != "X-Request-ID" {
+		t.Error("request ID not exposed")
+	}
+	if rec.Header().Get("Vary") != "Origin" {
+		t.Errorf("Vary = %q, want Origin", rec.Header().Get("Vary"))
+	}
+
+	rec = serveCORS("GET", "/note", "https://evil.test", false)
+	if rec.Header().Get("Access-Control-Allow-Origin") != "" {
+		t.Error("disallowed origin got Allow-Origin")
+	}
+	if rec.Code != http.StatusOK || rec.Header().Get("Vary") != "Origin" {
+		t.Errorf("disallowed origin: status %d, Vary %q", rec.Code, rec.Header().Get("Vary"))
+	}
+
+	rec = serveCORS("GET", "/note", "", false)
+	if rec.Header().Get("Access-Control-Allow-Origin") != "" || rec.Header().Get("Vary") != "Origin" {
+		t.Error("request without Origin got CORS headers or no Vary")
+	}
+}
+
+// TestCORSPreflight checks preflights list the methods of the requested
+// route and are rejected for unknown routes and disallowed origins.
+func TestCORSPreflight(t *testing.T) {
+	rec := serveCORS("OPTIONS", "/note", "http://localhost:3000", true)
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("status = %d, want 204", rec.Code)
+	}
+	if got := rec.Header().Get("Access-Control-Allow-Methods"); got != "GET, POST, OPTIONS" {
+		t.Errorf("Allow-Methods = %q", got)
+	}
+	if got := rec.Header().Get("Access-Control-Allow-Headers"); got != "Content-Type, Authorization" {
+		t.Errorf("Allow-Headers = %q", got)
+	}
+	if got := rec.Header().Get("Access-Control-Max-Age"); got != "600" {
+		t.Errorf("Max-Age = %q", got)
+	}
+	if got := rec.Header().Values("Vary"); len(got) != 3 {
+		t.Errorf("Vary = %v", got)
+	}
+
+	rec = serveCORS("OPTIONS", "/shares/abc", "http://localhost:3000", true)
+	if got := rec.Header().Get("Access-Control-Allow-Methods"); got != "DELETE, OPTIONS" {
+		t.Errorf("Allow-Methods for a path with a wildcard = %q", got)
+	}
+
+	rec = serveCORS("OPTIONS", "/unknown", "http://localhost:3000", true)
+	if rec.Code != http.StatusNotFound || rec.Header().Get("Access-Control-Allow-Origin") != "" {
+		t.Errorf("unknown route: status %d, Allow-Origin %q", rec.Code, rec.Header().Get("Access-Control-Allow-Origin"))
+	}
+
+	rec = serveCORS("OPTIONS", "/note", "https://evil.test", true)
+	if rec.Code != http.StatusForbidden || rec.Header().Get("Access-Control-Allow-Origin") != "" {
+		t.Errorf("disallowed origin: status %d", rec.Code)
+	}
+}
@@ -0,0 +1,144 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"media_management_go/backend/common"
+)
+
+// TestChainOrder checks the first middleware listed runs first.
+func TestChainOrder(t *testing.T) {
+	var order []string
+	mark := func(name string) Middleware {
+		return func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				order = append(order, name)
+				next.ServeHTTP(w, r)
+			})
+		}
+	}
+	h := Chain(mark("a"), mark("b"))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		order = append(order, "handler")
+	}))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+
+	if got := strings.Join(order, ","); got != "a,b,handler" {
+		t.Errorf("order = %s, want a,b,handler", got)
+	}
+}
+
+// TestRequestID checks the ID is returned in the header and visible to the
+// handler, differs between requests and is taken from the client when safe.
+func TestRequestID(t *testing.T) {
+	var seen string
+	h := RequestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = common.RequestID(r.Context())
+	}))
+	serve := func(header string) string {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		if header != "" {
+			req.Header.Set("X-Request-ID", header)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec.Header().Get("X-Request-ID")
+	}
+
+	id := serve("")
+	if id == "" || id != seen {
+		t.Fatalf("header ID %q, handler saw %q", id, seen)
+	}
+	if serve("") == id {
+		t.Error("two requests got the same ID")
+	}
+	if got := serve("trace-1234"); got != "trace-1234" || seen != "trace-1234" {
+		t.Errorf("client ID not kept: header %q, handler saw %q", got, seen)
+	}
+	for _, bad := range []string{"bad id", "x\nlevel=ERROR", strings.Repeat("a", maxRequestIDLength+1)} {
+		if got := serve(bad); got == bad {
+			t.Errorf("unsafe client ID %q was kept", bad)
+		}
+	}
+}
+
+// TestRecover checks a panic becomes a 500 JSON error, unless the response
+// had already started.
+func TestRecover(t *testing.T) {
+	rec := httptest.NewRecorder()
+	Recover(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("boom")
+	})).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	if rec.Code != http.StatusInternalServerError {
+		t.Fatalf("status = %d, want 500", rec.Code)
+	}
+	var body struct {
+		Error string `json:"error"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&body); err != nil || body.Error == "" {
+		t.Errorf("body is not a JSON error: %v", err)
+	}
+
+	rec = httptest.NewRecorder()
+	Recover(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusAccepted)
+		w.Write([]byte("partial"))
+		panic("boom")
+	})).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	if rec.Code != http.StatusAccepted || rec.Body.String() != "partial" {
+		t.Errorf("started response changed: %d %q", rec.Code, rec.Body.String())
+	}
+}
+
+// TestAccessLog checks the logged status, size and route, including for a
+// panic turned into a 500 by Recover.
+func TestAccessLog(t *testing.T) {
+	var buf bytes.Buffer
+	prev := slog.Default()
+	slog.SetDefault(slog.New(slog.NewJSONHandler(&buf, nil)))
+	t.Cleanup(func() { slog.SetDefault(prev) })
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /note/{id}", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte("hello"))
+	})
+	mux.HandleFunc("GET /boom", func(w http.ResponseWriter, r *http.Request) {
+		panic("boom")
+	})
+	h := Chain(AccessLog, Recover)(mux)
+
+	type entry struct {
+		Level  string `json:"level"`
+		Route  string `json:"route"`
+		Status int    `json:"status"`
+		Bytes  int64  `json:"bytes"`
+	}
+	serve := func(path string) entry {
+		buf.Reset()
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+		lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+		var e entry
+		if err := json.Unmarshal([]byte(lines[len(lines)-1]), &e); err != nil {
+			t.Fatalf("access log line is not JSON: %v", err)
+		}
+		return e
+	}
+
+	if e := serve("/note/1"); e.Route != "GET /note/{id}" || e.Status != http.StatusCreated || e.Bytes != 5 || e.Level != "INFO" {
+		t.Errorf("note: %+v", e)
+	}
+	if e := serve("/boom"); e.Status != http.StatusInternalServerError || e.Level != "ERROR" {
+		t.Errorf("panic: %+v", e)
+	}
+	if e := serve("/missing"); e.Status != http.StatusNotFound || e.Route != "" {
+		t.Errorf("unknown route: %+v", e)
+	}
+}
@@ -0,0 +1,125 @@
+package middleware
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+type (
+	clientIPKey struct{}
+	viaProxyKey struct{}
+)
+
+// RealIP works out the address of the client behind any trusted reverse
+// proxies and makes it available through ClientIP. Forwarding headers are
+// only believed from a trusted proxy; X-Forwarded-For is read from the
+// right, skipping trusted proxies, since anything further left may have
+// been made up by the client.
+func RealIP(trusted []netip.Prefix) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := context.WithValue(r.Context(), clientIPKey{}, realIP(r, trusted))
+			if addr, err := netip.ParseAddr(remoteHost(r)); err == nil && isTrusted(addr, trusted) {
+				ctx = context.WithValue(ctx, viaProxyKey{}, true)
+			}
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+func realIP(r *http.Request, trusted []netip.Prefix) string {
+	remote := remoteHost(r)
+	addr, err := netip.ParseAddr(remote)
+	if err != nil || !isTrusted(addr, trusted) {
+		return remote
+	}
+
+	if xff := r.Header.Values("X-Forwarded-For"); len(xff) > 0 {
+		hops := strings.Split(strings.Join(xff, ","), ",")
+		client := addr
+		for i := len(hops) - 1; i >= 0; i-- {
+			hop, ok := parseHop(hops[i])
+			if !ok {
+				break
+			}
+			client = hop
+			if !isTrusted(hop, trusted) {
+				break
+			}
+		}
+		return client.String()
+	}
+	if hop, ok := parseHop(r.Header.Get("X-Real-IP")); ok {
+		return hop.String()
+	}
+	return remote
+}
+
+// parseHop parses one address from a forwarding header, with or without a
+// port.
+func parseHop(s string) (netip.Addr, bool) {
+	s = strings.TrimSpace(s)
+	if addr, err := netip.ParseAddr(s); err == nil {
+		return addr.Unmap(), true
+	}
+	if ap, err := netip.ParseAddrPort(s); err == nil {
+		return ap.Addr().Unmap(), true
+	}
+	return netip.Addr{}, false
+}
+
+func isTrusted(addr netip.Addr, trusted []netip.Prefix) bool {
+	addr = addr.Unmap()
+	for _, p := range trusted {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// remoteHost is the address of the connection, without the port.
+func remoteHost(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// ClientIP returns the client's address as worked out by RealIP, or the
+// connection's address for requests that did not pass through it.
+func ClientIP(r *http.Request) string {
+	if ip, ok := r.Context().Value(clientIPKey{}).(string); ok {
+		return ip
+	}
+	return remoteHost(r)
+}
+
+// ViaTrustedProxy reports whether RealIP found r to come from a trusted
+// proxy, whose other forwarding headers can then be believed too.
+func ViaTrustedProxy(r *http.Request) bool {
+	via, _ := r.Context().Value(viaProxyKey{}).(bool)
+	return via
+}
+
+// LocalOnly refuses requests from clients outside the local network, as
+// worked out by RealIP: only loopback, private and link-local addresses
+// get through.
+func LocalOnly(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		addr, err := netip.ParseAddr(ClientIP(r))
+		if err != nil || !isLocal(addr.Unmap()) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func isLocal(addr netip.Addr) bool {
+	return addr.IsLoopback() || addr.IsPrivate() || addr.IsLinkLocalUnicast()
+}
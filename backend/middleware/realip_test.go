@@ -0,0 +1,75 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+)
+
+// TestRealIP checks forwarding headers are only believed from trusted
+// proxies and are read from the right.
+func TestRealIP(t *testing.T) {
+	trusted := []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8"), netip.MustParsePrefix("::1/128")}
+	for _, tc := range []struct {
+		name, remote, xff, realIP, want string
+	}{
+		{"direct", "203.0.113.5:4000", "", "", "203.0.113.5"},
+		{"untrusted forwarder", "203.0.113.5:4000", "198.51.100.1", "", "203.0.113.5"},
+		{"trusted proxy", "10.0.0.2:4000", "198.51.100.1", "", "198.51.100.1"},
+		{"spoofed left entry", "10.0.0.2:4000", "1.1.1.1, 198.51.100.1", "", "198.51.100.1"},
+		{"proxy chain", "10.0.0.2:4000", "198.51.100.1, 10.0.0.9", "", "198.51.100.1"},
+		{"all trusted", "10.0.0.2:4000", "10.0.0.8, 10.0.0.9", "", "10.0.0.8"},
+		{"garbage hop", "10.0.0.2:4000", "junk", "", "10.0.0.2"},
+		{"with port", "10.0.0.2:4000", "198.51.100.1:5555", "", "198.51.100.1"},
+		{"x-real-ip", "[::1]:4000", "", "2001:db8::1", "2001:db8::1"},
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.RemoteAddr = tc.remote
+		if tc.xff != "" {
+			req.Header.Set("X-Forwarded-For", tc.xff)
+		}
+		if tc.realIP != "" {
+			req.Header.Set("X-Real-IP", tc.realIP)
+		}
+		var got string
+		RealIP(trusted)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			got = ClientIP(r)
+		})).ServeHTTP(httptest.NewRecorder(), req)
+		if got != tc.want {
+			t.Errorf("%s: ClientIP = %q, want %q", tc.name, got, tc.want)
+		}
+	}
+}
+
+// TestLocalOnly checks only clients on the local network get through,
+// including behind a trusted proxy.
+func TestLocalOnly(t *testing.T) {
+	trusted := []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}
+	h := RealIP(trusted)(LocalOnly(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})))
+	for _, tc := range []struct {
+		remote, xff string
+		want        int
+	}{
+		{"127.0.0.1:4000", "", http.StatusOK},
+		{"[::1]:4000", "", http.StatusOK},
+		{"192.168.1.20:4000", "", http.StatusOK},
+		{"[fe80::1]:4000", "", http.StatusOK},
+		{"[::ffff:192.168.1.20]:4000", "", http.StatusOK},
+		{"203.0.113.5:4000", "", http.StatusForbidden},
+		{"203.0.113.5:4000", "192.168.1.20", http.StatusForbidden},
+		{"10.0.0.2:4000", "203.0.113.5", http.StatusForbidden},
+		{"10.0.0.2:4000", "192.168.1.20", http.StatusOK},
+	} {
+		req := httptest.NewRequest("POST", "/dlna/control/ContentDirectory", nil)
+		req.RemoteAddr = tc.remote
+		if tc.xff != "" {
+			req.Header.Set("X-Forwarded-For", tc.xff)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != tc.want {
+			t.Errorf("client %s forwarded for %q: %d, want %d", tc.remote, tc.xff, rec.Code, tc.want)
+		}
+	}
+}
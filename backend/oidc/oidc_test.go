@@ -0,0 +1,296 @@
+package oidc
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const testClientID = "media-app"
+
+// mockProvider is a minimal OpenID provider: discovery, JWKS and a token
+// endpoint that hands out the ID token queued for a code once its PKCE
+// verifier checks out.
+type mockProvider struct {
+	srv *httptest.Server
+
+	mu        sync.Mutex
+	keys      map[string]any // kid -> private key
+	jwksHits  int
+	codes     map[string]pendingCode
+	secretSet bool
+}
+
+type pendingCode struct {
+	challenge string
+	idToken   string
+}
+
+func newMockProvider(t *testing.T) *mockProvider {
+	t.Helper()
+	m := &mockProvider{keys: map[string]any{}, codes: map[string]pendingCode{}}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 m.srv.URL,
+			"authorization_endpoint": m.srv.URL + "/authorize",
+			"token_endpoint":         m.srv.URL + "/token",
+			"jwks_uri":               m.srv.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("GET /jwks", func(w http.ResponseWriter, r *http.Request) {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		m.jwksHits++
+		var keys []map[string]string
+		for kid, k := range m.keys {
+			switch k := k.(type) {
+			case *rsa.PrivateKey:
+				keys = append(keys, map[string]string{
+					"kid": kid, "kty": "RSA", "use": "sig",
+					"n": b64(k.N.Bytes()), "e": b64(big.NewInt(int64(k.E)).Bytes()),
+				})
+			case *ecdsa.PrivateKey:
+				pub, _ := k.PublicKey.Bytes()
+				keys = append(keys, map[string]string{
+					"kid": kid, "kty": "EC", "crv": "P-256",
+					"x": b64(pub[1:33]), "y": b64(pub[33:]),
+				})
+			}
+		}
+		json.NewEncoder(w).Encode(map[string]any{"keys": keys})
+	})
+	mux.HandleFunc("POST /token", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		m.mu.Lock()
+		pc, ok := m.codes[r.Form.Get("code")]
+		delete(m.codes, r.Form.Get("code"))
+		m.mu.Unlock()
+		id, secret, _ := r.BasicAuth()
+		m.secretSet = id == testClientID && secret == "s3cret"
+		if !ok || CodeChallenge(r.Form.Get("code_verifier")) != pc.challenge {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"access_token": "x", "token_type": "Bearer", "id_token": pc.idToken})
+	})
+	m.srv = httptest.NewServer(mux)
+	t.Cleanup(m.srv.Close)
+	return m
+}
+
+func b64(b []byte) string { return base64.RawURLEncoding.EncodeToString(b) }
+
+func (m *mockProvider) addRSAKey(t *testing.T, kid string) {
+	t.Helper()
+	k, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	m.mu.Lock()
+	m.keys[kid] = k
+	m.mu.Unlock()
+}
+
+// sign issues an ID token signed with kid; overrides replace default claims.
+func (m *mockProvider) sign(t *testing.T, kid string, overrides jwt.MapClaims) string {
+	t.Helper()
+	now := time.Now()
+	claims := jwt.MapClaims{
+		"iss":                m.srv.URL,
+		"aud":                testClientID,
+		"sub":                "user-123",
+		"iat":                now.Unix(),
+		"exp":                now.Add(5 * time.Minute).Unix(),
+		"nonce":              "n-1",
+		"preferred_username": "alice",
+		"email":              "alice@example.com",
+		"email_verified":     true,
+	}
+	for k, v := range overrides {
+		if v == nil {
+			delete(claims, k)
+		} else {
+			claims[k] = v
+		}
+	}
+
+	m.mu.Lock()
+	key := m.keys[kid]
+	m.mu.Unlock()
+	method := jwt.SigningMethod(jwt.SigningMethodRS256)
+	if _, ok := key.(*ecdsa.PrivateKey); ok {
+		method = jwt.SigningMethodES256
+	}
+	tok := jwt.NewWithClaims(method, claims)
+	tok.Header["kid"] = kid
+	s, err := tok.SignedString(key)
+	if err != nil {
+		t.Fatalf("sign id token: %v", err)
+	}
+	return s
+}
+
+func discover(t *testing.T, m *mockProvider, secret string) *Provider {
+	t.Helper()
+	p, err := Discover(context.Background(), Config{
+		Issuer:       m.srv.URL,
+		ClientID:     testClientID,
+		ClientSecret: secret,
+		RedirectURL:  "http://localhost:3000/login/oidc",
+	})
+	if err != nil {
+		t.Fatalf("Discover: %v", err)
+	}
+	return p
+}
+
+// TestAuthCodeFlow runs the whole flow: authorization URL, code exchange
+// with PKCE and ID token verification.
+func TestAuthCodeFlow(t *testing.T) {
+	m := newMockProvider(t)
+	m.addRSAKey(t, "k1")
+	p := discover(t, m, "s3cret")
+
+	verifier, err := RandomString()
+	if err != nil {
+		t.Fatalf("RandomString: %v", err)
+	}
+	u, err := url.Parse(p.AuthCodeURL("st-1", "n-1", verifier))
+	if err != nil {
+		t.Fatalf("parse auth url: %v", err)
+	}
+	q := u.Query()
+	if u.Path != "/authorize" || q.Get("state") != "st-1" || q.Get("nonce") != "n-1" ||
+		q.Get("code_challenge_method") != "S256" || q.Get("code_challenge") != CodeChallenge(verifier) ||
+		q.Get("client_id") != testClientID || !strings.Contains(q.Get("scope"), "openid") {
+		t.Fatalf("unexpected authorization URL %s", u)
+	}
+
+	m.codes["c-1"] = pendingCode{challenge: q.Get("code_challenge"), idToken: m.sign(t, "k1", nil)}
+	raw, err := p.Exchange(context.Background(), "c-1", verifier)
+	if err != nil {
+		t.Fatalf("Exchange: %v", err)
+	}
+	if !m.secretSet {
+		t.Error("client secret was not sent with basic auth")
+	}
+	c, err := p.VerifyIDToken(context.Background(), raw, "n-1")
+	if err != nil {
+		t.Fatalf("VerifyIDToken: %v", err)
+	}
+	if c.Subject != "user-123" || c.PreferredUsername != "alice" || c.Email != "alice@example.com" || !c.EmailVerified {
+		t.Errorf("unexpected claims %+v", c)
+	}
+
+	// the code is single use, and a wrong verifier is refused
+	m.codes["c-2"] = pendingCode{challenge: CodeChallenge(verifier), idToken: raw}
+	if _, err := p.Exchange(context.Background(), "c-2", "wrong"); err == nil {
+		t.Error("Exchange accepted a wrong PKCE verifier")
+	}
+	if _, err := p.Exchange(context.Background(), "c-1", verifier); err == nil {
+		t.Error("Exchange accepted a used code")
+	}
+}
+
+// TestDiscoverIssuerMismatch refuses a discovery document for another issuer.
+func TestDiscoverIssuerMismatch(t *testing.T) {
+	m := newMockProvider(t)
+	_, err := Discover(context.Background(), Config{Issuer: m.srv.URL + "/", ClientID: testClientID})
+	if err == nil {
+		t.Fatal("Discover accepted a mismatched issuer")
+	}
+}
+
+// TestVerifyIDTokenRejects covers the ID token checks.
+func TestVerifyIDTokenRejects(t *testing.T) {
+	m := newMockProvider(t)
+	m.addRSAKey(t, "k1")
+	p := discover(t, m, "")
+
+	// keys the provider does not publish, under a known and an unknown kid
+	stray := newMockProvider(t)
+	stray.srv.URL = m.srv.URL
+	stray.addRSAKey(t, "k1")
+	stray.addRSAKey(t, "k9")
+
+	hmac := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"iss": m.srv.URL, "aud": testClientID, "sub": "x", "nonce": "n-1",
+		"iat": time.Now().Unix(), "exp": time.Now().Add(time.Minute).Unix(),
+	})
+	hmacToken, _ := hmac.SignedString([]byte("secret"))
+
+	tests := []struct {
+		name  string
+		token string
+		nonce string
+	}{
+		{"wrong nonce", m.sign(t, "k1", nil), "n-2"},
+		{"missing nonce", m.sign(t, "k1", jwt.MapClaims{"nonce": nil}), "n-1"},
+		{"wrong audience", m.sign(t, "k1", jwt.MapClaims{"aud": "someone-else"}), "n-1"},
+		{"wrong issuer", m.sign(t, "k1", jwt.MapClaims{"iss": "https://evil.example"}), "n-1"},
+		{"expired", m.sign(t, "k1", jwt.MapClaims{"exp": time.Now().Add(-time.Hour).Unix()}), "n-1"},
+		{"no expiry", m.sign(t, "k1", jwt.MapClaims{"exp": nil}), "n-1"},
+		{"missing sub", m.sign(t, "k1", jwt.MapClaims{"sub": nil}), "n-1"},
+		{"foreign azp", m.sign(t, "k1", jwt.MapClaims{"aud": []string{testClientID, "b"}, "azp": "b"}), "n-1"},
+		{"wrong key", stray.sign(t, "k1", nil), "n-1"},
+		{"unknown kid", stray.sign(t, "k9", nil), "n-1"},
+		{"hmac", hmacToken, "n-1"},
+	}
+	for _, tt := range tests {
+		if _, err := p.VerifyIDToken(context.Background(), tt.token, tt.nonce); err == nil {
+			t.Errorf("%s: VerifyIDToken accepted the token", tt.name)
+		}
+	}
+
+	if _, err := p.VerifyIDToken(context.Background(), m.sign(t, "k1", jwt.MapClaims{"aud": []string{testClientID, "b"}, "azp": testClientID}), "n-1"); err != nil {
+		t.Errorf("VerifyIDToken with azp: %v", err)
+	}
+}
+
+// TestKeyRotation picks up a new provider key without a restart, but does
+// not refetch the JWKS for every unknown key ID.
+func TestKeyRotation(t *testing.T) {
+	m := newMockProvider(t)
+	m.addRSAKey(t, "k1")
+	p := discover(t, m, "")
+
+	if _, err := p.VerifyIDToken(context.Background(), m.sign(t, "k1", nil), "n-1"); err != nil {
+		t.Fatalf("VerifyIDToken: %v", err)
+	}
+
+	k, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	m.mu.Lock()
+	m.keys["k2"] = k
+	m.mu.Unlock()
+
+	// within keyRefreshInterval the new key is not fetched yet
+	if _, err := p.VerifyIDToken(context.Background(), m.sign(t, "k2", nil), "n-1"); err == nil {
+		t.Fatal("VerifyIDToken refetched keys too early")
+	}
+	p.keysFetched = time.Now().Add(-keyRefreshInterval)
+	if _, err := p.VerifyIDToken(context.Background(), m.sign(t, "k2", nil), "n-1"); err != nil {
+		t.Fatalf("VerifyIDToken after rotation: %v", err)
+	}
+	if m.jwksHits != 2 {
+		t.Errorf("jwks fetched %d times, want 2", m.jwksHits)
+	}
+}
@@ -0,0 +1,92 @@
+package ordering
+
+import (
+	"math/rand"
+	"sort"
+	"testing"
+)
+
+// TestKeyBetweenKnownValues pins the key format.
+func TestKeyBetweenKnownValues(t *testing.T) {
+	tests := []struct {
+		a, b, want string
+	}{
+		{"", "", "a0"},
+		{"a0", "", "a1"},
+		{"a1", "", "a2"},
+		{"", "a0", "Zz"},
+		{"a0", "a1", "a0V"},
+		{"a1", "a2", "a1V"},
+		{"a0V", "a1", "a0l"},
+		{"Zz", "a0", "ZzV"},
+		{"a0", "a0V", "a0G"},
+		{"az", "", "b00"},
+		{"b00", "", "b01"},
+	}
+	for _, tt := range tests {
+		got, err := KeyBetween(tt.a, tt.b)
+		if err != nil {
+			t.Fatalf("KeyBetween(%q, %q) failed: %v", tt.a, tt.b, err)
+		}
+		if got != tt.want {
+			t.Errorf("KeyBetween(%q, %q) = %q, want %q", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+// TestKeyBetweenErrors checks invalid and misordered input.
+func TestKeyBetweenErrors(t *testing.T) {
+	if _, err := KeyBetween("a1", "a0"); err != ErrOrder {
+		t.Errorf("expected ErrOrder, got %v", err)
+	}
+	if _, err := KeyBetween("a0", "a0"); err != ErrOrder {
+		t.Errorf("expected ErrOrder for equal keys, got %v", err)
+	}
+	for _, bad := range []string{"!", "a", "a00", "a0-"} {
+		if _, err := KeyBetween(bad, ""); err != ErrInvalidKey {
+			t.Errorf("expected ErrInvalidKey for %q, got %v", bad, err)
+		}
+	}
+}
+
+// TestKeyBetweenRandomInserts inserts at random positions and checks order is preserved.
+func TestKeyBetweenRandomInserts(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	var keys []string
+	for n := 0; n < 2000; n++ {
+		i := rng.Intn(len(keys) + 1)
+		var a, b string
+		if i > 0 {
+			a = keys[i-1]
+		}
+		if i < len(keys) {
+			b = keys[i]
+		}
+		k, err := KeyBetween(a, b)
+		if err != nil {
+			t.Fatalf("KeyBetween(%q, %q) failed: %v", a, b, err)
+		}
+		if (a != "" && k <= a) || (b != "" && k >= b) {
+			t.Fatalf("KeyBetween(%q, %q) = %q is not between", a, b, k)
+		}
+		keys = append(keys[:i], append([]string{k}, keys[i:]...)...)
+	}
+	if !sort.StringsAreSorted(keys) {
+		t.Fatalf("keys not sorted after inserts")
+	}
+}
+
+// TestAppendStaysShort ensures appending does not grow keys linearly.
+func TestAppendStaysShort(t *testing.T) {
+	k := ""
+	for n := 0; n < 10000; n++ {
+		next, err := KeyBetween(k, "")
+		if err != nil {
+			t.Fatalf("KeyBetween(%q, \"\") failed: %v", k, err)
+		}
+		k = next
+	}
+	if len(k) > 4 {
+		t.Errorf("expected short key after 10000 appends, got %q", k)
+	}
+}
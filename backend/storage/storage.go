@@ -0,0 +1,102 @@
+// Package storage keeps uploaded media files in the configured media
+// directory. Files are stored under generated names; the database maps them
+// back to their original file names.
+package storage
+
+import (
+	"errors"
+	"io"
+	"log/slog"
+	"mime"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"media_management_go/backend/common"
+
+	"github.com/google/uuid"
+)
+
+// Path returns the absolute location of a stored file.
+func Path(name string) string {
+	return filepath.Join(common.GetConfig().MEDIA_DIR, filepath.Base(name))
+}
+
+// Create opens a new, empty file for writing under a fresh name with the
+// given extension. The caller closes it.
+func Create(ext string) (*os.File, string, error) {
+	dir := common.GetConfig().MEDIA_DIR
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, "", err
+	}
+
+	name := uuid.New().String() + strings.ToLower(ext)
+	f, err := os.OpenFile(filepath.Join(dir, name), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if err != nil {
+		return nil, "", err
+	}
+	return f, name, nil
+}
+
+// Save copies src into a new stored file and returns its name and size.
+func Save(src io.Reader, ext string) (string, int64, error) {
+	dst, name, err := Create(ext)
+	if err != nil {
+		return "", 0, err
+	}
+
+	size, err := io.Copy(dst, src)
+	if cerr := dst.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		Remove(name)
+		return "", 0, err
+	}
+	return name, size, nil
+}
+
+// Open opens a stored file for reading.
+func Open(name string) (*os.File, error) {
+	return os.Open(Path(name))
+}
+
+// Remove deletes a stored file. A missing file is not an error.
+func Remove(name string) {
+	if err := os.Remove(Path(name)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		slog.Warn("Failed to remove media file", slog.String("path", name), slog.Any("error", err))
+	}
+}
+
+// CheckWritable returns an error unless files can be stored, by writing
+// and removing a probe file in the media directory.
+func CheckWritable() error {
+	dir := common.GetConfig().MEDIA_DIR
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(dir, ".probe-*")
+	if err != nil {
+		return err
+	}
+	_, err = f.WriteString("ok")
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if rerr := os.Remove(f.Name()); err == nil {
+		err = rerr
+	}
+	return err
+}
+
+// MimeType returns the declared content type of an upload, or one guessed from
+// the file name when the client sent none or a generic one.
+func MimeType(filename, declared string) string {
+	if declared != "" && declared != "application/octet-stream" {
+		return declared
+	}
+	if byExt := mime.TypeByExtension(filepath.Ext(filename)); byExt != "" {
+		return byExt
+	}
+	return "application/octet-stream"
+}
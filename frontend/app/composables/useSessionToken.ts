@@ -0,0 +1,94 @@
+// Shared access token; kept in sync with localStorage so components pick up
+// refreshed tokens without a reload. In cookie mode (NUXT_PUBLIC_COOKIE_SESSIONS)
+// the server keeps the tokens in HttpOnly cookies and this holds the CSRF
+// token instead.
+export const useSessionToken = () => {
+    const token = useState<string | null>('session_token', () => null)
+    // the server-rendered state is always empty; fill it in on the client
+    if (import.meta.client && !token.value) {
+        token.value = localStorage.getItem('session_token')
+    }
+    return token
+}
+
+export const useCookieSessions = () => useRuntimeConfig().public.cookieSessions === true
+
+// Headers that authenticate an API request with the given session token.
+export const authHeaders = (token: string | null): Record<string, string> =>
+    useCookieSessions()
+        ? { 'X-CSRF-Token': token ?? '' }
+        : { 'Authorization': `Bearer ${token}` }
+
+// Cookies only need to be sent in cookie mode; the server does not allow
+// credentialed requests otherwise.
+export const authCredentials = (): RequestCredentials =>
+    useCookieSessions() ? 'include' : 'same-origin'
+
+// Headers for login requests, asking for a cookie session in cookie mode.
+export const loginHeaders = (): Record<string, string> => ({
+    'Content-Type': 'application/json',
+    ...(useCookieSessions() ? { 'X-Session-Mode': 'cookie' } : {}),
+})
+
+export const storeTokens = (token: string, refreshToken: string) => {
+    localStorage.setItem('session_token', token)
+    localStorage.setItem('refresh_token', refreshToken)
+    useSessionToken().value = token
+}
+
+// Stores a login or refresh response in either mode. Returns false if it
+// carries no session.
+export const storeSession = (data: { token?: string, refresh_token?: string, csrf_token?: string }): boolean => {
+    if (data?.csrf_token) {
+        localStorage.setItem('session_token', data.csrf_token)
+        localStorage.removeItem('refresh_token')
+        useSessionToken().value = data.csrf_token
+        return true
+    }
+    if (data?.token && data.refresh_token) {
+        storeTokens(data.token, data.refresh_token)
+        return true
+    }
+    return false
+}
+
+export const clearTokens = () => {
+    localStorage.removeItem('session_token')
+    localStorage.removeItem('refresh_token')
+    useSessionToken().value = null
+}
+
+// Whether there is a session that refreshSession can renew.
+export const hasSession = (): boolean =>
+    useCookieSessions()
+        ? !!localStorage.getItem('session_token')
+        : !!localStorage.getItem('refresh_token')
+
+// Exchanges the stored refresh token for a new token pair.
+// Returns false when the session can no longer be renewed.
+export const refreshSession = async (): Promise<boolean> => {
+    const config = useRuntimeConfig()
+    const cookies = useCookieSessions()
+    const refreshToken = localStorage.getItem('refresh_token')
+    if (!cookies && !refreshToken) {
+        return false
+    }
+
+    try {
+        // in cookie mode the refresh token travels in its cookie
+        const res = await fetch(`${config.public.serverUrl}/token/refresh`, {
+            method: 'POST',
+            headers: { 'Content-Type': 'application/json' },
+            credentials: authCredentials(),
+            body: cookies ? '{}' : JSON.stringify({ refresh_token: refreshToken }),
+        })
+        if (!res.ok) {
+            clearTokens()
+            return false
+        }
+        return storeSession(await res.json())
+    } catch (err) {
+        console.error('Failed to refresh session:', err)
+        return false
+    }
+}
@@ -3,7 +3,7 @@ export default defineNuxtRouteMiddleware(async (to, from) => {
 
     const config = useRuntimeConfig()
 
-    // const token = useCookie('session_token').value
+    // the access token, or the CSRF token in cookie mode
     const token = localStorage.getItem('session_token')
     
     if (!token) {
@@ -11,18 +11,28 @@ export default defineNuxtRouteMiddleware(async (to, from) => {
       return navigateTo('/')
     }
 
-    const res = await fetch(`${config.public.serverUrl}/login`, {
+    const checkToken = (token: string | null) => fetch(`${config.public.serverUrl}/login`, {
       method: 'GET',
       headers: {
-        'Authorization': `Bearer ${token}`,
+        ...authHeaders(token),
         'Accept': 'application/json',
       },
+      credentials: authCredentials(),
       redirect: 'manual',
     })
 
+    const res = await checkToken(token)
     if (res.status === 200) {
       return
     }
 
+    // access token expired; try to renew it with the refresh token
+    if (res.status === 401 && await refreshSession()) {
+      const retry = await checkToken(localStorage.getItem('session_token'))
+      if (retry.status === 200) {
+        return
+      }
+    }
+
     return navigateTo('/')
 })
\ No newline at end of file
@@ -0,0 +1,10 @@
+// Access tokens live for 15 minutes; renew them a little earlier while the app is open.
+const REFRESH_INTERVAL_MS = 10 * 60 * 1000
+
+export default defineNuxtPlugin(() => {
+    setInterval(async () => {
+        if (hasSession() && !(await refreshSession())) {
+            navigateTo('/')
+        }
+    }, REFRESH_INTERVAL_MS)
+})
@@ -7,6 +7,10 @@ export default defineNuxtConfig({
   runtimeConfig: {
     public: {
       serverUrl: process.env.NUXT_PUBLIC_SERVER_URL,
+      // shows the single sign-on button; the backend needs OIDC_ISSUER too
+      ssoEnabled: process.env.NUXT_PUBLIC_SSO_ENABLED === 'true',
+      // keeps the session in HttpOnly cookies; the backend needs SESSION_COOKIES too
+      cookieSessions: process.env.NUXT_PUBLIC_COOKIE_SESSIONS === 'true',
     }
   },
   modules: ['@nuxt/content', '@nuxt/ui']